@@ -5,7 +5,6 @@
 package main
 
 import (
-	"archive/tar"
 	"archive/zip"
 	"bytes"
 	"context"
@@ -26,10 +25,9 @@ import (
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
-	gzip "github.com/klauspost/pgzip"
 	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
-	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb"
 	"golang.org/x/mod/sumdb/tlog"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
@@ -42,6 +40,14 @@ var httpClient = &http.Client{
 	},
 }
 
+// The base URLs of the services allcode talks to, which can be changed with
+// flags, for example to point at local stand-ins.
+var (
+	proxyURLBase = "https://proxy.golang.org/cached-only"
+	indexURL     = "https://index.golang.org"
+	sumdbURL     = "https://sum.golang.org"
+)
+
 func newRequestWithContext(ctx context.Context, method, url string) *http.Request {
 	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
@@ -55,8 +61,10 @@ type Index struct {
 	d    *json.Decoder
 }
 
-func NewIndex(ctx context.Context) (*Index, error) {
-	i := &Index{}
+// NewIndex returns an Index that starts after the given timestamp, which
+// can be zero to start from the beginning.
+func NewIndex(ctx context.Context, since time.Time) (*Index, error) {
+	i := &Index{last: since}
 	if err := i.nextPage(ctx); err != nil {
 		return nil, err
 	}
@@ -64,7 +72,7 @@ func NewIndex(ctx context.Context) (*Index, error) {
 }
 
 func (i *Index) nextPage(ctx context.Context) error {
-	url := "https://index.golang.org/index?since=" + i.last.Add(1).Format(time.RFC3339Nano)
+	url := indexURL + "/index?since=" + i.last.Add(1).Format(time.RFC3339Nano)
 	req, err := httpClient.Do(newRequestWithContext(ctx, "GET", url))
 	if err != nil {
 		return err
@@ -95,7 +103,7 @@ func (i *Index) next(ctx context.Context) (*Version, error) {
 }
 
 func fetchLatest(ctx context.Context) ([]byte, error) {
-	url := "https://sum.golang.org/latest"
+	url := sumdbURL + "/latest"
 	res, err := httpClient.Do(newRequestWithContext(ctx, "GET", url))
 	if err != nil {
 		return nil, err
@@ -118,7 +126,7 @@ func proxyURL(path, version, suffix string) (string, error) {
 	if err != nil {
 		return "", errorInvalidName
 	}
-	return "https://proxy.golang.org/cached-only/" + p + "/@v/" + v + suffix, nil
+	return proxyURLBase + "/" + p + "/@v/" + v + suffix, nil
 }
 
 var errorGone = errors.New("410 Gone")
@@ -213,13 +221,181 @@ func ignoreFile(name string) bool {
 	return true
 }
 
+// A fetcher downloads module versions, verifies them against the checksum
+// database, and writes the ones that look like Go modules to a corpus,
+// counting the reasons it skipped the others.
+type fetcher struct {
+	sumdb  *sumdb.Client
+	corpus corpusWriter
+	all    bool // include potential forks
+	gcp    *semaphore.Weighted
+	bar    *pb.ProgressBar
+
+	outMu sync.Mutex
+
+	gone, invalidName, vendor, spam, mismatchedGoMod, invalidGoMod int64
+	noGoCode, noGoMod, gcsBytes, good, goBytes, allBytes, goFiles  int64
+	checksumMismatch, notInSumDB                                   int64
+}
+
+// fetchModule processes a module version. It can be called concurrently.
+// release frees the slot the caller acquired for it, so that slow downloads
+// from GCS wait on f.gcp instead. It might be called more than once.
+func (f *fetcher) fetchModule(ctx context.Context, path, version string, release func()) error {
+	if strings.Contains(path, "/vendor/") || strings.Contains(path, "/kubernetes/staging/") {
+		atomic.AddInt64(&f.vendor, 1)
+		return nil
+	}
+	if strings.HasPrefix(path, "github.com/bbiswy/") ||
+		strings.HasPrefix(path, "github.com/wMc27rFqQaH7tQxv3/") {
+		atomic.AddInt64(&f.spam, 1)
+		return nil
+	}
+	modBytes, err := fetchMod(ctx, path, version)
+	if err == errorInvalidName {
+		atomic.AddInt64(&f.invalidName, 1)
+		return nil
+	}
+	if err == errorGone {
+		atomic.AddInt64(&f.gone, 1)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mod, err := modfile.ParseLax(path+"@"+version, modBytes, nil)
+	if err != nil {
+		atomic.AddInt64(&f.invalidGoMod, 1)
+		return nil
+	}
+	if mod.Module.Mod.Path != path && !f.all {
+		atomic.AddInt64(&f.mismatchedGoMod, 1)
+		return nil
+	}
+
+	url, size, err := fetchZipHead(ctx, path, version)
+	if err == errorGone {
+		atomic.AddInt64(&f.gone, 1)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(url, "https://storage.googleapis.com/") {
+		atomic.AddInt64(&f.gcsBytes, size)
+		release()
+		f.gcp.Acquire(ctx, 1)
+		defer f.gcp.Release(1)
+	}
+
+	zipBytes, err := fetchZip(ctx, path, version)
+	if err == errorGone {
+		atomic.AddInt64(&f.gone, 1)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&f.allBytes, size)
+
+	zipBytesReader := bytes.NewReader(zipBytes)
+	z, err := zip.NewReader(zipBytesReader, size)
+	if err != nil {
+		return err
+	}
+
+	err = verifyModule(f.sumdb, path, version, modBytes, z)
+	if err == errorNotInSumDB {
+		atomic.AddInt64(&f.notInSumDB, 1)
+		return nil
+	}
+	if err == errorChecksumMismatch {
+		atomic.AddInt64(&f.checksumMismatch, 1)
+		log.Printf("%s@%s: checksum mismatch", path, version)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var hasGoMod, hasGoFiles bool
+	var extractedSize uint64
+	for _, zf := range z.File {
+		if strings.HasSuffix(zf.Name, ".go") {
+			hasGoFiles = true
+		}
+		if strings.HasSuffix(zf.Name, "/go.mod") {
+			hasGoMod = true
+		}
+		if !ignoreFile(zf.Name) {
+			extractedSize += zf.UncompressedSize64
+		}
+	}
+	if !hasGoFiles {
+		atomic.AddInt64(&f.noGoCode, 1)
+		return nil
+	}
+	if !hasGoMod && !f.all {
+		atomic.AddInt64(&f.noGoMod, 1)
+		return nil
+	}
+	atomic.AddInt64(&f.good, 1)
+
+	f.outMu.Lock()
+	defer f.outMu.Unlock()
+	const largeExtractedSize = 100 << 20 // 100MB
+	if extractedSize > largeExtractedSize {
+		f.bar.Set("prefix", "Fetching modules... [Large module! "+path+"]")
+		f.bar.Write()
+		defer f.bar.Set("prefix", "Fetching modules...")
+	}
+	files, n, err := f.corpus.WriteModule(path, version, z)
+	atomic.AddInt64(&f.goFiles, files)
+	atomic.AddInt64(&f.goBytes, n)
+	return err
+}
+
+// printSummary writes how many modules were skipped for each reason, and how
+// many were written to the corpus.
+func (f *fetcher) printSummary(w io.Writer) {
+	fmt.Fprintf(w, "Vendor paths:         % 7d -\n", f.vendor)
+	fmt.Fprintf(w, "Spam:                 % 7d -\n", f.spam)
+	fmt.Fprintf(w, "Invalid names:        % 7d -\n", f.invalidName)
+	fmt.Fprintf(w, "Gone:                 % 7d -\n", f.gone)
+	fmt.Fprintf(w, "Invalid go.mod files: % 7d -\n", f.invalidGoMod)
+	fmt.Fprintf(w, "Not in sumdb:         % 7d -\n", f.notInSumDB)
+	fmt.Fprintf(w, "Checksum mismatch:    % 7d -\n", f.checksumMismatch)
+	if !f.all {
+		fmt.Fprintf(w, "Mismatching go.mod:   % 7d -\n", f.mismatchedGoMod)
+		fmt.Fprintf(w, "No go.mod file:       % 7d -\n", f.noGoMod)
+	}
+	fmt.Fprintf(w, "No .go files:         % 7d =\n", f.noGoCode)
+	fmt.Fprintf(w, "                      -------\n")
+	fmt.Fprintf(w, "                      % 7d\n", f.good)
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "Downloaded %d bytes (%d from GCS).\n", f.allBytes, f.gcsBytes)
+	fmt.Fprintf(w, "Wrote %d Go files (%d bytes).\n", f.goFiles, f.goBytes)
+}
+
 var pbTemplate pb.ProgressBarTemplate = `{{string . "prefix"}} {{counters . }} {{bar . }} {{percent . }} {{etime . }}`
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		searchMain(os.Args[2:])
+		return
+	}
+
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `FILE`")
 	memprofile := flag.String("memprofile", "", "write memory profile to `FILE`")
 	compress := flag.Bool("z", false, "compress the output tar archive with gzip")
+	output := flag.String("o", "", "write a searchable SQLite index to `FILE` instead of a tar archive to stdout")
 	all := flag.Bool("all", false, "include potential forks (mismatching and missing go.mod)")
+	since := flag.String("since", "", "only fetch modules updated since the run that wrote the state `FILE`")
+	stateFile := flag.String("state", "", "write the index state to `FILE` for a later -since run")
+	flag.StringVar(&proxyURLBase, "proxy", proxyURLBase, "module proxy base `URL`")
+	flag.StringVar(&indexURL, "index", indexURL, "module index base `URL`")
+	flag.StringVar(&sumdbURL, "sumdb", sumdbURL, "checksum database base `URL`")
+	sumdbKey := flag.String("sumdb-key", "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8", "checksum database verifier `key`")
 	flag.Parse()
 
 	if *cpuprofile != "" {
@@ -246,220 +422,108 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	bar := pbTemplate.Start64(tree.N).Set("prefix", "Fetching index...")
-	latestVersions := make(map[string]string)
-
-	i, err := NewIndex(ctx)
+	sumdbClient, err := newSumDBClient(ctx, *sumdbKey, latest)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var linesSeen uint64
-	for {
-		v, err := i.next(ctx)
-		if err == io.EOF {
-			break
-		}
+	state := &State{LatestVersions: make(map[string]string)}
+	if *since != "" {
+		state, err = readState(*since)
 		if err != nil {
 			log.Fatal(err)
 		}
-		linesSeen++
-		bar.Increment()
+	}
 
-		if semver.Compare(v.Version, latestVersions[v.Path]) >= 0 {
-			latestVersions[v.Path] = v.Version
-		}
+	bar := pbTemplate.Start64(tree.N).Set("prefix", "Fetching index...")
+	bar.SetCurrent(state.IndexEntries)
+	updatedVersions, removed, err := updateState(ctx, state, func() { bar.Increment() })
+	if err != nil {
+		log.Fatal(err)
 	}
 	bar.Finish()
 
-	outMu := &sync.Mutex{}
-	var out io.WriteCloser = os.Stdout
-	if *compress {
-		out = gzip.NewWriter(out)
+	modules := state.LatestVersions
+	if *since != "" {
+		modules = updatedVersions
+	}
+
+	var corpus corpusWriter = newTarCorpus(os.Stdout, *compress)
+	if *output != "" {
+		corpus, err = openIndexCorpus(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
-	tw := tar.NewWriter(out)
 
-	bar = pbTemplate.Start(len(latestVersions)).Set("prefix", "Fetching modules...")
+	bar = pbTemplate.Start(len(modules)).Set("prefix", "Fetching modules...")
+	f := &fetcher{
+		sumdb:  sumdbClient,
+		corpus: corpus,
+		all:    *all,
+		gcp:    semaphore.NewWeighted(500), // GCS can take it, and it's way way slower
+		bar:    bar,
+	}
 	sem := semaphore.NewWeighted(200)
-	gcp := semaphore.NewWeighted(500) // GCS can take it, and it's way way slower
 	g, ctx := errgroup.WithContext(ctx)
 
-	var gone, invalidName, vendor, spam, mismatchedGoMod, invalidGoMod int64
-	var noGoCode, noGoMod, gcsBytes, good, goBytes, allBytes, goFiles int64
-	for path, version := range latestVersions {
+	failed := false
+	for path, version := range modules {
 		if err := ctx.Err(); err != nil {
 			bar.Finish()
 			log.Println(err)
+			failed = true
 			break
 		}
 
 		if err := sem.Acquire(ctx, 1); err != nil {
 			bar.Finish()
 			log.Println(err)
+			failed = true
 			break
 		}
 
-		path, version := path, version
 		g.Go(func() error {
-			releaseOnce := &sync.Once{}
-			defer releaseOnce.Do(func() { sem.Release(1) })
+			release := sync.OnceFunc(func() { sem.Release(1) })
+			defer release()
 			defer bar.Increment()
-
-			if strings.Contains(path, "/vendor/") || strings.Contains(path, "/kubernetes/staging/") {
-				atomic.AddInt64(&vendor, 1)
-				return nil
-			}
-			if strings.HasPrefix(path, "github.com/bbiswy/") ||
-				strings.HasPrefix(path, "github.com/wMc27rFqQaH7tQxv3/") {
-				atomic.AddInt64(&spam, 1)
-				return nil
-			}
-			modBytes, err := fetchMod(ctx, path, version)
-			if err == errorInvalidName {
-				atomic.AddInt64(&invalidName, 1)
-				return nil
-			}
-			if err == errorGone {
-				atomic.AddInt64(&gone, 1)
-				return nil
-			}
-			if err != nil {
-				return err
-			}
-			mod, err := modfile.ParseLax(path+"@"+version, modBytes, nil)
-			if err != nil {
-				atomic.AddInt64(&invalidGoMod, 1)
-				return nil
-			}
-			if mod.Module.Mod.Path != path && !*all {
-				atomic.AddInt64(&mismatchedGoMod, 1)
-				return nil
-			}
-
-			url, size, err := fetchZipHead(ctx, path, version)
-			if err == errorGone {
-				atomic.AddInt64(&gone, 1)
-				return nil
-			}
-			if err != nil {
-				return err
-			}
-			if strings.HasPrefix(url, "https://storage.googleapis.com/") {
-				atomic.AddInt64(&gcsBytes, size)
-				releaseOnce.Do(func() { sem.Release(1) })
-				gcp.Acquire(ctx, 1)
-				defer gcp.Release(1)
-			}
-
-			zipBytes, err := fetchZip(ctx, path, version)
-			if err == errorGone {
-				atomic.AddInt64(&gone, 1)
-				return nil
-			}
-			if err != nil {
-				return err
-			}
-			atomic.AddInt64(&allBytes, size)
-
-			zipBytesReader := bytes.NewReader(zipBytes)
-			z, err := zip.NewReader(zipBytesReader, size)
-			if err != nil {
-				return err
-			}
-
-			var hasGoMod, hasGoFiles bool
-			var extractedSize uint64
-			for _, f := range z.File {
-				if strings.HasSuffix(f.Name, ".go") {
-					hasGoFiles = true
-				}
-				if strings.HasSuffix(f.Name, "/go.mod") {
-					hasGoMod = true
-				}
-				if !ignoreFile(f.Name) {
-					extractedSize += f.UncompressedSize64
-				}
-			}
-			if !hasGoFiles {
-				atomic.AddInt64(&noGoCode, 1)
-				return nil
-			}
-			if !hasGoMod && !*all {
-				atomic.AddInt64(&noGoMod, 1)
-				return nil
-			}
-			atomic.AddInt64(&good, 1)
-
-			outMu.Lock()
-			defer outMu.Unlock()
-			const largeExtractedSize = 100 << 20 // 100MB
-			if extractedSize > largeExtractedSize {
-				bar.Set("prefix", "Fetching modules... [Large module! "+path+"]")
-				bar.Write()
-				defer bar.Set("prefix", "Fetching modules...")
-			}
-			for _, f := range z.File {
-				if ignoreFile(f.Name) {
-					continue
-				}
-
-				src, err := z.Open(f.Name)
-				if err != nil {
-					return err
-				}
-
-				hdr := &tar.Header{
-					Name: f.Name,
-					Mode: 0664,
-					Size: int64(f.UncompressedSize64),
-				}
-				if err := tw.WriteHeader(hdr); err != nil {
-					return err
-				}
-
-				n, err := io.Copy(tw, src)
-				if err != nil {
-					return err
-				}
-
-				atomic.AddInt64(&goFiles, 1)
-				atomic.AddInt64(&goBytes, n)
-			}
-
-			return nil
+			return f.fetchModule(ctx, path, version, release)
 		})
 	}
 
 	if err := g.Wait(); err != nil {
 		bar.Finish()
 		log.Println(err)
+		failed = true
 	}
-	if err := tw.Close(); err != nil {
-		log.Println(err)
+	if *since != "" {
+		if err := corpus.RemoveModules(removed); err != nil {
+			log.Println(err)
+			failed = true
+		}
 	}
-	if err := out.Close(); err != nil {
+	if err := corpus.Close(); err != nil {
 		log.Println(err)
+		failed = true
 	}
 	bar.Finish()
 
 	fmt.Fprintf(os.Stderr, "\n")
-	fmt.Fprintf(os.Stderr, "Unique modules:       % 7d -\n", len(latestVersions))
-	fmt.Fprintf(os.Stderr, "Vendor paths:         % 7d -\n", vendor)
-	fmt.Fprintf(os.Stderr, "Spam:                 % 7d -\n", spam)
-	fmt.Fprintf(os.Stderr, "Invalid names:        % 7d -\n", invalidName)
-	fmt.Fprintf(os.Stderr, "Gone:                 % 7d -\n", gone)
-	fmt.Fprintf(os.Stderr, "Invalid go.mod files: % 7d -\n", invalidGoMod)
-	if !*all {
-		fmt.Fprintf(os.Stderr, "Mismatching go.mod:   % 7d -\n", mismatchedGoMod)
-		fmt.Fprintf(os.Stderr, "No go.mod file:       % 7d -\n", noGoMod)
-	}
-	fmt.Fprintf(os.Stderr, "No .go files:         % 7d =\n", noGoCode)
-	fmt.Fprintf(os.Stderr, "                      -------\n")
-	fmt.Fprintf(os.Stderr, "                      % 7d\n", good)
-	fmt.Fprintf(os.Stderr, "\n")
-	fmt.Fprintf(os.Stderr, "Downloaded %d bytes (%d from GCS).\n", allBytes, gcsBytes)
-	fmt.Fprintf(os.Stderr, "Wrote %d Go files (%d bytes).\n", goFiles, goBytes)
+	if *since != "" {
+		fmt.Fprintf(os.Stderr, "Removed versions:     % 7d\n", len(removed))
+		fmt.Fprintf(os.Stderr, "Updated modules:      % 7d -\n", len(modules))
+	} else {
+		fmt.Fprintf(os.Stderr, "Unique modules:       % 7d -\n", len(modules))
+	}
+	f.printSummary(os.Stderr)
+
+	if *stateFile != "" && failed {
+		log.Println("not writing state file, as the archive is incomplete")
+	} else if *stateFile != "" {
+		if err := writeState(*stateFile, state); err != nil {
+			log.Println(err)
+		}
+	}
 
 	if *memprofile != "" {
 		f, err := os.Create(*memprofile)
@@ -0,0 +1,100 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+
+	gzip "github.com/klauspost/pgzip"
+)
+
+// A corpusWriter stores the extracted module files. Its methods are not safe
+// for concurrent use.
+type corpusWriter interface {
+	// WriteModule stores the files of a module version that are not ignored,
+	// and returns how many files and bytes it stored.
+	WriteModule(path, version string, z *zip.Reader) (files, n int64, err error)
+	// RemoveModules drops the module versions, in path@version form, that
+	// were replaced by newer ones since the previous run.
+	RemoveModules(versions []string) error
+	Close() error
+}
+
+// tarCorpus writes the module files as a tar archive, with names of the
+// form path@version/file, like in the module zip files.
+type tarCorpus struct {
+	out io.WriteCloser
+	tw  *tar.Writer
+}
+
+func newTarCorpus(out io.WriteCloser, compress bool) *tarCorpus {
+	if compress {
+		out = gzip.NewWriter(out)
+	}
+	return &tarCorpus{out: out, tw: tar.NewWriter(out)}
+}
+
+func (c *tarCorpus) WriteModule(path, version string, z *zip.Reader) (files, n int64, err error) {
+	for _, f := range z.File {
+		if ignoreFile(f.Name) {
+			continue
+		}
+
+		src, err := z.Open(f.Name)
+		if err != nil {
+			return files, n, err
+		}
+
+		hdr := &tar.Header{
+			Name: f.Name,
+			Mode: 0664,
+			Size: int64(f.UncompressedSize64),
+		}
+		if err := c.tw.WriteHeader(hdr); err != nil {
+			return files, n, err
+		}
+
+		nn, err := io.Copy(c.tw, src)
+		if err != nil {
+			return files, n, err
+		}
+
+		files++
+		n += nn
+	}
+	return files, n, nil
+}
+
+// removedManifest is the name of the file in -since archives that lists the
+// module directories, in path@version form, replaced by newer versions. It
+// can't collide with module files, which are all under path@version/.
+const removedManifest = "REMOVED"
+
+func (c *tarCorpus) RemoveModules(versions []string) error {
+	var buf bytes.Buffer
+	for _, m := range versions {
+		buf.WriteString(m + "\n")
+	}
+	hdr := &tar.Header{
+		Name: removedManifest,
+		Mode: 0664,
+		Size: int64(buf.Len()),
+	}
+	if err := c.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := c.tw.Write(buf.Bytes())
+	return err
+}
+
+func (c *tarCorpus) Close() error {
+	if err := c.tw.Close(); err != nil {
+		return err
+	}
+	return c.out.Close()
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"go/parser"
+	"go/token"
+	"io"
+	"strconv"
+	"strings"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+const indexSchema = `
+CREATE TABLE IF NOT EXISTS modules (
+	id INTEGER PRIMARY KEY,
+	path TEXT NOT NULL,
+	version TEXT NOT NULL,
+	UNIQUE (path, version)
+);
+CREATE TABLE IF NOT EXISTS files (
+	id INTEGER PRIMARY KEY,
+	module_id INTEGER NOT NULL REFERENCES modules(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	size INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_module_id ON files(module_id);
+CREATE TABLE IF NOT EXISTS imports (
+	file_id INTEGER NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+	path TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS imports_path ON imports(path);
+CREATE INDEX IF NOT EXISTS imports_file_id ON imports(file_id);
+-- The rowid of contents is the id of the file. Only .go files are indexed.
+CREATE VIRTUAL TABLE IF NOT EXISTS contents USING fts5(content, tokenize = 'trigram');
+`
+
+// indexCorpus stores the module files in a SQLite database, with a trigram
+// index over the contents of .go files and a table of their imports.
+type indexCorpus struct {
+	conn *sqlite.Conn
+}
+
+func openIndexCorpus(name string) (*indexCorpus, error) {
+	conn, err := sqlite.OpenConn(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := sqlitex.ExecuteTransient(conn, `PRAGMA journal_mode = WAL;`, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := sqlitex.ExecuteTransient(conn, `PRAGMA foreign_keys = ON;`, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := sqlitex.ExecuteScript(conn, indexSchema, nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &indexCorpus{conn: conn}, nil
+}
+
+func (c *indexCorpus) WriteModule(path, version string, z *zip.Reader) (files, n int64, err error) {
+	defer sqlitex.Save(c.conn)(&err)
+
+	// Replace the module if it was already indexed by a previous run.
+	if err := c.removeModule(path, version); err != nil {
+		return 0, 0, err
+	}
+	err = sqlitex.Execute(c.conn, `INSERT INTO modules (path, version) VALUES (?, ?);`,
+		&sqlitex.ExecOptions{Args: []any{path, version}})
+	if err != nil {
+		return 0, 0, err
+	}
+	moduleID := c.conn.LastInsertRowID()
+
+	prefix := path + "@" + version + "/"
+	for _, f := range z.File {
+		if ignoreFile(f.Name) {
+			continue
+		}
+		name := strings.TrimPrefix(f.Name, prefix)
+		err := sqlitex.Execute(c.conn, `INSERT INTO files (module_id, name, size) VALUES (?, ?, ?);`,
+			&sqlitex.ExecOptions{Args: []any{moduleID, name, int64(f.UncompressedSize64)}})
+		if err != nil {
+			return files, n, err
+		}
+		fileID := c.conn.LastInsertRowID()
+		files++
+		n += int64(f.UncompressedSize64)
+
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		src, err := f.Open()
+		if err != nil {
+			return files, n, err
+		}
+		content, err := io.ReadAll(src)
+		src.Close()
+		if err != nil {
+			return files, n, err
+		}
+		err = sqlitex.Execute(c.conn, `INSERT INTO contents (rowid, content) VALUES (?, ?);`,
+			&sqlitex.ExecOptions{Args: []any{fileID, string(content)}})
+		if err != nil {
+			return files, n, err
+		}
+
+		// Files that don't parse are indexed anyway, just without imports.
+		parsed, _ := parser.ParseFile(token.NewFileSet(), name, content, parser.ImportsOnly)
+		if parsed == nil {
+			continue
+		}
+		for _, imp := range parsed.Imports {
+			importPath, err := strconv.Unquote(imp.Path.Value)
+			if err != nil {
+				continue
+			}
+			err = sqlitex.Execute(c.conn, `INSERT INTO imports (file_id, path) VALUES (?, ?);`,
+				&sqlitex.ExecOptions{Args: []any{fileID, importPath}})
+			if err != nil {
+				return files, n, err
+			}
+		}
+	}
+	return files, n, nil
+}
+
+func (c *indexCorpus) removeModule(path, version string) error {
+	// The contents table can't have foreign keys, so delete its rows first.
+	err := sqlitex.Execute(c.conn, `
+		DELETE FROM contents WHERE rowid IN (
+			SELECT files.id FROM files JOIN modules ON files.module_id = modules.id
+			WHERE modules.path = ? AND modules.version = ?
+		);`, &sqlitex.ExecOptions{Args: []any{path, version}})
+	if err != nil {
+		return err
+	}
+	return sqlitex.Execute(c.conn, `DELETE FROM modules WHERE path = ? AND version = ?;`,
+		&sqlitex.ExecOptions{Args: []any{path, version}})
+}
+
+func (c *indexCorpus) RemoveModules(versions []string) (err error) {
+	defer sqlitex.Save(c.conn)(&err)
+	for _, m := range versions {
+		path, version, _ := strings.Cut(m, "@")
+		if err := c.removeModule(path, version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *indexCorpus) Close() error {
+	return c.conn.Close()
+}
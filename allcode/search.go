@@ -0,0 +1,165 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func searchMain(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: allcode search [flags] [regexp]\n")
+		fs.PrintDefaults()
+	}
+	dbPath := fs.String("db", "index.db", "SQLite index written with -o")
+	imports := fs.String("imports", "", "only search modules that import the package at `PATH`")
+	filesOnly := fs.Bool("l", false, "only print the names of matching files")
+	fs.Parse(args)
+	if fs.NArg() > 1 || (fs.NArg() == 0 && *imports == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := sqlite.OpenConn(*dbPath, sqlite.OpenReadOnly)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if err := search(conn, w, fs.Arg(0), *imports, *filesOnly); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// search writes the lines of the indexed Go files that match the regexp expr,
+// or with filesOnly just the names of the files, only considering the modules
+// that import the package imports, if not empty. If expr is empty, it writes
+// the modules that import imports instead.
+func search(conn *sqlite.Conn, w io.Writer, expr, imports string, filesOnly bool) error {
+	if expr == "" {
+		return sqlitex.Execute(conn, `
+			SELECT DISTINCT modules.path, modules.version FROM modules
+			JOIN files ON files.module_id = modules.id
+			JOIN imports ON imports.file_id = files.id
+			WHERE imports.path = ?
+			ORDER BY modules.path;`, &sqlitex.ExecOptions{
+			Args: []any{imports},
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				_, err := fmt.Fprintf(w, "%s@%s\n", stmt.ColumnText(0), stmt.ColumnText(1))
+				return err
+			},
+		})
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	query, err := trigramQuery(expr)
+	if err != nil {
+		return err
+	}
+
+	// The trigram index narrows down the candidate files, and then the
+	// regexp is applied to each of them to find the actual matches.
+	var sql strings.Builder
+	var sqlArgs []any
+	sql.WriteString(`
+		SELECT modules.path, modules.version, files.name, contents.content FROM contents
+		JOIN files ON files.id = contents.rowid
+		JOIN modules ON modules.id = files.module_id
+		WHERE 1`)
+	if query != "" {
+		sql.WriteString(` AND contents MATCH ?`)
+		sqlArgs = append(sqlArgs, query)
+	}
+	if imports != "" {
+		sql.WriteString(` AND modules.id IN (
+			SELECT files.module_id FROM imports
+			JOIN files ON files.id = imports.file_id
+			WHERE imports.path = ?)`)
+		sqlArgs = append(sqlArgs, imports)
+	}
+	sql.WriteString(`;`)
+
+	return sqlitex.Execute(conn, sql.String(), &sqlitex.ExecOptions{
+		Args: sqlArgs,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			name := stmt.ColumnText(0) + "@" + stmt.ColumnText(1) + "/" + stmt.ColumnText(2)
+			content := stmt.ColumnText(3)
+			for i, line := range strings.Split(content, "\n") {
+				if !re.MatchString(line) {
+					continue
+				}
+				if filesOnly {
+					_, err := fmt.Fprintln(w, name)
+					return err
+				}
+				if _, err := fmt.Fprintf(w, "%s:%d: %s\n", name, i+1, line); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// trigramQuery returns an FTS5 query that matches a superset of the files
+// matched by the regexp expr, or an empty string if the index can't be used
+// and all files need to be scanned.
+func trigramQuery(expr string) (string, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var terms []string
+	for _, lit := range requiredLiterals(re.Simplify()) {
+		// The trigram tokenizer can't match strings shorter than a trigram.
+		if utf8.RuneCountInString(lit) < 3 {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(lit, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " AND "), nil
+}
+
+// requiredLiterals returns strings that must appear in any text matching re.
+// It's not exhaustive, and it gives up on anything but concatenations.
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		// The trigram tokenizer is case-insensitive, so the FoldCase flag
+		// doesn't need special handling.
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiterals(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		var lits []string
+		for _, sub := range re.Sub {
+			lits = append(lits, requiredLiterals(sub)...)
+		}
+		return lits
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"path/filepath"
+	"regexp/syntax"
+	"slices"
+	"strings"
+	"testing"
+
+	"zombiezen.com/go/sqlite"
+)
+
+func TestTrigramQuery(t *testing.T) {
+	tests := []struct {
+		expr, query string
+	}{
+		{`elliptic\.Marshal\(`, `"elliptic.Marshal("`},
+		{`[a-z]+Marshal`, `"Marshal"`},
+		{`(foo)?barbaz`, `"barbaz"`},
+		{`(hello)+`, `"hello"`},
+		{`Sign(er)?`, `"Sign"`},
+		{`^package main$`, `"package main"`},
+		{`"quoted"`, `"""quoted"""`},
+		{`日本語`, `"日本語"`},
+		// The tokenizer is case-insensitive.
+		{`(?i)Marshal`, `"MARSHAL"`},
+		// Required literals are ANDed.
+		{`ecdsa\.Sign\(rand, .*, hash\)`, `"ecdsa.Sign(rand, " AND ", hash)"`},
+		// Alternations require none of their branches, unless the
+		// parser factors out a common prefix.
+		{`foo|barbaz`, ``},
+		{`crypto/(md5|sha1)`, `"crypto/"`},
+		{`foobar|foobaz`, `"fooba"`},
+		// Character classes break up literals.
+		{`[Ee]lliptic`, `"lliptic"`},
+		// Literals shorter than a trigram can't be searched for, and
+		// must not make the query match nothing.
+		{`x`, ``},
+		{`ab.*cd`, ``},
+		{`ab[xy]cd`, ``},
+		{`日本`, ``},
+		{`a{2,}bcd`, `"bcd"`},
+		{`ab.*Marshal`, `"Marshal"`},
+		// Optional and repeated-zero-times parts aren't required.
+		{`(Marshal)?x`, ``},
+		{`(Marshal)*x`, ``},
+		{`(Marshal){0,2}`, ``},
+		{`(Marshal){1,2}`, `"Marshal"`},
+	}
+	for _, tt := range tests {
+		query, err := trigramQuery(tt.expr)
+		if err != nil {
+			t.Errorf("trigramQuery(%q): %v", tt.expr, err)
+			continue
+		}
+		if query != tt.query {
+			t.Errorf("trigramQuery(%q) = %q, want %q", tt.expr, query, tt.query)
+		}
+	}
+
+	if _, err := trigramQuery(`(`); err == nil {
+		t.Error("trigramQuery of an invalid regexp succeeded")
+	}
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		expr string
+		lits []string
+	}{
+		{`abc`, []string{"abc"}},
+		{`ab.cd`, []string{"ab", "cd"}},
+		{`a+bc`, []string{"a", "bc"}},
+		{`(ab|cd)ef`, []string{"ef"}},
+		{`[ab]`, nil},
+		{`.*`, nil},
+	}
+	for _, tt := range tests {
+		re, err := syntax.Parse(tt.expr, syntax.Perl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lits := requiredLiterals(re.Simplify()); !slices.Equal(lits, tt.lits) {
+			t.Errorf("requiredLiterals(%q) = %q, want %q", tt.expr, lits, tt.lits)
+		}
+	}
+}
+
+func testZip(t *testing.T, path, version string, files map[string]string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(path + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+func TestIndexSearch(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "index.db")
+	c, err := openIndexCorpus(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modules := []struct {
+		path, version string
+		files         map[string]string
+	}{
+		{"example.com/old", "v1.0.0", map[string]string{
+			"go.mod": "module example.com/old\n",
+			"old.go": "package old\n\nimport \"crypto/elliptic\"\n\nvar _ = elliptic.Marshal(nil, nil, nil)\n",
+		}},
+		{"example.com/ecdh", "v1.0.0", map[string]string{
+			"go.mod":  "module example.com/ecdh\n",
+			"ecdh.go": "package ecdh\n\nimport (\n\t\"crypto/elliptic\"\n\t\"fmt\"\n)\n\nfunc F() {\n\tb := elliptic.Marshal(c, x, y)\n\tfmt.Println(b)\n}\n",
+			// Files that don't parse are still searchable.
+			"broken.go": "package ecdh\n\nfunc elliptic.Marshal(\n",
+			// Only .go files are indexed.
+			"README.c": "elliptic.Marshal(",
+		}},
+		{"example.com/dsa", "v0.1.0", map[string]string{
+			"go.mod": "module example.com/dsa\n",
+			"dsa.go": "package dsa\n\nimport \"crypto/dsa\"\n\nvar Sign = dsa.Sign\n",
+		}},
+	}
+	for _, m := range modules {
+		if _, _, err := c.WriteModule(m.path, m.version, testZip(t, m.path, m.version, m.files)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Re-indexing a module replaces it.
+	m := modules[2]
+	if _, _, err := c.WriteModule(m.path, m.version, testZip(t, m.path, m.version, m.files)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RemoveModules([]string{"example.com/old@v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := sqlite.OpenConn(name, sqlite.OpenReadOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		expr, imports string
+		filesOnly     bool
+		want          []string
+	}{
+		{`elliptic\.Marshal\(`, "", false, []string{
+			"example.com/ecdh@v1.0.0/broken.go:3: func elliptic.Marshal(",
+			"example.com/ecdh@v1.0.0/ecdh.go:9: \tb := elliptic.Marshal(c, x, y)",
+		}},
+		{`elliptic\.Marshal\(`, "", true, []string{
+			"example.com/ecdh@v1.0.0/broken.go",
+			"example.com/ecdh@v1.0.0/ecdh.go",
+		}},
+		{`elliptic\.Marshal\(`, "crypto/elliptic", true, []string{
+			"example.com/ecdh@v1.0.0/broken.go",
+			"example.com/ecdh@v1.0.0/ecdh.go",
+		}},
+		{`elliptic\.Marshal\(`, "crypto/dsa", false, nil},
+		{`(?i)ELLIPTIC\.marshal\(`, "", true, []string{
+			"example.com/ecdh@v1.0.0/broken.go",
+			"example.com/ecdh@v1.0.0/ecdh.go",
+		}},
+		// Too short for the trigram index, so every file is scanned.
+		{`b :?=`, "", false, []string{
+			"example.com/ecdh@v1.0.0/ecdh.go:9: \tb := elliptic.Marshal(c, x, y)",
+		}},
+		{`dsa\.Sign|fmt\.Println`, "", true, []string{
+			"example.com/dsa@v0.1.0/dsa.go",
+			"example.com/ecdh@v1.0.0/ecdh.go",
+		}},
+		{"", "crypto/elliptic", false, []string{"example.com/ecdh@v1.0.0"}},
+		{"", "crypto/dsa", false, []string{"example.com/dsa@v0.1.0"}},
+		{"", "fmt", false, []string{"example.com/ecdh@v1.0.0"}},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		if err := search(conn, &out, tt.expr, tt.imports, tt.filesOnly); err != nil {
+			t.Errorf("search(%q, imports %q): %v", tt.expr, tt.imports, err)
+			continue
+		}
+		got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+		if out.Len() == 0 {
+			got = nil
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("search(%q, imports %q, -l %v) = %q, want %q",
+				tt.expr, tt.imports, tt.filesOnly, got, tt.want)
+		}
+	}
+}
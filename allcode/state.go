@@ -0,0 +1,98 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// State records how far into the index a run got, so that a later run can
+// produce a delta from it.
+type State struct {
+	// Timestamp is the timestamp of the last index entry that was processed.
+	Timestamp time.Time
+	// IndexEntries is the number of index entries processed so far.
+	IndexEntries int64
+	// LatestVersions maps module paths to their latest version.
+	LatestVersions map[string]string
+}
+
+func readState(name string) (*State, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	if s.LatestVersions == nil {
+		s.LatestVersions = make(map[string]string)
+	}
+	return s, nil
+}
+
+// writeState atomically replaces the file at name, which might be the same one
+// the previous state was read from.
+func writeState(name string, s *State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// updateState reads the index from where state left off, recording the latest
+// version of each module, and calling progress for each entry.
+//
+// It returns the modules whose latest version changed, which are the only
+// ones fetched in -since mode, and the path@version of the previous versions
+// they replaced, whose directories are removed.
+func updateState(ctx context.Context, state *State, progress func()) (updated map[string]string, removed []string, err error) {
+	i, err := NewIndex(ctx, state.Timestamp)
+	if err != nil {
+		return nil, nil, err
+	}
+	updated = make(map[string]string)
+	for {
+		v, err := i.next(ctx)
+		if err == io.EOF {
+			return updated, removed, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		state.IndexEntries++
+		state.Timestamp = v.Timestamp
+		progress()
+
+		if prev := state.LatestVersions[v.Path]; v.Version != prev && semver.Compare(v.Version, prev) >= 0 {
+			state.LatestVersions[v.Path] = v.Version
+			if _, ok := updated[v.Path]; !ok && prev != "" {
+				removed = append(removed, v.Path+"@"+prev)
+			}
+			updated[v.Path] = v.Version
+		}
+	}
+}
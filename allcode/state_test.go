@@ -0,0 +1,213 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "state.json")
+	s := &State{
+		Timestamp:    time.Date(2021, 5, 21, 10, 0, 0, 123456789, time.UTC),
+		IndexEntries: 42,
+		LatestVersions: map[string]string{
+			"filippo.io/age":          "v1.0.0",
+			"filippo.io/edwards25519": "v1.0.0-beta.3",
+		},
+	}
+	if err := writeState(name, s); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readState(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Timestamp.Equal(s.Timestamp) || got.IndexEntries != s.IndexEntries ||
+		!reflect.DeepEqual(got.LatestVersions, s.LatestVersions) {
+		t.Errorf("readState = %+v, want %+v", got, s)
+	}
+
+	// -since and -state can be the same file.
+	got.IndexEntries++
+	if err := writeState(name, got); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readState(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IndexEntries != 43 {
+		t.Errorf("IndexEntries = %d after rewrite, want 43", got.IndexEntries)
+	}
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestReadStateEmpty(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(name, []byte(`{"IndexEntries": 1}`), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s, err := readState(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LatestVersions == nil {
+		t.Error("LatestVersions is nil")
+	}
+
+	if _, err := readState(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("readState of a missing file succeeded")
+	}
+}
+
+// newIndexServer starts a stand-in for index.golang.org serving versions,
+// which must be sorted by timestamp, two per page.
+func newIndexServer(t *testing.T, versions []Version) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index" {
+			http.NotFound(w, r)
+			return
+		}
+		since, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("since"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := 0
+		for _, v := range versions {
+			if v.Timestamp.Before(since) || n == 2 {
+				continue
+			}
+			json.NewEncoder(w).Encode(v)
+			n++
+		}
+	}))
+	t.Cleanup(srv.Close)
+	old := indexURL
+	indexURL = srv.URL
+	t.Cleanup(func() { indexURL = old })
+}
+
+func TestUpdateStateSince(t *testing.T) {
+	t0 := time.Date(2021, 5, 21, 0, 0, 0, 0, time.UTC)
+	at := func(h int) time.Time { return t0.Add(time.Duration(h) * time.Hour) }
+	newIndexServer(t, []Version{
+		{"example.com/a", "v1.0.0", at(0)},
+		{"example.com/b", "v1.0.0", at(1)},
+		{"example.com/a", "v1.1.0", at(2)},
+		{"example.com/c", "v0.1.0", at(3)},
+		{"example.com/b", "v0.9.0", at(4)}, // older than the latest, ignored
+		{"example.com/a", "v1.2.0", at(5)},
+		{"example.com/b", "v1.0.0", at(6)}, // republished, ignored
+	})
+
+	// The first run starts from scratch, and fetches everything.
+	state := &State{LatestVersions: make(map[string]string)}
+	var progress int
+	updated, removed, err := updateState(context.Background(), state, func() { progress++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := map[string]string{
+		"example.com/a": "v1.2.0",
+		"example.com/b": "v1.0.0",
+		"example.com/c": "v0.1.0",
+	}
+	if !reflect.DeepEqual(updated, latest) || !reflect.DeepEqual(state.LatestVersions, latest) {
+		t.Errorf("updateState = %v, LatestVersions = %v, want %v", updated, state.LatestVersions, latest)
+	}
+	if state.IndexEntries != 7 || progress != 7 || !state.Timestamp.Equal(at(6)) {
+		t.Errorf("IndexEntries = %d, progress = %d, Timestamp = %v; want 7, 7, %v",
+			state.IndexEntries, progress, state.Timestamp, at(6))
+	}
+
+	// The next run starts from the state of the first one.
+	state = &State{
+		Timestamp:      at(1),
+		IndexEntries:   2,
+		LatestVersions: map[string]string{"example.com/a": "v1.0.0", "example.com/b": "v1.0.0"},
+	}
+	updated, removed, err = updateState(context.Background(), state, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"example.com/a": "v1.2.0", "example.com/c": "v0.1.0"}; !reflect.DeepEqual(updated, want) {
+		t.Errorf("updated = %v, want %v", updated, want)
+	}
+	// a@v1.1.0 was never in the previous corpus, so only a@v1.0.0 is removed.
+	if want := []string{"example.com/a@v1.0.0"}; !slices.Equal(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+	if !reflect.DeepEqual(state.LatestVersions, latest) {
+		t.Errorf("LatestVersions = %v, want %v", state.LatestVersions, latest)
+	}
+	if state.IndexEntries != 7 {
+		t.Errorf("IndexEntries = %d, want 7", state.IndexEntries)
+	}
+
+	// A run with nothing new is empty.
+	updated, removed, err = updateState(context.Background(), state, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updated) != 0 || len(removed) != 0 {
+		t.Errorf("updateState with no new entries = %v, %v", updated, removed)
+	}
+}
+
+func TestTarCorpusRemoved(t *testing.T) {
+	var buf bytes.Buffer
+	c := newTarCorpus(nopCloser{&buf}, false)
+	removed := []string{"example.com/a@v1.0.0", "example.com/b@v0.1.0"}
+	if err := c.RemoveModules(removed); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&buf)
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != removedManifest {
+		t.Errorf("file name = %q, want %q", hdr.Name, removedManifest)
+	}
+	content, err := io.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "example.com/a@v1.0.0\nexample.com/b@v0.1.0\n"; string(content) != want {
+		t.Errorf("%s = %q, want %q", removedManifest, content, want)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("unexpected extra file: %v", err)
+	}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
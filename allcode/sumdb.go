@@ -0,0 +1,144 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+)
+
+// clientOps implements sumdb.ClientOps entirely in memory, starting from the
+// tree head fetched at the beginning of the run.
+type clientOps struct {
+	ctx context.Context
+	key string
+
+	mu     sync.Mutex
+	config map[string][]byte
+}
+
+func newSumDBClient(ctx context.Context, key string, latest []byte) (*sumdb.Client, error) {
+	verifier, err := note.NewVerifier(key)
+	if err != nil {
+		return nil, err
+	}
+	ops := &clientOps{ctx: ctx, key: key, config: map[string][]byte{
+		verifier.Name() + "/latest": latest,
+	}}
+	return sumdb.NewClient(ops), nil
+}
+
+var errorNotInSumDB = errors.New("not in checksum database")
+
+func (ops *clientOps) ReadRemote(path string) ([]byte, error) {
+	url := sumdbURL + path
+	res, err := httpClient.Do(newRequestWithContext(ops.ctx, "GET", url))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if strings.HasPrefix(path, "/lookup/") &&
+		(res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone) {
+		return nil, errorNotInSumDB
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %q: %v", url, res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+func (ops *clientOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(ops.key), nil
+	}
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	return ops.config[file], nil
+}
+
+func (ops *clientOps) WriteConfig(file string, old, new []byte) error {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+	if !bytes.Equal(ops.config[file], old) {
+		return sumdb.ErrWriteConflict
+	}
+	ops.config[file] = new
+	return nil
+}
+
+// The Client already caches tiles and records in memory for its lifetime,
+// which is the whole run, so there is no need for another cache layer.
+
+func (ops *clientOps) ReadCache(file string) ([]byte, error) {
+	return nil, errors.New("no cache")
+}
+
+func (ops *clientOps) WriteCache(file string, data []byte) {}
+
+func (ops *clientOps) Log(msg string) {
+	log.Print(msg)
+}
+
+func (ops *clientOps) SecurityError(msg string) {
+	log.Fatal(msg)
+}
+
+var errorChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyModule checks the go.mod and zip of a module version against the
+// verified go.sum lines served by the checksum database.
+func verifyModule(client *sumdb.Client, path, version string, modBytes []byte, z *zip.Reader) error {
+	zipLines, err := client.Lookup(path, version)
+	// Lookup flattens errors into strings, so errors.Is doesn't work.
+	if err != nil && strings.HasSuffix(err.Error(), errorNotInSumDB.Error()) {
+		return errorNotInSumDB
+	}
+	if err != nil {
+		return err
+	}
+	// This is served from the record cached by the previous Lookup.
+	modLines, err := client.Lookup(path, version+"/go.mod")
+	if err != nil {
+		return err
+	}
+
+	modHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(modBytes)), nil
+	})
+	if err != nil {
+		return err
+	}
+	var files []string
+	zipFiles := make(map[string]*zip.File)
+	for _, f := range z.File {
+		files = append(files, f.Name)
+		zipFiles[f.Name] = f
+	}
+	zipHash, err := dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		return zipFiles[name].Open()
+	})
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(zipLines, path+" "+version+" "+zipHash) ||
+		!slices.Contains(modLines, path+" "+version+"/go.mod "+modHash) {
+		return errorChecksumMismatch
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/mod/sumdb/note"
+	"golang.org/x/sync/semaphore"
+)
+
+// A testModule is a module version served by the proxy stand-in.
+type testModule struct {
+	path, version string
+	files         map[string]string // file name in the module → content
+}
+
+func (m testModule) goMod() []byte {
+	return []byte("module " + m.path + "\n")
+}
+
+func (m testModule) zip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range m.files {
+		w, err := zw.Create(m.path + "@" + m.version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// goSum returns the go.sum lines for m, computed like the go command does.
+func (m testModule) goSum(t *testing.T) []byte {
+	name := filepath.Join(t.TempDir(), "m.zip")
+	if err := os.WriteFile(name, m.zip(t), 0666); err != nil {
+		t.Fatal(err)
+	}
+	zipHash, err := dirhash.HashZip(name, dirhash.Hash1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modHash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(m.goMod())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(m.path + " " + m.version + " " + zipHash + "\n" +
+		m.path + " " + m.version + "/go.mod " + modHash + "\n")
+}
+
+// newProxyServer starts a stand-in for the module proxy serving modules.
+func newProxyServer(t *testing.T, modules []testModule) {
+	t.Helper()
+	mux := http.NewServeMux()
+	for _, m := range modules {
+		p, err := module.EscapePath(m.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		base := "/" + p + "/@v/" + m.version
+		mod, zip := m.goMod(), m.zip(t)
+		mux.HandleFunc(base+".mod", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(mod)
+		})
+		mux.HandleFunc(base+".zip", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(zip))
+		})
+	}
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	old := proxyURLBase
+	proxyURLBase = srv.URL
+	t.Cleanup(func() { proxyURLBase = old })
+}
+
+// newSumDBServer starts a stand-in for the checksum database, serving the
+// given go.sum lines, and returns a client for it.
+func newSumDBServer(t *testing.T, gosum map[string][]byte) *sumdb.Client {
+	t.Helper()
+	skey, vkey, err := note.GenerateKey(rand.Reader, "sum.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := sumdb.NewTestServer(skey, func(path, version string) ([]byte, error) {
+		lines, ok := gosum[path+"@"+version]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return lines, nil
+	})
+	srv := httptest.NewServer(sumdb.NewServer(ops))
+	t.Cleanup(srv.Close)
+	old := sumdbURL
+	sumdbURL = srv.URL
+	t.Cleanup(func() { sumdbURL = old })
+
+	ctx := context.Background()
+	latest, err := fetchLatest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := newSumDBClient(ctx, vkey, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// memCorpus records the module versions written to it.
+type memCorpus struct{ modules []string }
+
+func (c *memCorpus) WriteModule(path, version string, z *zip.Reader) (files, n int64, err error) {
+	c.modules = append(c.modules, path+"@"+version)
+	return int64(len(z.File)), 0, nil
+}
+
+func (c *memCorpus) RemoveModules(versions []string) error { return nil }
+func (c *memCorpus) Close() error                          { return nil }
+
+func TestFetchModuleSumDB(t *testing.T) {
+	good := testModule{"example.com/good", "v1.0.0", map[string]string{
+		"go.mod":  "module example.com/good\n",
+		"good.go": "package good\n",
+	}}
+	tampered := testModule{"example.com/tampered", "v1.0.0", map[string]string{
+		"go.mod":   "module example.com/tampered\n",
+		"false.go": "package tampered\n\nconst Tampered = true\n",
+	}}
+	original := testModule{"example.com/tampered", "v1.0.0", map[string]string{
+		"go.mod":   "module example.com/tampered\n",
+		"false.go": "package tampered\n\nconst Tampered = false\n",
+	}}
+	unknown := testModule{"example.com/unknown", "v1.0.0", map[string]string{
+		"go.mod":     "module example.com/unknown\n",
+		"unknown.go": "package unknown\n",
+	}}
+	newProxyServer(t, []testModule{good, tampered, unknown})
+	client := newSumDBServer(t, map[string][]byte{
+		"example.com/good@v1.0.0":     good.goSum(t),
+		"example.com/tampered@v1.0.0": original.goSum(t),
+	})
+
+	corpus := &memCorpus{}
+	f := &fetcher{
+		sumdb:  client,
+		corpus: corpus,
+		gcp:    semaphore.NewWeighted(1),
+		bar:    pbTemplate.New(0),
+	}
+	ctx := context.Background()
+	for _, m := range []testModule{good, tampered, unknown} {
+		if err := f.fetchModule(ctx, m.path, m.version, func() {}); err != nil {
+			t.Errorf("%s@%s: %v", m.path, m.version, err)
+		}
+	}
+
+	if want := []string{"example.com/good@v1.0.0"}; !slices.Equal(corpus.modules, want) {
+		t.Errorf("written modules = %v, want %v", corpus.modules, want)
+	}
+	if f.good != 1 || f.checksumMismatch != 1 || f.notInSumDB != 1 {
+		t.Errorf("good = %d, checksum mismatch = %d, not in sumdb = %d; want 1 each",
+			f.good, f.checksumMismatch, f.notInSumDB)
+	}
+
+	var summary strings.Builder
+	f.printSummary(&summary)
+	for _, line := range []string{
+		"Not in sumdb:               1 -\n",
+		"Checksum mismatch:          1 -\n",
+		"                            1\n",
+	} {
+		if !strings.Contains(summary.String(), line) {
+			t.Errorf("summary is missing %q:\n%s", line, summary.String())
+		}
+	}
+}
+
+func TestVerifyModuleGoMod(t *testing.T) {
+	m := testModule{"example.com/m", "v1.0.0", map[string]string{
+		"go.mod": "module example.com/m\n",
+		"m.go":   "package m\n",
+	}}
+	client := newSumDBServer(t, map[string][]byte{"example.com/m@v1.0.0": m.goSum(t)})
+	zipBytes := m.zip(t)
+	z, err := zip.NewReader(bytes.NewReader(zipBytes), int64(len(zipBytes)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyModule(client, m.path, m.version, m.goMod(), z); err != nil {
+		t.Errorf("verifyModule with the right go.mod: %v", err)
+	}
+	err = verifyModule(client, m.path, m.version, []byte("module example.com/other\n"), z)
+	if err != errorChecksumMismatch {
+		t.Errorf("verifyModule with the wrong go.mod = %v, want %v", err, errorChecksumMismatch)
+	}
+}
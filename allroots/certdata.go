@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nssTrust is the trust configuration of a root in certdata.txt.
+//
+// nss.Parse only returns the roots trusted for server authentication, without
+// their email trust, so this parses the rest of the trust and certificate
+// objects that are relevant to the manifest.
+type nssTrust struct {
+	ServerAuth          bool
+	EmailProtection     bool
+	ServerDistrustAfter *time.Time
+	EmailDistrustAfter  *time.Time
+}
+
+// parseNSSTrust returns the trust configuration of every certificate in
+// certdata.txt, keyed by the SHA-256 fingerprint of the certificate.
+// Certificates excluded by Mozilla policy (CKA_NSS_MOZILLA_CA_POLICY false)
+// are skipped.
+func parseNSSTrust(r io.Reader) (map[[32]byte]*nssTrust, error) {
+	type object map[string][]byte
+	var objects []object
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	var obj object
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" {
+			obj = nil
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 || !strings.HasPrefix(f[0], "CKA_") {
+			continue
+		}
+		if f[0] == "CKA_CLASS" {
+			obj = make(object)
+			objects = append(objects, obj)
+		}
+		if obj == nil {
+			continue
+		}
+		if f[1] == "MULTILINE_OCTAL" {
+			var buf bytes.Buffer
+			for sc.Scan() && sc.Text() != "END" {
+				b, err := strconv.Unquote(`"` + sc.Text() + `"`)
+				if err != nil {
+					return nil, fmt.Errorf("malformed %s: %v", f[0], err)
+				}
+				buf.WriteString(b)
+			}
+			obj[f[0]] = buf.Bytes()
+		} else {
+			obj[f[0]] = []byte(strings.Join(f[1:], " "))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	parseDate := func(v []byte) (*time.Time, error) {
+		if v == nil || string(v) == "CK_BBOOL CK_FALSE" {
+			return nil, nil
+		}
+		t, err := time.Parse("060102150405Z0700", string(v))
+		if err != nil {
+			return nil, fmt.Errorf("malformed distrust after date: %v", err)
+		}
+		return &t, nil
+	}
+
+	certs := make(map[[sha1.Size]byte]*nssTrust)
+	fingerprints := make(map[[sha1.Size]byte][32]byte)
+	for _, obj := range objects {
+		if string(obj["CKA_CLASS"]) != "CK_OBJECT_CLASS CKO_CERTIFICATE" {
+			continue
+		}
+		if string(obj["CKA_NSS_MOZILLA_CA_POLICY"]) == "CK_BBOOL CK_FALSE" {
+			continue
+		}
+		der := obj["CKA_VALUE"]
+		if der == nil {
+			return nil, fmt.Errorf("certificate object without CKA_VALUE")
+		}
+		t := &nssTrust{}
+		var err error
+		if t.ServerDistrustAfter, err = parseDate(obj["CKA_NSS_SERVER_DISTRUST_AFTER"]); err != nil {
+			return nil, err
+		}
+		if t.EmailDistrustAfter, err = parseDate(obj["CKA_NSS_EMAIL_DISTRUST_AFTER"]); err != nil {
+			return nil, err
+		}
+		h := sha1.Sum(der)
+		certs[h] = t
+		fingerprints[h] = sha256.Sum256(der)
+	}
+
+	trust := make(map[[32]byte]*nssTrust)
+	for _, obj := range objects {
+		if string(obj["CKA_CLASS"]) != "CK_OBJECT_CLASS CKO_NSS_TRUST" {
+			continue
+		}
+		h := [sha1.Size]byte(obj["CKA_CERT_SHA1_HASH"])
+		t, ok := certs[h]
+		if !ok {
+			continue
+		}
+		const delegator = "CK_TRUST CKT_NSS_TRUSTED_DELEGATOR"
+		t.ServerAuth = string(obj["CKA_TRUST_SERVER_AUTH"]) == delegator
+		t.EmailProtection = string(obj["CKA_TRUST_EMAIL_PROTECTION"]) == delegator
+		trust[fingerprints[h]] = t
+	}
+	return trust, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A manifest records the provenance of a build: the snapshots it was built
+// from, and for each root which programs include it, and with what trust.
+type manifest struct {
+	Sources []source        `json:"sources"`
+	Roots   []*manifestRoot `json:"roots"`
+}
+
+type manifestRoot struct {
+	// SHA256 is the hex-encoded fingerprint of the certificate.
+	SHA256   string    `json:"sha256"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	// Programs is keyed by "mozilla", "chrome", "apple", or "extra".
+	Programs map[string]*programTrust `json:"programs"`
+}
+
+// programTrust is the trust a root program assigns to a root. The Chrome Root
+// Store is only for server authentication. The Apple list doesn't specify the
+// trust bits, so Apple roots have an empty programTrust.
+type programTrust struct {
+	ServerAuth          bool       `json:"server_auth,omitempty"`
+	EmailProtection     bool       `json:"email_protection,omitempty"`
+	ServerDistrustAfter *time.Time `json:"server_distrust_after,omitempty"`
+	EmailDistrustAfter  *time.Time `json:"email_distrust_after,omitempty"`
+}
+
+func (t *programTrust) String() string {
+	var parts []string
+	if t.ServerAuth {
+		parts = append(parts, "server")
+	}
+	if t.EmailProtection {
+		parts = append(parts, "email")
+	}
+	if t.ServerDistrustAfter != nil {
+		parts = append(parts, "server distrust after "+t.ServerDistrustAfter.Format(time.DateOnly))
+	}
+	if t.EmailDistrustAfter != nil {
+		parts = append(parts, "email distrust after "+t.EmailDistrustAfter.Format(time.DateOnly))
+	}
+	if len(parts) == 0 {
+		return "included"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (r *manifestRoot) programs() string {
+	var names []string
+	for name := range r.Programs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// diffManifests summarizes the changes from old to new, one per line: "+" for
+// added roots, "-" for removed roots, and "~" for roots whose programs or trust
+// changed. Changed source snapshots are listed first.
+func diffManifests(old, new *manifest) []string {
+	var lines []string
+	for _, n := range new.Sources {
+		for _, o := range old.Sources {
+			if o.Name == n.Name && o.SHA256 != n.SHA256 {
+				lines = append(lines, fmt.Sprintf("source %s: %s -> %s", n.Name, o.SHA256, n.SHA256))
+			}
+		}
+	}
+
+	oldRoots := make(map[string]*manifestRoot)
+	for _, r := range old.Roots {
+		oldRoots[r.SHA256] = r
+	}
+	newRoots := make(map[string]*manifestRoot)
+	for _, r := range new.Roots {
+		newRoots[r.SHA256] = r
+	}
+	for _, r := range old.Roots {
+		if _, ok := newRoots[r.SHA256]; !ok {
+			lines = append(lines, fmt.Sprintf("- %s (%s) [%s]", r.Subject, r.SHA256, r.programs()))
+		}
+	}
+	for _, r := range new.Roots {
+		o, ok := oldRoots[r.SHA256]
+		if !ok {
+			lines = append(lines, fmt.Sprintf("+ %s (%s) [%s]", r.Subject, r.SHA256, r.programs()))
+			continue
+		}
+		var names []string
+		for name := range r.Programs {
+			names = append(names, name)
+		}
+		for name := range o.Programs {
+			if _, ok := r.Programs[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			ot, nt := o.Programs[name], r.Programs[name]
+			switch {
+			case ot == nil:
+				lines = append(lines, fmt.Sprintf("~ %s (%s): added to %s (%v)", r.Subject, r.SHA256, name, nt))
+			case nt == nil:
+				lines = append(lines, fmt.Sprintf("~ %s (%s): removed from %s", r.Subject, r.SHA256, name))
+			case ot.String() != nt.String():
+				lines = append(lines, fmt.Sprintf("~ %s (%s): %s trust changed from %v to %v", r.Subject, r.SHA256, name, ot, nt))
+			}
+		}
+	}
+	return lines
+}
@@ -1,14 +1,25 @@
+// Command allroots builds a bundle of the roots trusted by the Mozilla, Chrome,
+// and Apple root programs, plus a few extra roots.
+//
+// The inputs are snapshotted in the library directory, along with their
+// hashes, by "allroots fetch". Without arguments, allroots builds the bundle
+// from the snapshots, without network access, and writes it to stdout. With
+// -manifest, it also writes a JSON manifest recording which programs include
+// each root, and with what trust. "allroots diff old.json new.json" summarizes
+// the changes between two manifests.
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -25,22 +36,116 @@ const AppleRoots = "https://support.apple.com/en-us/121672"
 var AppleFingerprintRegex = regexp.MustCompile(`([0-9A-F][0-9A-F] ){31}[0-9A-F][0-9A-F]`)
 var GoogleFingerprintRegex = regexp.MustCompile(`[0-9a-f]{64}`)
 
+// minProgramRoots is the minimum number of roots expected from the Google and
+// Apple lists, to detect format changes that break the regexps.
+var minProgramRoots = 50
+
 func main() {
-	res, err := http.Get(NSSCertdata)
+	libraryFlag := flag.String("library", "library", "`directory` of the snapshots and of the extra certificates")
+	extraFlag := flag.String("extra", "extra.pem", "PEM `file` of extra roots, copied verbatim to the output")
+	manifestFlag := flag.String("manifest", "", "write the JSON manifest of the build to `file`")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: allroots [-library dir] [-extra file] [-manifest file] > roots.pem")
+		fmt.Fprintln(os.Stderr, "       allroots [-library dir] fetch")
+		fmt.Fprintln(os.Stderr, "       allroots diff old.json new.json")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "":
+		var out bytes.Buffer
+		m, err := build(*libraryFlag, *extraFlag, &out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *manifestFlag != "" {
+			j, err := json.MarshalIndent(m, "", "\t")
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := os.WriteFile(*manifestFlag, append(j, '\n'), 0o644); err != nil {
+				log.Fatal(err)
+			}
+		}
+		os.Stdout.Write(out.Bytes())
+	case "fetch":
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		if err := fetchSources(*libraryFlag); err != nil {
+			log.Fatal(err)
+		}
+	case "diff":
+		if flag.NArg() != 3 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		old, err := readManifest(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		new, err := readManifest(flag.Arg(2))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range diffManifests(old, new) {
+			fmt.Println(line)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+func readManifest(path string) (*manifest, error) {
+	j, err := os.ReadFile(path)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(j, m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
 	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		log.Fatalf("failed to get roots: %s", res.Status)
+	return m, nil
+}
+
+// build writes the bundle to w, from the snapshots and the *.crt certificates
+// in the library directory, and from the extra roots file.
+func build(library, extraFile string, w io.Writer) (*manifest, error) {
+	contents, srcs, err := readSources(library)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{Sources: srcs}
+	roots := make(map[[32]byte]*manifestRoot)
+	addRoot := func(c *x509.Certificate, program string, trust *programTrust) {
+		fingerprint := sha256.Sum256(c.Raw)
+		r, ok := roots[fingerprint]
+		if !ok {
+			r = &manifestRoot{
+				SHA256:   hex.EncodeToString(fingerprint[:]),
+				Subject:  c.Subject.String(),
+				NotAfter: c.NotAfter,
+				Programs: make(map[string]*programTrust),
+			}
+			roots[fingerprint] = r
+			m.Roots = append(m.Roots, r)
+		}
+		r.Programs[program] = trust
 	}
 
-	certs, err := nss.Parse(res.Body)
+	certs, err := nss.Parse(bytes.NewReader(contents["mozilla"]))
 	if err != nil {
-		log.Fatalf("failed to parse certdata: %v", err)
+		return nil, fmt.Errorf("failed to parse certdata: %v", err)
 	}
 	if len(certs) == 0 {
-		log.Fatal("certdata.txt appears to contain zero roots")
+		return nil, fmt.Errorf("certdata.txt appears to contain zero roots")
+	}
+	nssTrust, err := parseNSSTrust(bytes.NewReader(contents["mozilla"]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certdata trust: %v", err)
 	}
 
 	sort.Slice(certs, func(i, j int) bool {
@@ -51,131 +156,120 @@ func main() {
 		return string(certs[i].X509.Raw) < string(certs[j].X509.Raw)
 	})
 
-	fmt.Println("Mozilla Roots")
-	fmt.Println("=============")
-	fmt.Println("")
+	fmt.Fprintln(w, "Mozilla Roots")
+	fmt.Fprintln(w, "=============")
+	fmt.Fprintln(w, "")
 
 	rootsByFingerprint := make(map[[32]byte][]byte)
 	for _, c := range certs {
 		fingerprint := sha256.Sum256(c.X509.Raw)
 		rootsByFingerprint[fingerprint] = c.X509.Raw
-		fmt.Printf("# %s\n# %X\n# from Mozilla root program\n%s\n", c.X509.Subject, fingerprint,
+		fmt.Fprintf(w, "# %s\n# %X\n# from Mozilla root program\n%s\n", c.X509.Subject, fingerprint,
 			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.X509.Raw}))
+		t, ok := nssTrust[fingerprint]
+		if !ok {
+			return nil, fmt.Errorf("missing trust for Mozilla root %X", fingerprint)
+		}
+		addRoot(c.X509, "mozilla", &programTrust{
+			ServerAuth:          t.ServerAuth,
+			EmailProtection:     t.EmailProtection,
+			ServerDistrustAfter: t.ServerDistrustAfter,
+			EmailDistrustAfter:  t.EmailDistrustAfter,
+		})
 	}
 
 	localRootsByFingerprint := make(map[[32]byte][]byte)
-	files, err := filepath.Glob("library/*.crt")
+	files, err := filepath.Glob(filepath.Join(library, "*.crt"))
 	if err != nil {
-		log.Fatalf("failed to read certificate files: %v", err)
+		return nil, fmt.Errorf("failed to read certificate files: %v", err)
 	}
 	for _, file := range files {
 		data, err := os.ReadFile(file)
 		if err != nil {
-			log.Fatalf("failed to read %s: %v", file, err)
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
 		}
 		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("failed to decode PEM from %s", file)
+		}
 		cert, err := x509.ParseCertificate(block.Bytes)
 		if err != nil {
-			log.Fatalf("failed to parse certificate from %s: %v", file, err)
+			return nil, fmt.Errorf("failed to parse certificate from %s: %v", file, err)
 		}
 		fingerprint := sha256.Sum256(cert.Raw)
 		localRootsByFingerprint[fingerprint] = cert.Raw
 	}
 
-	fmt.Println("Google Roots")
-	fmt.Println("============")
-	fmt.Println("")
-
-	res, err = http.Get(GoogleRoots)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		log.Fatalf("failed to get roots: %s", res.Status)
-	}
-	googleRoots, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Fatal(err)
+	programs := []struct {
+		name, title string
+		regexp      *regexp.Regexp
+		trust       programTrust
+	}{
+		{"chrome", "Google", GoogleFingerprintRegex, programTrust{ServerAuth: true}},
+		{"apple", "Apple", AppleFingerprintRegex, programTrust{}},
 	}
+	for _, p := range programs {
+		header := p.title + " Roots"
+		fmt.Fprintln(w, header)
+		fmt.Fprintln(w, strings.Repeat("=", len(header)))
+		fmt.Fprintln(w, "")
 
-	matches := GoogleFingerprintRegex.FindAllString(string(googleRoots), -1)
-	if len(matches) < 50 {
-		log.Fatalf("expected at least 50 Google roots, got %d", len(matches))
-	}
-	for _, h := range matches {
-		f, err := hex.DecodeString(h)
-		if err != nil {
-			log.Fatal(err)
-		}
-		fingerprint := [32]byte(f)
-		if _, ok := rootsByFingerprint[fingerprint]; ok {
-			continue
+		matches := p.regexp.FindAllString(string(contents[p.name]), -1)
+		if len(matches) < minProgramRoots {
+			return nil, fmt.Errorf("expected at least %d %s roots, got %d", minProgramRoots, p.title, len(matches))
 		}
-		if der, ok := localRootsByFingerprint[fingerprint]; ok {
-			c, err := x509.ParseCertificate(der)
+		for _, h := range matches {
+			f, err := hex.DecodeString(strings.ReplaceAll(h, " ", ""))
 			if err != nil {
-				log.Fatalf("failed to parse certificate: %v", err)
+				return nil, err
+			}
+			fingerprint := [32]byte(f)
+			if der, ok := rootsByFingerprint[fingerprint]; ok {
+				c, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse certificate: %v", err)
+				}
+				trust := p.trust
+				addRoot(c, p.name, &trust)
+				continue
 			}
-			fmt.Printf("# %s\n# %X\n# from Google root program\n%s\n", c.Subject, fingerprint,
-				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
-			rootsByFingerprint[fingerprint] = der
-			continue
+			if der, ok := localRootsByFingerprint[fingerprint]; ok {
+				c, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse certificate: %v", err)
+				}
+				fmt.Fprintf(w, "# %s\n# %X\n# from %s root program\n%s\n", c.Subject, fingerprint, p.title,
+					pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+				rootsByFingerprint[fingerprint] = der
+				trust := p.trust
+				addRoot(c, p.name, &trust)
+				continue
+			}
+			return nil, fmt.Errorf("missing root https://crt.sh/?q=%X", fingerprint)
 		}
-		log.Fatalf("missing root https://crt.sh/?q=%X", fingerprint)
 	}
 
-	fmt.Println("Apple Roots")
-	fmt.Println("===========")
-	fmt.Println("")
+	fmt.Fprintln(w, "Extra Roots")
+	fmt.Fprintln(w, "===========")
+	fmt.Fprintln(w, "")
 
-	res, err = http.Get(AppleRoots)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		log.Fatalf("failed to get roots: %s", res.Status)
-	}
-	appleRoots, err := io.ReadAll(res.Body)
+	extra, err := os.ReadFile(extraFile)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to read extra roots: %v", err)
 	}
-
-	matches = AppleFingerprintRegex.FindAllString(string(appleRoots), -1)
-	if len(matches) < 50 {
-		log.Fatalf("expected at least 50 Apple roots, got %d", len(matches))
-	}
-	for _, h := range matches {
-		f, err := hex.DecodeString(strings.ReplaceAll(h, " ", ""))
-		if err != nil {
-			log.Fatal(err)
+	for rest := extra; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
 		}
-		fingerprint := [32]byte(f)
-		if _, ok := rootsByFingerprint[fingerprint]; ok {
-			continue
-		}
-		if der, ok := localRootsByFingerprint[fingerprint]; ok {
-			c, err := x509.ParseCertificate(der)
-			if err != nil {
-				log.Fatalf("failed to parse certificate: %v", err)
-			}
-			fmt.Printf("# %s\n# %X\n# from Apple root program\n%s\n", c.Subject, fingerprint,
-				pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
-			rootsByFingerprint[fingerprint] = der
-			continue
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse extra root: %v", err)
 		}
-		log.Fatalf("missing root https://crt.sh/?q=%X", fingerprint)
+		addRoot(c, "extra", &programTrust{})
 	}
+	w.Write(extra)
 
-	fmt.Println("Extra Roots")
-	fmt.Println("===========")
-	fmt.Println("")
-
-	extra, err := os.Open("extra.pem")
-	if err != nil {
-		log.Fatalf("failed to open extra.pem: %v", err)
-	}
-	defer extra.Close()
-	io.Copy(os.Stdout, extra)
+	return m, nil
 }
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	minProgramRoots = 1
+}
+
+func newRoot(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func octal(b []byte) string {
+	var s strings.Builder
+	for i, c := range b {
+		fmt.Fprintf(&s, "\\%03o", c)
+		if i%16 == 15 {
+			s.WriteString("\n")
+		}
+	}
+	if len(b)%16 != 0 {
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+type nssEntry struct {
+	cert                               *x509.Certificate
+	server, email                      bool
+	serverDistrustAfter, emailDistrust string
+}
+
+func writeCertdata(entries []nssEntry) []byte {
+	var b bytes.Buffer
+	b.WriteString("# Test certdata.txt\nBEGINDATA\n\n")
+	trust := func(ok bool) string {
+		if ok {
+			return "CKT_NSS_TRUSTED_DELEGATOR"
+		}
+		return "CKT_NSS_MUST_VERIFY_TRUST"
+	}
+	distrust := func(attr, date string) {
+		if date == "" {
+			fmt.Fprintf(&b, "%s CK_BBOOL CK_FALSE\n", attr)
+		} else {
+			fmt.Fprintf(&b, "%s MULTILINE_OCTAL\n%sEND\n", attr, octal([]byte(date)))
+		}
+	}
+	for _, e := range entries {
+		fmt.Fprintf(&b, "# Certificate %q\n", e.cert.Subject.CommonName)
+		b.WriteString("CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\n")
+		b.WriteString("CKA_TOKEN CK_BBOOL CK_TRUE\n")
+		fmt.Fprintf(&b, "CKA_LABEL UTF8 %q\n", e.cert.Subject.CommonName)
+		b.WriteString("CKA_CERTIFICATE_TYPE CK_CERTIFICATE_TYPE CKC_X_509\n")
+		fmt.Fprintf(&b, "CKA_VALUE MULTILINE_OCTAL\n%sEND\n", octal(e.cert.Raw))
+		b.WriteString("CKA_NSS_MOZILLA_CA_POLICY CK_BBOOL CK_TRUE\n")
+		distrust("CKA_NSS_SERVER_DISTRUST_AFTER", e.serverDistrustAfter)
+		distrust("CKA_NSS_EMAIL_DISTRUST_AFTER", e.emailDistrust)
+		b.WriteString("\n")
+		h := sha1.Sum(e.cert.Raw)
+		fmt.Fprintf(&b, "# Trust for %q\n", e.cert.Subject.CommonName)
+		b.WriteString("CKA_CLASS CK_OBJECT_CLASS CKO_NSS_TRUST\n")
+		fmt.Fprintf(&b, "CKA_CERT_SHA1_HASH MULTILINE_OCTAL\n%sEND\n", octal(h[:]))
+		fmt.Fprintf(&b, "CKA_TRUST_SERVER_AUTH CK_TRUST %s\n", trust(e.server))
+		fmt.Fprintf(&b, "CKA_TRUST_EMAIL_PROTECTION CK_TRUST %s\n", trust(e.email))
+		b.WriteString("CKA_TRUST_STEP_UP_APPROVED CK_BBOOL CK_FALSE\n\n")
+	}
+	return b.Bytes()
+}
+
+func fingerprint(c *x509.Certificate) string {
+	h := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(h[:])
+}
+
+func appleFingerprint(c *x509.Certificate) string {
+	h := sha256.Sum256(c.Raw)
+	return strings.TrimSpace(fmt.Sprintf("% X", h[:]))
+}
+
+type testRoots struct {
+	both, server, email, chrome, apple, extra *x509.Certificate
+}
+
+// writeLibrary writes snapshots of the sources to a new library directory,
+// with their hashes, and returns it and the path of the extra roots file.
+func writeLibrary(t *testing.T, r *testRoots, certdata []byte, chrome, apple []*x509.Certificate) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string][]byte{"certdata.txt": certdata}
+	var md bytes.Buffer
+	md.WriteString("# Chrome Root Store\n\n| Subject | SHA-256 |\n|---|---|\n")
+	for _, c := range chrome {
+		fmt.Fprintf(&md, "| %s | %s |\n", c.Subject.CommonName, fingerprint(c))
+	}
+	files["chrome_root_store.md"] = md.Bytes()
+	var html bytes.Buffer
+	html.WriteString("<html><table>\n")
+	for _, c := range apple {
+		fmt.Fprintf(&html, "<tr><td>%s</td><td>%s</td></tr>\n", c.Subject.CommonName, appleFingerprint(c))
+	}
+	html.WriteString("</table></html>\n")
+	files["apple_roots.html"] = html.Bytes()
+
+	var sums bytes.Buffer
+	for _, s := range sources {
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(files[s.File]), s.File)
+	}
+	files[sumsFile] = sums.Bytes()
+	for _, c := range []*x509.Certificate{r.chrome, r.apple} {
+		files[c.Subject.CommonName+".crt"] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	extra := filepath.Join(t.TempDir(), "extra.pem")
+	extraPEM := "# Extra Root\n" + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.extra.Raw}))
+	if err := os.WriteFile(extra, []byte(extraPEM), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir, extra
+}
+
+func newTestRoots(t *testing.T) *testRoots {
+	return &testRoots{
+		both:   newRoot(t, "Both Root"),
+		server: newRoot(t, "Server Root"),
+		email:  newRoot(t, "Email Root"),
+		chrome: newRoot(t, "Chrome Root"),
+		apple:  newRoot(t, "Apple Root"),
+		extra:  newRoot(t, "Extra Root"),
+	}
+}
+
+func (r *testRoots) certdata() []byte {
+	return writeCertdata([]nssEntry{
+		{cert: r.both, server: true, email: true, emailDistrust: "250401000000Z"},
+		{cert: r.server, server: true, serverDistrustAfter: "241130235959Z"},
+		{cert: r.email, email: true},
+	})
+}
+
+func TestBuild(t *testing.T) {
+	r := newTestRoots(t)
+	library, extra := writeLibrary(t, r, r.certdata(),
+		[]*x509.Certificate{r.both, r.chrome}, []*x509.Certificate{r.server, r.apple, r.chrome})
+
+	var out bytes.Buffer
+	m, err := build(library, extra, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var subjects []string
+	for rest := out.Bytes(); ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subjects = append(subjects, c.Subject.CommonName)
+	}
+	if got, want := strings.Join(subjects, ", "), "Both Root, Server Root, Chrome Root, Apple Root, Extra Root"; got != want {
+		t.Errorf("bundle roots = %s, want %s", got, want)
+	}
+	for _, s := range []string{"Mozilla Roots\n", "# from Google root program\n", "Apple Roots\n", "# Extra Root\n"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("bundle does not contain %q", s)
+		}
+	}
+
+	var out2 bytes.Buffer
+	if _, err := build(library, extra, &out2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), out2.Bytes()) {
+		t.Errorf("build is not deterministic")
+	}
+
+	if len(m.Sources) != len(sources) {
+		t.Errorf("manifest has %d sources, want %d", len(m.Sources), len(sources))
+	}
+	for _, s := range m.Sources {
+		data, err := os.ReadFile(filepath.Join(library, s.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h := sha256.Sum256(data); s.SHA256 != hex.EncodeToString(h[:]) {
+			t.Errorf("source %s has hash %s", s.Name, s.SHA256)
+		}
+	}
+
+	date := func(s string) *time.Time {
+		d, err := time.Parse("060102150405Z0700", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &d
+	}
+	want := map[string]map[string]*programTrust{
+		"Both Root": {
+			"mozilla": {ServerAuth: true, EmailProtection: true, EmailDistrustAfter: date("250401000000Z")},
+			"chrome":  {ServerAuth: true},
+		},
+		"Server Root": {
+			"mozilla": {ServerAuth: true, ServerDistrustAfter: date("241130235959Z")},
+			"apple":   {},
+		},
+		"Chrome Root": {"chrome": {ServerAuth: true}, "apple": {}},
+		"Apple Root":  {"apple": {}},
+		"Extra Root":  {"extra": {}},
+	}
+	if len(m.Roots) != len(want) {
+		t.Errorf("manifest has %d roots, want %d", len(m.Roots), len(want))
+	}
+	for _, root := range m.Roots {
+		name := strings.TrimPrefix(root.Subject, "CN=")
+		if !reflect.DeepEqual(root.Programs, want[name]) {
+			t.Errorf("%s: programs = %v, want %v", name, root.Programs, want[name])
+		}
+	}
+}
+
+func TestBuildHashMismatch(t *testing.T) {
+	r := newTestRoots(t)
+	library, extra := writeLibrary(t, r, r.certdata(),
+		[]*x509.Certificate{r.both, r.chrome}, []*x509.Certificate{r.apple})
+	if err := os.WriteFile(filepath.Join(library, "certdata.txt"),
+		writeCertdata([]nssEntry{{cert: r.both, server: true}}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := build(library, extra, new(bytes.Buffer)); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("build with modified snapshot: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(library, sumsFile)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := build(library, extra, new(bytes.Buffer)); err == nil {
+		t.Errorf("build without %s succeeded", sumsFile)
+	}
+}
+
+func TestBuildMissingRoot(t *testing.T) {
+	r := newTestRoots(t)
+	unknown := newRoot(t, "Unknown Root")
+	library, extra := writeLibrary(t, r, r.certdata(),
+		[]*x509.Certificate{r.both, unknown}, []*x509.Certificate{r.apple})
+	if _, err := build(library, extra, new(bytes.Buffer)); err == nil || !strings.Contains(err.Error(), "missing root") {
+		t.Errorf("build with unknown Chrome root: %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	r := newTestRoots(t)
+	library, extra := writeLibrary(t, r, r.certdata(),
+		[]*x509.Certificate{r.both, r.chrome}, []*x509.Certificate{r.server, r.apple})
+	old, err := build(library, extra, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both Root loses email trust, Server Root leaves Mozilla but is still in
+	// the Apple list, Chrome Root is removed, and Email Root gains server trust.
+	certdata := writeCertdata([]nssEntry{
+		{cert: r.both, server: true},
+		{cert: r.email, server: true, email: true},
+	})
+	library, _ = writeLibrary(t, r, certdata,
+		[]*x509.Certificate{r.both}, []*x509.Certificate{r.server, r.apple})
+	if err := os.WriteFile(filepath.Join(library, r.server.Subject.CommonName+".crt"),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.server.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	new, err := build(library, extra, new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := diffManifests(old, new)
+	var sourceLines, rootLines []string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "source ") {
+			sourceLines = append(sourceLines, l)
+		} else {
+			rootLines = append(rootLines, l)
+		}
+	}
+	if len(sourceLines) != 2 {
+		t.Errorf("expected the mozilla and chrome sources to change, got %q", sourceLines)
+	}
+	want := []string{
+		fmt.Sprintf("- CN=Chrome Root (%s) [chrome]", fingerprint(r.chrome)),
+		fmt.Sprintf("~ CN=Both Root (%s): mozilla trust changed from server, email, email distrust after 2025-04-01 to server", fingerprint(r.both)),
+		fmt.Sprintf("+ CN=Email Root (%s) [mozilla]", fingerprint(r.email)),
+		fmt.Sprintf("~ CN=Server Root (%s): removed from mozilla", fingerprint(r.server)),
+	}
+	if !reflect.DeepEqual(rootLines, want) {
+		t.Errorf("diff =\n%s\nwant\n%s", strings.Join(rootLines, "\n"), strings.Join(want, "\n"))
+	}
+
+	if lines := diffManifests(new, new); len(lines) != 0 {
+		t.Errorf("diff of identical manifests: %q", lines)
+	}
+}
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("network access during offline build: %s", req.URL)
+}
+
+// TestBuildLibrary builds the bundle from the committed library snapshots, like
+// allroots does without arguments, with network access disabled.
+func TestBuildLibrary(t *testing.T) {
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = offlineTransport{}
+	t.Cleanup(func() { http.DefaultTransport = defaultTransport })
+
+	m, err := build("library", "extra.pem", new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sources) != len(sources) {
+		t.Errorf("manifest has %d sources, want %d", len(m.Sources), len(sources))
+	}
+	counts := make(map[string]int)
+	for _, r := range m.Roots {
+		for name := range r.Programs {
+			counts[name]++
+		}
+	}
+	for _, name := range []string{"mozilla", "chrome", "apple"} {
+		if counts[name] < 50 {
+			t.Errorf("%s has %d roots, want at least 50", name, counts[name])
+		}
+	}
+}
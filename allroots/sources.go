@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A source is an input of the root store, snapshotted in the library directory
+// by "allroots fetch" so that builds are reproducible and offline.
+type source struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	File string `json:"file"`
+	// SHA256 is the hex-encoded hash of the snapshot, recorded in the
+	// SHA256SUMS file of the library.
+	SHA256 string `json:"sha256"`
+}
+
+var sources = []source{
+	{Name: "mozilla", URL: NSSCertdata, File: "certdata.txt"},
+	{Name: "chrome", URL: GoogleRoots, File: "chrome_root_store.md"},
+	{Name: "apple", URL: AppleRoots, File: "apple_roots.html"},
+}
+
+// sumsFile is the name of the file in the library directory that records the
+// hashes of the snapshots, in the format of sha256sum(1).
+const sumsFile = "SHA256SUMS"
+
+// fetchSources downloads all sources into dir, and records their hashes.
+func fetchSources(dir string) error {
+	var sums bytes.Buffer
+	for _, s := range sources {
+		res, err := http.Get(s.URL)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to fetch %s: %v", s.URL, err)
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to fetch %s: %s", s.URL, res.Status)
+		}
+		if err := os.WriteFile(filepath.Join(dir, s.File), data, 0o644); err != nil {
+			return err
+		}
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(data), s.File)
+	}
+	return os.WriteFile(filepath.Join(dir, sumsFile), sums.Bytes(), 0o644)
+}
+
+// readSources reads the snapshots from dir, checking them against the hashes
+// in its SHA256SUMS file. It returns the contents of each source by name, and
+// the sources with their hashes.
+func readSources(dir string) (map[string][]byte, []source, error) {
+	sumsData, err := os.ReadFile(filepath.Join(dir, sumsFile))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read snapshot hashes (run \"allroots fetch\"): %v", err)
+	}
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(bytes.NewReader(sumsData))
+	for sc.Scan() {
+		hash, file, ok := strings.Cut(sc.Text(), "  ")
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed %s line: %q", sumsFile, sc.Text())
+		}
+		sums[file] = hash
+	}
+
+	contents := make(map[string][]byte)
+	var out []source
+	for _, s := range sources {
+		data, err := os.ReadFile(filepath.Join(dir, s.File))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s snapshot: %v", s.Name, err)
+		}
+		h := sha256.Sum256(data)
+		if want, ok := sums[s.File]; !ok {
+			return nil, nil, fmt.Errorf("%s snapshot %s is missing from %s", s.Name, s.File, sumsFile)
+		} else if hex.EncodeToString(h[:]) != want {
+			return nil, nil, fmt.Errorf("%s snapshot %s does not match its hash in %s", s.Name, s.File, sumsFile)
+		}
+		s.SHA256 = hex.EncodeToString(h[:])
+		contents[s.Name] = data
+		out = append(out, s)
+	}
+	return contents, out, nil
+}
@@ -0,0 +1,259 @@
+package amend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	printableString = 0x13
+	utf8String      = 0x0c
+)
+
+// makeName encodes the Name "CN=Test Root", with the common name's string value
+// using the given ASN.1 string tag.
+func makeName(stringTag byte) []byte {
+	cn := "Test Root"
+	value := append([]byte{stringTag, byte(len(cn))}, cn...)
+	commonNameOID := []byte{0x06, 0x03, 0x55, 0x04, 0x03} // 2.5.4.3
+	atv := der(0x30, append(commonNameOID, value...))     // AttributeTypeAndValue
+	rdn := der(0x31, atv)                                 // RelativeDistinguishedName (SET)
+	return der(0x30, rdn)                                 // RDNSequence (Name)
+}
+
+// der wraps content in a short-form DER element with the given tag.
+func der(tag byte, content []byte) []byte {
+	if len(content) > 127 {
+		panic("der: long-form length not supported")
+	}
+	return append([]byte{tag, byte(len(content))}, content...)
+}
+
+// mismatchedChain returns a root with a UTF8String common name, and a leaf
+// signed by its key whose Issuer uses a PrintableString.
+func mismatchedChain(t *testing.T) (root, leaf *x509.Certificate) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(tmpl, parent *x509.Certificate, pub any) *x509.Certificate {
+		tmpl.NotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		tmpl.NotAfter = time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, rootKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	rootTmpl := func(name []byte) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(1), RawSubject: name,
+			IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	}
+	oldRoot := create(rootTmpl(makeName(printableString)), rootTmpl(makeName(printableString)), &rootKey.PublicKey)
+	root = create(rootTmpl(makeName(utf8String)), rootTmpl(makeName(utf8String)), &rootKey.PublicKey)
+	leaf = create(&x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "leaf.example"}},
+		oldRoot, &leafKey.PublicKey)
+	return root, leaf
+}
+
+func TestAmendIssuer(t *testing.T) {
+	root, leaf := mismatchedChain(t)
+
+	if _, err := AmendIssuer(leaf, root); err == nil {
+		t.Error("AmendIssuer succeeded with swapped inputs")
+	}
+
+	amended, err := AmendIssuer(root, leaf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(amended.RawSubject, leaf.RawIssuer) || !bytes.Equal(amended.RawIssuer, leaf.RawIssuer) {
+		t.Error("amended names do not match the leaf Issuer")
+	}
+	if !amended.PublicKey.(*ecdsa.PublicKey).Equal(root.PublicKey) {
+		t.Error("amended certificate did not preserve the issuer's public key")
+	}
+	if !bytes.Equal(amended.RawSubjectPublicKeyInfo, root.RawSubjectPublicKeyInfo) ||
+		amended.SerialNumber.Cmp(root.SerialNumber) != 0 || !amended.NotAfter.Equal(root.NotAfter) {
+		t.Error("amended certificate did not preserve the issuer's fields")
+	}
+	if len(amended.Signature) != 0 || amended.SignatureAlgorithm != x509.UnknownSignatureAlgorithm {
+		t.Error("amended certificate is signed")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	root, leaf := mismatchedChain(t)
+	opts := x509.VerifyOptions{
+		CurrentTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	chains, amendments, err := Verify(leaf, []*x509.Certificate{root}, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(amendments) != 1 || !amendments[0].Issuer.Equal(root) || !amendments[0].Child.Equal(leaf) {
+		t.Fatalf("unexpected amendments: %v", amendments)
+	}
+	if len(chains) != 1 || len(chains[0]) != 2 || !chains[0][1].Equal(amendments[0].Amended) {
+		t.Errorf("unexpected chains: %v", chains)
+	}
+	if s := amendments[0].String(); !strings.Contains(s, "root") || !strings.Contains(s, "leaf.example") {
+		t.Errorf("unexpected explanation: %s", s)
+	}
+
+	// A leaf that verifies without amendments.
+	chains, amendments, err = Verify(root, []*x509.Certificate{root}, nil, opts)
+	if err != nil || len(chains) != 1 || len(amendments) != 0 {
+		t.Errorf("Verify(root) = %v, %v, %v", chains, amendments, err)
+	}
+
+	// Errors other than an unknown authority are not retried.
+	expired := opts
+	expired.CurrentTime = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	if _, _, err := Verify(leaf, []*x509.Certificate{root}, nil, expired); err == nil {
+		t.Error("Verify succeeded with an expired chain")
+	}
+
+	// An unrelated root doesn't help.
+	other, _ := mismatchedChain(t)
+	if _, _, err := Verify(leaf, []*x509.Certificate{other}, nil, opts); err == nil {
+		t.Error("Verify succeeded with an unrelated root")
+	}
+}
+
+func TestVerifyRealChain(t *testing.T) {
+	parse := func(name string) *x509.Certificate {
+		b, err := os.ReadFile("../testdata/stm-tpm-ecc/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			t.Fatalf("%s: no PEM block", name)
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	globalSignRoot := parse("tpmeccroot.pem")
+	root01 := parse("stmtpmeccroot01.pem")
+	int02 := parse("stmtpmeccint02.pem")
+	golden := parse("amended.pem")
+
+	chains, amendments, err := Verify(int02, []*x509.Certificate{globalSignRoot},
+		[]*x509.Certificate{root01}, x509.VerifyOptions{
+			CurrentTime: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC),
+			KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(amendments) != 1 || !amendments[0].Amended.Equal(golden) {
+		t.Fatalf("unexpected amendments: %v", amendments)
+	}
+	if s := amendments[0].String(); !strings.Contains(s, "intermediate") {
+		t.Errorf("unexpected explanation: %s", s)
+	}
+	if len(chains) != 1 || len(chains[0]) != 2 || !chains[0][1].Equal(golden) {
+		t.Errorf("unexpected chains: %v", chains)
+	}
+}
+
+func TestAnchors(t *testing.T) {
+	var keys [3]*ecdsa.PrivateKey
+	for i := range keys {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = k
+	}
+	rootKey, interKey, leafKey := keys[0], keys[1], keys[2]
+	create := func(tmpl, parent *x509.Certificate, pub any, priv *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl.NotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		tmpl.NotAfter = time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	caTmpl := func(serial int64, name []byte) *x509.Certificate {
+		return &x509.Certificate{SerialNumber: big.NewInt(serial), RawSubject: name,
+			IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	}
+
+	// The intermediate has an Issuer mismatched with the root, and critical
+	// name constraints Go doesn't support, so it can only be an anchor once
+	// the root is amended, and ignoring the constraints.
+	root := create(caTmpl(1, makeName(utf8String)), caTmpl(1, makeName(utf8String)), &rootKey.PublicKey, rootKey)
+	dnsSubtree := der(0x30, der(0x82, []byte("example.com")))
+	dirSubtree := der(0x30, der(0xa4, name(rdn(atv(oidOrganization, "Example")))))
+	interTmpl := caTmpl(2, name(rdn(atv(oidCommonName, "Test Intermediate"))))
+	interTmpl.ExtraExtensions = []pkix.Extension{{Id: oidNameConstraints, Critical: true,
+		Value: der(0x30, der(0xa0, append(dnsSubtree, dirSubtree...)))}}
+	inter := create(interTmpl, caTmpl(1, makeName(printableString)), &interKey.PublicKey, rootKey)
+	leaf := create(&x509.Certificate{SerialNumber: big.NewInt(3), DNSNames: []string{"leaf.example.com"}},
+		inter, &leafKey.PublicKey, interKey)
+
+	candidates := []*x509.Certificate{root, inter, leaf}
+	interAmendments, err := Amendments(leaf, candidates, Subject|NameConstraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootAmendments, err := Amendments(inter, candidates, Subject|NameConstraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(interAmendments) != 1 || interAmendments[0].Fixes != NameConstraints ||
+		len(rootAmendments) != 1 || rootAmendments[0].Fixes != Subject {
+		t.Fatalf("unexpected amendments: %v, %v", interAmendments, rootAmendments)
+	}
+	if a, err := Amendments(leaf, candidates, Subject); err != nil || len(a) != 0 {
+		t.Errorf("Amendments(Subject) = %v, %v, want none", a, err)
+	}
+
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	roots := []*x509.Certificate{root}
+	if anchors := Anchors(interAmendments, roots, opts); len(anchors) != 0 {
+		t.Errorf("intermediate is an anchor without the amended root: %v", anchors)
+	}
+	// The intermediate is listed first, so it's only added on a second pass.
+	anchors := Anchors(append(interAmendments, rootAmendments...), roots, opts)
+	if len(anchors) != 2 || anchors[0] != rootAmendments[0] || anchors[1] != interAmendments[0] {
+		t.Fatalf("unexpected anchors: %v", anchors)
+	}
+	if _, err := verifyWithRoots(leaf, opts, roots, []*x509.Certificate{anchors[1].Amended}); err != nil {
+		t.Errorf("leaf does not verify against the amended intermediate: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package amend
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A Fix is a set of amendments to apply to an issuer certificate.
+//
+// Mis-encodings that make Go reject the issuer certificate while parsing it,
+// such as trailing data or invalid strings in extensions, can't be fixed by
+// amending a parsed certificate, and have no Fix.
+type Fix int
+
+const (
+	// Subject replaces the issuer's Subject with the exact encoding of the
+	// child's Issuer. This fixes any encoding difference between the two names,
+	// such as different string types, a different RDN ordering, or
+	// attributes grouped in a multi-valued RDN on one side only.
+	Subject Fix = 1 << iota
+
+	// KeyID replaces (or adds) the issuer's SubjectKeyIdentifier with the
+	// keyIdentifier of the child's AuthorityKeyIdentifier.
+	//
+	// Go only uses key identifiers to prioritize candidate issuers, but other
+	// verifiers, such as OpenSSL, reject mismatched issuers.
+	KeyID
+
+	// NameConstraints marks the issuer's name constraints extension as
+	// non-critical, if it is critical and includes name forms that Go doesn't
+	// support, such as directoryName, causing Go to reject any chain through
+	// the issuer. Go keeps enforcing the name forms it supports (DNS names, IP
+	// ranges, email addresses, and URI domains), but the others are ignored.
+	NameConstraints
+)
+
+func (f Fix) String() string {
+	var names []string
+	for _, fix := range []struct {
+		f    Fix
+		name string
+	}{{Subject, "Subject"}, {KeyID, "KeyID"}, {NameConstraints, "NameConstraints"}} {
+		if f&fix.f != 0 {
+			names = append(names, fix.name)
+			f &^= fix.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("Fix(%#x)", int(f)))
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// Needed returns the fixes that would change how the child's relationship to
+// the issuer is evaluated by Go or other verifiers. It doesn't check that the
+// issuer signed the child.
+func Needed(issuer, child *x509.Certificate) Fix {
+	var f Fix
+	if !bytes.Equal(issuer.RawSubject, child.RawIssuer) {
+		f |= Subject
+	}
+	if len(issuer.SubjectKeyId) > 0 && len(child.AuthorityKeyId) > 0 &&
+		!bytes.Equal(issuer.SubjectKeyId, child.AuthorityKeyId) {
+		f |= KeyID
+	}
+	if hasUnhandledNameConstraints(issuer) {
+		f |= NameConstraints
+	}
+	return f
+}
+
+var (
+	oidSubjectKeyID    = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidNameConstraints = asn1.ObjectIdentifier{2, 5, 29, 30}
+)
+
+func hasUnhandledNameConstraints(c *x509.Certificate) bool {
+	for _, oid := range c.UnhandledCriticalExtensions {
+		if oid.Equal(oidNameConstraints) {
+			return true
+		}
+	}
+	return false
+}
+
+// An extensionEdit modifies the list of extensions of a certificate.
+type extensionEdit func(exts []pkix.Extension) ([]pkix.Extension, error)
+
+func replaceSubjectKeyID(keyID []byte) extensionEdit {
+	return func(exts []pkix.Extension) ([]pkix.Extension, error) {
+		// SubjectKeyIdentifier ::= KeyIdentifier ::= OCTET STRING
+		value, err := asn1.Marshal(keyID)
+		if err != nil {
+			return nil, err
+		}
+		for i := range exts {
+			if exts[i].Id.Equal(oidSubjectKeyID) {
+				exts[i].Value = value
+				return exts, nil
+			}
+		}
+		return append(exts, pkix.Extension{Id: oidSubjectKeyID, Value: value}), nil
+	}
+}
+
+func nonCriticalNameConstraints(exts []pkix.Extension) ([]pkix.Extension, error) {
+	for i := range exts {
+		if exts[i].Id.Equal(oidNameConstraints) {
+			exts[i].Critical = false
+			return exts, nil
+		}
+	}
+	return nil, errors.New("issuer has no name constraints extension")
+}
+
+// editExtensions applies edits to the extensions in the optional trailing
+// fields of a TBSCertificate. Extensions that are not modified keep their
+// original encoding.
+func editExtensions(extra []byte, edits []extensionEdit) ([]byte, error) {
+	// ... issuerUniqueID [1] IMPLICIT OPTIONAL,
+	//     subjectUniqueID [2] IMPLICIT OPTIONAL,
+	//     extensions [3] EXPLICIT Extensions OPTIONAL }
+	var before, extsField []byte
+	for rest := extra; len(rest) > 0; {
+		element, r, err := next(rest)
+		if err != nil {
+			return nil, err
+		}
+		if element[0] == 0xA3 {
+			extsField = element
+			if len(r) != 0 {
+				return nil, errors.New("malformed tbsCertificate: trailing data after extensions")
+			}
+			break
+		}
+		before = append(before, element...)
+		rest = r
+	}
+
+	var raw []asn1.RawValue
+	if extsField != nil {
+		var field asn1.RawValue
+		if _, err := asn1.Unmarshal(extsField, &field); err != nil {
+			return nil, fmt.Errorf("malformed extensions: %w", err)
+		}
+		rest, err := asn1.Unmarshal(field.Bytes, &raw)
+		if err != nil || len(rest) != 0 {
+			return nil, fmt.Errorf("malformed extensions: %v", err)
+		}
+	}
+	exts := make([]pkix.Extension, len(raw))
+	for i, r := range raw {
+		if _, err := asn1.Unmarshal(r.FullBytes, &exts[i]); err != nil {
+			return nil, fmt.Errorf("malformed extension: %w", err)
+		}
+	}
+
+	for _, edit := range edits {
+		var err error
+		if exts, err = edit(slices.Clone(exts)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Edits only modify extensions in place or append new ones, so the
+	// unchanged ones can be copied with their original encoding.
+	var body []byte
+	for i, e := range exts {
+		if i < len(raw) {
+			var orig pkix.Extension
+			asn1.Unmarshal(raw[i].FullBytes, &orig)
+			if orig.Id.Equal(e.Id) && orig.Critical == e.Critical && bytes.Equal(orig.Value, e.Value) {
+				body = append(body, raw[i].FullBytes...)
+				continue
+			}
+		}
+		encoded, err := asn1.Marshal(e)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, encoded...)
+	}
+	seq, err := sequence(body)
+	if err != nil {
+		return nil, err
+	}
+	field, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 3, IsCompound: true, Bytes: seq})
+	if err != nil {
+		return nil, err
+	}
+	return append(before, field...), nil
+}
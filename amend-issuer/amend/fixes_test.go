@@ -0,0 +1,274 @@
+package amend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"slices"
+	"testing"
+	"time"
+)
+
+var (
+	oidCommonName   = []byte{0x06, 0x03, 0x55, 0x04, 0x03} // 2.5.4.3
+	oidOrganization = []byte{0x06, 0x03, 0x55, 0x04, 0x0a} // 2.5.4.10
+)
+
+// atv encodes an AttributeTypeAndValue with a PrintableString value.
+func atv(oid []byte, value string) []byte {
+	return der(0x30, append(oid, der(printableString, []byte(value))...))
+}
+
+// rdn encodes a RelativeDistinguishedName, a SET of attributes.
+func rdn(atvs ...[]byte) []byte {
+	return der(0x31, bytes.Join(atvs, nil))
+}
+
+// name encodes a Name, a SEQUENCE of RDNs.
+func name(rdns ...[]byte) []byte {
+	return der(0x30, bytes.Join(rdns, nil))
+}
+
+type fixture struct {
+	t         *testing.T
+	issuerKey *ecdsa.PrivateKey
+	serial    int64
+}
+
+func newFixture(t *testing.T) *fixture {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{t: t, issuerKey: k}
+}
+
+// issuer returns a self-signed CA certificate from tmpl, signed by the fixture
+// issuer key.
+func (f *fixture) issuer(tmpl *x509.Certificate) *x509.Certificate {
+	tmpl.IsCA, tmpl.BasicConstraintsValid, tmpl.KeyUsage = true, true, x509.KeyUsageCertSign
+	return f.create(tmpl, tmpl, &f.issuerKey.PublicKey)
+}
+
+// child returns a leaf for dnsName, signed by the fixture issuer key, and with
+// Issuer and AuthorityKeyIdentifier from parent, which might not match the
+// certificate returned by issuer.
+func (f *fixture) child(parent *x509.Certificate, dnsName string) *x509.Certificate {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	parent.PublicKey = &f.issuerKey.PublicKey
+	return f.create(&x509.Certificate{Subject: pkix.Name{CommonName: dnsName}, DNSNames: []string{dnsName}},
+		parent, &k.PublicKey)
+}
+
+func (f *fixture) create(tmpl, parent *x509.Certificate, pub any) *x509.Certificate {
+	f.t.Helper()
+	f.serial++
+	tmpl.SerialNumber = big.NewInt(f.serial)
+	tmpl.NotBefore = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tmpl.NotAfter = time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, f.issuerKey)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return c
+}
+
+func verifyWithRoot(leaf, root *x509.Certificate) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+func TestAmendNameEncodings(t *testing.T) {
+	cn := atv(oidCommonName, "Test Root")
+	o := atv(oidOrganization, "Example")
+	for _, tt := range []struct {
+		name                string
+		issuerName, encoded []byte
+	}{
+		{"RDNOrdering", name(rdn(o), rdn(cn)), name(rdn(cn), rdn(o))},
+		{"MultiValuedRDN", name(rdn(o), rdn(cn)), name(rdn(o, cn))},
+		{"SingleValuedRDNs", name(rdn(o, cn)), name(rdn(o), rdn(cn))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t)
+			issuer := f.issuer(&x509.Certificate{RawSubject: tt.issuerName})
+			leaf := f.child(&x509.Certificate{RawSubject: tt.encoded, SubjectKeyId: issuer.SubjectKeyId},
+				"leaf.example")
+
+			if err := verifyWithRoot(leaf, issuer); err == nil {
+				t.Fatal("expected verification against the original issuer to fail")
+			}
+			if got := Needed(issuer, leaf); got != Subject {
+				t.Errorf("Needed = %v, want Subject", got)
+			}
+			amended, err := Amend(issuer, leaf, Subject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(amended.RawSubject, tt.encoded) {
+				t.Errorf("amended Subject = %x, want %x", amended.RawSubject, tt.encoded)
+			}
+			if err := verifyWithRoot(leaf, amended); err != nil {
+				t.Errorf("verification against the amended issuer failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAmendKeyID(t *testing.T) {
+	f := newFixture(t)
+	issuerName := name(rdn(atv(oidCommonName, "Test Root")))
+	issuer := f.issuer(&x509.Certificate{RawSubject: issuerName, SubjectKeyId: []byte{1, 2, 3, 4}})
+	leaf := f.child(&x509.Certificate{RawSubject: issuerName, SubjectKeyId: []byte{5, 6, 7, 8}}, "leaf.example")
+	if !bytes.Equal(leaf.AuthorityKeyId, []byte{5, 6, 7, 8}) {
+		t.Fatalf("leaf AuthorityKeyId = %x", leaf.AuthorityKeyId)
+	}
+
+	if got := Needed(issuer, leaf); got != KeyID {
+		t.Errorf("Needed = %v, want KeyID", got)
+	}
+	amended, err := Amend(issuer, leaf, KeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(amended.SubjectKeyId, leaf.AuthorityKeyId) {
+		t.Errorf("amended SubjectKeyId = %x, want %x", amended.SubjectKeyId, leaf.AuthorityKeyId)
+	}
+	if !bytes.Equal(amended.RawSubject, issuer.RawSubject) {
+		t.Error("amended Subject changed")
+	}
+	if Needed(amended, leaf) != 0 {
+		t.Errorf("Needed(amended) = %v", Needed(amended, leaf))
+	}
+	// The other extensions keep their encoding.
+	if len(amended.Extensions) != len(issuer.Extensions) {
+		t.Fatalf("amended has %d extensions, want %d", len(amended.Extensions), len(issuer.Extensions))
+	}
+	for i, e := range issuer.Extensions {
+		if e.Id.Equal(oidSubjectKeyID) {
+			continue
+		}
+		if a := amended.Extensions[i]; !a.Id.Equal(e.Id) || a.Critical != e.Critical || !bytes.Equal(a.Value, e.Value) {
+			t.Errorf("extension %v changed", e.Id)
+		}
+	}
+	if err := verifyWithRoot(leaf, amended); err != nil {
+		t.Errorf("verification against the amended issuer failed: %v", err)
+	}
+
+	// An issuer without SubjectKeyIdentifier gets one.
+	noSKIDER, err := unsignedCertificate(issuer.Raw, issuer.RawSubject, func(exts []pkix.Extension) ([]pkix.Extension, error) {
+		return slices.DeleteFunc(exts, func(e pkix.Extension) bool { return e.Id.Equal(oidSubjectKeyID) }), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	noSKI, err := x509.ParseCertificate(noSKIDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(noSKI.SubjectKeyId) != 0 {
+		t.Fatalf("issuer SubjectKeyId = %x", noSKI.SubjectKeyId)
+	}
+	if amended, err := Amend(noSKI, leaf, KeyID); err != nil {
+		t.Error(err)
+	} else if !bytes.Equal(amended.SubjectKeyId, leaf.AuthorityKeyId) {
+		t.Errorf("amended SubjectKeyId = %x, want %x", amended.SubjectKeyId, leaf.AuthorityKeyId)
+	}
+
+	// A child without AuthorityKeyIdentifier can't be matched.
+	noAKI := f.child(&x509.Certificate{RawSubject: issuerName}, "leaf.example")
+	if len(noAKI.AuthorityKeyId) != 0 {
+		t.Fatalf("leaf AuthorityKeyId = %x", noAKI.AuthorityKeyId)
+	}
+	if _, err := Amend(issuer, noAKI, KeyID); err == nil {
+		t.Error("Amend(KeyID) succeeded for a child without AuthorityKeyIdentifier")
+	}
+}
+
+func TestAmendNameConstraints(t *testing.T) {
+	f := newFixture(t)
+	issuerName := name(rdn(atv(oidCommonName, "Test Root")))
+
+	// NameConstraints ::= SEQUENCE { permittedSubtrees [0] GeneralSubtrees }
+	// with a dNSName, which Go enforces, and a directoryName, which it doesn't.
+	dnsSubtree := der(0x30, der(0x82, []byte("example.com")))
+	dirSubtree := der(0x30, der(0xa4, name(rdn(atv(oidOrganization, "Example")))))
+	constraints := der(0x30, der(0xa0, append(dnsSubtree, dirSubtree...)))
+	issuer := f.issuer(&x509.Certificate{RawSubject: issuerName, ExtraExtensions: []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{2, 5, 29, 30}, Critical: true, Value: constraints},
+	}})
+	leaf := f.child(&x509.Certificate{RawSubject: issuerName, SubjectKeyId: issuer.SubjectKeyId}, "leaf.example.com")
+	outside := f.child(&x509.Certificate{RawSubject: issuerName, SubjectKeyId: issuer.SubjectKeyId}, "leaf.example.org")
+
+	var unhandled x509.UnhandledCriticalExtension
+	if err := verifyWithRoot(leaf, issuer); !errors.As(err, &unhandled) {
+		t.Fatalf("expected an unhandled critical extension error, got %v", err)
+	}
+	if got := Needed(issuer, leaf); got != NameConstraints {
+		t.Errorf("Needed = %v, want NameConstraints", got)
+	}
+	amended, err := Amend(issuer, leaf, NameConstraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(amended.UnhandledCriticalExtensions) != 0 || amended.PermittedDNSDomainsCritical {
+		t.Error("amended name constraints are still critical")
+	}
+	if len(amended.PermittedDNSDomains) != 1 || amended.PermittedDNSDomains[0] != "example.com" {
+		t.Errorf("amended PermittedDNSDomains = %v", amended.PermittedDNSDomains)
+	}
+	if err := verifyWithRoot(leaf, amended); err != nil {
+		t.Errorf("verification against the amended issuer failed: %v", err)
+	}
+	// The supported constraints are still enforced.
+	if err := verifyWithRoot(outside, amended); err == nil {
+		t.Error("verification of a name outside the constraints succeeded")
+	}
+
+	// Fixes can be combined.
+	amended, err = Amend(issuer, leaf, Subject|KeyID|NameConstraints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyWithRoot(leaf, amended); err != nil {
+		t.Errorf("verification against the amended issuer failed: %v", err)
+	}
+
+	plain := f.issuer(&x509.Certificate{RawSubject: issuerName})
+	if _, err := Amend(plain, leaf, NameConstraints); err == nil {
+		t.Error("Amend(NameConstraints) succeeded for an issuer without name constraints")
+	}
+}
+
+func TestFixString(t *testing.T) {
+	for f, want := range map[Fix]string{
+		0:                       "none",
+		Subject:                 "Subject",
+		KeyID | NameConstraints: "KeyID|NameConstraints",
+		Subject | 1<<10:         "Subject|Fix(0x400)",
+	} {
+		if got := f.String(); got != want {
+			t.Errorf("Fix(%d).String() = %q, want %q", int(f), got, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package amend
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// An Amendment is an amended issuer, returned by [Amendments] and [Verify].
+type Amendment struct {
+	// Issuer is the original issuer certificate, from the roots or
+	// intermediates passed to Verify.
+	Issuer *x509.Certificate
+	// Child is the certificate whose Issuer field doesn't match the Subject of
+	// Issuer, despite being signed by it.
+	Child *x509.Certificate
+	// Fixes are the fixes applied to Issuer.
+	Fixes Fix
+	// Amended is the result of Amend(Issuer, Child, Fixes).
+	Amended *x509.Certificate
+}
+
+func (a *Amendment) String() string {
+	kind := "root"
+	if !IsSelfSigned(a.Issuer) {
+		kind = "intermediate"
+	}
+	return fmt.Sprintf("amended %s %q to match the Issuer encoding of %q",
+		kind, a.Issuer.Subject.String(), a.Child.Subject.String())
+}
+
+// Signers returns the candidates, other than child itself, whose key signed
+// child, regardless of their Subject.
+func Signers(child *x509.Certificate, candidates []*x509.Certificate) []*x509.Certificate {
+	var signers []*x509.Certificate
+	for _, parent := range candidates {
+		if parent.Equal(child) || child.CheckSignatureFrom(parent) != nil {
+			continue
+		}
+		signers = append(signers, parent)
+	}
+	return signers
+}
+
+// Amendments returns an amendment for each of the candidates that signed
+// child, but that needs some of the selected fixes (see [Needed]).
+func Amendments(child *x509.Certificate, candidates []*x509.Certificate, fixes Fix) ([]*Amendment, error) {
+	var amendments []*Amendment
+	for _, parent := range Signers(child, candidates) {
+		needed := Needed(parent, child) & fixes
+		if needed == 0 {
+			continue
+		}
+		amended, err := Amend(parent, child, needed)
+		if err != nil {
+			return nil, err
+		}
+		amendments = append(amendments, &Amendment{Issuer: parent, Child: child, Fixes: needed, Amended: amended})
+	}
+	return amendments, nil
+}
+
+// Anchors returns the amendments that can be used as trust anchors: those of
+// an issuer in roots, and those of an intermediate that verifies against roots
+// and the anchors found so far, until no more can be added, since an
+// intermediate might only verify thanks to another amendment.
+//
+// The intermediates are checked with opts, ignoring opts.Roots; if roots is
+// empty and there are no anchors yet, the system roots are used. Intermediates
+// amended with NameConstraints are checked ignoring their unhandled critical
+// extensions. Note that an amended intermediate used as an anchor doesn't carry
+// the constraints imposed by the rest of its chain.
+func Anchors(amendments []*Amendment, roots []*x509.Certificate, opts x509.VerifyOptions) []*Amendment {
+	isRoot := func(c *x509.Certificate) bool {
+		return slices.ContainsFunc(roots, c.Equal)
+	}
+	var anchors []*Amendment
+	var anchorCerts []*x509.Certificate
+	used := make([]bool, len(amendments))
+	for {
+		added := false
+		for i, a := range amendments {
+			if used[i] {
+				continue
+			}
+			if !isRoot(a.Issuer) {
+				issuer := a.Issuer
+				if a.Fixes&NameConstraints != 0 {
+					c := *issuer
+					c.UnhandledCriticalExtensions = nil
+					issuer = &c
+				}
+				if _, err := verifyWithRoots(issuer, opts, roots, anchorCerts); err != nil {
+					continue
+				}
+			}
+			used[i] = true
+			anchors = append(anchors, a)
+			anchorCerts = append(anchorCerts, a.Amended)
+			added = true
+		}
+		if !added {
+			return anchors
+		}
+	}
+}
+
+// verifyWithRoots verifies c with opts, using roots and extraRoots as the
+// roots, or the system roots if both are empty.
+func verifyWithRoots(c *x509.Certificate, opts x509.VerifyOptions, roots, extraRoots []*x509.Certificate) ([][]*x509.Certificate, error) {
+	opts.Roots = nil
+	if len(roots) > 0 || len(extraRoots) > 0 {
+		opts.Roots = x509.NewCertPool()
+	}
+	for _, r := range roots {
+		opts.Roots.AddCert(r)
+	}
+	for _, r := range extraRoots {
+		opts.Roots.AddCert(r)
+	}
+	return c.Verify(opts)
+}
+
+// Verify is like [x509.Certificate.Verify], but if verification fails with an
+// [x509.UnknownAuthorityError], it retries with amended versions of the roots
+// and intermediates that signed a certificate in the chain, but whose Subject
+// is encoded differently than the Issuer of their child.
+//
+// Since [x509.CertPool] can't be enumerated, the candidate roots and
+// intermediates are passed as slices, and opts.Roots and opts.Intermediates
+// are ignored. If roots is empty, the system roots are used, but can't be
+// amended.
+//
+// Amended roots are used as roots. Amended intermediates are used as roots only
+// if the original intermediate verifies with the same options (see [Anchors]).
+//
+// The returned amendments are the ones that appear in the returned chains.
+// If verification fails, the error is the one from the first, unamended
+// attempt.
+func Verify(cert *x509.Certificate, roots, intermediates []*x509.Certificate, opts x509.VerifyOptions) ([][]*x509.Certificate, []*Amendment, error) {
+	opts.Intermediates = x509.NewCertPool()
+	for _, i := range intermediates {
+		opts.Intermediates.AddCert(i)
+	}
+
+	chains, err := verifyWithRoots(cert, opts, roots, nil)
+	var unknownAuthority x509.UnknownAuthorityError
+	if err == nil || !errors.As(err, &unknownAuthority) {
+		return chains, nil, err
+	}
+
+	// Walk up from cert through every intermediate that signed a certificate
+	// already reached, collecting the mismatched pairs on the way.
+	candidates := append(roots[:len(roots):len(roots)], intermediates...)
+	var amendments []*Amendment
+	reached := []*x509.Certificate{cert}
+	for i := 0; i < len(reached); i++ {
+		child := reached[i]
+		for _, parent := range Signers(child, candidates) {
+			if !slices.ContainsFunc(roots, parent.Equal) && !slices.ContainsFunc(reached, parent.Equal) {
+				reached = append(reached, parent)
+			}
+		}
+		found, err := Amendments(child, candidates, Subject)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range found {
+			if !slices.ContainsFunc(amendments, func(b *Amendment) bool { return b.Amended.Equal(a.Amended) }) {
+				amendments = append(amendments, a)
+			}
+		}
+	}
+	if len(amendments) == 0 {
+		return nil, nil, err
+	}
+
+	var anchors []*x509.Certificate
+	for _, a := range Anchors(amendments, roots, opts) {
+		anchors = append(anchors, a.Amended)
+	}
+	chains, amendedErr := verifyWithRoots(cert, opts, roots, anchors)
+	if amendedErr != nil {
+		return nil, nil, err
+	}
+	var applied []*Amendment
+	for _, a := range amendments {
+	chains:
+		for _, chain := range chains {
+			for _, c := range chain {
+				if c.Equal(a.Amended) {
+					applied = append(applied, a)
+					break chains
+				}
+			}
+		}
+	}
+	return chains, applied, nil
+}
+
+// IsSelfSigned reports whether c is signed by its own key, regardless of
+// whether its Issuer and Subject encodings match.
+func IsSelfSigned(c *x509.Certificate) bool {
+	return c.CheckSignatureFrom(c) == nil
+}
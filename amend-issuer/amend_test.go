@@ -14,6 +14,9 @@ import (
 	"time"
 )
 
+// idAlgUnsigned is the id-alg-unsigned OBJECT IDENTIFIER from RFC 9925.
+var idAlgUnsigned = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 6, 36}
+
 // makeName encodes the Name "CN=Test Root", with the common name's string value
 // using the given ASN.1 string tag. The same logical name encoded with two
 // different tags (PrintableString vs UTF8String) reproduces the byte-for-byte
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"filippo.io/mostly-harmless/amend-issuer/amend"
+)
+
+// bundleAmendment is an amended issuer produced by amendBundle.
+type bundleAmendment struct {
+	// Issuer is the original issuer certificate.
+	Issuer *x509.Certificate
+	// Children are the certificates whose Issuer field matches the amended
+	// Subject, and whose signature was made by Issuer.
+	Children []*x509.Certificate
+	// Fixes are the amendments applied to Issuer.
+	Fixes amend.Fix
+	// Amended is the unsigned amended version of Issuer.
+	Amended *x509.Certificate
+	// Anchor is true if Amended was used as a trust anchor in the leaf
+	// verification: either Issuer is a root, or it chains to one.
+	Anchor bool
+}
+
+// bundleLeaf is the verification outcome of a leaf of the bundle, before and
+// after the amendments. A nil error means the leaf verifies.
+type bundleLeaf struct {
+	Cert          *x509.Certificate
+	Before, After error
+}
+
+type bundleResult struct {
+	Amendments []*bundleAmendment
+	Leaves     []*bundleLeaf
+}
+
+// amendBundle scans bundle for every parent/child pair where the parent signed
+// the child, but needs some of the selected fixes (see [amend.Needed]), such as
+// when the child's Issuer doesn't byte-for-byte match the parent's Subject. It
+// amends the parent once for each distinct amended result.
+//
+// Parents are looked up in both bundle and roots. If roots is empty, the
+// self-signed certificates of the bundle are used as roots.
+//
+// The leaves of the bundle, the certificates that are not roots and did not
+// issue any other certificate in it, are then verified at time now, before and
+// after adding the amended roots to the root pool. Amended intermediates are
+// also added to the root pool if the original intermediate verifies (ignoring
+// unhandled critical extensions if NameConstraints is selected): they are
+// trusted just like the original, although without the constraints of the
+// rest of its chain.
+func amendBundle(bundle, roots []*x509.Certificate, now time.Time, fixes amend.Fix) (*bundleResult, error) {
+	bundle = dedupCertificates(bundle)
+	if len(roots) == 0 {
+		for _, c := range bundle {
+			if amend.IsSelfSigned(c) {
+				roots = append(roots, c)
+			}
+		}
+	}
+	roots = dedupCertificates(roots)
+	isRoot := func(c *x509.Certificate) bool {
+		return slices.ContainsFunc(roots, c.Equal)
+	}
+	candidates := dedupCertificates(append(append([]*x509.Certificate{}, bundle...), roots...))
+
+	res := &bundleResult{}
+	byAmendment := make(map[*amend.Amendment]*bundleAmendment)
+	var amendments []*amend.Amendment
+	issuedOther := make(map[*x509.Certificate]bool)
+	for _, child := range bundle {
+		if isRoot(child) {
+			continue
+		}
+		signers := amend.Signers(child, candidates)
+		for _, parent := range signers {
+			issuedOther[parent] = true
+		}
+		found, err := amend.Amendments(child, candidates, fixes)
+		if err != nil {
+			return nil, err
+		}
+		// Skip children that already have a valid issuer that needs no fixes.
+		if len(found) < len(signers) {
+			continue
+		}
+	found:
+		for _, f := range found {
+			for _, a := range res.Amendments {
+				if a.Amended.Equal(f.Amended) {
+					a.Children = append(a.Children, child)
+					continue found
+				}
+			}
+			a := &bundleAmendment{
+				Issuer:   f.Issuer,
+				Children: []*x509.Certificate{child},
+				Fixes:    f.Fixes,
+				Amended:  f.Amended,
+			}
+			res.Amendments = append(res.Amendments, a)
+			amendments = append(amendments, f)
+			byAmendment[f] = a
+		}
+	}
+
+	opts := x509.VerifyOptions{
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	for _, i := range bundle {
+		opts.Intermediates.AddCert(i)
+	}
+	verify := func(c *x509.Certificate, roots []*x509.Certificate) error {
+		opts := opts
+		opts.Roots = x509.NewCertPool()
+		for _, r := range roots {
+			opts.Roots.AddCert(r)
+		}
+		_, err := c.Verify(opts)
+		return err
+	}
+
+	var leaves []*x509.Certificate
+	for _, c := range bundle {
+		if !isRoot(c) && !issuedOther[c] {
+			leaves = append(leaves, c)
+		}
+	}
+	for _, c := range leaves {
+		res.Leaves = append(res.Leaves, &bundleLeaf{Cert: c, Before: verify(c, roots)})
+	}
+
+	anchors := roots
+	for _, f := range amend.Anchors(amendments, roots, opts) {
+		byAmendment[f].Anchor = true
+		anchors = append(anchors, f.Amended)
+	}
+	for _, l := range res.Leaves {
+		l.After = verify(l.Cert, anchors)
+	}
+
+	return res, nil
+}
+
+// parseCertificates parses all the CERTIFICATE PEM blocks in pemBytes.
+func parseCertificates(pemBytes []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := pemBytes; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate #%d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no CERTIFICATE PEM block found")
+	}
+	return certs, nil
+}
+
+func dedupCertificates(certs []*x509.Certificate) []*x509.Certificate {
+	var out []*x509.Certificate
+certs:
+	for _, c := range certs {
+		for _, o := range out {
+			if o.Equal(c) {
+				continue certs
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"filippo.io/mostly-harmless/amend-issuer/amend"
+)
+
+func TestAmendBundle(t *testing.T) {
+	const (
+		printableString = 0x13
+		utf8String      = 0x0c
+	)
+	notBefore := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	newKey := func() *ecdsa.PrivateKey {
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	serial := int64(0)
+	create := func(tmpl, parent *x509.Certificate, pub, priv any) *x509.Certificate {
+		t.Helper()
+		serial++
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotBefore, tmpl.NotAfter = notBefore, notAfter
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	leaf := func(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+		return create(&x509.Certificate{Subject: pkix.Name{CommonName: name}},
+			parent, &newKey().PublicKey, parentKey)
+	}
+
+	// The deployed root uses a UTF8String common name, but some leaves were
+	// issued when it used a PrintableString.
+	rootKey := newKey()
+	caTmpl := func(name []byte) *x509.Certificate {
+		return &x509.Certificate{RawSubject: name, IsCA: true, BasicConstraintsValid: true,
+			KeyUsage: x509.KeyUsageCertSign}
+	}
+	rootOld := create(caTmpl(makeName(printableString)), caTmpl(makeName(printableString)), &rootKey.PublicKey, rootKey)
+	root := create(caTmpl(makeName(utf8String)), caTmpl(makeName(utf8String)), &rootKey.PublicKey, rootKey)
+	leafA := leaf("a.example", rootOld, rootKey)
+	leafB := leaf("b.example", rootOld, rootKey)
+	leafC := leaf("c.example", root, rootKey)
+
+	// An intermediate issued by the root, with the same mismatch towards its
+	// own children.
+	intKey := newKey()
+	intTmpl := caTmpl(nil)
+	intTmpl.Subject = pkix.Name{CommonName: "Intermediate", Organization: []string{"Example"}}
+	intermediate := create(intTmpl, root, &intKey.PublicKey, rootKey)
+	intOld := &x509.Certificate{RawSubject: bytes.Replace(intermediate.RawSubject,
+		[]byte{printableString, byte(len("Intermediate"))}, []byte{utf8String, byte(len("Intermediate"))}, 1),
+		PublicKey: &intKey.PublicKey}
+	if bytes.Equal(intOld.RawSubject, intermediate.RawSubject) {
+		t.Fatal("failed to re-encode the intermediate name")
+	}
+	leafD := leaf("d.example", intOld, intKey)
+
+	// An unrelated leaf that will not verify either way.
+	otherKey := newKey()
+	other := create(caTmpl(makeName(utf8String)), caTmpl(makeName(utf8String)), &otherKey.PublicKey, otherKey)
+	leafE := leaf("e.example", other, otherKey)
+
+	bundle := []*x509.Certificate{leafA, intermediate, leafB, leafC, leafD, leafE, leafA}
+	res, err := amendBundle(bundle, []*x509.Certificate{root}, now, amend.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res.Amendments) != 2 {
+		t.Fatalf("got %d amendments, want 2", len(res.Amendments))
+	}
+	for i, want := range []struct {
+		issuer   *x509.Certificate
+		children []*x509.Certificate
+	}{
+		{root, []*x509.Certificate{leafA, leafB}},
+		{intermediate, []*x509.Certificate{leafD}},
+	} {
+		a := res.Amendments[i]
+		if !a.Issuer.Equal(want.issuer) {
+			t.Errorf("amendment %d: issuer %q, want %q", i, a.Issuer.Subject, want.issuer.Subject)
+		}
+		if len(a.Children) != len(want.children) {
+			t.Errorf("amendment %d: %d children, want %d", i, len(a.Children), len(want.children))
+		}
+		for _, c := range want.children {
+			if !bytes.Equal(a.Amended.RawSubject, c.RawIssuer) {
+				t.Errorf("amendment %d: Subject does not match the Issuer of %q", i, c.Subject)
+			}
+		}
+		if !a.Anchor {
+			t.Errorf("amendment %d: not used as an anchor", i)
+		}
+		assertUnsigned(t, a.Amended.Raw)
+	}
+
+	for _, want := range []struct {
+		cert          *x509.Certificate
+		before, after bool
+	}{
+		{leafA, false, true},
+		{leafB, false, true},
+		{leafC, true, true},
+		{leafD, false, true},
+		{leafE, false, false},
+	} {
+		var l *bundleLeaf
+		for _, got := range res.Leaves {
+			if got.Cert.Equal(want.cert) {
+				l = got
+			}
+		}
+		if l == nil {
+			t.Errorf("leaf %q not reported", want.cert.Subject)
+			continue
+		}
+		if (l.Before == nil) != want.before || (l.After == nil) != want.after {
+			t.Errorf("leaf %q: before %v, after %v", want.cert.Subject, l.Before, l.After)
+		}
+	}
+	if len(res.Leaves) != 5 {
+		t.Errorf("got %d leaves, want 5", len(res.Leaves))
+	}
+
+	// Without explicit roots, the self-signed certificates in the bundle are
+	// trusted, so the unrelated leaf verifies too.
+	res, err = amendBundle(append(bundle, root, other), nil, now, amend.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Amendments) != 2 {
+		t.Errorf("got %d amendments without roots, want 2", len(res.Amendments))
+	}
+	for _, l := range res.Leaves {
+		if l.After != nil {
+			t.Errorf("leaf %q does not verify without roots: %v", l.Cert.Subject, l.After)
+		}
+	}
+}
+
+func TestAmendBundleRealChain(t *testing.T) {
+	var bundlePEM []byte
+	for _, name := range []string{"stmtpmeccint02.pem", "stmtpmeccroot01.pem"} {
+		b, err := os.ReadFile("testdata/stm-tpm-ecc/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundlePEM = append(bundlePEM, b...)
+	}
+	bundle, err := parseCertificates(bundlePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPEM, err := os.ReadFile("testdata/stm-tpm-ecc/tpmeccroot.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := parseCertificates(rootPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := amendBundle(bundle, roots, time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), amend.Subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Amendments) != 1 || !res.Amendments[0].Anchor {
+		t.Fatalf("unexpected amendments: %+v", res.Amendments)
+	}
+	golden, err := os.ReadFile("testdata/stm-tpm-ecc/amended.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, err := decodeCertificate(golden); err != nil || !bytes.Equal(res.Amendments[0].Amended.Raw, want) {
+		t.Error("amendment does not match testdata/stm-tpm-ecc/amended.pem")
+	}
+	if len(res.Leaves) != 1 || res.Leaves[0].Before == nil || res.Leaves[0].After != nil {
+		t.Errorf("unexpected leaves: %+v", res.Leaves)
+	}
+}
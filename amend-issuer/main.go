@@ -3,25 +3,109 @@
 package main
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
 	"fmt"
 	"os"
+	"time"
+
+	"filippo.io/mostly-harmless/amend-issuer/amend"
 )
 
 func main() {
-	if len(os.Args) != 3 {
+	bundleFlag := flag.String("bundle", "", "scan the certificates in `file` for every needed amendment")
+	rootsFlag := flag.String("roots", "", "trusted roots `file` for -bundle (default: the self-signed certificates in the bundle)")
+	subjectFlag := flag.Bool("subject", true, "replace the issuer's Subject with the child's Issuer encoding")
+	keyIDFlag := flag.Bool("key-id", false, "replace the issuer's SubjectKeyIdentifier with the child's AuthorityKeyIdentifier")
+	nameConstraintsFlag := flag.Bool("name-constraints", false, "mark the issuer's critical name constraints non-critical, if Go doesn't support them")
+	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "usage: amend-issuer <issuer.pem> <child.pem>")
-		fmt.Fprintln(os.Stderr, "Writes the amended unsigned issuer certificate as PEM to stdout.")
+		fmt.Fprintln(os.Stderr, "       amend-issuer -bundle certs.pem [-roots roots.pem]")
+		fmt.Fprintln(os.Stderr, "Writes the amended unsigned issuer certificates as PEM to stdout.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var fixes amend.Fix
+	if *subjectFlag {
+		fixes |= amend.Subject
+	}
+	if *keyIDFlag {
+		fixes |= amend.KeyID
+	}
+	if *nameConstraintsFlag {
+		fixes |= amend.NameConstraints
+	}
+	if fixes == 0 {
+		fmt.Fprintln(os.Stderr, "amend-issuer: no amendments selected")
 		os.Exit(2)
 	}
-	issuerPEM, err := os.ReadFile(os.Args[1])
+
+	if *bundleFlag != "" {
+		if flag.NArg() != 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		runBundle(*bundleFlag, *rootsFlag, fixes)
+		return
+	}
+	if flag.NArg() != 2 || *rootsFlag != "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	issuerPEM, err := os.ReadFile(flag.Arg(0))
 	check(err)
-	childPEM, err := os.ReadFile(os.Args[2])
+	childPEM, err := os.ReadFile(flag.Arg(1))
 	check(err)
-	out, err := amendIssuer(issuerPEM, childPEM)
+	out, err := amendPEM(issuerPEM, childPEM, fixes)
 	check(err)
 	os.Stdout.Write(out)
 }
 
+// runBundle writes the amendments needed by the bundle to stdout, and a report
+// of the amendments and of the leaves' verification to stderr.
+func runBundle(bundleFile, rootsFile string, fixes amend.Fix) {
+	bundlePEM, err := os.ReadFile(bundleFile)
+	check(err)
+	bundle, err := parseCertificates(bundlePEM)
+	check(err)
+	var roots []*x509.Certificate
+	if rootsFile != "" {
+		rootsPEM, err := os.ReadFile(rootsFile)
+		check(err)
+		roots, err = parseCertificates(rootsPEM)
+		check(err)
+	}
+
+	res, err := amendBundle(bundle, roots, time.Now(), fixes)
+	check(err)
+
+	for _, a := range res.Amendments {
+		fmt.Fprintf(os.Stderr, "amended %q (%v) for %d child(ren):\n", a.Issuer.Subject.String(), a.Fixes, len(a.Children))
+		for _, c := range a.Children {
+			fmt.Fprintf(os.Stderr, "    %q\n", c.Subject.String())
+		}
+		if !a.Anchor {
+			fmt.Fprintln(os.Stderr, "    warning: the issuer does not chain to a trusted root")
+		}
+		os.Stdout.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.Amended.Raw}))
+	}
+	if len(res.Amendments) == 0 {
+		fmt.Fprintln(os.Stderr, "no amendments needed")
+	}
+	for _, l := range res.Leaves {
+		switch {
+		case l.After != nil:
+			fmt.Fprintf(os.Stderr, "leaf %q does not verify: %v\n", l.Cert.Subject.String(), l.After)
+		case l.Before != nil:
+			fmt.Fprintf(os.Stderr, "leaf %q now verifies\n", l.Cert.Subject.String())
+		default:
+			fmt.Fprintf(os.Stderr, "leaf %q verifies\n", l.Cert.Subject.String())
+		}
+	}
+}
+
 func check(err error) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "amend-issuer:", err)
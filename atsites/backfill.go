@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/atdata"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/ipfs/go-cid"
+)
+
+// backfill fetches the whole repository of an account from its PDS, and
+// replaces all its stored site.standard records with the ones in it.
+//
+// This covers records created before the server started following the
+// repository on Tap, or while it was down.
+func (s *Server) backfill(ctx context.Context, account string) error {
+	id, err := syntax.ParseAtIdentifier(account)
+	if err != nil {
+		return fmt.Errorf("invalid AT identifier %q: %w", account, err)
+	}
+	i, err := s.dir.Lookup(ctx, id)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", account, err)
+	}
+	pds := i.PDSEndpoint()
+	if pds == "" {
+		return fmt.Errorf("%s has no PDS", i.DID)
+	}
+
+	u := strings.TrimSuffix(pds, "/") + "/xrpc/com.atproto.sync.getRepo?did=" + url.QueryEscape(i.DID.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch repository: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch repository: %s", resp.Status)
+	}
+
+	return s.importRepo(ctx, i, resp.Body)
+}
+
+// importRepo stores the site.standard records of a repository CAR export,
+// after verifying it is signed by the identity's key, and deletes any stored
+// record of the repository that is not in it.
+func (s *Server) importRepo(ctx context.Context, i *identity.Identity, r io.Reader) error {
+	commit, rr, err := repo.LoadRepoFromCAR(ctx, r)
+	if err != nil {
+		return fmt.Errorf("load repository: %w", err)
+	}
+	if commit.DID != i.DID.String() {
+		return fmt.Errorf("repository is for %s, expected %s", commit.DID, i.DID)
+	}
+	key, err := i.PublicKey()
+	if err != nil {
+		return fmt.Errorf("get signing key of %s: %w", i.DID, err)
+	}
+	if err := commit.VerifySignature(key); err != nil {
+		return fmt.Errorf("verify repository commit: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q := s.queries.WithTx(tx)
+
+	if err := q.DeletePublicationsForRepo(ctx, i.DID.String()); err != nil {
+		return fmt.Errorf("delete publications: %w", err)
+	}
+	if err := q.DeleteDocumentsForRepo(ctx, i.DID.String()); err != nil {
+		return fmt.Errorf("delete documents: %w", err)
+	}
+	var n int
+	if err := rr.MST.Walk(func(key []byte, val cid.Cid) error {
+		collection, rkey, ok := strings.Cut(string(key), "/")
+		if !ok || !strings.HasPrefix(collection, "site.standard.") {
+			return nil
+		}
+		blk, err := rr.RecordStore.Get(ctx, val)
+		if err != nil {
+			return fmt.Errorf("get record %s: %w", key, err)
+		}
+		record, err := atdata.UnmarshalCBOR(blk.RawData())
+		if err != nil {
+			slog.WarnContext(ctx, "decode record", "error", err,
+				"uri", fmt.Sprintf("at://%s/%s", i.DID, key))
+			return nil
+		}
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("encode record %s: %w", key, err)
+		}
+		n++
+		return s.handleRecordEvent(ctx, q, &recordEvent{
+			Repo:       i.DID.String(),
+			Rkey:       rkey,
+			Collection: collection,
+			Action:     "create",
+			Record:     recordJSON,
+		})
+	}); err != nil {
+		return err
+	}
+	if err := storeIdentity(ctx, q, i.DID, i.Handle); err != nil {
+		return fmt.Errorf("store identity: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "backfilled repository", "did", i.DID, "handle", i.Handle,
+		"rev", commit.Rev, "records", n)
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/mostly-harmless/atsites/internal/db"
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	_ "modernc.org/sqlite"
+)
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "atsites.sqlite3")+"?_pragma=foreign_keys(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if _, err := sqlDB.Exec(schemaSQL); err != nil {
+		t.Fatal(err)
+	}
+	return &Server{db: sqlDB, queries: db.New(sqlDB), dir: identity.NewMockDirectory()}
+}
+
+// fixtureIdentity returns the identity that signed testdata/repo.car (see
+// testdata/gencar.go), with its PDS serving the CAR.
+func fixtureIdentity(t *testing.T) *identity.Identity {
+	t.Helper()
+	j, err := os.ReadFile("testdata/repo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture struct {
+		DID string `json:"did"`
+		Key string `json:"key"`
+	}
+	if err := json.Unmarshal(j, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	car, err := os.ReadFile("testdata/repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/xrpc/com.atproto.sync.getRepo" || r.FormValue("did") != fixture.DID {
+			http.Error(w, "RepoNotFound", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.ipld.car")
+		w.Write(car)
+	}))
+	t.Cleanup(pds.Close)
+
+	return &identity.Identity{
+		DID:    syntax.DID(fixture.DID),
+		Handle: syntax.Handle("Blog.Example.com"),
+		Keys: map[string]identity.VerificationMethod{
+			"atproto": {Type: "Multikey", PublicKeyMultibase: fixture.Key},
+		},
+		Services: map[string]identity.ServiceEndpoint{
+			"atproto_pds": {Type: "AtprotoPersonalDataServer", URL: pds.URL},
+		},
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	i := fixtureIdentity(t)
+	s.dir.(*identity.MockDirectory).Insert(*i)
+	did := i.DID.String()
+
+	// A document deleted while the server was not following the repository.
+	if err := s.queries.StoreDocument(ctx, db.StoreDocumentParams{
+		Repo: did, Rkey: "3ldeleted", PublicationRepo: did, PublicationRkey: "3lpubaaaaaaa2",
+		DocumentJson: []byte(`{"title": "Deleted"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.backfill(ctx, "blog.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	publications, err := s.getPublications(ctx, did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publications) != 1 || publications[0].Name != "Example Blog" || publications[0].Rkey != "3lpubaaaaaaa2" {
+		t.Errorf("unexpected publications: %+v", publications)
+	}
+	documents, err := s.getDocumentsForPublication(ctx, did, "3lpubaaaaaaa2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, d := range documents {
+		titles = append(titles, d.Title)
+	}
+	if got := strings.Join(titles, ", "); got != "Second post, First post" {
+		t.Errorf("unexpected documents: %s", got)
+	}
+
+	// The identity is now cached, and the directory is not needed anymore.
+	s.dir = identity.NewMockDirectory()
+	gotDID, handle, err := s.lookupIdentity(ctx, syntax.Handle("blog.example.com").AtIdentifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotDID != i.DID || handle != "blog.example.com" {
+		t.Errorf("lookupIdentity = %s, %s", gotDID, handle)
+	}
+
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/profile/blog.example.com", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Example Blog") {
+		t.Errorf("unexpected profile page: %d\n%s", rec.Code, rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/profile/"+did+"/publication/3lpubaaaaaaa2/atom.xml", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Example Blog by blog.example.com") {
+		t.Errorf("unexpected feed: %d\n%s", rec.Code, rec.Body)
+	}
+}
+
+func TestBackfillWrongKey(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	i := fixtureIdentity(t)
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := key.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	i.Keys["atproto"] = identity.VerificationMethod{Type: "Multikey", PublicKeyMultibase: pub.Multibase()}
+
+	car, err := os.Open("testdata/repo.car")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer car.Close()
+	if err := s.importRepo(ctx, i, car); err == nil {
+		t.Fatal("importRepo succeeded with the wrong key")
+	}
+	if publications, err := s.getPublications(ctx, i.DID.String()); err != nil || len(publications) != 0 {
+		t.Errorf("unexpected publications: %v, %v", publications, err)
+	}
+}
+
+func TestIdentityEvent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	const did = "did:plc:atsitestestfixture0000000"
+
+	var ev identityEvent
+	for _, handle := range []string{"old.example.com", "New.Example.com"} {
+		if err := json.NewDecoder(strings.NewReader(`{"did": "` + did + `", "handle": "` + handle +
+			`", "is_active": true, "status": "active"}`)).Decode(&ev); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.handleIdentityEvent(ctx, &ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gotDID, handle, err := s.lookupIdentity(ctx, syntax.DID(did).AtIdentifier())
+	if err != nil || gotDID != did || handle != "new.example.com" {
+		t.Errorf("lookupIdentity(did) = %s, %s, %v", gotDID, handle, err)
+	}
+	gotDID, _, err = s.lookupIdentity(ctx, syntax.Handle("new.example.com").AtIdentifier())
+	if err != nil || gotDID != did {
+		t.Errorf("lookupIdentity(new handle) = %s, %v", gotDID, err)
+	}
+	// The old handle is not cached anymore, and the (empty) directory fails.
+	if _, _, err := s.lookupIdentity(ctx, syntax.Handle("old.example.com").AtIdentifier()); err == nil {
+		t.Errorf("lookupIdentity(old handle) succeeded")
+	}
+
+	if err := s.handleIdentityEvent(ctx, &identityEvent{DID: did}); err != nil {
+		t.Fatal(err)
+	}
+	if _, handle, _ := s.lookupIdentity(ctx, syntax.DID(did).AtIdentifier()); handle != syntax.HandleInvalid {
+		t.Errorf("handle after event without handle = %s", handle)
+	}
+}
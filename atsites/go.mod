@@ -11,6 +11,7 @@ require (
 	github.com/bluesky-social/indigo v0.0.0-20260127035949-131be32d6fc1
 	github.com/coder/websocket v1.8.14
 	github.com/gorilla/feeds v1.2.0
+	github.com/ipfs/go-cid v0.6.0
 	golang.org/x/sync v0.19.0
 	modernc.org/sqlite v1.44.3
 )
@@ -46,7 +47,6 @@ require (
 	github.com/ipfs/boxo v0.35.2 // indirect
 	github.com/ipfs/go-block-format v0.2.3 // indirect
 	github.com/ipfs/go-blockservice v0.5.2 // indirect
-	github.com/ipfs/go-cid v0.6.0 // indirect
 	github.com/ipfs/go-datastore v0.9.0 // indirect
 	github.com/ipfs/go-ipfs-blockstore v1.3.1 // indirect
 	github.com/ipfs/go-ipfs-ds-help v1.1.1 // indirect
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"filippo.io/mostly-harmless/atsites/internal/db"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// identityTTL is how long a cached handle/DID mapping is used before resolving
+// it again. Identity events from Tap keep the ones of tracked repos fresh.
+const identityTTL = 24 * time.Hour
+
+// lookupIdentity resolves an AT identifier to a DID and handle, using the
+// identities table as a cache in front of the directory.
+func (s *Server) lookupIdentity(ctx context.Context, id syntax.AtIdentifier) (syntax.DID, syntax.Handle, error) {
+	if did, err := id.AsDID(); err == nil {
+		row, err := s.queries.GetIdentityByDID(ctx, did.String())
+		if err != nil && err != sql.ErrNoRows {
+			return "", "", err
+		}
+		if err == nil && time.Since(time.Unix(row.UpdatedAt, 0)) < identityTTL {
+			return did, syntax.Handle(row.Handle), nil
+		}
+	} else if handle, err := id.AsHandle(); err == nil && !handle.IsInvalidHandle() {
+		row, err := s.queries.GetIdentityByHandle(ctx, handle.Normalize().String())
+		if err != nil && err != sql.ErrNoRows {
+			return "", "", err
+		}
+		if err == nil && time.Since(time.Unix(row.UpdatedAt, 0)) < identityTTL {
+			return syntax.DID(row.Did), handle.Normalize(), nil
+		}
+	}
+
+	i, err := s.dir.Lookup(ctx, id)
+	if err != nil {
+		return "", "", err
+	}
+	if err := storeIdentity(ctx, s.queries, i.DID, i.Handle); err != nil {
+		slog.WarnContext(ctx, "failed to cache identity", "did", i.DID, "error", err)
+	}
+	return i.DID, i.Handle, nil
+}
+
+func storeIdentity(ctx context.Context, q *db.Queries, did syntax.DID, handle syntax.Handle) error {
+	return q.StoreIdentity(ctx, db.StoreIdentityParams{
+		Did:       did.String(),
+		Handle:    handle.Normalize().String(),
+		UpdatedAt: time.Now().Unix(),
+	})
+}
+
+type identityEvent struct {
+	DID      string `json:"did"`
+	Handle   string `json:"handle"`
+	IsActive bool   `json:"is_active"`
+	Status   string `json:"status"`
+}
+
+func (s *Server) handleIdentityEvent(ctx context.Context, ev *identityEvent) error {
+	did, err := syntax.ParseDID(ev.DID)
+	if err != nil {
+		slog.WarnContext(ctx, "invalid DID in identity event", "did", ev.DID, "error", err)
+		return nil
+	}
+	handle, err := syntax.ParseHandle(ev.Handle)
+	if err != nil {
+		// The handle might be missing if it failed verification.
+		handle = syntax.HandleInvalid
+	}
+	slog.DebugContext(ctx, "storing identity", "did", did, "handle", handle,
+		"active", ev.IsActive, "status", ev.Status)
+	if err := storeIdentity(ctx, s.queries, did, handle); err != nil {
+		return fmt.Errorf("store identity: %w", err)
+	}
+	return nil
+}
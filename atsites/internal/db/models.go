@@ -12,6 +12,18 @@ type Document struct {
 	DocumentJson    []byte
 }
 
+type DocumentsFt struct {
+	Title       string
+	Description string
+	Content     string
+}
+
+type Identity struct {
+	Did       string
+	Handle    string
+	UpdatedAt int64
+}
+
 type Publication struct {
 	Repo       string
 	Rkey       string
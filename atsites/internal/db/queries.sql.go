@@ -24,6 +24,16 @@ func (q *Queries) DeleteDocument(ctx context.Context, arg DeleteDocumentParams)
 	return err
 }
 
+const deleteDocumentsForRepo = `-- name: DeleteDocumentsForRepo :exec
+DELETE FROM documents
+WHERE repo = ?
+`
+
+func (q *Queries) DeleteDocumentsForRepo(ctx context.Context, repo string) error {
+	_, err := q.db.ExecContext(ctx, deleteDocumentsForRepo, repo)
+	return err
+}
+
 const deletePublication = `-- name: DeletePublication :exec
 DELETE FROM publications
 WHERE repo = ? AND rkey = ?
@@ -39,6 +49,40 @@ func (q *Queries) DeletePublication(ctx context.Context, arg DeletePublicationPa
 	return err
 }
 
+const deletePublicationsForRepo = `-- name: DeletePublicationsForRepo :exec
+DELETE FROM publications
+WHERE repo = ?
+`
+
+func (q *Queries) DeletePublicationsForRepo(ctx context.Context, repo string) error {
+	_, err := q.db.ExecContext(ctx, deletePublicationsForRepo, repo)
+	return err
+}
+
+const getDocument = `-- name: GetDocument :one
+SELECT document_json, publication_repo, publication_rkey
+FROM documents
+WHERE repo = ? AND rkey = ?
+`
+
+type GetDocumentParams struct {
+	Repo string
+	Rkey string
+}
+
+type GetDocumentRow struct {
+	DocumentJson    []byte
+	PublicationRepo string
+	PublicationRkey string
+}
+
+func (q *Queries) GetDocument(ctx context.Context, arg GetDocumentParams) (GetDocumentRow, error) {
+	row := q.db.QueryRowContext(ctx, getDocument, arg.Repo, arg.Rkey)
+	var i GetDocumentRow
+	err := row.Scan(&i.DocumentJson, &i.PublicationRepo, &i.PublicationRkey)
+	return i, err
+}
+
 const getDocumentsForPublication = `-- name: GetDocumentsForPublication :many
 SELECT document_json, rkey
 FROM documents
@@ -80,6 +124,44 @@ func (q *Queries) GetDocumentsForPublication(ctx context.Context, arg GetDocumen
 	return items, nil
 }
 
+const getIdentityByDID = `-- name: GetIdentityByDID :one
+SELECT handle, updated_at
+FROM identities
+WHERE did = ?
+`
+
+type GetIdentityByDIDRow struct {
+	Handle    string
+	UpdatedAt int64
+}
+
+func (q *Queries) GetIdentityByDID(ctx context.Context, did string) (GetIdentityByDIDRow, error) {
+	row := q.db.QueryRowContext(ctx, getIdentityByDID, did)
+	var i GetIdentityByDIDRow
+	err := row.Scan(&i.Handle, &i.UpdatedAt)
+	return i, err
+}
+
+const getIdentityByHandle = `-- name: GetIdentityByHandle :one
+SELECT did, updated_at
+FROM identities
+WHERE handle = ?
+ORDER BY updated_at DESC
+LIMIT 1
+`
+
+type GetIdentityByHandleRow struct {
+	Did       string
+	UpdatedAt int64
+}
+
+func (q *Queries) GetIdentityByHandle(ctx context.Context, handle string) (GetIdentityByHandleRow, error) {
+	row := q.db.QueryRowContext(ctx, getIdentityByHandle, handle)
+	var i GetIdentityByHandleRow
+	err := row.Scan(&i.Did, &i.UpdatedAt)
+	return i, err
+}
+
 const getPublication = `-- name: GetPublication :one
 SELECT record_json
 FROM publications
@@ -133,6 +215,241 @@ func (q *Queries) GetPublications(ctx context.Context, repo string) ([]GetPublic
 	return items, nil
 }
 
+const getRecentDocuments = `-- name: GetRecentDocuments :many
+SELECT documents.repo, documents.rkey, documents.document_json,
+    documents.publication_rkey, publications.record_json
+FROM documents
+JOIN publications ON publications.repo = documents.publication_repo
+    AND publications.rkey = documents.publication_rkey
+WHERE documents.repo = documents.publication_repo
+AND CAST(json_extract(documents.document_json, '$.publishedAt') AS TEXT) <= CAST(?1 AS TEXT)
+ORDER BY json_extract(documents.document_json, '$.publishedAt') DESC
+LIMIT ?2
+`
+
+type GetRecentDocumentsParams struct {
+	Before  string
+	MaxRows int64
+}
+
+type GetRecentDocumentsRow struct {
+	Repo            string
+	Rkey            string
+	DocumentJson    []byte
+	PublicationRkey string
+	RecordJson      []byte
+}
+
+func (q *Queries) GetRecentDocuments(ctx context.Context, arg GetRecentDocumentsParams) ([]GetRecentDocumentsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentDocuments, arg.Before, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentDocumentsRow
+	for rows.Next() {
+		var i GetRecentDocumentsRow
+		if err := rows.Scan(
+			&i.Repo,
+			&i.Rkey,
+			&i.DocumentJson,
+			&i.PublicationRkey,
+			&i.RecordJson,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRecentlyUpdatedPublications = `-- name: GetRecentlyUpdatedPublications :many
+SELECT publications.repo, publications.rkey, publications.record_json,
+    CAST(MAX(COALESCE(json_extract(documents.document_json, '$.updatedAt'), json_extract(documents.document_json, '$.publishedAt'))) AS TEXT) AS updated_at
+FROM publications
+JOIN documents ON documents.publication_repo = publications.repo
+    AND documents.publication_rkey = publications.rkey
+    AND documents.repo = publications.repo
+WHERE CAST(COALESCE(json_extract(documents.document_json, '$.updatedAt'), json_extract(documents.document_json, '$.publishedAt')) AS TEXT) <= CAST(?1 AS TEXT)
+GROUP BY publications.repo, publications.rkey
+ORDER BY updated_at DESC
+LIMIT ?2
+`
+
+type GetRecentlyUpdatedPublicationsParams struct {
+	Before  string
+	MaxRows int64
+}
+
+type GetRecentlyUpdatedPublicationsRow struct {
+	Repo       string
+	Rkey       string
+	RecordJson []byte
+	UpdatedAt  string
+}
+
+func (q *Queries) GetRecentlyUpdatedPublications(ctx context.Context, arg GetRecentlyUpdatedPublicationsParams) ([]GetRecentlyUpdatedPublicationsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRecentlyUpdatedPublications, arg.Before, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRecentlyUpdatedPublicationsRow
+	for rows.Next() {
+		var i GetRecentlyUpdatedPublicationsRow
+		if err := rows.Scan(
+			&i.Repo,
+			&i.Rkey,
+			&i.RecordJson,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnindexedDocuments = `-- name: GetUnindexedDocuments :many
+SELECT repo, rkey, document_json
+FROM documents
+WHERE rowid NOT IN (SELECT rowid FROM documents_fts)
+`
+
+type GetUnindexedDocumentsRow struct {
+	Repo         string
+	Rkey         string
+	DocumentJson []byte
+}
+
+func (q *Queries) GetUnindexedDocuments(ctx context.Context) ([]GetUnindexedDocumentsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUnindexedDocuments)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUnindexedDocumentsRow
+	for rows.Next() {
+		var i GetUnindexedDocumentsRow
+		if err := rows.Scan(&i.Repo, &i.Rkey, &i.DocumentJson); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const indexDocument = `-- name: IndexDocument :exec
+INSERT OR REPLACE INTO documents_fts (rowid, title, description, content)
+SELECT documents.rowid, ?1, ?2, ?3
+FROM documents
+WHERE repo = ?4 AND rkey = ?5
+`
+
+type IndexDocumentParams struct {
+	Title       string
+	Description string
+	Content     string
+	Repo        string
+	Rkey        string
+}
+
+func (q *Queries) IndexDocument(ctx context.Context, arg IndexDocumentParams) error {
+	_, err := q.db.ExecContext(ctx, indexDocument,
+		arg.Title,
+		arg.Description,
+		arg.Content,
+		arg.Repo,
+		arg.Rkey,
+	)
+	return err
+}
+
+const searchDocuments = `-- name: SearchDocuments :many
+SELECT documents.repo, documents.rkey, documents.document_json,
+    documents.publication_rkey, publications.record_json, matches.snippet
+FROM (
+    SELECT CAST(documents_fts.rowid AS INTEGER) AS fts_rowid, CAST(documents_fts.rank AS REAL) AS fts_rank,
+        CAST(snippet(documents_fts, -1, char(57344), char(57345), char(8230), 24) AS TEXT) AS snippet
+    FROM documents_fts
+    WHERE documents_fts.title MATCH ?1
+        OR documents_fts.description MATCH ?1
+        OR documents_fts.content MATCH ?1
+    ORDER BY fts_rank
+    LIMIT 1000
+) AS matches
+JOIN documents ON documents.rowid = matches.fts_rowid
+JOIN publications ON publications.repo = documents.publication_repo
+    AND publications.rkey = documents.publication_rkey
+WHERE documents.repo = documents.publication_repo
+ORDER BY matches.fts_rank
+LIMIT ?2
+`
+
+type SearchDocumentsParams struct {
+	Query   string
+	MaxRows int64
+}
+
+type SearchDocumentsRow struct {
+	Repo            string
+	Rkey            string
+	DocumentJson    []byte
+	PublicationRkey string
+	RecordJson      []byte
+	Snippet         string
+}
+
+// The MATCH constraints must be in a subquery on documents_fts alone, which
+// the LIMIT keeps from being flattened into the join.
+func (q *Queries) SearchDocuments(ctx context.Context, arg SearchDocumentsParams) ([]SearchDocumentsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchDocuments, arg.Query, arg.MaxRows)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchDocumentsRow
+	for rows.Next() {
+		var i SearchDocumentsRow
+		if err := rows.Scan(
+			&i.Repo,
+			&i.Rkey,
+			&i.DocumentJson,
+			&i.PublicationRkey,
+			&i.RecordJson,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const storeDocument = `-- name: StoreDocument :exec
 INSERT INTO documents (repo, rkey, publication_repo, publication_rkey, document_json)
 VALUES (?, ?, ?, ?, ?)
@@ -158,6 +475,23 @@ func (q *Queries) StoreDocument(ctx context.Context, arg StoreDocumentParams) er
 	return err
 }
 
+const storeIdentity = `-- name: StoreIdentity :exec
+INSERT INTO identities (did, handle, updated_at)
+VALUES (?, ?, ?)
+ON CONFLICT(did) DO UPDATE SET handle=excluded.handle, updated_at=excluded.updated_at
+`
+
+type StoreIdentityParams struct {
+	Did       string
+	Handle    string
+	UpdatedAt int64
+}
+
+func (q *Queries) StoreIdentity(ctx context.Context, arg StoreIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, storeIdentity, arg.Did, arg.Handle, arg.UpdatedAt)
+	return err
+}
+
 const storePublication = `-- name: StorePublication :exec
 INSERT INTO publications (repo, rkey, record_json)
 VALUES (?, ?, ?)
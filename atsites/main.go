@@ -42,7 +42,16 @@ func main() {
 	tapFlag := flag.String("tap", "ws://localhost:2480/channel", "Tap WebSocket URL")
 	listenFlag := flag.String("listen", ":8000", "address to listen on for HTTP server")
 	debugFlag := flag.Bool("debug", false, "enable debug logging")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s [flags] backfill <did or handle>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if flag.NArg() > 0 && (flag.Arg(0) != "backfill" || flag.NArg() == 1) {
+		flag.Usage()
+		os.Exit(2)
+	}
 
 	if *debugFlag {
 		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
@@ -68,7 +77,31 @@ func main() {
 		return
 	}
 
-	s := &Server{db: sqlDB, queries: db.New(sqlDB)}
+	s := &Server{db: sqlDB, queries: db.New(sqlDB), dir: &identity.BaseDirectory{}}
+
+	if err := s.reindex(ctx); err != nil {
+		slog.Error("failed to index documents", "error", err)
+		return
+	}
+
+	if flag.Arg(0) == "backfill" {
+		failed := false
+		for _, account := range flag.Args()[1:] {
+			if err := s.backfill(ctx, account); err != nil {
+				slog.Error("failed to backfill repository", "account", account, "error", err)
+				failed = true
+			}
+		}
+		if err := sqlDB.Close(); err != nil {
+			slog.Error("failed to close SQLite database", "error", err)
+			failed = true
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
 	group.Go(func() error {
 		c, _, err := websocket.Dial(ctx, *tapFlag, nil)
 		if err != nil {
@@ -109,14 +142,16 @@ func main() {
 type Server struct {
 	db      *sql.DB
 	queries *db.Queries
+	dir     identity.Directory
 }
 
 func (s *Server) handleTap(ctx context.Context, c *websocket.Conn) error {
 	for {
 		var msg struct {
-			ID     int             `json:"id"`
-			Type   string          `json:"type"` // record, identity
-			Record json.RawMessage `json:"record"`
+			ID       int             `json:"id"`
+			Type     string          `json:"type"` // record, identity
+			Record   json.RawMessage `json:"record"`
+			Identity json.RawMessage `json:"identity"`
 		}
 		if err := wsjson.Read(ctx, c, &msg); err != nil {
 			return err
@@ -124,8 +159,14 @@ func (s *Server) handleTap(ctx context.Context, c *websocket.Conn) error {
 
 		switch msg.Type {
 		case "identity":
-			slog.DebugContext(ctx, "received identity event")
-			continue
+			var ev identityEvent
+			if err := json.Unmarshal(msg.Identity, &ev); err != nil {
+				return fmt.Errorf("unmarshal identity: %w", err)
+			}
+			slog.DebugContext(ctx, "received identity event", "did", ev.DID, "handle", ev.Handle)
+			if err := s.handleIdentityEvent(ctx, &ev); err != nil {
+				return fmt.Errorf("handle identity event: %w", err)
+			}
 		case "record":
 			var rec recordEvent
 			if err := json.Unmarshal(msg.Record, &rec); err != nil {
@@ -133,7 +174,7 @@ func (s *Server) handleTap(ctx context.Context, c *websocket.Conn) error {
 			}
 			slog.DebugContext(ctx, "received record event", "action", rec.Action,
 				"uri", fmt.Sprintf("at://%s/%s/%s", rec.Repo, rec.Collection, rec.Rkey))
-			if err := s.handleRecordEvent(ctx, &rec); err != nil {
+			if err := s.handleRecordEvent(ctx, s.queries, &rec); err != nil {
 				return fmt.Errorf("handle record event: %w", err)
 			}
 		default:
@@ -160,18 +201,18 @@ type recordEvent struct {
 	Record     json.RawMessage `json:"record"`
 }
 
-func (s *Server) handleRecordEvent(ctx context.Context, rec *recordEvent) error {
+func (s *Server) handleRecordEvent(ctx context.Context, q *db.Queries, rec *recordEvent) error {
 	switch rec.Collection {
 	case "site.standard.publication":
 		if rec.Action == "delete" {
 			slog.DebugContext(ctx, "deleting publication", "repo", rec.Repo, "rkey", rec.Rkey)
-			return s.queries.DeletePublication(ctx, db.DeletePublicationParams{
+			return q.DeletePublication(ctx, db.DeletePublicationParams{
 				Repo: rec.Repo,
 				Rkey: rec.Rkey,
 			})
 		}
 		slog.DebugContext(ctx, "storing publication", "repo", rec.Repo, "rkey", rec.Rkey)
-		return s.queries.StorePublication(ctx, db.StorePublicationParams{
+		return q.StorePublication(ctx, db.StorePublicationParams{
 			Repo:       rec.Repo,
 			Rkey:       rec.Rkey,
 			RecordJson: rec.Record,
@@ -179,7 +220,7 @@ func (s *Server) handleRecordEvent(ctx context.Context, rec *recordEvent) error
 	case "site.standard.document":
 		if rec.Action == "delete" {
 			slog.DebugContext(ctx, "deleting document", "repo", rec.Repo, "rkey", rec.Rkey)
-			return s.queries.DeleteDocument(ctx, db.DeleteDocumentParams{
+			return q.DeleteDocument(ctx, db.DeleteDocumentParams{
 				Repo: rec.Repo,
 				Rkey: rec.Rkey,
 			})
@@ -212,13 +253,16 @@ func (s *Server) handleRecordEvent(ctx context.Context, rec *recordEvent) error
 		lastDocumentSeen.Store(new(time.Now()))
 		slog.DebugContext(ctx, "storing document", "repo", rec.Repo, "rkey", rec.Rkey,
 			"publication_repo", u.Did, "publication_rkey", u.Rkey)
-		return s.queries.StoreDocument(ctx, db.StoreDocumentParams{
+		if err := q.StoreDocument(ctx, db.StoreDocumentParams{
 			Repo:            rec.Repo,
 			Rkey:            rec.Rkey,
 			PublicationRepo: u.Did,
 			PublicationRkey: u.Rkey,
 			DocumentJson:    rec.Record,
-		})
+		}); err != nil {
+			return err
+		}
+		return indexDocument(ctx, q, rec.Repo, rec.Rkey, rec.Record)
 	default:
 		slog.DebugContext(ctx, "ignoring record from unknown collection",
 			"uri", fmt.Sprintf("at://%s/%s/%s", rec.Repo, rec.Collection, rec.Rkey))
@@ -251,6 +295,10 @@ func (s *Server) httpHandler() http.Handler {
 	mux.HandleFunc("GET /profile/{handle}", s.handleProfile)
 	mux.HandleFunc("GET /profile/{did}/publication/{rkey}", s.handlePublication)
 	mux.HandleFunc("GET /profile/{did}/publication/{rkey}/atom.xml", s.handleFeed)
+	mux.HandleFunc("GET /profile/{did}/document/{rkey}", s.handleDocument)
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /recent", s.handleRecent)
+	mux.HandleFunc("GET /atom.xml", s.handleRecentFeed)
 	return mux
 }
 
@@ -276,13 +324,13 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("invalid AT identifier: %v", err), http.StatusBadRequest)
 		return
 	}
-	i, err := (&identity.BaseDirectory{}).Lookup(r.Context(), id)
+	did, handle, err := s.lookupIdentity(r.Context(), id)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to resolve handle: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	publications, err := s.getPublications(r.Context(), i.DID.String())
+	publications, err := s.getPublications(r.Context(), did.String())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to fetch publications: %v", err), http.StatusInternalServerError)
 		return
@@ -294,8 +342,8 @@ func (s *Server) handleProfile(w http.ResponseWriter, r *http.Request) {
 		DID          string
 		Publications []*Publication
 	}{
-		Handle:       i.Handle.String(),
-		DID:          i.DID.String(),
+		Handle:       handle.String(),
+		DID:          did.String(),
 		Publications: publications,
 	}); err != nil && r.Context().Err() == nil {
 		slog.ErrorContext(r.Context(), "execute profile template", "error", err)
@@ -343,7 +391,7 @@ func (s *Server) handlePublication(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("invalid DID: %v", err), http.StatusBadRequest)
 		return
 	}
-	i, err := (&identity.BaseDirectory{}).LookupDID(r.Context(), did)
+	_, handle, err := s.lookupIdentity(r.Context(), did.AtIdentifier())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to resolve DID: %v", err), http.StatusInternalServerError)
 		return
@@ -372,7 +420,7 @@ func (s *Server) handlePublication(w http.ResponseWriter, r *http.Request) {
 		Publication *Publication
 		Documents   []*Document
 	}{
-		Handle:      i.Handle.String(),
+		Handle:      handle.String(),
 		Publication: publication,
 		Documents:   documents,
 	}); err != nil && r.Context().Err() == nil {
@@ -389,6 +437,9 @@ type Document struct {
 	Description string `json:"description"`
 	PublishedAt string `json:"publishedAt"`
 	UpdatedAt   string `json:"updatedAt"`
+	TextContent string `json:"textContent"`
+
+	Content json.RawMessage `json:"content"`
 }
 
 func (s *Server) getPublication(ctx context.Context, repo, rkey string) (*Publication, error) {
@@ -452,7 +503,7 @@ func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("invalid DID: %v", err), http.StatusBadRequest)
 		return
 	}
-	i, err := (&identity.BaseDirectory{}).LookupDID(r.Context(), did)
+	_, handle, err := s.lookupIdentity(r.Context(), did.AtIdentifier())
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to resolve DID: %v", err), http.StatusInternalServerError)
 		return
@@ -475,10 +526,10 @@ func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
 	}
 
 	f := &feeds.Feed{
-		Title:       publication.Name + " by " + i.Handle.String(),
+		Title:       publication.Name + " by " + handle.String(),
 		Link:        &feeds.Link{Href: publication.URL},
 		Description: publication.Description,
-		Author:      &feeds.Author{Name: i.Handle.String()},
+		Author:      &feeds.Author{Name: handle.String()},
 	}
 	for _, doc := range documents {
 		item := &feeds.Item{
@@ -487,7 +538,7 @@ func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
 			Title:       doc.Title,
 			Content:     doc.Description,
 			Link:        &feeds.Link{Href: publication.URL + doc.Path},
-			Author:      &feeds.Author{Name: i.Handle.String()},
+			Author:      &feeds.Author{Name: handle.String()},
 		}
 		t, err := syntax.ParseDatetimeLenient(doc.PublishedAt)
 		if err == nil {
@@ -508,3 +559,63 @@ func (s *Server) handleFeed(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(atom))
 
 }
+
+var documentTemplate = template.Must(template.New("document.html").ParseFS(templates, "templates/document.html"))
+
+func (s *Server) handleDocument(w http.ResponseWriter, r *http.Request) {
+	repo := r.PathValue("did")
+	rkey := r.PathValue("rkey")
+
+	did, err := syntax.ParseDID(repo)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid DID: %v", err), http.StatusBadRequest)
+		return
+	}
+	row, err := s.queries.GetDocument(r.Context(), db.GetDocumentParams{
+		Repo: repo,
+		Rkey: rkey,
+	})
+	// Documents can only be published in publications of the same repo.
+	if err == sql.ErrNoRows || err == nil && row.PublicationRepo != repo {
+		http.Error(w, "document not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch document: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	_, handle, err := s.lookupIdentity(r.Context(), did.AtIdentifier())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to resolve DID: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	publication, err := s.getPublication(r.Context(), repo, row.PublicationRkey)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch publication: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if publication == nil {
+		http.Error(w, "publication not found", http.StatusNotFound)
+		return
+	}
+
+	doc := parseDocument(repo, rkey, row.DocumentJson)
+	content, _ := renderContent(doc.Content, doc.TextContent)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := documentTemplate.Execute(w, struct {
+		Handle      string
+		Publication *Publication
+		Document    *Document
+		Content     template.HTML
+	}{
+		Handle:      handle.String(),
+		Publication: publication,
+		Document:    doc,
+		Content:     content,
+	}); err != nil && r.Context().Err() == nil {
+		slog.ErrorContext(r.Context(), "execute document template", "error", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"filippo.io/mostly-harmless/atsites/internal/db"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/gorilla/feeds"
+)
+
+// handleFor returns the handle of a DID for display, or the DID itself if it
+// can't be resolved.
+func (s *Server) handleFor(ctx context.Context, repo string) string {
+	did, err := syntax.ParseDID(repo)
+	if err != nil {
+		return repo
+	}
+	_, handle, err := s.lookupIdentity(ctx, did.AtIdentifier())
+	if err != nil || handle == syntax.HandleInvalid {
+		return repo
+	}
+	return handle.String()
+}
+
+// now is the upper bound for document dates, to keep documents with dates in
+// the future from sticking at the top of listings.
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+type recentPublication struct {
+	Publication *Publication
+	Handle      string
+	UpdatedAt   string
+}
+
+var recentTemplate = template.Must(template.New("recent.html").ParseFS(templates, "templates/recent.html"))
+
+func (s *Server) handleRecent(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.queries.GetRecentlyUpdatedPublications(r.Context(), db.GetRecentlyUpdatedPublicationsParams{
+		Before:  now(),
+		MaxRows: 50,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch publications: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	publications := make([]recentPublication, 0, len(rows))
+	for _, row := range rows {
+		publications = append(publications, recentPublication{
+			Publication: parsePublication(row.Repo, row.Rkey, row.RecordJson),
+			Handle:      s.handleFor(r.Context(), row.Repo),
+			UpdatedAt:   row.UpdatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := recentTemplate.Execute(w, struct {
+		Publications []recentPublication
+	}{
+		Publications: publications,
+	}); err != nil && r.Context().Err() == nil {
+		slog.ErrorContext(r.Context(), "execute recent template", "error", err)
+	}
+}
+
+// handleRecentFeed serves a combined Atom feed of the latest documents across
+// all publications.
+func (s *Server) handleRecentFeed(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.queries.GetRecentDocuments(r.Context(), db.GetRecentDocumentsParams{
+		Before:  now(),
+		MaxRows: 50,
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch documents: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	f := &feeds.Feed{
+		Title:       "AT Sites",
+		Link:        &feeds.Link{Href: "https://sites.at.geomys.org/"},
+		Description: "The latest documents from standard.site publications.",
+	}
+	for _, row := range rows {
+		publication := parsePublication(row.Repo, row.PublicationRkey, row.RecordJson)
+		doc := parseDocument(row.Repo, row.Rkey, row.DocumentJson)
+		if publication.Invalid || doc.Invalid {
+			continue
+		}
+		handle := s.handleFor(r.Context(), row.Repo)
+		item := &feeds.Item{
+			Id:          fmt.Sprintf("at://%s/site.standard.document/%s", row.Repo, row.Rkey),
+			IsPermaLink: "false",
+			Title:       doc.Title,
+			Content:     doc.Description,
+			Link:        &feeds.Link{Href: publication.URL + doc.Path},
+			Author:      &feeds.Author{Name: publication.Name + " by " + handle},
+		}
+		t, err := syntax.ParseDatetimeLenient(doc.PublishedAt)
+		if err == nil {
+			item.Created = t.Time()
+		}
+		u, err := syntax.ParseDatetimeLenient(doc.UpdatedAt)
+		if err == nil {
+			item.Updated = u.Time()
+		}
+		f.Add(item)
+	}
+	atom, err := f.ToAtom()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to generate Atom feed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(atom))
+}
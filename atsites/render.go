@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"html"
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+// renderContent converts the content of a site.standard.document record to
+// HTML, and extracts its plain text for the search index.
+//
+// The content is an open union, defined by the publishing platform. Leaflet
+// documents (pub.leaflet.content) are rendered block by block, everything
+// else falls back to the textContent field.
+//
+// The HTML is built from a fixed set of tags, with all text escaped, and only
+// http(s) links, so it's safe to embed in pages.
+func renderContent(content json.RawMessage, textContent string) (template.HTML, string) {
+	r := &renderer{}
+	var c struct {
+		Type  string `json:"$type"`
+		Pages []struct {
+			Blocks []struct {
+				Block json.RawMessage `json:"block"`
+			} `json:"blocks"`
+		} `json:"pages"`
+	}
+	if err := json.Unmarshal(content, &c); err == nil && c.Type == "pub.leaflet.content" {
+		for _, p := range c.Pages {
+			for _, b := range p.Blocks {
+				r.block(b.Block)
+			}
+		}
+	}
+	if r.html.Len() == 0 {
+		for _, p := range strings.Split(strings.ReplaceAll(textContent, "\r\n", "\n"), "\n\n") {
+			if p = strings.TrimSpace(p); p != "" {
+				r.html.WriteString("<p>")
+				r.plain(p)
+				r.html.WriteString("</p>\n")
+				r.text.WriteString(p + "\n")
+			}
+		}
+	}
+	return template.HTML(r.html.String()), r.text.String()
+}
+
+type renderer struct {
+	html, text strings.Builder
+}
+
+type leafletBlock struct {
+	Type        string          `json:"$type"`
+	Plaintext   string          `json:"plaintext"`
+	Facets      []leafletFacet  `json:"facets"`
+	Level       int             `json:"level"`
+	Children    []leafletItem   `json:"children"`
+	Alt         string          `json:"alt"`
+	Src         string          `json:"src"`
+	URL         string          `json:"url"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Text        string          `json:"text"`
+	Tex         string          `json:"tex"`
+	Content     json.RawMessage `json:"content"`
+}
+
+type leafletItem struct {
+	Content  json.RawMessage `json:"content"`
+	Children []leafletItem   `json:"children"`
+}
+
+type leafletFacet struct {
+	Index struct {
+		ByteStart int `json:"byteStart"`
+		ByteEnd   int `json:"byteEnd"`
+	} `json:"index"`
+	Features []struct {
+		Type  string `json:"$type"`
+		URI   string `json:"uri"`
+		DID   string `json:"did"`
+		AtURI string `json:"atURI"`
+	} `json:"features"`
+}
+
+func (r *renderer) block(raw json.RawMessage) {
+	var b leafletBlock
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return
+	}
+	switch strings.TrimPrefix(b.Type, "pub.leaflet.blocks.") {
+	case "text":
+		if strings.TrimSpace(b.Plaintext) == "" {
+			return
+		}
+		r.html.WriteString("<p>")
+		r.richText(b.Plaintext, b.Facets)
+		r.html.WriteString("</p>\n")
+		r.text.WriteString(b.Plaintext + "\n")
+	case "header":
+		// The document title is the h1.
+		tag := "h" + string(rune('0'+min(max(b.Level, 1)+1, 6)))
+		r.html.WriteString("<" + tag + ">")
+		r.richText(b.Plaintext, b.Facets)
+		r.html.WriteString("</" + tag + ">\n")
+		r.text.WriteString(b.Plaintext + "\n")
+	case "blockquote":
+		r.html.WriteString("<blockquote><p>")
+		r.richText(b.Plaintext, b.Facets)
+		r.html.WriteString("</p></blockquote>\n")
+		r.text.WriteString(b.Plaintext + "\n")
+	case "code":
+		r.html.WriteString("<pre><code>")
+		r.html.WriteString(html.EscapeString(b.Plaintext))
+		r.html.WriteString("</code></pre>\n")
+		r.text.WriteString(b.Plaintext + "\n")
+	case "math":
+		r.html.WriteString("<pre>")
+		r.html.WriteString(html.EscapeString(b.Tex))
+		r.html.WriteString("</pre>\n")
+	case "horizontalRule":
+		r.html.WriteString("<hr>\n")
+	case "unorderedList":
+		r.list("ul", b.Children)
+	case "orderedList":
+		r.list("ol", b.Children)
+	case "image":
+		if b.Alt != "" {
+			r.html.WriteString("<p><em>[image: ")
+			r.plain(b.Alt)
+			r.html.WriteString("]</em></p>\n")
+			r.text.WriteString(b.Alt + "\n")
+		}
+	case "website":
+		r.html.WriteString("<p>")
+		r.link(b.Src, cmp.Or(b.Title, b.Src))
+		if b.Description != "" {
+			r.html.WriteString("<br>")
+			r.plain(b.Description)
+		}
+		r.html.WriteString("</p>\n")
+		r.text.WriteString(b.Title + "\n" + b.Description + "\n")
+	case "button":
+		r.html.WriteString("<p>")
+		r.link(b.URL, b.Text)
+		r.html.WriteString("</p>\n")
+		r.text.WriteString(b.Text + "\n")
+	case "iframe":
+		r.html.WriteString("<p>")
+		r.link(b.URL, b.URL)
+		r.html.WriteString("</p>\n")
+	}
+}
+
+func (r *renderer) list(tag string, items []leafletItem) {
+	if len(items) == 0 {
+		return
+	}
+	r.html.WriteString("<" + tag + ">\n")
+	for _, item := range items {
+		r.html.WriteString("<li>")
+		r.block(item.Content)
+		r.list(tag, item.Children)
+		r.html.WriteString("</li>\n")
+	}
+	r.html.WriteString("</" + tag + ">\n")
+}
+
+// richText renders text with Leaflet facets, which apply formatting to UTF-8
+// byte ranges, possibly overlapping.
+func (r *renderer) richText(text string, facets []leafletFacet) {
+	var valid []leafletFacet
+	cuts := []int{0, len(text)}
+	for _, f := range facets {
+		start, end := f.Index.ByteStart, f.Index.ByteEnd
+		if start < 0 || end > len(text) || start >= end ||
+			!utf8.RuneStart(text[start]) || (end < len(text) && !utf8.RuneStart(text[end])) {
+			continue
+		}
+		valid = append(valid, f)
+		cuts = append(cuts, start, end)
+	}
+	sort.Ints(cuts)
+	for i := 1; i < len(cuts); i++ {
+		start, end := cuts[i-1], cuts[i]
+		if start == end {
+			continue
+		}
+		var closing []string
+		for _, f := range valid {
+			if f.Index.ByteStart > start || f.Index.ByteEnd < end {
+				continue
+			}
+			for _, feature := range f.Features {
+				open, close := r.facetTags(feature.Type, feature.URI, feature.DID, feature.AtURI)
+				r.html.WriteString(open)
+				closing = append(closing, close)
+			}
+		}
+		r.plain(text[start:end])
+		for i := len(closing) - 1; i >= 0; i-- {
+			r.html.WriteString(closing[i])
+		}
+	}
+}
+
+func (r *renderer) facetTags(featureType, uri, did, atURI string) (open, close string) {
+	switch strings.TrimPrefix(featureType, "pub.leaflet.richtext.facet#") {
+	case "bold":
+		return "<strong>", "</strong>"
+	case "italic":
+		return "<em>", "</em>"
+	case "code":
+		return "<code>", "</code>"
+	case "strikethrough":
+		return "<s>", "</s>"
+	case "underline":
+		return "<u>", "</u>"
+	case "highlight":
+		return "<mark>", "</mark>"
+	case "link":
+		if safeURL(uri) {
+			return `<a href="` + html.EscapeString(uri) + `" rel="nofollow ugc">`, "</a>"
+		}
+	case "didMention":
+		if d, err := syntax.ParseDID(did); err == nil {
+			return `<a href="/profile/` + html.EscapeString(d.String()) + `">`, "</a>"
+		}
+	case "atMention":
+		if u, err := syntax.ParseATURI(atURI); err == nil {
+			return `<a href="/profile/` + html.EscapeString(u.Authority().String()) + `">`, "</a>"
+		}
+	}
+	return "", ""
+}
+
+func (r *renderer) link(href, text string) {
+	if !safeURL(href) {
+		r.plain(text)
+		return
+	}
+	r.html.WriteString(`<a href="` + html.EscapeString(href) + `" rel="nofollow ugc">`)
+	r.plain(text)
+	r.html.WriteString("</a>")
+}
+
+// plain writes escaped text, preserving line breaks.
+func (r *renderer) plain(text string) {
+	r.html.WriteString(strings.ReplaceAll(html.EscapeString(text), "\n", "<br>"))
+}
+
+func safeURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRenderContent(t *testing.T) {
+	content := json.RawMessage(`{
+		"$type": "pub.leaflet.content",
+		"pages": [{"$type": "pub.leaflet.pages.linearDocument", "blocks": [
+			{"block": {"$type": "pub.leaflet.blocks.header", "level": 1, "plaintext": "Hello <world>"}},
+			{"block": {"$type": "pub.leaflet.blocks.text", "plaintext": "bold, link, evil, café!",
+				"facets": [
+					{"index": {"byteStart": 0, "byteEnd": 4}, "features": [{"$type": "pub.leaflet.richtext.facet#bold"}]},
+					{"index": {"byteStart": 6, "byteEnd": 10}, "features": [{"$type": "pub.leaflet.richtext.facet#link", "uri": "https://example.com/?a=1&b=\"2\""}]},
+					{"index": {"byteStart": 12, "byteEnd": 16}, "features": [{"$type": "pub.leaflet.richtext.facet#link", "uri": "javascript:alert(1)"}]},
+					{"index": {"byteStart": 18, "byteEnd": 23}, "features": [{"$type": "pub.leaflet.richtext.facet#italic"}]},
+					{"index": {"byteStart": 18, "byteEnd": 22}, "features": [{"$type": "pub.leaflet.richtext.facet#underline"}]},
+					{"index": {"byteStart": 0, "byteEnd": 99}, "features": [{"$type": "pub.leaflet.richtext.facet#italic"}]}
+				]}},
+			{"block": {"$type": "pub.leaflet.blocks.code", "plaintext": "if a < b {\n}"}},
+			{"block": {"$type": "pub.leaflet.blocks.unorderedList", "children": [
+				{"content": {"$type": "pub.leaflet.blocks.text", "plaintext": "one"}},
+				{"content": {"$type": "pub.leaflet.blocks.text", "plaintext": "two"}}
+			]}},
+			{"block": {"$type": "pub.leaflet.blocks.image", "alt": "a \"cat\""}},
+			{"block": {"$type": "pub.leaflet.blocks.unknown", "plaintext": "ignored"}}
+		]}]
+	}`)
+	html, text := renderContent(content, "fallback")
+	for _, want := range []string{
+		"<h2>Hello &lt;world&gt;</h2>",
+		"<p><strong>bold</strong>, ",
+		`<a href="https://example.com/?a=1&amp;b=&#34;2&#34;" rel="nofollow ugc">link</a>, evil, <em>café</em>!</p>`,
+		"<pre><code>if a &lt; b {\n}</code></pre>",
+		"<ul>\n<li><p>one</p>\n</li>\n<li><p>two</p>\n</li>\n</ul>",
+		"<p><em>[image: a &#34;cat&#34;]</em></p>",
+	} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("rendered HTML does not contain %q:\n%s", want, html)
+		}
+	}
+	for _, unwanted := range []string{"javascript", "ignored", "fallback", "<u>"} {
+		if strings.Contains(string(html), unwanted) {
+			t.Errorf("rendered HTML contains %q:\n%s", unwanted, html)
+		}
+	}
+	if want := "Hello <world>\nbold, link, evil, café!\nif a < b {\n}\none\ntwo\na \"cat\"\n"; text != want {
+		t.Errorf("text = %q, want %q", text, want)
+	}
+
+	html, text = renderContent(json.RawMessage(`{"$type": "com.example.markdown", "markdown": "# Hi"}`),
+		"First <paragraph>\nsame paragraph\n\nSecond paragraph")
+	if want := "<p>First &lt;paragraph&gt;<br>same paragraph</p>\n<p>Second paragraph</p>\n"; string(html) != want {
+		t.Errorf("fallback HTML = %q, want %q", html, want)
+	}
+	if want := "First <paragraph>\nsame paragraph\nSecond paragraph\n"; text != want {
+		t.Errorf("fallback text = %q, want %q", text, want)
+	}
+}
+
+func TestFTSQuery(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                      "",
+		"  hello   world ":      `"hello" "world"`,
+		`NEAR(a b) "quoted" OR`: `"NEAR(a" "b)" """quoted""" "OR"`,
+		"title:x*":              `"title:x*"`,
+	} {
+		if got := ftsQuery(in); got != want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"filippo.io/mostly-harmless/atsites/internal/db"
+)
+
+// indexDocument stores the text of a document in the full-text search index.
+// The document must already be stored.
+func indexDocument(ctx context.Context, q *db.Queries, repo, rkey string, record json.RawMessage) error {
+	doc := parseDocument(repo, rkey, record)
+	_, text := renderContent(doc.Content, doc.TextContent)
+	return q.IndexDocument(ctx, db.IndexDocumentParams{
+		Repo:        repo,
+		Rkey:        rkey,
+		Title:       doc.Title,
+		Description: doc.Description,
+		Content:     text,
+	})
+}
+
+// reindex indexes any documents missing from the full-text search index, such
+// as those stored before the index was introduced.
+func (s *Server) reindex(ctx context.Context) error {
+	rows, err := s.queries.GetUnindexedDocuments(ctx)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		slog.InfoContext(ctx, "indexing documents", "count", len(rows))
+	}
+	for _, row := range rows {
+		if err := indexDocument(ctx, s.queries, row.Repo, row.Rkey, row.DocumentJson); err != nil {
+			return fmt.Errorf("index document at://%s/site.standard.document/%s: %w", row.Repo, row.Rkey, err)
+		}
+	}
+	return nil
+}
+
+// ftsQuery turns user input into an FTS5 query matching documents that contain
+// all the words, without exposing the FTS5 query syntax.
+func ftsQuery(s string) string {
+	var terms []string
+	for _, f := range strings.Fields(s) {
+		terms = append(terms, `"`+strings.ReplaceAll(f, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// snippetHTML escapes a snippet returned by SearchDocuments, and replaces the
+// U+E000 and U+E001 markers around matches with mark tags.
+func snippetHTML(snippet string) template.HTML {
+	s := html.EscapeString(snippet)
+	s = strings.ReplaceAll(s, "\ue000", "<mark>")
+	s = strings.ReplaceAll(s, "\ue001", "</mark>")
+	return template.HTML(s)
+}
+
+type searchResult struct {
+	Publication *Publication
+	Document    *Document
+	Snippet     template.HTML
+}
+
+var searchTemplate = template.Must(template.New("search.html").ParseFS(templates, "templates/search.html"))
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	var results []searchResult
+	if q := ftsQuery(query); q != "" {
+		rows, err := s.queries.SearchDocuments(r.Context(), db.SearchDocumentsParams{
+			Query:   q,
+			MaxRows: 50,
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("failed to search documents: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, row := range rows {
+			results = append(results, searchResult{
+				Publication: parsePublication(row.Repo, row.PublicationRkey, row.RecordJson),
+				Document:    parseDocument(row.Repo, row.Rkey, row.DocumentJson),
+				Snippet:     snippetHTML(row.Snippet),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := searchTemplate.Execute(w, struct {
+		Query   string
+		Results []searchResult
+	}{
+		Query:   query,
+		Results: results,
+	}); err != nil && r.Context().Err() == nil {
+		slog.ErrorContext(r.Context(), "execute search template", "error", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filippo.io/mostly-harmless/atsites/internal/db"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+)
+
+func TestSearchAndDiscovery(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	const did = "did:plc:atsitestestfixture0000000"
+	const other = "did:plc:atsitestestother000000000"
+	s.dir.(*identity.MockDirectory).Insert(identity.Identity{
+		DID: syntax.DID(did), Handle: syntax.Handle("blog.example.com"),
+	})
+
+	for _, rec := range []recordEvent{
+		{Repo: did, Collection: "site.standard.publication", Rkey: "pub", Action: "create",
+			Record: json.RawMessage(`{"url": "https://blog.example.com", "name": "Example Blog"}`)},
+		{Repo: did, Collection: "site.standard.document", Rkey: "old", Action: "create",
+			Record: json.RawMessage(`{"site": "at://` + did + `/site.standard.publication/pub", "path": "/old",
+				"title": "Old post", "publishedAt": "2024-01-01T00:00:00Z",
+				"textContent": "Nothing about <gophers> here."}`)},
+		{Repo: did, Collection: "site.standard.document", Rkey: "new", Action: "create",
+			Record: json.RawMessage(`{"site": "at://` + did + `/site.standard.publication/pub", "path": "/new",
+				"title": "New post", "publishedAt": "2025-01-01T00:00:00Z",
+				"content": {"$type": "pub.leaflet.content", "pages": [{"blocks": [
+					{"block": {"$type": "pub.leaflet.blocks.text", "plaintext": "All about the quokka."}}]}]}}`)},
+		{Repo: did, Collection: "site.standard.document", Rkey: "future", Action: "create",
+			Record: json.RawMessage(`{"site": "at://` + did + `/site.standard.publication/pub", "path": "/future",
+				"title": "Future post", "publishedAt": "2999-01-01T00:00:00Z", "textContent": "quokka"}`)},
+		// A document in someone else's publication.
+		{Repo: other, Collection: "site.standard.document", Rkey: "spam", Action: "create",
+			Record: json.RawMessage(`{"site": "at://` + did + `/site.standard.publication/pub", "path": "/spam",
+				"title": "Spam quokka", "publishedAt": "2025-06-01T00:00:00Z"}`)},
+	} {
+		if err := s.handleRecordEvent(ctx, s.queries, &rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Update a document, replacing its index entry.
+	if err := s.handleRecordEvent(ctx, s.queries, &recordEvent{
+		Repo: did, Collection: "site.standard.document", Rkey: "old", Action: "update",
+		Record: json.RawMessage(`{"site": "at://` + did + `/site.standard.publication/pub", "path": "/old",
+			"title": "Old post", "publishedAt": "2024-01-01T00:00:00Z", "updatedAt": "2025-02-01T00:00:00Z",
+			"textContent": "Now about <gophers> and quokkas."}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) string {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: %d\n%s", path, rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	body := get("/search?q=quokka")
+	if !strings.Contains(body, "/profile/"+did+"/document/new") ||
+		!strings.Contains(body, "All about the <mark>quokka</mark>.") ||
+		!strings.Contains(body, "/profile/"+did+"/document/future") ||
+		strings.Contains(body, "spam") || strings.Contains(body, "/document/old") {
+		t.Errorf("unexpected search results:\n%s", body)
+	}
+	body = get("/search?q=gophers")
+	if !strings.Contains(body, "/document/old") || !strings.Contains(body, "&lt;<mark>gophers</mark>&gt;") {
+		t.Errorf("unexpected search results:\n%s", body)
+	}
+	body = get("/search?q=%22+OR+NEAR(")
+	if !strings.Contains(body, "No documents found.") {
+		t.Errorf("unexpected search results:\n%s", body)
+	}
+
+	// Deleting a document removes it from the index.
+	if err := s.handleRecordEvent(ctx, s.queries, &recordEvent{
+		Repo: did, Collection: "site.standard.document", Rkey: "new", Action: "delete",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if body := get("/search?q=quokka"); strings.Contains(body, "/document/new") {
+		t.Errorf("deleted document in search results:\n%s", body)
+	}
+
+	body = get("/profile/" + did + "/document/old")
+	if !strings.Contains(body, "<p>Now about &lt;gophers&gt; and quokkas.</p>") ||
+		!strings.Contains(body, `<link rel="canonical" href="https://blog.example.com/old">`) ||
+		!strings.Contains(body, `<a href="/profile/blog.example.com">blog.example.com</a>`) {
+		t.Errorf("unexpected document page:\n%s", body)
+	}
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/profile/"+other+"/document/spam", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("document in someone else's publication: %d", rec.Code)
+	}
+
+	body = get("/recent")
+	if !strings.Contains(body, "2025-02-01T00:00:00Z") || !strings.Contains(body, "Example Blog") ||
+		!strings.Contains(body, `<a href="/profile/blog.example.com">`) {
+		t.Errorf("unexpected recent page:\n%s", body)
+	}
+
+	body = get("/atom.xml")
+	if !strings.Contains(body, "<title>Old post</title>") || !strings.Contains(body, "https://blog.example.com/old") ||
+		!strings.Contains(body, "Example Blog by blog.example.com") ||
+		strings.Contains(body, "Future post") || strings.Contains(body, "Spam") {
+		t.Errorf("unexpected feed:\n%s", body)
+	}
+}
+
+func TestReindex(t *testing.T) {
+	ctx := context.Background()
+	s := newTestServer(t)
+	const did = "did:plc:atsitestestfixture0000000"
+	if err := s.queries.StorePublication(ctx, db.StorePublicationParams{
+		Repo: did, Rkey: "pub", RecordJson: []byte(`{"name": "Example Blog"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.queries.StoreDocument(ctx, db.StoreDocumentParams{
+		Repo: did, Rkey: "doc", PublicationRepo: did, PublicationRkey: "pub",
+		DocumentJson: []byte(`{"title": "Unindexed wombat"}`),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	search := db.SearchDocumentsParams{Query: ftsQuery("wombat"), MaxRows: 10}
+	if rows, err := s.queries.SearchDocuments(ctx, search); err != nil || len(rows) != 0 {
+		t.Fatalf("SearchDocuments before reindex = %v, %v", rows, err)
+	}
+	if err := s.reindex(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rows, err := s.queries.SearchDocuments(ctx, search); err != nil || len(rows) != 1 {
+		t.Fatalf("SearchDocuments after reindex = %v, %v", rows, err)
+	}
+}
@@ -33,3 +33,89 @@ FROM documents
 WHERE publication_repo = ? AND publication_rkey = ?
 AND repo = publication_repo -- don't let strangers inject documents into others' publications
 ORDER BY rowid DESC;
+
+-- name: DeletePublicationsForRepo :exec
+DELETE FROM publications
+WHERE repo = ?;
+
+-- name: DeleteDocumentsForRepo :exec
+DELETE FROM documents
+WHERE repo = ?;
+
+-- name: StoreIdentity :exec
+INSERT INTO identities (did, handle, updated_at)
+VALUES (?, ?, ?)
+ON CONFLICT(did) DO UPDATE SET handle=excluded.handle, updated_at=excluded.updated_at;
+
+-- name: GetIdentityByDID :one
+SELECT handle, updated_at
+FROM identities
+WHERE did = ?;
+
+-- name: GetIdentityByHandle :one
+SELECT did, updated_at
+FROM identities
+WHERE handle = ?
+ORDER BY updated_at DESC
+LIMIT 1;
+
+-- name: GetDocument :one
+SELECT document_json, publication_repo, publication_rkey
+FROM documents
+WHERE repo = ? AND rkey = ?;
+
+-- name: IndexDocument :exec
+INSERT OR REPLACE INTO documents_fts (rowid, title, description, content)
+SELECT documents.rowid, sqlc.arg(title), sqlc.arg(description), sqlc.arg(content)
+FROM documents
+WHERE repo = sqlc.arg(repo) AND rkey = sqlc.arg(rkey);
+
+-- name: GetUnindexedDocuments :many
+SELECT repo, rkey, document_json
+FROM documents
+WHERE rowid NOT IN (SELECT rowid FROM documents_fts);
+
+-- name: SearchDocuments :many
+-- The MATCH constraints must be in a subquery on documents_fts alone, which
+-- the LIMIT keeps from being flattened into the join.
+SELECT documents.repo, documents.rkey, documents.document_json,
+    documents.publication_rkey, publications.record_json, matches.snippet
+FROM (
+    SELECT CAST(documents_fts.rowid AS INTEGER) AS fts_rowid, CAST(documents_fts.rank AS REAL) AS fts_rank,
+        CAST(snippet(documents_fts, -1, char(57344), char(57345), char(8230), 24) AS TEXT) AS snippet
+    FROM documents_fts
+    WHERE documents_fts.title MATCH sqlc.arg(query)
+        OR documents_fts.description MATCH sqlc.arg(query)
+        OR documents_fts.content MATCH sqlc.arg(query)
+    ORDER BY fts_rank
+    LIMIT 1000
+) AS matches
+JOIN documents ON documents.rowid = matches.fts_rowid
+JOIN publications ON publications.repo = documents.publication_repo
+    AND publications.rkey = documents.publication_rkey
+WHERE documents.repo = documents.publication_repo
+ORDER BY matches.fts_rank
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetRecentlyUpdatedPublications :many
+SELECT publications.repo, publications.rkey, publications.record_json,
+    CAST(MAX(COALESCE(json_extract(documents.document_json, '$.updatedAt'), json_extract(documents.document_json, '$.publishedAt'))) AS TEXT) AS updated_at
+FROM publications
+JOIN documents ON documents.publication_repo = publications.repo
+    AND documents.publication_rkey = publications.rkey
+    AND documents.repo = publications.repo
+WHERE CAST(COALESCE(json_extract(documents.document_json, '$.updatedAt'), json_extract(documents.document_json, '$.publishedAt')) AS TEXT) <= CAST(sqlc.arg(before) AS TEXT)
+GROUP BY publications.repo, publications.rkey
+ORDER BY updated_at DESC
+LIMIT sqlc.arg(max_rows);
+
+-- name: GetRecentDocuments :many
+SELECT documents.repo, documents.rkey, documents.document_json,
+    documents.publication_rkey, publications.record_json
+FROM documents
+JOIN publications ON publications.repo = documents.publication_repo
+    AND publications.rkey = documents.publication_rkey
+WHERE documents.repo = documents.publication_repo
+AND CAST(json_extract(documents.document_json, '$.publishedAt') AS TEXT) <= CAST(sqlc.arg(before) AS TEXT)
+ORDER BY json_extract(documents.document_json, '$.publishedAt') DESC
+LIMIT sqlc.arg(max_rows);
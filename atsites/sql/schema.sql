@@ -17,3 +17,25 @@ CREATE TABLE IF NOT EXISTS documents (
 
 CREATE INDEX IF NOT EXISTS idx_documents_publication
     ON documents (publication_repo, publication_rkey);
+
+CREATE TABLE IF NOT EXISTS identities (
+    did TEXT NOT NULL PRIMARY KEY,
+    handle TEXT NOT NULL, -- normalized, or handle.invalid
+    updated_at INTEGER NOT NULL -- unix seconds
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS idx_identities_handle
+    ON identities (handle);
+
+-- Full-text index of documents, with the same rowid as the documents table.
+-- Rows are inserted by the application, which extracts the text content.
+CREATE VIRTUAL TABLE IF NOT EXISTS documents_fts USING fts5 (
+    title,
+    description,
+    content
+);
+
+CREATE TRIGGER IF NOT EXISTS documents_fts_delete AFTER DELETE ON documents
+BEGIN
+    DELETE FROM documents_fts WHERE rowid = old.rowid;
+END;
@@ -0,0 +1,162 @@
+//go:build ignore
+
+// gencar generates repo.car, a signed repository export with a few
+// site.standard records, and repo.json, with the DID and public key needed to
+// verify it. Run it with "go run testdata/gencar.go" from the module root.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/atdata"
+	"github.com/bluesky-social/indigo/atproto/repo"
+	"github.com/bluesky-social/indigo/atproto/repo/mst"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	"github.com/ipld/go-car"
+	carutil "github.com/ipld/go-car/util"
+	"github.com/multiformats/go-multihash"
+)
+
+const did = "did:plc:atsitestestfixture0000000"
+
+var records = map[string]map[string]any{
+	"site.standard.publication/3lpubaaaaaaa2": {
+		"$type":       "site.standard.publication",
+		"url":         "https://blog.example.com",
+		"name":        "Example Blog",
+		"description": "A blog about examples.",
+	},
+	"site.standard.document/3ldocaaaaaaa2": {
+		"$type":       "site.standard.document",
+		"site":        "at://" + did + "/site.standard.publication/3lpubaaaaaaa2",
+		"path":        "/first",
+		"title":       "First post",
+		"publishedAt": "2025-01-01T00:00:00Z",
+	},
+	"site.standard.document/3ldocbbbbbbb2": {
+		"$type":       "site.standard.document",
+		"site":        "at://" + did + "/site.standard.publication/3lpubaaaaaaa2",
+		"path":        "/second",
+		"title":       "Second post",
+		"publishedAt": "2025-02-01T00:00:00Z",
+		"updatedAt":   "2025-03-01T00:00:00Z",
+	},
+	"site.standard.document/3ldocccccccc2": {
+		"$type": "site.standard.document",
+		"site":  "https://loose.example.com",
+		"path":  "/loose",
+		"title": "Loose document",
+	},
+	"app.bsky.feed.post/3lpostaaaaaa2": {
+		"$type":     "app.bsky.feed.post",
+		"text":      "not a site record",
+		"createdAt": "2025-01-01T00:00:00Z",
+	},
+}
+
+func main() {
+	ctx := context.Background()
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+
+	tree := mst.NewEmptyTree()
+	for path, record := range records {
+		b, err := atdata.MarshalCBOR(record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		blk := cborBlock(b)
+		if err := bs.Put(ctx, blk); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := tree.Insert([]byte(path), blk.Cid()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	root, err := tree.WriteDiffBlocks(ctx, bs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	if err != nil {
+		log.Fatal(err)
+	}
+	commit := &repo.Commit{
+		DID:     did,
+		Version: repo.ATPROTO_REPO_VERSION,
+		Data:    *root,
+		Rev:     syntax.NewTIDFromTime(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), 0).String(),
+	}
+	if err := commit.Sign(key); err != nil {
+		log.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := commit.MarshalCBOR(buf); err != nil {
+		log.Fatal(err)
+	}
+	commitBlock := cborBlock(buf.Bytes())
+	if err := bs.Put(ctx, commitBlock); err != nil {
+		log.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	if err := car.WriteHeader(&car.CarHeader{Roots: []cid.Cid{commitBlock.Cid()}, Version: 1}, out); err != nil {
+		log.Fatal(err)
+	}
+	keys, err := bs.AllKeysChan(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for c := range keys {
+		// The blockstore indexes by multihash, and returns raw CIDs.
+		c = cid.NewCidV1(cid.DagCBOR, c.Hash())
+		blk, err := bs.Get(ctx, c)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := carutil.LdWrite(out, c.Bytes(), blk.RawData()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := os.WriteFile("testdata/repo.car", out.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+
+	pub, err := key.PublicKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	j, err := json.MarshalIndent(map[string]string{
+		"did": did,
+		"key": pub.Multibase(),
+	}, "", "\t")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("testdata/repo.json", append(j, '\n'), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func cborBlock(b []byte) blocks.Block {
+	c, err := cid.NewPrefixV1(cid.DagCBOR, multihash.SHA2_256).Sum(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blk, err := blocks.NewBlockWithCid(b, c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return blk
+}
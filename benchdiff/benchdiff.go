@@ -1,16 +1,41 @@
-// Command benchdiff runs Go benchmarks on two git refs and uses benchstat to
-// show the delta.
+// Command benchdiff runs Go benchmarks on two git refs and shows the delta,
+// computed like benchstat does.
 //
 // By default, the base ref is HEAD and the head ref is the current worktree.
 // Use the -base-ref and -head-ref flags to specify different refs.
 //
+// To compare more than two refs, use the -refs flag with a comma-separated
+// list, where "worktree" is the current worktree. The first ref is the
+// baseline for the others. For example:
+//
+//	benchdiff -refs v1.2.0,v1.3.0,HEAD,worktree
+//
+// The -go flag similarly takes a comma-separated list of toolchains, each
+// either a command in $PATH (like those installed by golang.org/dl) or a
+// GOTOOLCHAIN value. Every ref is benchmarked with every toolchain.
+//
+//	benchdiff -refs HEAD -go go1.22.0,go1.23.0
+//
 // To pass flags to "go test", pass them after a double dash. For example:
 //
 //	benchdiff -- -benchmem
 //
+// The test binaries for all refs are compiled once, and then run alternately,
+// one round at a time, so that thermal drift and background load affect all
+// of them equally. The number of rounds is set by the "go test" -count flag,
+// which defaults to 6.
+//
 // Non-worktree runs are cached. To clear the cache, use the -clear-cache flag.
 //
-// Benchmarking the standard library is supported.
+// To investigate a delta, use -profile cpu or -profile mem to also collect
+// profiles of the benchmarks matching -profile-bench for each ref, and print
+// the top entries of their difference from the first ref, like
+// "go tool pprof -diff_base". Use -save-profiles to keep the profiles next to
+// the cached results.
+//
+// Benchmarking the standard library is supported. The toolchains built for
+// non-worktree refs are cached by commit hash, and the least recently used are
+// removed when the cache grows larger than -toolchain-cache-size gigabytes.
 //
 // On macOS, benchdiff will attempt to prevent the system from sleeping.
 //
@@ -32,6 +57,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 
 	"github.com/cheggaaa/pb/v3"
@@ -49,7 +75,14 @@ func main() {
 	clearCacheFlag := flag.Bool("clear-cache", false, "clear the cache")
 	baseRef := flag.String("base-ref", "HEAD", "base git ref")
 	headRef := flag.String("head-ref", "", "head git ref (defaults to worktree)")
+	refsFlag := flag.String("refs", "", "comma-separated git refs to compare, including \"worktree\" (overrides -base-ref and -head-ref)")
+	goFlag := flag.String("go", "", "comma-separated go commands or GOTOOLCHAIN values to compare")
 	reduildStdlibFlag := flag.Bool("rebuild-stdlib", true, "rebuild the standard library")
+	toolchainCacheSize := flag.Float64("toolchain-cache-size", 10, "maximum size of the standard library toolchain cache, in GB")
+	profileFlag := flag.String("profile", "", "collect and diff profiles of type cpu or mem")
+	profileBenchFlag := flag.String("profile-bench", ".", "benchmarks to profile (regular expression)")
+	profileTopFlag := flag.Int("profile-top", 20, "number of profile diff entries to show")
+	saveProfilesFlag := flag.Bool("save-profiles", false, "save profiles next to the cached results")
 	debugFlag := flag.Bool("debug", false, "enable debug output")
 
 	flag.Parse()
@@ -67,25 +100,63 @@ func main() {
 		if err != nil {
 			log.Fatalf("error finding files in %s: %v", cacheDir, err)
 		}
+		profiles, err := filepath.Glob(filepath.Join(cacheDir, "benchdiff-*.pprof"))
+		if err != nil {
+			log.Fatalf("error finding files in %s: %v", cacheDir, err)
+		}
+		files = append(files, profiles...)
 		for _, file := range files {
 			err = os.Remove(file)
 			if err != nil {
 				log.Fatalf("error removing %s: %v", file, err)
 			}
 		}
+		if err := os.RemoveAll(filepath.Join(cacheDir, "toolchains")); err != nil {
+			log.Fatalf("error removing cached toolchains: %v", err)
+		}
 		os.Exit(0)
 	}
 
-	benchArgs := []string{"test", "-run", "^$", "-bench", ".", "-count", "6"}
+	benchArgs := []string{"-run", "^$", "-bench", ".", "-count", "6"}
 	benchArgs = append(benchArgs, flag.Args()...)
 
+	refs := []string{*baseRef, *headRef}
+	if *refsFlag != "" {
+		refs = strings.Split(*refsFlag, ",")
+		for i, ref := range refs {
+			if ref == "worktree" {
+				refs[i] = ""
+			}
+		}
+	}
+	var toolchains []string
+	if *goFlag != "" {
+		toolchains = strings.Split(*goFlag, ",")
+	}
+	if *profileFlag != "" && *profileFlag != "cpu" && *profileFlag != "mem" {
+		log.Fatalf("invalid -profile value %q, must be cpu or mem", *profileFlag)
+	}
+	profileDir := getCacheDir()
+	if *profileFlag != "" && !*saveProfilesFlag {
+		tmpDir, err := os.MkdirTemp("", "benchdiff")
+		if err != nil {
+			log.Fatalf("error creating temporary directory: %v", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		profileDir = tmpDir
+	}
+
 	bd := &Benchdiff{
-		BenchArgs:     benchArgs,
-		ResultsDir:    getCacheDir(),
-		BaseRef:       *baseRef,
-		HeadRef:       *headRef,
-		Debug:         log.New(io.Discard, "", 0),
-		RebuildStdlib: *reduildStdlibFlag,
+		BenchArgs:          benchArgs,
+		ResultsDir:         getCacheDir(),
+		Refs:               refs,
+		Toolchains:         toolchains,
+		Debug:              log.New(io.Discard, "", 0),
+		RebuildStdlib:      *reduildStdlibFlag,
+		ToolchainCacheSize: int64(*toolchainCacheSize * 1e9),
+		Profile:            *profileFlag,
+		ProfileBench:       *profileBenchFlag,
+		ProfileDir:         profileDir,
 	}
 	if *debugFlag {
 		bd.Debug = log.New(os.Stderr, "", 0)
@@ -95,33 +166,42 @@ func main() {
 		log.Fatalf("error running benchmarks: %v", err)
 	}
 
-	cmd := exec.CommandContext(ctx, "benchstat",
-		result.BaseRef+"="+result.BaseOutputFile,
-		result.HeadRef+"="+result.HeadOutputFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	bd.Debug.Printf("+ %s", cmd)
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("error running benchstat: %v", err)
+	if err := printComparison(os.Stdout, result.Columns); err != nil {
+		log.Fatalf("error computing statistics: %v", err)
+	}
+	if bd.Profile != "" {
+		if err := bd.printProfileDiffs(ctx, os.Stdout, result.Columns, *profileTopFlag); err != nil {
+			log.Fatalf("error diffing profiles: %v", err)
+		}
 	}
 }
 
 type Benchdiff struct {
-	BenchArgs     []string
+	BenchArgs []string
+	// Refs are the git refs to benchmark, where the empty string is the
+	// worktree. The first one is the baseline.
+	Refs []string
+	// Toolchains are the go commands or GOTOOLCHAIN values to benchmark each
+	// ref with. If empty, the go command in $PATH is used.
+	Toolchains    []string
 	ResultsDir    string
-	BaseRef       string
-	HeadRef       string
 	RebuildStdlib bool
-	Stdlib        bool
-	RootPath      string
-	Debug         *log.Logger
+	// ToolchainCacheSize is the size in bytes past which cached standard
+	// library toolchains are evicted.
+	ToolchainCacheSize int64
+	// Profile is "cpu" or "mem" to collect profiles of the benchmarks matching
+	// ProfileBench into ProfileDir, or empty.
+	Profile      string
+	ProfileBench string
+	ProfileDir   string
+	Stdlib       bool
+	RootPath     string
+	Debug        *log.Logger
 }
 
 type RunResult struct {
-	HeadOutputFile string
-	BaseOutputFile string
-	HeadRef        string
-	BaseRef        string
+	// Columns has one entry per ref and toolchain combination, in order.
+	Columns []column
 }
 
 func fileExists(path string) bool {
@@ -154,123 +234,179 @@ func runCmd(cmd *exec.Cmd, debug *log.Logger) error {
 	return err
 }
 
-var errCached = fmt.Errorf("cached")
+// testFlags are the "go test" flags that are passed to the test binary, mapped
+// to whether they are boolean. All other flags are passed to "go test -c".
+var testFlags = map[string]bool{
+	"artifacts": true, "bench": false, "benchmem": true, "benchtime": false,
+	"blockprofile": false, "blockprofilerate": false, "count": false,
+	"coverprofile": false, "cpu": false, "cpuprofile": false, "failfast": true,
+	"fullpath": true, "fuzz": false, "fuzzminimizetime": false, "fuzztime": false,
+	"list": false, "memprofile": false, "memprofilerate": false,
+	"mutexprofile": false, "mutexprofilefraction": false, "outputdir": false,
+	"parallel": false, "run": false, "short": true, "shuffle": false, "skip": false,
+	"timeout": false, "trace": false, "v": true,
+}
+
+// buildBoolFlags are the "go test" build flags that don't take a value.
+var buildBoolFlags = map[string]bool{
+	"a": true, "asan": true, "buildvcs": true, "cover": true, "json": true,
+	"linkshared": true, "modcacherw": true, "msan": true, "n": true, "race": true,
+	"trimpath": true, "work": true, "x": true,
+}
+
+// unsupportedFlags are the "go test" flags that would change the output
+// benchdiff parses.
+var unsupportedFlags = map[string]bool{"json": true}
 
-func (c *Benchdiff) runBenchmark(ctx context.Context, ref, filename string, count int) error {
-	c.Debug.Printf("output file: %s", filename)
-	if ref != "" && fileExists(filename) {
-		return errCached
+type goTestArgs struct {
+	Build    []string // flags for "go list" and "go test -c"
+	Test     []string // flags for the test binary, in -test.name=value form
+	Packages []string
+	Count    int
+}
+
+// parseGoTestArgs splits "go test" arguments like "go test" itself does.
+func parseGoTestArgs(args []string) (*goTestArgs, error) {
+	a := &goTestArgs{Count: 1}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			a.Packages = append(a.Packages, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		name = strings.TrimPrefix(name, "test.")
+		if name == "args" {
+			a.Test = append(a.Test, args[i+1:]...)
+			break
+		}
+		isBool, isTestFlag := testFlags[name]
+		if !isTestFlag {
+			isBool = buildBoolFlags[name]
+		}
+		if !hasValue && !isBool {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("missing value for flag %s", arg)
+			}
+			i++
+			value, hasValue = args[i], true
+		}
+		switch {
+		case unsupportedFlags[name]:
+			return nil, fmt.Errorf("flag %s is not supported", arg)
+		case name == "count":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid -count value %q", value)
+			}
+			a.Count = n
+		case isTestFlag && hasValue:
+			a.Test = append(a.Test, "-test."+name+"="+value)
+		case isTestFlag:
+			a.Test = append(a.Test, "-test."+name)
+		case hasValue:
+			a.Build = append(a.Build, "-"+name+"="+value)
+		default:
+			a.Build = append(a.Build, "-"+name)
+		}
+	}
+	if len(a.Packages) == 0 {
+		a.Packages = []string{"."}
 	}
+	return a, nil
+}
 
-	progress := pb.Simple.Start(count)
-	defer progress.Finish()
+// A side is a tree whose benchmarks are being run.
+type side struct {
+	Ref      string // git ref, or empty for the worktree
+	Name     string
+	Filename string
+	GoPath   string
+	Env      []string
+	Binaries []testBinary
+	Output   bytes.Buffer
+	Cached   bool
+}
 
-	cmd := exec.CommandContext(ctx, "go", c.BenchArgs...)
+type testBinary struct {
+	Path string
+	Dir  string // the package directory, in which the binary runs
+}
+
+func (c *Benchdiff) goPath() string {
 	if c.Stdlib {
-		cmd.Path = filepath.Join(c.RootPath, "bin", "go")
+		return filepath.Join(c.RootPath, "bin", "go")
 	}
+	return "go"
+}
 
-	fileBuffer := &bytes.Buffer{}
-	cmd.Stdout = io.MultiWriter(fileBuffer, &LineWriter{f: func(line string) {
-		if strings.HasPrefix(line, "Benchmark") && strings.Contains(line, "\t") {
-			progress.Increment()
-			parts := strings.Split(line, "\t")
-			if len(parts) < 3 {
-				return
-			}
-			name := strings.TrimSpace(parts[0])
-			name, _, _ = strings.Cut(name, "-")
-			time := strings.TrimSpace(parts[2])
-			progress.Set("prefix", name+" "+time+" |")
-		}
-	}})
+// linkToolchain sets up a standard library worktree to be built with the
+// toolchain of the current GOROOT.
+func (c *Benchdiff) linkToolchain(s *side, workPath string) error {
+	if err := os.Symlink(filepath.Join(c.RootPath, "pkg"), filepath.Join(workPath, "pkg")); err != nil {
+		return err
+	}
+	if err := os.Symlink(filepath.Join(c.RootPath, "bin"), filepath.Join(workPath, "bin")); err != nil {
+		return err
+	}
+	s.Env = append(os.Environ(), "GOROOT="+workPath)
+	return nil
+}
 
-	if !c.Stdlib {
-		goVersion, err := c.runGoCmd("env", "GOVERSION")
-		if err != nil {
-			return err
-		}
-		fmt.Fprintf(fileBuffer, "go: %s\n", goVersion)
+// buildTests compiles a test binary for each package matched by args in dir,
+// and stores them in binDir.
+func (c *Benchdiff) buildTests(ctx context.Context, s *side, dir string, args *goTestArgs, binDir string) error {
+	if err := os.MkdirAll(binDir, 0o700); err != nil {
+		return err
 	}
 
-	goFIPS, err := c.runGoCmd("env", "GOFIPS140")
-	if err != nil {
+	var stdout bytes.Buffer
+	listArgs := []string{"list", "-f", "{{if or .TestGoFiles .XTestGoFiles}}{{.ImportPath}}\t{{.Dir}}{{end}}"}
+	listArgs = append(listArgs, args.Build...)
+	listArgs = append(listArgs, args.Packages...)
+	cmd := exec.CommandContext(ctx, s.GoPath, listArgs...)
+	cmd.Dir = dir
+	cmd.Env = s.Env
+	cmd.Stdout = &stdout
+	if err := runCmd(cmd, c.Debug); err != nil {
 		return err
 	}
-	if goFIPS != "" {
-		fmt.Fprintf(fileBuffer, "fips140: %s\n", goFIPS)
-	}
-
-	var runErr error
-	if ref == "" {
-		runErr = runCmd(cmd, c.Debug)
-	} else {
-		err := c.runAtGitRef(c.BaseRef, func(workPath string) {
-			if c.Stdlib && c.RebuildStdlib {
-				// TODO: cache toolchains.
-				makeCmd := exec.CommandContext(ctx, filepath.Join(workPath, "src", "make.bash"))
-				makeCmd.Dir = filepath.Join(workPath, "src")
-				makeCmd.Env = append(os.Environ(), "GOOS=", "GOARCH=")
-				makeCmd.Stdout = &LineWriter{f: func(line string) {
-					words := strings.Fields(line)
-					if strings.HasPrefix(line, "Building Go") {
-						words = words[:3]
-					}
-					if strings.HasPrefix(line, "Building packages") {
-						words = words[:4]
-					}
-					if strings.HasPrefix(line, "***") {
-						words = []string{"Toolchain", "built"}
-					}
-					line = strings.Join(words, " ")
-					progress.Set("prefix", line+" |")
-				}}
-				runErr = runCmd(makeCmd, c.Debug)
-				if runErr != nil {
-					return
-				}
-				cmd.Path = filepath.Join(workPath, "bin", "go")
-			} else if c.Stdlib {
-				runErr = os.Symlink(filepath.Join(c.RootPath, "pkg"), filepath.Join(workPath, "pkg"))
-				if runErr != nil {
-					return
-				}
-				runErr = os.Symlink(filepath.Join(c.RootPath, "bin"), filepath.Join(workPath, "bin"))
-				if runErr != nil {
-					return
-				}
-				cmd.Env = append(os.Environ(), "GOROOT="+workPath)
-			}
-			cmd.Dir = workPath // TODO: add relative path of working directory.
-			runErr = runCmd(cmd, c.Debug)
-		})
-		if err != nil {
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	for i, line := range lines {
+		importPath, pkgDir, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		path := filepath.Join(binDir, fmt.Sprintf("%d.test", i))
+		testArgs := []string{"test", "-c", "-o", path}
+		testArgs = append(testArgs, args.Build...)
+		testArgs = append(testArgs, importPath)
+		cmd := exec.CommandContext(ctx, s.GoPath, testArgs...)
+		cmd.Dir = dir
+		cmd.Env = s.Env
+		if err := runCmd(cmd, c.Debug); err != nil {
 			return err
 		}
+		s.Binaries = append(s.Binaries, testBinary{Path: path, Dir: pkgDir})
 	}
-	if runErr != nil {
-		return runErr
+	if len(s.Binaries) == 0 {
+		return fmt.Errorf("no test files in %s", strings.Join(args.Packages, " "))
 	}
-	return os.WriteFile(filename, fileBuffer.Bytes(), 0o666)
+	return nil
 }
 
-func (c *Benchdiff) countBenchmarks(ctx context.Context) (int, error) {
-	var count int
-
-	benchArgs := append([]string(nil), c.BenchArgs...)
-	benchArgs = append(benchArgs, "-benchtime", "1x", "-run", "^$")
-	cmd := exec.CommandContext(ctx, "go", benchArgs...)
-	if c.Stdlib {
-		cmd.Path = filepath.Join(c.RootPath, "bin", "go")
-	}
-	cmd.Stdout = &LineWriter{f: func(line string) {
-		if strings.HasPrefix(line, "Benchmark") && strings.Contains(line, "\t") {
-			count++
+func (c *Benchdiff) runTests(ctx context.Context, s *side, args []string, stdout io.Writer) error {
+	for _, b := range s.Binaries {
+		cmd := exec.CommandContext(ctx, b.Path, args...)
+		cmd.Dir = b.Dir
+		cmd.Env = s.Env
+		cmd.Stdout = stdout
+		if err := runCmd(cmd, c.Debug); err != nil {
+			return err
 		}
-	}}
-
-	err := runCmd(cmd, c.Debug)
-	return count, err
+	}
+	return nil
 }
 
 func (c *Benchdiff) Run(ctx context.Context) (result *RunResult, err error) {
@@ -280,11 +416,28 @@ func (c *Benchdiff) Run(ctx context.Context) (result *RunResult, err error) {
 	}
 	c.RootPath = string(rootPath)
 
-	if c.HeadRef == "" {
+	args, err := parseGoTestArgs(c.BenchArgs)
+	if err != nil {
+		return nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	relPath, err := filepath.Rel(c.RootPath, cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range c.Refs {
+		if ref != "" {
+			continue
+		}
 		goModPath := filepath.Join(c.RootPath, "go.mod")
 		if diff, err := c.runGitCmd("diff", goModPath); err == nil && len(diff) > 0 {
 			fmt.Fprintf(os.Stderr, "Warning: go.mod is dirty.\n")
 		}
+		break
 	}
 
 	// lib/time/zoneinfo.zip is a specific enough path, and it's here to
@@ -294,6 +447,9 @@ func (c *Benchdiff) Run(ctx context.Context) (result *RunResult, err error) {
 		c.Stdlib = true
 		c.Debug.Println("standard library detected")
 	}
+	if c.Stdlib && len(c.Toolchains) > 0 {
+		return nil, fmt.Errorf("cannot select toolchains when benchmarking the standard library")
+	}
 
 	if err := os.MkdirAll(c.ResultsDir, 0o700); err != nil {
 		return nil, err
@@ -304,61 +460,193 @@ func (c *Benchdiff) Run(ctx context.Context) (result *RunResult, err error) {
 		// TODO: use env GOVERSION (with -dirty).
 		tagsFlag = "--long"
 	}
-	headFlag := "--dirty"
-	if c.HeadRef != "" {
-		headFlag = c.HeadRef
+	toolchains := c.Toolchains
+	if len(toolchains) == 0 {
+		toolchains = []string{""}
 	}
-	headRef, err := c.runGitCmd("describe", tagsFlag, "--always", headFlag)
-	if err != nil {
-		return nil, err
-	}
-	headFilename, err := c.cacheFilename(string(headRef))
-	if err != nil {
-		return nil, err
+
+	result = &RunResult{}
+	var sides []*side
+	for _, ref := range c.Refs {
+		refFlag := "--dirty"
+		if ref != "" {
+			refFlag = ref
+		}
+		describedRef, err := c.runGitCmd("describe", tagsFlag, "--always", refFlag)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, toolchain := range toolchains {
+			s := &side{Ref: ref, Name: string(describedRef), GoPath: c.goPath()}
+			if toolchain != "" {
+				s.Name += "@" + toolchain
+				if path, err := exec.LookPath(toolchain); err == nil {
+					s.GoPath = path
+				} else {
+					s.Env = append(os.Environ(), "GOTOOLCHAIN="+toolchain)
+				}
+			}
+			s.Filename, err = c.cacheFilename(s)
+			if err != nil {
+				return nil, err
+			}
+			result.Columns = append(result.Columns, column{Name: s.Name, Filename: s.Filename})
+
+			c.Debug.Printf("output file for %s: %s", s.Name, s.Filename)
+			if s.Ref != "" && fileExists(s.Filename) {
+				fmt.Fprintf(os.Stderr, "Using cached benchmark for %s.\n", s.Name)
+				s.Cached = true
+			}
+			sides = append(sides, s)
+		}
 	}
 
-	baseRef, err := c.runGitCmd("describe", tagsFlag, "--always", c.BaseRef)
-	if err != nil {
-		return nil, err
+	// Cached sides still need their tests built to collect profiles.
+	var toBuild, toRun []*side
+	for _, s := range sides {
+		if !s.Cached {
+			toRun = append(toRun, s)
+		}
+		if !s.Cached || c.Profile != "" {
+			toBuild = append(toBuild, s)
+		}
 	}
-	baseFilename, err := c.cacheFilename(string(baseRef))
-	if err != nil {
-		return nil, err
+	if len(toBuild) == 0 {
+		return result, nil
 	}
 
-	// TODO: use base-ref cache if available.
-	count, err := c.countBenchmarks(ctx)
+	binDir, err := os.MkdirTemp("", "benchdiff")
 	if err != nil {
 		return nil, err
 	}
-	c.Debug.Printf("counted %d benchmarks", count)
+	defer os.RemoveAll(binDir)
+
+	progress := pb.Simple.Start(0)
+	defer progress.Finish()
+
+	// Worktrees are shared by the sides that only differ by toolchain.
+	worktrees := make(map[string]string)
+	for i, s := range toBuild {
+		if !c.Stdlib {
+			goVersion, err := c.runGoCmd(s, "env", "GOVERSION")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&s.Output, "go: %s\n", goVersion)
+		}
+		goFIPS, err := c.runGoCmd(s, "env", "GOFIPS140")
+		if err != nil {
+			return nil, err
+		}
+		if goFIPS != "" {
+			fmt.Fprintf(&s.Output, "fips140: %s\n", goFIPS)
+		}
 
-	result = &RunResult{
-		HeadRef:        strings.TrimSpace(string(headRef)),
-		BaseRef:        strings.TrimSpace(string(baseRef)),
-		BaseOutputFile: baseFilename,
-		HeadOutputFile: headFilename,
+		treePath := c.RootPath
+		switch {
+		case s.Ref == "":
+		case c.Stdlib && c.RebuildStdlib:
+			goroot, err := c.cachedToolchain(ctx, s.Ref, progress)
+			if err != nil {
+				return nil, err
+			}
+			treePath = goroot
+			s.GoPath = filepath.Join(goroot, "bin", "go")
+		case worktrees[s.Ref] != "":
+			treePath = worktrees[s.Ref]
+		default:
+			worktree, remove, err := c.addWorktree(s.Ref)
+			if err != nil {
+				return nil, err
+			}
+			defer remove()
+			worktrees[s.Ref] = worktree
+			treePath = worktree
+			if c.Stdlib {
+				if err := c.linkToolchain(s, worktree); err != nil {
+					return nil, err
+				}
+			}
+		}
+		progress.Set("prefix", "Building "+s.Name+" |")
+		binPath := filepath.Join(binDir, fmt.Sprint(i))
+		if err := c.buildTests(ctx, s, filepath.Join(treePath, relPath), args, binPath); err != nil {
+			return nil, err
+		}
 	}
 
-	// TODO: interleave runs?
+	if len(toRun) > 0 {
+		var count int
+		countArgs := append([]string{"-test.count=1"}, args.Test...)
+		countArgs = append(countArgs, "-test.benchtime=1x")
+		err = c.runTests(ctx, toRun[0], countArgs, &LineWriter{f: func(line string) {
+			if strings.HasPrefix(line, "Benchmark") && strings.Contains(line, "\t") {
+				count++
+			}
+		}})
+		if err != nil {
+			return nil, err
+		}
+		c.Debug.Printf("counted %d benchmarks", count)
+		progress.SetTotal(int64(count * args.Count * len(toRun)))
+
+		progressWriter := &LineWriter{f: func(line string) {
+			if strings.HasPrefix(line, "Benchmark") && strings.Contains(line, "\t") {
+				progress.Increment()
+				parts := strings.Split(line, "\t")
+				if len(parts) < 3 {
+					return
+				}
+				name := strings.TrimSpace(parts[0])
+				name, _, _ = strings.Cut(name, "-")
+				time := strings.TrimSpace(parts[2])
+				progress.Set("prefix", name+" "+time+" |")
+			}
+		}}
+
+		// Run one round of each side at a time, rotating which side goes
+		// first, so that drift over time affects all sides equally.
+		runArgs := append([]string{"-test.count=1"}, args.Test...)
+		for round := 0; round < args.Count; round++ {
+			for j := range toRun {
+				s := toRun[(j+round)%len(toRun)]
+				stdout := io.MultiWriter(&s.Output, progressWriter)
+				if err := c.runTests(ctx, s, runArgs, stdout); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for _, s := range toRun {
+			if err := os.WriteFile(s.Filename, s.Output.Bytes(), 0o666); err != nil {
+				return nil, err
+			}
+		}
+	}
 
-	if err := c.runBenchmark(ctx, c.BaseRef, baseFilename, count); err == errCached {
-		fmt.Fprintf(os.Stderr, "Using cached benchmark for %s.\n", result.BaseRef)
-	} else if err != nil {
-		return nil, err
+	if c.Profile != "" {
+		for i, s := range sides {
+			progress.Set("prefix", "Profiling "+s.Name+" |")
+			profile, err := c.collectProfile(ctx, s, args, filepath.Join(binDir, fmt.Sprintf("profile-%d", i)))
+			if err != nil {
+				return nil, err
+			}
+			result.Columns[i].Profile = profile
+		}
 	}
 
-	if err := c.runBenchmark(ctx, c.HeadRef, headFilename, count); err == errCached {
-		fmt.Fprintf(os.Stderr, "Using cached benchmark for %s.\n", result.HeadRef)
-	} else if err != nil {
-		return nil, err
+	if c.Stdlib && c.RebuildStdlib {
+		if err := c.evictToolchains(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to clean up toolchain cache: %v\n", err)
+		}
 	}
 
 	return result, nil
 }
 
-func (c *Benchdiff) cacheFilename(ref string) (string, error) {
-	env, err := c.runGoCmd("env", "GOARCH", "GOEXPERIMENT", "GOOS", "GOVERSION", "CC", "CXX", "CGO_ENABLED", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS", "GOFIPS140")
+func (c *Benchdiff) cacheFilename(s *side) (string, error) {
+	env, err := c.runGoCmd(s, "env", "GOARCH", "GOEXPERIMENT", "GOOS", "GOVERSION", "CC", "CXX", "CGO_ENABLED", "CGO_CFLAGS", "CGO_CPPFLAGS", "CGO_CXXFLAGS", "CGO_LDFLAGS", "GOFIPS140")
 	if err != nil {
 		return "", err
 	}
@@ -369,19 +657,17 @@ func (c *Benchdiff) cacheFilename(ref string) (string, error) {
 	}
 	fmt.Fprintf(h, "%q\n", c.BenchArgs)
 	fmt.Fprintf(h, "%s\n", env)
-	fmt.Fprintf(h, "%s\n", ref)
+	fmt.Fprintf(h, "%s\n", s.Name)
 	fmt.Fprintf(h, "%s\n", c.RootPath)
 	cacheKey := base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
 
 	return filepath.Join(c.ResultsDir, fmt.Sprintf("benchdiff-%s.out", cacheKey)), nil
 }
 
-func (c *Benchdiff) runGoCmd(args ...string) (string, error) {
+func (c *Benchdiff) runGoCmd(s *side, args ...string) (string, error) {
 	var stdout bytes.Buffer
-	cmd := exec.Command("go", args...)
-	if c.Stdlib {
-		cmd.Path = filepath.Join(c.RootPath, "bin", "go")
-	}
+	cmd := exec.Command(s.GoPath, args...)
+	cmd.Env = s.Env
 	cmd.Stdout = &stdout
 	err := runCmd(cmd, c.Debug)
 	return string(bytes.TrimSpace(stdout.Bytes())), err
@@ -395,24 +681,27 @@ func (c *Benchdiff) runGitCmd(args ...string) ([]byte, error) {
 	return bytes.TrimSpace(stdout.Bytes()), err
 }
 
-func (c *Benchdiff) runAtGitRef(ref string, fn func(path string)) error {
+// addWorktree checks out ref in a temporary worktree, and returns its path and
+// a function to remove it.
+func (c *Benchdiff) addWorktree(ref string) (string, func(), error) {
 	worktree, err := os.MkdirTemp("", "benchdiff")
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	defer func() {
+	removeDir := func() {
 		rErr := os.RemoveAll(worktree)
 		if rErr != nil {
 			fmt.Printf("Could not delete temp directory: %s\n", worktree)
 		}
-	}()
+	}
 
 	_, err = c.runGitCmd("worktree", "add", "--quiet", "--detach", worktree, ref)
 	if err != nil {
-		return err
+		removeDir()
+		return "", nil, err
 	}
 
-	defer func() {
+	return worktree, func() {
 		_, cerr := c.runGitCmd("worktree", "remove", "--force", worktree)
 		if cerr != nil {
 			if exitErr, ok := cerr.(*exec.ExitError); ok {
@@ -420,9 +709,8 @@ func (c *Benchdiff) runAtGitRef(ref string, fn func(path string)) error {
 			}
 			fmt.Println(cerr)
 		}
-	}()
-	fn(worktree)
-	return nil
+		removeDir()
+	}, nil
 }
 
 type LineWriter struct {
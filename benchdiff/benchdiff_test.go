@@ -0,0 +1,262 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGoTestArgs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    *goTestArgs
+		wantErr bool
+	}{
+		{
+			args: nil,
+			want: &goTestArgs{Packages: []string{"."}, Count: 1},
+		},
+		{
+			args: []string{"-bench", "Foo", "./pkg"},
+			want: &goTestArgs{Test: []string{"-test.bench=Foo"}, Packages: []string{"./pkg"}, Count: 1},
+		},
+		{
+			args: []string{"-bench=.", "-benchmem", "-v", "./a", "./b"},
+			want: &goTestArgs{Test: []string{"-test.bench=.", "-test.benchmem", "-test.v"},
+				Packages: []string{"./a", "./b"}, Count: 1},
+		},
+		{
+			args: []string{"-test.bench", "Foo", "--test.benchtime=2s", "-test.short", "."},
+			want: &goTestArgs{Test: []string{"-test.bench=Foo", "-test.benchtime=2s", "-test.short"},
+				Packages: []string{"."}, Count: 1},
+		},
+		{
+			args: []string{"-count", "10", "-test.count=5", "./pkg"},
+			want: &goTestArgs{Packages: []string{"./pkg"}, Count: 5},
+		},
+		{
+			args: []string{"-race", "-tags", "purego", "-trimpath", "-gcflags=-N -l", "./pkg"},
+			want: &goTestArgs{Build: []string{"-race", "-tags=purego", "-trimpath", "-gcflags=-N -l"},
+				Packages: []string{"./pkg"}, Count: 1},
+		},
+		{
+			args: []string{"-cover", "-buildvcs", "-failfast", "-fullpath", "-artifacts", "./pkg"},
+			want: &goTestArgs{Build: []string{"-cover", "-buildvcs"},
+				Test: []string{"-test.failfast", "-test.fullpath", "-test.artifacts"}, Packages: []string{"./pkg"}, Count: 1},
+		},
+		{
+			args: []string{"-race=false", "-v=true", "./pkg"},
+			want: &goTestArgs{Build: []string{"-race=false"}, Test: []string{"-test.v=true"},
+				Packages: []string{"./pkg"}, Count: 1},
+		},
+		{
+			args: []string{"-fuzz", "FuzzFoo", "-list", "Bench", "./pkg"},
+			want: &goTestArgs{Test: []string{"-test.fuzz=FuzzFoo", "-test.list=Bench"},
+				Packages: []string{"./pkg"}, Count: 1},
+		},
+		{
+			args: []string{"./pkg", "-bench", ".", "-args", "-custom", "-bench", "x"},
+			want: &goTestArgs{Test: []string{"-test.bench=.", "-custom", "-bench", "x"},
+				Packages: []string{"./pkg"}, Count: 1},
+		},
+		{
+			args: []string{"-", "-test.args"},
+			want: &goTestArgs{Packages: []string{"-"}, Count: 1},
+		},
+		{args: []string{"-json", "./pkg"}, wantErr: true},
+		{args: []string{"-bench"}, wantErr: true},
+		{args: []string{"-count", "0"}, wantErr: true},
+		{args: []string{"-count=x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseGoTestArgs(tt.args)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseGoTestArgs(%q) = %+v, want error", tt.args, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGoTestArgs(%q): %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseGoTestArgs(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestPrintComparison(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{
+			name: "geomean",
+			files: []string{`pkg: example.com/a
+BenchmarkFoo-8 100 100 ns/op 16 B/op
+BenchmarkFoo-8 100 102 ns/op 16 B/op
+BenchmarkFoo-8 100 98 ns/op 16 B/op
+BenchmarkFoo-8 100 101 ns/op 16 B/op
+BenchmarkFoo-8 100 99 ns/op 16 B/op
+BenchmarkBar-8 100 1000 ns/op 0 B/op
+BenchmarkBar-8 100 1010 ns/op 0 B/op
+BenchmarkBar-8 100 990 ns/op 0 B/op
+BenchmarkBar-8 100 1005 ns/op 0 B/op
+BenchmarkBar-8 100 995 ns/op 0 B/op
+`, `pkg: example.com/a
+BenchmarkFoo-8 100 50 ns/op 16 B/op
+BenchmarkFoo-8 100 51 ns/op 16 B/op
+BenchmarkFoo-8 100 49 ns/op 16 B/op
+BenchmarkFoo-8 100 50 ns/op 16 B/op
+BenchmarkFoo-8 100 50 ns/op 16 B/op
+BenchmarkBar-8 100 1000 ns/op 0 B/op
+BenchmarkBar-8 100 1010 ns/op 0 B/op
+BenchmarkBar-8 100 990 ns/op 0 B/op
+BenchmarkBar-8 100 1005 ns/op 0 B/op
+BenchmarkBar-8 100 995 ns/op 0 B/op
+`},
+			want: `pkg: example.com/a
+sec/op   base          head          vs base
+Foo-8    100.00n ± ∞   50.00n ± ∞    -50.00% (p=0.008 n=5)
+Bar-8    1000.00n ± ∞  1000.00n ± ∞  ~ (p=1.000 n=5)
+geomean  316.23n       223.61n       -29.29%
+
+B/op   base       head       vs base
+Foo-8  16.00 ± ∞  16.00 ± ∞  ~ (p=1.000 n=5)
+Bar-8  0.00 ± ∞   0.00 ± ∞   ~ (p=1.000 n=5)
+
+`,
+		},
+		{
+			name: "missing",
+			files: []string{`pkg: example.com/a
+BenchmarkFoo-8 100 100 ns/op
+BenchmarkFoo-8 100 100 ns/op
+BenchmarkFoo-8 100 100 ns/op
+pkg: example.com/b
+BenchmarkBaz-8 100 10 ns/op
+BenchmarkBaz-8 100 10 ns/op
+BenchmarkBaz-8 100 10 ns/op
+`, `pkg: example.com/a
+BenchmarkFoo-8 100 200 ns/op
+BenchmarkFoo-8 100 200 ns/op
+BenchmarkFoo-8 100 200 ns/op
+`, `pkg: example.com/a
+BenchmarkFoo-8 100 100 ns/op
+BenchmarkFoo-8 100 100 ns/op
+BenchmarkFoo-8 100 100 ns/op
+pkg: example.com/b
+BenchmarkBaz-8 100 20 ns/op
+BenchmarkBaz-8 100 20 ns/op
+BenchmarkBaz-8 100 20 ns/op
+`},
+			want: `pkg: example.com/a
+sec/op  base        head        vs base          other       vs base
+Foo-8   100.0n ± ∞  200.0n ± ∞  ~ (p=0.100 n=3)  100.0n ± ∞  ~ (p=1.000 n=3)
+
+pkg: example.com/b
+sec/op  base        head  vs base  other       vs base
+Baz-8   10.00n ± ∞  -              20.00n ± ∞  ~ (p=0.100 n=3)
+
+`,
+		},
+	}
+	names := []string{"base", "head", "other"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			var cols []column
+			for i, data := range tt.files {
+				filename := filepath.Join(dir, names[i])
+				if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				cols = append(cols, column{Name: names[i], Filename: filename})
+			}
+			var out strings.Builder
+			if err := printComparison(&out, cols); err != nil {
+				t.Fatal(err)
+			}
+			// Ignore the tabwriter padding at the end of each line.
+			lines := strings.Split(out.String(), "\n")
+			for i := range lines {
+				lines[i] = strings.TrimRight(lines[i], " ")
+			}
+			if got := strings.Join(lines, "\n"); got != tt.want {
+				t.Errorf("printComparison output:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictToolchains(t *testing.T) {
+	// Toolchains from least to most recently used.
+	toolchains := []struct {
+		name string
+		size int
+	}{{"a", 100}, {"b", 200}, {"c", 300}}
+	tests := []struct {
+		cacheSize int64
+		want      []string
+	}{
+		{cacheSize: 1000, want: []string{"a", "b", "c"}},
+		{cacheSize: 600, want: []string{"a", "b", "c"}},
+		{cacheSize: 500, want: []string{"b", "c"}},
+		{cacheSize: 400, want: []string{"a", "c"}},
+		{cacheSize: 300, want: []string{"c"}},
+		{cacheSize: 0, want: []string{"c"}},
+	}
+	for _, tt := range tests {
+		c := &Benchdiff{
+			ResultsDir:         t.TempDir(),
+			ToolchainCacheSize: tt.cacheSize,
+			Debug:              log.New(io.Discard, "", 0),
+		}
+		dir := c.toolchainsDir()
+		now := time.Now()
+		for i, tc := range toolchains {
+			path := filepath.Join(dir, tc.name)
+			if err := os.MkdirAll(filepath.Join(path, "bin"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			data := make([]byte, tc.size)
+			if err := os.WriteFile(filepath.Join(path, "bin", "go"), data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			lastUsed := now.Add(time.Duration(i-len(toolchains)) * time.Hour)
+			if err := os.Chtimes(path, lastUsed, lastUsed); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// In-progress builds and stray files are never evicted.
+		if err := os.Mkdir(filepath.Join(dir, "d.tmp"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "e"), make([]byte, 1000), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := c.evictToolchains(); err != nil {
+			t.Fatalf("cache size %d: %v", tt.cacheSize, err)
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, e := range entries {
+			got = append(got, e.Name())
+		}
+		want := append(tt.want, "d.tmp", "e")
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("cache size %d: got %q, want %q", tt.cacheSize, got, want)
+		}
+	}
+}
@@ -2,14 +2,18 @@ module filippo.io/mostly-harmless/benchdiff
 
 go 1.22.2
 
-require github.com/cheggaaa/pb/v3 v3.1.5
+require (
+	github.com/cheggaaa/pb/v3 v3.1.5
+	golang.org/x/perf v0.0.0-20240716160700-783bcb78a185
+)
 
 require (
 	github.com/VividCortex/ewma v1.2.0 // indirect
+	github.com/aclements/go-moremath v0.0.0-20210112150236-f10218a38794 // indirect
 	github.com/fatih/color v1.15.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.19 // indirect
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
+	golang.org/x/sys v0.22.0 // indirect
 )
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// collectProfile runs the benchmarks matching ProfileBench once for each test
+// binary of s, and merges their profiles into a single file in ProfileDir,
+// whose path it returns.
+func (c *Benchdiff) collectProfile(ctx context.Context, s *side, args *goTestArgs, tmpDir string) (string, error) {
+	if err := os.MkdirAll(tmpDir, 0o700); err != nil {
+		return "", err
+	}
+
+	var profiles []string
+	for j, b := range s.Binaries {
+		// The test binary interprets relative profile paths relative to its
+		// working directory, so use an absolute one.
+		path := filepath.Join(tmpDir, fmt.Sprintf("%d.pprof", j))
+		runArgs := append([]string{"-test.count=1"}, args.Test...)
+		runArgs = append(runArgs, "-test.bench="+c.ProfileBench)
+		runArgs = append(runArgs, "-test."+c.Profile+"profile="+path)
+		cmd := exec.CommandContext(ctx, b.Path, runArgs...)
+		cmd.Dir = b.Dir
+		cmd.Env = s.Env
+		if err := runCmd(cmd, c.Debug); err != nil {
+			return "", err
+		}
+		if fileExists(path) {
+			profiles = append(profiles, path)
+		}
+	}
+	if len(profiles) == 0 {
+		return "", fmt.Errorf("no profiles collected for %s", s.Name)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(s.Filename), ".out") + "." + c.Profile + ".pprof"
+	profile := filepath.Join(c.ProfileDir, name)
+	out, err := os.Create(profile)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	mergeArgs := append([]string{"tool", "pprof", "-proto"}, profiles...)
+	cmd := exec.CommandContext(ctx, s.GoPath, mergeArgs...)
+	cmd.Env = s.Env
+	cmd.Stdout = out
+	if err := runCmd(cmd, c.Debug); err != nil {
+		return "", err
+	}
+	return profile, out.Close()
+}
+
+// printProfileDiffs prints the top entries of the difference between the
+// profile of each column and the one of the first column.
+func (c *Benchdiff) printProfileDiffs(ctx context.Context, w io.Writer, cols []column, top int) error {
+	for _, col := range cols[1:] {
+		if col.Profile == "" || cols[0].Profile == "" {
+			continue
+		}
+		fmt.Fprintf(w, "%s profile of %s vs %s:\n", c.Profile, col.Name, cols[0].Name)
+		if c.ProfileDir == c.ResultsDir {
+			fmt.Fprintf(w, "(saved at %s and %s)\n", cols[0].Profile, col.Profile)
+		}
+		pprofArgs := []string{"tool", "pprof", "-top", "-nodecount=" + strconv.Itoa(top)}
+		if c.Profile == "mem" {
+			pprofArgs = append(pprofArgs, "-sample_index=alloc_space")
+		}
+		pprofArgs = append(pprofArgs, "-diff_base="+cols[0].Profile, col.Profile)
+		cmd := exec.CommandContext(ctx, c.goPath(), pprofArgs...)
+		cmd.Stdout = w
+		if err := runCmd(cmd, c.Debug); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
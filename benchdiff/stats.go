@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/perf/benchfmt"
+	"golang.org/x/perf/benchmath"
+	"golang.org/x/perf/benchunit"
+)
+
+// A column is a set of benchmark results to compare, such as those of a ref.
+type column struct {
+	Name     string
+	Filename string
+	Profile  string // optional
+}
+
+// A table collects the samples for one package and unit across all columns.
+type table struct {
+	pkg, unit string
+	names     []string               // in order of first appearance
+	samples   map[string][][]float64 // name -> column -> values
+}
+
+// printComparison reads the benchmark results of each column and prints a
+// table of medians and confidence intervals, comparing each column against
+// the first one with a Mann-Whitney U-test, like benchstat does.
+func printComparison(w io.Writer, cols []column) error {
+	var tables []*table
+	tableIndex := make(map[[2]string]*table)
+	for i, col := range cols {
+		f, err := os.Open(col.Filename)
+		if err != nil {
+			return err
+		}
+		r := benchfmt.NewReader(f, col.Filename)
+		for r.Scan() {
+			switch rec := r.Result().(type) {
+			case *benchfmt.SyntaxError:
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", rec)
+			case *benchfmt.Result:
+				pkg := rec.GetConfig("pkg")
+				name := rec.Name.String()
+				for _, v := range rec.Values {
+					t := tableIndex[[2]string{pkg, v.Unit}]
+					if t == nil {
+						t = &table{pkg: pkg, unit: v.Unit,
+							samples: make(map[string][][]float64)}
+						tableIndex[[2]string{pkg, v.Unit}] = t
+						tables = append(tables, t)
+					}
+					if t.samples[name] == nil {
+						t.names = append(t.names, name)
+						t.samples[name] = make([][]float64, len(cols))
+					}
+					t.samples[name][i] = append(t.samples[name][i], v.Value)
+				}
+			}
+		}
+		f.Close()
+		if err := r.Err(); err != nil {
+			return err
+		}
+	}
+
+	var warnings []string
+	seenWarnings := make(map[string]bool)
+	warn := func(errs []error) {
+		for _, err := range errs {
+			if !seenWarnings[err.Error()] {
+				seenWarnings[err.Error()] = true
+				warnings = append(warnings, err.Error())
+			}
+		}
+	}
+
+	lastPkg := ""
+	for _, t := range tables {
+		if t.pkg != lastPkg {
+			fmt.Fprintf(w, "pkg: %s\n", t.pkg)
+			lastPkg = t.pkg
+		}
+
+		summaries := make(map[string][]*benchmath.Summary)
+		var centers []float64
+		for _, name := range t.names {
+			summaries[name] = make([]*benchmath.Summary, len(cols))
+			for i, values := range t.samples[name] {
+				if len(values) == 0 {
+					continue
+				}
+				sample := benchmath.NewSample(values, &benchmath.DefaultThresholds)
+				s := benchmath.AssumeNothing.Summary(sample, 0.95)
+				warn(s.Warnings)
+				summaries[name][i] = &s
+				centers = append(centers, s.Center)
+			}
+		}
+		scaler := benchunit.CommonScale(centers, benchunit.ClassOf(t.unit))
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		header := []string{t.unit}
+		for i, col := range cols {
+			header = append(header, col.Name)
+			if i > 0 {
+				header = append(header, "vs "+cols[0].Name)
+			}
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t")+"\t")
+
+		for _, name := range t.names {
+			row := []string{name}
+			base := summaries[name][0]
+			for i, s := range summaries[name] {
+				if s == nil {
+					row = append(row, "-")
+				} else {
+					row = append(row, scaler.Format(s.Center)+" ± "+s.PctRangeString())
+				}
+				if i == 0 {
+					continue
+				}
+				if s == nil || base == nil {
+					row = append(row, "")
+					continue
+				}
+				s0 := benchmath.NewSample(t.samples[name][0], &benchmath.DefaultThresholds)
+				s1 := benchmath.NewSample(t.samples[name][i], &benchmath.DefaultThresholds)
+				cmp := benchmath.AssumeNothing.Compare(s0, s1)
+				warn(cmp.Warnings)
+				row = append(row, cmp.FormatDelta(base.Center, s.Center)+" ("+cmp.String()+")")
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+		}
+
+		if geomeans := geomeanRow(t, summaries, len(cols)); geomeans != nil {
+			row := []string{"geomean"}
+			for i, g := range geomeans {
+				row = append(row, scaler.Format(g))
+				if i > 0 {
+					row = append(row, fmt.Sprintf("%+.2f%%", (g/geomeans[0]-1)*100))
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(row, "\t")+"\t")
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+
+	for _, msg := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+	}
+	return nil
+}
+
+// geomeanRow returns the geometric mean of the medians of each column, over
+// the benchmarks that have positive results in every column. It returns nil
+// if there are fewer than two such benchmarks.
+func geomeanRow(t *table, summaries map[string][]*benchmath.Summary, ncols int) []float64 {
+	logSums := make([]float64, ncols)
+	n := 0
+names:
+	for _, name := range t.names {
+		for _, s := range summaries[name] {
+			if s == nil || s.Center <= 0 {
+				continue names
+			}
+		}
+		for i, s := range summaries[name] {
+			logSums[i] += math.Log(s.Center)
+		}
+		n++
+	}
+	if n < 2 {
+		return nil
+	}
+	geomeans := make([]float64, ncols)
+	for i := range geomeans {
+		geomeans[i] = math.Exp(logSums[i] / float64(n))
+	}
+	return geomeans
+}
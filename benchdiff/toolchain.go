@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+func (c *Benchdiff) toolchainsDir() string {
+	return filepath.Join(c.ResultsDir, "toolchains")
+}
+
+// cachedToolchain returns the GOROOT of a toolchain built from the standard
+// library at ref, building it with make.bash if it's not in the cache yet.
+//
+// Cached toolchains are keyed by commit hash, and include the source tree
+// they were built from, so they can also be used to build the tests.
+func (c *Benchdiff) cachedToolchain(ctx context.Context, ref string, progress *pb.ProgressBar) (string, error) {
+	hash, err := c.runGitCmd("rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	goroot := filepath.Join(c.toolchainsDir(), string(hash))
+	if fileExists(goroot) {
+		c.Debug.Printf("using cached toolchain %s", goroot)
+		now := time.Now()
+		return goroot, os.Chtimes(goroot, now, now)
+	}
+
+	if err := os.MkdirAll(c.toolchainsDir(), 0o700); err != nil {
+		return "", err
+	}
+	// Build in a temporary directory and move it into place only once it's
+	// complete, so that interrupted builds don't poison the cache.
+	workPath := goroot + ".tmp"
+	if err := os.RemoveAll(workPath); err != nil {
+		return "", err
+	}
+	if _, err := c.runGitCmd("worktree", "add", "--quiet", "--detach", workPath, string(hash)); err != nil {
+		return "", err
+	}
+	defer func() {
+		if fileExists(workPath) {
+			c.runGitCmd("worktree", "remove", "--force", workPath)
+			os.RemoveAll(workPath)
+		}
+	}()
+
+	makeCmd := exec.CommandContext(ctx, filepath.Join(workPath, "src", "make.bash"))
+	makeCmd.Dir = filepath.Join(workPath, "src")
+	makeCmd.Env = append(os.Environ(), "GOOS=", "GOARCH=")
+	makeCmd.Stdout = &LineWriter{f: func(line string) {
+		words := strings.Fields(line)
+		if strings.HasPrefix(line, "Building Go") {
+			words = words[:3]
+		}
+		if strings.HasPrefix(line, "Building packages") {
+			words = words[:4]
+		}
+		if strings.HasPrefix(line, "***") {
+			words = []string{"Toolchain", "built"}
+		}
+		line = strings.Join(words, " ")
+		progress.Set("prefix", line+" |")
+	}}
+	if err := runCmd(makeCmd, c.Debug); err != nil {
+		return "", err
+	}
+
+	// The version is baked into the built toolchain, so the worktree can be
+	// detached from the repository, to outlive it in the cache.
+	if err := os.Remove(filepath.Join(workPath, ".git")); err != nil {
+		return "", err
+	}
+	if _, err := c.runGitCmd("worktree", "prune"); err != nil {
+		return "", err
+	}
+	if err := os.Rename(workPath, goroot); err != nil {
+		return "", err
+	}
+	return goroot, nil
+}
+
+// evictToolchains removes the least recently used toolchains from the cache
+// until their total size is below ToolchainCacheSize. The most recently used
+// toolchain is always kept.
+func (c *Benchdiff) evictToolchains() error {
+	entries, err := os.ReadDir(c.toolchainsDir())
+	if err != nil {
+		return err
+	}
+
+	type toolchain struct {
+		path     string
+		size     int64
+		lastUsed time.Time
+	}
+	var toolchains []toolchain
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		t := toolchain{path: filepath.Join(c.toolchainsDir(), e.Name()), lastUsed: info.ModTime()}
+		err = filepath.WalkDir(t.path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				t.size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		toolchains = append(toolchains, t)
+	}
+
+	sort.Slice(toolchains, func(i, j int) bool {
+		return toolchains[i].lastUsed.After(toolchains[j].lastUsed)
+	})
+	var total int64
+	for i, t := range toolchains {
+		total += t.size
+		if i == 0 || total <= c.ToolchainCacheSize {
+			continue
+		}
+		c.Debug.Printf("evicting cached toolchain %s", t.path)
+		if err := os.RemoveAll(t.path); err != nil {
+			return err
+		}
+		total -= t.size
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// A finding is a diagnostic, identified independently of its position.
+type finding struct {
+	Analyzer string
+	Package  string
+	// Func is the enclosing function, like F or T.M, or "-" at package level.
+	Func    string
+	Message string
+}
+
+func (f finding) String() string {
+	return strings.Join([]string{f.Analyzer, f.Package, f.Func, f.Message}, "\t")
+}
+
+func parseFinding(line string) (finding, error) {
+	fields := strings.SplitN(line, "\t", 4)
+	if len(fields) != 4 {
+		return finding{}, fmt.Errorf("malformed baseline line %q", line)
+	}
+	return finding{Analyzer: fields[0], Package: fields[1], Func: fields[2], Message: fields[3]}, nil
+}
+
+// readBaseline reads the findings listed in a baseline file, with the number
+// of times each is listed. A missing file is an empty baseline.
+func readBaseline(name string) (map[finding]int, error) {
+	baseline := make(map[finding]int)
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return baseline, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fd, err := parseFinding(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		baseline[fd]++
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return baseline, nil
+}
+
+// appended is how many times each finding was appended to the baseline file
+// by this process. The checker analyzes a package and its test variant
+// separately, with the same package path, and both would otherwise append the
+// findings of the non-test files.
+var appended = struct {
+	sync.Mutex
+	findings map[finding]int
+}{findings: make(map[finding]int)}
+
+// appendBaseline appends the n-th unaccepted occurrence of a finding in a
+// package to a baseline file, unless an analysis of another variant of the
+// package already did. The line is written with a single append-only write, so
+// that concurrent runs don't interleave.
+func appendBaseline(name string, fd finding, n int) error {
+	appended.Lock()
+	defer appended.Unlock()
+	if appended.findings[fd] >= n {
+		return nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte(fd.String() + "\n")); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	appended.findings[fd] = n
+	return nil
+}
+
+var loadBaseline = sync.OnceValues(func() (map[finding]int, error) {
+	return readBaseline(*baselineFile)
+})
+
+// withBaseline returns a copy of a that doesn't report the findings in the
+// -baseline file, or that appends them to it with -update-baseline. A finding
+// listed n times in the baseline is accepted up to n times per package, and
+// any further identical finding is reported.
+func withBaseline(a *analysis.Analyzer) *analysis.Analyzer {
+	wrapped := *a
+	wrapped.Run = func(pass *analysis.Pass) (any, error) {
+		if *baselineFile == "" {
+			if *updateBaseline {
+				return nil, errors.New("-update-baseline requires -baseline")
+			}
+			return a.Run(pass)
+		}
+		baseline, err := loadBaseline()
+		if err != nil {
+			return nil, err
+		}
+		report := pass.Report
+		var appendErr error
+		seen := make(map[finding]int)
+		pass.Report = func(d analysis.Diagnostic) {
+			fd := finding{
+				Analyzer: a.Name,
+				Package:  pass.Pkg.Path(),
+				Func:     enclosingFunc(pass, d.Pos),
+				Message:  d.Message,
+			}
+			seen[fd]++
+			switch {
+			case seen[fd] <= baseline[fd]:
+			case *updateBaseline:
+				if err := appendBaseline(*baselineFile, fd, seen[fd]-baseline[fd]); err != nil && appendErr == nil {
+					appendErr = err
+				}
+			default:
+				report(d)
+			}
+		}
+		res, err := a.Run(pass)
+		if err == nil {
+			err = appendErr
+		}
+		return res, err
+	}
+	return &wrapped
+}
+
+// enclosingFunc returns the name of the function declaration enclosing pos,
+// like F or T.M, or "-" if there is none.
+func enclosingFunc(pass *analysis.Pass, pos token.Pos) string {
+	for _, f := range pass.Files {
+		if pos < f.FileStart || pos > f.FileEnd {
+			continue
+		}
+		path, _ := astutil.PathEnclosingInterval(f, pos, pos)
+		for _, n := range path {
+			decl, ok := n.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			if decl.Recv == nil || len(decl.Recv.List) == 0 {
+				return decl.Name.Name
+			}
+			return recvTypeName(decl.Recv.List[0].Type) + "." + decl.Name.Name
+		}
+	}
+	return "-"
+}
+
+// recvTypeName returns the name of a receiver type, without pointers or type
+// parameters.
+func recvTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return "?"
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/nodivision"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func setBaseline(t *testing.T, name string, update bool) {
+	oldFile, oldUpdate := *baselineFile, *updateBaseline
+	*baselineFile, *updateBaseline = name, update
+	loadBaseline = sync.OnceValues(func() (map[finding]int, error) {
+		return readBaseline(*baselineFile)
+	})
+	t.Cleanup(func() { *baselineFile, *updateBaseline = oldFile, oldUpdate })
+}
+
+func TestBaseline(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cryptocheck.baseline")
+	if err := os.WriteFile(name, []byte("# accepted findings\n\n"+
+		"nodivision\ta\tT.Div\tuse of non-constant-time / operator\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setBaseline(t, name, false)
+	analysistest.Run(t, analysistest.TestData(), withBaseline(nodivision.Analyzer), "a")
+}
+
+func TestUpdateBaseline(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cryptocheck.baseline")
+	setBaseline(t, name, true)
+	analysistest.Run(t, analysistest.TestData(), withBaseline(nodivision.Analyzer), "b")
+
+	out, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+	slices.Sort(lines)
+	want := []string{
+		"nodivision\tb\t-\tuse of non-constant-time / operator",
+		"nodivision\tb\tT.Mod\tuse of non-constant-time % operator",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("baseline = %q, want %q", lines, want)
+	}
+
+	baseline, err := readBaseline(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baseline) != len(want) {
+		t.Errorf("read %d findings, want %d", len(baseline), len(want))
+	}
+}
+
+func TestBaselineRepeated(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cryptocheck.baseline")
+	if err := os.WriteFile(name, []byte(
+		"nodivision\tc\tDiv\tuse of non-constant-time / operator\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setBaseline(t, name, false)
+	analysistest.Run(t, analysistest.TestData(), withBaseline(nodivision.Analyzer), "c")
+}
+
+func TestUpdateBaselineRepeated(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cryptocheck.baseline")
+	if err := os.WriteFile(name, []byte(
+		"nodivision\td\tDiv\tuse of non-constant-time / operator\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setBaseline(t, name, true)
+	// d is analyzed both as d and as d [d.test], but the missing occurrence
+	// must be appended only once.
+	analysistest.Run(t, analysistest.TestData(), withBaseline(nodivision.Analyzer), "d")
+
+	baseline, err := readBaseline(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := finding{"nodivision", "d", "Div", "use of non-constant-time / operator"}
+	if len(baseline) != 1 || baseline[fd] != 2 {
+		t.Errorf("baseline = %v, want %v listed twice", baseline, fd)
+	}
+}
+
+func TestReadBaselineMalformed(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cryptocheck.baseline")
+	if err := os.WriteFile(name, []byte("nodivision a F\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBaseline(name); err == nil {
+		t.Error("malformed baseline was accepted")
+	}
+}
@@ -0,0 +1,60 @@
+// Command cryptocheck runs the cryptocheck analyzers: nodivision,
+// nosecretbranch, nosecretcompare, and taint, which only reports malformed
+// annotations.
+//
+// It can be run standalone
+//
+//	cryptocheck [-json] [-fix] [-baseline file] ./...
+//
+// or by go vet
+//
+//	go vet -vettool=$(which cryptocheck) [-baseline file] ./...
+//
+// With -json, the diagnostics are printed as JSON, and the exit status doesn't
+// reflect them. With -fix, the first
+// suggested fix of each diagnostic is applied: using
+// crypto/subtle.ConstantTimeCompare, annotating the parameters it depends on
+// //cryptovet:public, or renaming the enclosing function with a VarTime
+// suffix. With -taint.annotated-only, only values derived from
+// //cryptovet:secret annotations are reported.
+//
+// # Baseline
+//
+// With -baseline, the accepted findings listed in file are not reported, so
+// that CI can fail only on new ones. Each line of the file is a tab-separated
+// analyzer name, package path, function, and message, so that findings
+// survive unrelated edits that move them around. A finding occurring more
+// than once in the same function is listed once per accepted occurrence, and
+// any additional occurrence is reported. Lines starting with # are comments.
+//
+// With -update-baseline, findings are appended to the baseline file instead
+// of being reported. To start from scratch, delete the file first. Since
+// packages are analyzed concurrently, the new lines are not sorted.
+//
+// go vet runs the tool in each package's directory, so the baseline file
+// should be an absolute path, like -baseline=$PWD/cryptocheck.baseline.
+package main
+
+import (
+	"flag"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/nodivision"
+	"filippo.io/mostly-harmless/cryptocheck/passes/nosecretbranch"
+	"filippo.io/mostly-harmless/cryptocheck/passes/nosecretcompare"
+	"filippo.io/mostly-harmless/cryptocheck/passes/taint"
+	"golang.org/x/tools/go/analysis/multichecker"
+)
+
+var (
+	baselineFile   = flag.String("baseline", "", "don't report the accepted findings listed in `file`")
+	updateBaseline = flag.Bool("update-baseline", false, "append findings to the -baseline file instead of reporting them")
+)
+
+func main() {
+	multichecker.Main(
+		withBaseline(nodivision.Analyzer),
+		withBaseline(nosecretbranch.Analyzer),
+		withBaseline(nosecretcompare.Analyzer),
+		taint.Analyzer,
+	)
+}
@@ -0,0 +1,12 @@
+// Package a is test input for the baseline filtering.
+package a
+
+type T struct{ n int }
+
+func (t *T) Div(x int) int {
+	return x / t.n
+}
+
+func Div(x, y int) int {
+	return x / y // want `use of non-constant-time / operator`
+}
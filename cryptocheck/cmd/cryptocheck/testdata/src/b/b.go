@@ -0,0 +1,10 @@
+// Package b is test input for the baseline updates.
+package b
+
+type T[E any] struct{ n int }
+
+func (t *T[E]) Mod(x int) int {
+	return x % t.n
+}
+
+var Global = func(x, y int) int { return x / y }(1, 2)
@@ -0,0 +1,9 @@
+// Package c is test input for findings repeated in the same function, and
+// analyzed again with its tests.
+package c
+
+func Div(x, y int) int {
+	q := x / y
+	r := x / y // want `use of non-constant-time / operator`
+	return q + r
+}
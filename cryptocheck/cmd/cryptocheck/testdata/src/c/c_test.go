@@ -0,0 +1,7 @@
+package c
+
+import "testing"
+
+func TestDiv(t *testing.T) {
+	Div(4, 2)
+}
@@ -0,0 +1,9 @@
+// Package d is test input for the baseline updates of findings repeated in the
+// same function, and analyzed again with its tests.
+package d
+
+func Div(x, y int) int {
+	q := x / y
+	r := x / y
+	return q + r
+}
@@ -0,0 +1,7 @@
+package d
+
+import "testing"
+
+func TestDiv(t *testing.T) {
+	Div(4, 2)
+}
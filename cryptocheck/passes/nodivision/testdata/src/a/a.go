@@ -323,3 +323,33 @@ func rangeIndexOutsideLoop(f []byte, secret int) int {
 	_ = i / secret // want `use of non-constant-time / operator`
 	return 0
 }
+
+// For loop counters are safe like range indices, if their initial value and
+// step are safe.
+
+func forCounterDiv(f []byte, secret int) int {
+	var sum int
+	for i := 0; i < len(f); i++ {
+		sum += i / 7
+	}
+	for i := len(f) - 1; i >= 0; i -= 2 {
+		sum += i % 5
+	}
+	for i := secret; i < 10; i++ {
+		sum += i / 7 // want `use of non-constant-time / operator`
+	}
+	for i := 0; i < 10; i += secret {
+		sum += i / 7 // want `use of non-constant-time / operator`
+	}
+	return sum
+}
+
+// Values derived from annotated secrets are tracked across functions, and the
+// diagnostic explains how.
+
+//cryptovet:secret k
+func scalarShift(k int) int { return k >> 1 }
+
+func annotatedDiv(k, n int) int {
+	return n / scalarShift(k) // want `use of non-constant-time / operator \(secret: parameter k is annotated //cryptovet:secret -> returned by scalarShift\)`
+}
@@ -0,0 +1,13 @@
+// Package fix is test input for the nodivision suggested fixes.
+package fix
+
+func div(x, n int) int {
+	return x / n // want `use of non-constant-time / operator`
+}
+
+func callDiv() int { return div(10, 3) }
+
+//cryptovet:secret k
+func Reduce(k int) int {
+	return k % 7 // want `use of non-constant-time % operator \(secret: parameter k is annotated //cryptovet:secret\)`
+}
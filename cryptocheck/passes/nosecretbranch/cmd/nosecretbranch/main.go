@@ -0,0 +1,9 @@
+// The nosecretbranch command runs the nosecretbranch analyzer.
+package main
+
+import (
+	"filippo.io/mostly-harmless/cryptocheck/passes/nosecretbranch"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() { singlechecker.Main(nosecretbranch.Analyzer) }
@@ -0,0 +1,139 @@
+// Package nosecretbranch defines an Analyzer that flags branches and memory
+// accesses that depend on values that might be secret.
+package nosecretbranch
+
+import (
+	"go/ast"
+	"go/types"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/taint"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const Doc = `check for branches and memory indexes that depend on secret values
+
+Branching on a secret value, or using it to index into memory, leaks it
+through timing and cache side-channels in cryptographic code.
+
+The following are flagged when their value is not "safe" (public):
+  - if, for, and switch conditions, and switch case expressions
+  - slice, array, string, and map indexes
+
+They are allowed in:
+  - Test files (*_test.go)
+  - Functions or methods with a VarTime name suffix (e.g., ScalarMultVarTime)
+
+Safe values are the ones the taint analyzer knows to be public, including:
+  - Constants and literals
+  - Lengths (which leak via cache side-channels anyway)
+  - Return values of functions marked with //cryptovet:return-value-is-not-secret
+    or //cryptovet:public, or computed only from safe arguments
+  - Range loop index variables (already leaked by loop progression)
+  - Variables only assigned safe values, like most loop counters
+  - Errors, and comparisons with nil
+
+Values derived from //cryptovet:secret annotations are reported with the
+path through which they became secret. With -taint.annotated-only (in the
+cryptocheck command), only those are reported.
+
+Diagnostics suggest annotating the parameters they depend on as
+//cryptovet:public, or renaming an unexported enclosing function with a
+VarTime suffix.`
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "nosecretbranch",
+	Doc:      Doc,
+	Requires: []*analysis.Analyzer{inspect.Analyzer, taint.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	safe := pass.ResultOf[taint.Analyzer].(*taint.Result)
+
+	nodeFilter := []ast.Node{
+		(*ast.IfStmt)(nil),
+		(*ast.ForStmt)(nil),
+		(*ast.SwitchStmt)(nil),
+		(*ast.IndexExpr)(nil),
+	}
+
+	inspect.WithStack(nodeFilter, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+
+		// Check if we're in a test file or in a VarTime function.
+		if taint.Exempt(pass, n, stack) {
+			return true
+		}
+
+		report := func(expr ast.Expr, what string) {
+			pass.Report(analysis.Diagnostic{
+				Pos:            expr.Pos(),
+				End:            expr.End(),
+				Message:        what + " might depend on a secret value" + safe.Explain(expr),
+				SuggestedFixes: safe.SuggestedFixes(stack, expr),
+			})
+		}
+
+		switch n := n.(type) {
+		case *ast.IfStmt:
+			if !safe.IsSafe(n.Cond) {
+				report(n.Cond, "if condition")
+			}
+		case *ast.ForStmt:
+			if n.Cond != nil && !safe.IsSafe(n.Cond) {
+				report(n.Cond, "for condition")
+			}
+		case *ast.SwitchStmt:
+			tagSafe := true
+			if n.Tag != nil && !safe.IsSafe(n.Tag) {
+				report(n.Tag, "switch tag")
+				tagSafe = false
+			}
+			for _, stmt := range n.Body.List {
+				for _, expr := range stmt.(*ast.CaseClause).List {
+					// An unsafe tag was already reported.
+					if tagSafe && !safe.IsSafe(expr) {
+						report(expr, "switch case")
+					}
+				}
+			}
+		case *ast.IndexExpr:
+			if !isMemoryIndex(pass, n) {
+				return true
+			}
+			if !safe.IsSafe(n.Index) {
+				report(n.Index, "index")
+			}
+		}
+
+		return true
+	})
+
+	return nil, nil
+}
+
+// isMemoryIndex reports whether n indexes a slice, array, pointer to array,
+// string, or map, as opposed to instantiating a generic function or type.
+func isMemoryIndex(pass *analysis.Pass, n *ast.IndexExpr) bool {
+	tv, ok := pass.TypesInfo.Types[n.X]
+	if !ok || !tv.IsValue() {
+		return false
+	}
+	t := tv.Type.Underlying()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem().Underlying()
+	}
+	switch t := t.(type) {
+	case *types.Slice, *types.Array, *types.Map:
+		return true
+	case *types.Basic:
+		return t.Info()&types.IsString != 0
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+package nosecretbranch_test
+
+import (
+	"testing"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/nosecretbranch"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func Test(t *testing.T) {
+	testdata := analysistest.TestData()
+	analysistest.Run(t, testdata, nosecretbranch.Analyzer, "a")
+}
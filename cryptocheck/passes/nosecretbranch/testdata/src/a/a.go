@@ -0,0 +1,191 @@
+// Package a is test input for the nosecretbranch analyzer.
+package a
+
+import "errors"
+
+func If(secret int) int {
+	if secret == 0 { // want `if condition might depend on a secret value`
+		return 1
+	}
+	if secret > 0 && secret < 10 { // want `if condition might depend on a secret value`
+		return 2
+	}
+	if !(secret != 3) { // want `if condition might depend on a secret value`
+		return 3
+	}
+	return 0
+}
+
+func For(secret int) int {
+	var n int
+	for n < secret { // want `for condition might depend on a secret value`
+		n++
+	}
+	for i := 0; i < secret; i++ { // want `for condition might depend on a secret value`
+		n++
+	}
+	for {
+		break
+	}
+	return n
+}
+
+func Switch(secret, public int) int {
+	switch secret { // want `switch tag might depend on a secret value`
+	case 1, 2:
+		return 1
+	}
+	switch {
+	case secret > 10: // want `switch case might depend on a secret value`
+		return 2
+	case len("abc") > 2:
+		return 3
+	}
+	switch 3 {
+	case 1:
+	case public: // want `switch case might depend on a secret value`
+	}
+	return 0
+}
+
+func Index(table []byte, m map[int]byte, arr *[16]byte, s string, secret int) byte {
+	a := table[secret] // want `index might depend on a secret value`
+	b := m[secret]     // want `index might depend on a secret value`
+	c := arr[secret]   // want `index might depend on a secret value`
+	d := s[secret]     // want `index might depend on a secret value`
+	table[secret] = 0  // want `index might depend on a secret value`
+	return a + b + c + d + table[0] + arr[len(table)%16]
+}
+
+// Generic instantiations are not memory accesses.
+
+func generic[T any](v T) T { return v }
+
+type box[T any] struct{ v T }
+
+func Generic(secret int) int {
+	f := generic[int]
+	b := box[int]{v: secret}
+	return f(b.v)
+}
+
+// ScalarMultVarTime is allowed because its name ends with VarTime.
+func ScalarMultVarTime(table []byte, secret int) byte {
+	if secret == 0 {
+		return 0
+	}
+	for i := 0; i < secret; i++ {
+		switch secret {
+		case 1:
+		}
+	}
+	return table[secret]
+}
+
+type T struct{}
+
+// LookupVarTime is allowed because its name ends with VarTime.
+func (T) LookupVarTime(table []byte, secret int) byte {
+	return table[secret]
+}
+
+// Lookup is not allowed even on a method.
+func (T) Lookup(table []byte, secret int) byte {
+	return table[secret] // want `index might depend on a secret value`
+}
+
+func nested(table []byte, secret int) byte {
+	f := func() byte {
+		return table[secret] // want `index might depend on a secret value`
+	}
+	return f()
+}
+
+// Safe values are allowed.
+
+const size = 32
+
+func constants(table []byte) byte {
+	if size > 16 {
+		return table[size-1]
+	}
+	switch size {
+	case 16, 32:
+	}
+	return table[0]
+}
+
+func lengths(table, x []byte) byte {
+	if len(x) < 16 {
+		return 0
+	}
+	for len(x) > 0 {
+		x = x[1:]
+	}
+	return table[len(x)]
+}
+
+func loops(table []byte, secret byte) byte {
+	var acc byte
+	for i := range table {
+		if i%2 == 0 {
+			acc ^= table[i]
+		}
+	}
+	for i := 0; i < len(table); i++ {
+		acc ^= table[i]
+	}
+	for i, v := range table {
+		acc ^= table[v] // want `index might depend on a secret value`
+		_ = i
+	}
+	for i := int(secret); i < 10; i++ { // want `for condition might depend on a secret value`
+		acc ^= table[i] // want `index might depend on a secret value`
+	}
+	return acc
+}
+
+var errFoo = errors.New("foo")
+
+func errs(p *int, b []byte, m map[int]int, f func() error) error {
+	err := f()
+	if err != nil {
+		return err
+	}
+	if err == errFoo {
+		return nil
+	}
+	if p == nil || b == nil || m != nil {
+		return nil
+	}
+	switch err {
+	case errFoo, nil:
+	}
+	return nil
+}
+
+//cryptovet:return-value-is-not-secret
+func publicIndex(secret int) int { return secret & 15 }
+
+func useNotSecret(table []byte, secret int) byte {
+	if publicIndex(secret) > 3 {
+		return table[publicIndex(secret)]
+	}
+	return table[publicIndex(secret)+secret] // want `index might depend on a secret value`
+}
+
+// Scalar is a secret scalar.
+//
+//cryptovet:secret
+type Scalar [32]byte
+
+//cryptovet:public i
+func (s *Scalar) bit(i int) byte { return s[i/8] >> (i % 8) & 1 }
+
+func ladder(s *Scalar, table []byte) byte {
+	b := s.bit(0)
+	if b == 1 { // want `if condition might depend on a secret value \(secret: s has type Scalar, annotated //cryptovet:secret -> returned by bit -> assigned to b\)`
+		return 0
+	}
+	return table[s.bit(1)] // want `index might depend on a secret value \(secret: s has type Scalar, annotated //cryptovet:secret -> returned by bit\)`
+}
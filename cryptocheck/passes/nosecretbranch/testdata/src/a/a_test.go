@@ -0,0 +1,12 @@
+package a
+
+import "testing"
+
+// TestBranch is in a _test.go file, so branches are allowed.
+func TestBranch(t *testing.T) {
+	table := []byte{1, 2, 3}
+	secret := 1
+	if table[secret] != 2 {
+		t.Error("indexing is broken")
+	}
+}
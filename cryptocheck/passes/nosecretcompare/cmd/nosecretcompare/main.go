@@ -0,0 +1,9 @@
+// The nosecretcompare command runs the nosecretcompare analyzer.
+package main
+
+import (
+	"filippo.io/mostly-harmless/cryptocheck/passes/nosecretcompare"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() { singlechecker.Main(nosecretcompare.Analyzer) }
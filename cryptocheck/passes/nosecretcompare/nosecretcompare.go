@@ -0,0 +1,395 @@
+// Package nosecretcompare defines an Analyzer that flags variable-time
+// comparisons of values that might be secret, like MACs and tags.
+package nosecretcompare
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/token"
+	"go/types"
+	"strconv"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/taint"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+const Doc = `check for variable-time comparisons of secret values
+
+bytes.Equal and the == operator on strings and arrays return as soon as
+they find a difference, leaking through timing how much of a secret value,
+like a MAC or a tag, matches an attacker-controlled one. Hand-written loops
+that break or return at the first difference leak the same way.
+
+The following are flagged when their operands are not "safe" (public):
+  - calls to bytes.Equal, bytes.Compare, strings.Compare, and slices.Equal
+  - == and != on strings and arrays
+  - for and range loops that exit early on a comparison of indexed elements
+
+Use crypto/subtle.ConstantTimeCompare or crypto/hmac.Equal instead. The
+crypto/subtle functions are never flagged, and the results of
+ConstantTimeCompare and hmac.Equal are public.
+
+Comparisons are allowed in:
+  - Test files (*_test.go)
+  - Functions or methods with a VarTime name suffix (e.g., EqualVarTime)
+
+Safe values are the ones the taint analyzer knows to be public, as for
+the nodivision analyzer.
+
+Diagnostics suggest replacing bytes.Equal and string comparisons with
+crypto/subtle.ConstantTimeCompare.`
+
+var Analyzer = &analysis.Analyzer{
+	Name:     "nosecretcompare",
+	Doc:      Doc,
+	Requires: []*analysis.Analyzer{inspect.Analyzer, taint.Analyzer},
+	Run:      run,
+}
+
+// variableTimeFuncs are the variable-time comparison functions.
+var variableTimeFuncs = map[string]bool{
+	"bytes.Equal":     true,
+	"bytes.Compare":   true,
+	"strings.Compare": true,
+	"slices.Equal":    true,
+}
+
+const advice = "use crypto/subtle.ConstantTimeCompare or crypto/hmac.Equal"
+
+func run(pass *analysis.Pass) (any, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	safe := pass.ResultOf[taint.Analyzer].(*taint.Result)
+
+	nodeFilter := []ast.Node{
+		(*ast.CallExpr)(nil),
+		(*ast.BinaryExpr)(nil),
+		(*ast.ForStmt)(nil),
+		(*ast.RangeStmt)(nil),
+	}
+
+	inspect.WithStack(nodeFilter, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+
+		// Check if we're in a test file or in a VarTime function.
+		if taint.Exempt(pass, n, stack) {
+			return true
+		}
+
+		report := func(rng analysis.Range, message string, fix *analysis.SuggestedFix, operands ...ast.Expr) {
+			d := analysis.Diagnostic{
+				Pos:     rng.Pos(),
+				End:     rng.End(),
+				Message: message + safe.Explain(operands...) + "; " + advice,
+			}
+			if fix != nil {
+				d.SuggestedFixes = append(d.SuggestedFixes, *fix)
+			}
+			d.SuggestedFixes = append(d.SuggestedFixes, safe.SuggestedFixes(stack, operands...)...)
+			pass.Report(d)
+		}
+
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			fn := typeutil.StaticCallee(pass.TypesInfo, n)
+			if fn == nil || fn.Pkg() == nil || len(n.Args) != 2 {
+				return true
+			}
+			name := fn.Pkg().Path() + "." + fn.Name()
+			if !variableTimeFuncs[name] || allSafe(safe, n.Args...) {
+				return true
+			}
+			var fix *analysis.SuggestedFix
+			if name == "bytes.Equal" {
+				fix = constantTimeCompareFix(pass, n, stack, n.Args[0], n.Args[1], token.EQL, false)
+			}
+			report(n, name+" is not constant-time, and its arguments might be secret", fix, n.Args...)
+		case *ast.BinaryExpr:
+			if n.Op != token.EQL && n.Op != token.NEQ {
+				return true
+			}
+			var kind string
+			isString := false
+			switch t := pass.TypesInfo.TypeOf(n.X).Underlying().(type) {
+			case *types.Basic:
+				if t.Info()&types.IsString == 0 {
+					return true
+				}
+				// Comparing with "" only reveals the length.
+				if isEmptyString(pass, n.X) || isEmptyString(pass, n.Y) {
+					return true
+				}
+				kind, isString = "strings", true
+			case *types.Array:
+				kind = "arrays"
+			default:
+				return true
+			}
+			if allSafe(safe, n.X, n.Y) {
+				return true
+			}
+			var fix *analysis.SuggestedFix
+			if isString {
+				fix = constantTimeCompareFix(pass, n, stack, n.X, n.Y, n.Op, true)
+			}
+			report(n, fmt.Sprintf("%s on %s is not constant-time, and its operands might be secret", n.Op, kind), fix, n.X, n.Y)
+		case *ast.ForStmt, *ast.RangeStmt:
+			var body *ast.BlockStmt
+			if f, ok := n.(*ast.ForStmt); ok {
+				body = f.Body
+			} else {
+				body = n.(*ast.RangeStmt).Body
+			}
+			for _, cond := range earlyExits(pass, body) {
+				if !safe.IsSafe(cond) {
+					report(cond, "loop exits early on a comparison that might depend on a secret value", nil, cond)
+				}
+			}
+		}
+
+		return true
+	})
+
+	return nil, nil
+}
+
+func allSafe(safe *taint.Result, exprs ...ast.Expr) bool {
+	for _, expr := range exprs {
+		if !safe.IsSafe(expr) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmptyString(pass *analysis.Pass, expr ast.Expr) bool {
+	tv := pass.TypesInfo.Types[expr]
+	return tv.Value != nil && tv.Value.Kind() == constant.String && constant.StringVal(tv.Value) == ""
+}
+
+// earlyExits returns the conditions of the if statements in a loop body that
+// compare indexed elements, and break or return from the loop. Nested loops
+// and function literals are not inspected, since they are checked on their
+// own.
+func earlyExits(pass *analysis.Pass, body *ast.BlockStmt) []ast.Expr {
+	var conds []ast.Expr
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit, *ast.ForStmt, *ast.RangeStmt:
+			return false
+		case *ast.IfStmt:
+			if comparesElements(pass, n.Cond) && exitsLoop(n.Body) {
+				conds = append(conds, n.Cond)
+			}
+		}
+		return true
+	})
+	return conds
+}
+
+// comparesElements reports whether cond contains a comparison with an
+// indexed slice, array, or string element as an operand.
+func comparesElements(pass *analysis.Pass, cond ast.Expr) bool {
+	found := false
+	ast.Inspect(cond, func(n ast.Node) bool {
+		b, ok := n.(*ast.BinaryExpr)
+		if !ok {
+			return !found
+		}
+		switch b.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			for _, operand := range []ast.Expr{b.X, b.Y} {
+				if isElement(pass, operand) {
+					found = true
+				}
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// isElement reports whether expr is an index expression into a slice, array,
+// pointer to array, or string, possibly combined with other values.
+func isElement(pass *analysis.Pass, expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit, *ast.CallExpr:
+			return false
+		case *ast.IndexExpr:
+			t := pass.TypesInfo.TypeOf(n.X)
+			if t == nil {
+				return true
+			}
+			t = t.Underlying()
+			if p, ok := t.(*types.Pointer); ok {
+				t = p.Elem().Underlying()
+			}
+			switch t := t.(type) {
+			case *types.Slice, *types.Array:
+				found = true
+			case *types.Basic:
+				found = found || t.Info()&types.IsString != 0
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// exitsLoop reports whether block contains a return, a goto, or a break
+// that exits the enclosing loop.
+func exitsLoop(block *ast.BlockStmt) bool {
+	return exits(block, false)
+}
+
+// exits reports whether n contains a return, a goto, or a break that exits
+// the loop. If inner is true, n is nested in a statement that an unlabeled
+// break would exit instead.
+func exits(n ast.Node, inner bool) bool {
+	found := false
+	ast.Inspect(n, func(m ast.Node) bool {
+		if found {
+			return false
+		}
+		switch m := m.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+			if m != n {
+				found = exits(m, true)
+				return false
+			}
+		case *ast.ReturnStmt:
+			found = true
+		case *ast.BranchStmt:
+			found = m.Tok == token.GOTO || m.Tok == token.BREAK && (m.Label != nil || !inner)
+		}
+		return !found
+	})
+	return found
+}
+
+// constantTimeCompareFix returns a fix replacing expr, a comparison of x and
+// y with op, with crypto/subtle.ConstantTimeCompare, or nil if it can't. If
+// toBytes is true, x and y are converted to []byte.
+func constantTimeCompareFix(pass *analysis.Pass, expr ast.Expr, stack []ast.Node, x, y ast.Expr, op token.Token, toBytes bool) *analysis.SuggestedFix {
+	file, _ := stack[0].(*ast.File)
+	if file == nil {
+		return nil
+	}
+	name, importEdits, ok := importSubtle(pass, file, expr.Pos())
+	if !ok {
+		return nil
+	}
+
+	// Replace !bytes.Equal(a, b) as a whole.
+	var replaced ast.Node = expr
+	var parent ast.Node
+	if len(stack) > 1 {
+		parent = stack[len(stack)-2]
+	}
+	if u, ok := parent.(*ast.UnaryExpr); ok && u.Op == token.NOT {
+		replaced = u
+		op = token.NEQ
+		parent = nil
+		if len(stack) > 2 {
+			parent = stack[len(stack)-3]
+		}
+	}
+
+	var args [2]string
+	for i, arg := range []ast.Expr{x, y} {
+		var buf bytes.Buffer
+		if err := format.Node(&buf, pass.Fset, arg); err != nil {
+			return nil
+		}
+		args[i] = buf.String()
+		if toBytes {
+			args[i] = "[]byte(" + args[i] + ")"
+		}
+	}
+	text := fmt.Sprintf("%s.ConstantTimeCompare(%s, %s) %s 1", name, args[0], args[1], op)
+	if b, ok := parent.(*ast.BinaryExpr); ok && b.Op != token.LAND && b.Op != token.LOR {
+		text = "(" + text + ")"
+	}
+
+	return &analysis.SuggestedFix{
+		Message: "Use crypto/subtle.ConstantTimeCompare",
+		TextEdits: append(importEdits, analysis.TextEdit{
+			Pos:     replaced.Pos(),
+			End:     replaced.End(),
+			NewText: []byte(text),
+		}),
+	}
+}
+
+// importSubtle returns the name of crypto/subtle in file, and the edits to
+// import it if it's not imported yet. It fails if the name is shadowed at pos.
+func importSubtle(pass *analysis.Pass, file *ast.File, pos token.Pos) (string, []analysis.TextEdit, bool) {
+	const path = "crypto/subtle"
+	for _, imp := range file.Imports {
+		if p, _ := strconv.Unquote(imp.Path.Value); p != path {
+			continue
+		}
+		if imp.Name == nil {
+			return "subtle", nil, true
+		}
+		if imp.Name.Name == "_" || imp.Name.Name == "." {
+			return "", nil, false
+		}
+		return imp.Name.Name, nil, true
+	}
+
+	if scope := pass.TypesInfo.Scopes[file].Innermost(pos); scope != nil {
+		if _, obj := scope.LookupParent("subtle", pos); obj != nil {
+			return "", nil, false
+		}
+	}
+	if pass.Pkg.Scope().Lookup("subtle") != nil {
+		return "", nil, false
+	}
+
+	for _, decl := range file.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		// An empty import () group has nowhere to anchor the new import.
+		if !ok || decl.Tok != token.IMPORT || len(decl.Specs) == 0 {
+			continue
+		}
+		if !decl.Lparen.IsValid() {
+			return "subtle", []analysis.TextEdit{{
+				Pos: decl.Pos(), End: decl.Pos(), NewText: []byte("import \"" + path + "\"\n"),
+			}}, true
+		}
+		// Insert in order in the first group of imports.
+		last := decl.Specs[0].(*ast.ImportSpec)
+		for i, spec := range decl.Specs {
+			spec := spec.(*ast.ImportSpec)
+			if i > 0 && pass.Fset.Position(spec.Pos()).Line > pass.Fset.Position(last.End()).Line+1 {
+				break
+			}
+			if p, _ := strconv.Unquote(spec.Path.Value); p > path {
+				return "subtle", []analysis.TextEdit{{
+					Pos: spec.Pos(), End: spec.Pos(), NewText: []byte(strconv.Quote(path) + "\n\t"),
+				}}, true
+			}
+			last = spec
+		}
+		return "subtle", []analysis.TextEdit{{
+			Pos: last.End(), End: last.End(), NewText: []byte("\n\t" + strconv.Quote(path)),
+		}}, true
+	}
+
+	return "subtle", []analysis.TextEdit{{
+		Pos: file.Name.End(), End: file.Name.End(), NewText: []byte("\n\nimport \"" + path + "\""),
+	}}, true
+}
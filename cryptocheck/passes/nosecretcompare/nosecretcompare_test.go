@@ -0,0 +1,18 @@
+package nosecretcompare_test
+
+import (
+	"testing"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/nosecretcompare"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func Test(t *testing.T) {
+	testdata := analysistest.TestData()
+	analysistest.Run(t, testdata, nosecretcompare.Analyzer, "a")
+}
+
+func TestSuggestedFixes(t *testing.T) {
+	testdata := analysistest.TestData()
+	analysistest.RunWithSuggestedFixes(t, testdata, nosecretcompare.Analyzer, "fix", "emptyimport")
+}
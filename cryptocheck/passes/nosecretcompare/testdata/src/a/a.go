@@ -0,0 +1,102 @@
+// Package a is test input for the nosecretcompare analyzer.
+package a
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/subtle"
+	"slices"
+	"strings"
+)
+
+func Calls(mac, expected []byte, s, t string) bool {
+	if bytes.Equal(mac, expected) { // want `bytes.Equal is not constant-time, and its arguments might be secret; use crypto/subtle.ConstantTimeCompare or crypto/hmac.Equal`
+		return true
+	}
+	_ = bytes.Compare(mac, expected) // want `bytes.Compare is not constant-time`
+	_ = strings.Compare(s, t)        // want `strings.Compare is not constant-time`
+	_ = slices.Equal(mac, expected)  // want `slices.Equal is not constant-time`
+	_ = bytes.Equal([]byte("a"), []byte("b"))
+	_ = bytes.Equal(mac[:len(expected)], mac[:0]) // want `bytes.Equal is not constant-time`
+	return false
+}
+
+func Operators(a, b [16]byte, s, t string, n, m int) bool {
+	if a == b { // want `== on arrays is not constant-time, and its operands might be secret`
+		return true
+	}
+	if s != t { // want `!= on strings is not constant-time`
+		return true
+	}
+	if s == "" || len(s) == len(t) {
+		return false
+	}
+	return n == m
+}
+
+func Subtle(mac, expected []byte) bool {
+	if subtle.ConstantTimeCompare(mac, expected) == 1 {
+		return true
+	}
+	return hmac.Equal(mac, expected)
+}
+
+func Loops(a, b []byte) bool {
+	for i := range a {
+		if a[i] != b[i] { // want `loop exits early on a comparison that might depend on a secret value`
+			return false
+		}
+	}
+	for i := 0; i < len(a); i++ {
+		if a[i]^b[i] != 0 { // want `loop exits early on a comparison that might depend on a secret value`
+			break
+		}
+	}
+	var v byte
+	for i := range a {
+		v |= a[i] ^ b[i]
+	}
+	for i := range a {
+		if subtle.ConstantTimeByteEq(a[i], b[i]) == 0 {
+			v = 1
+		}
+	}
+	for i := range a {
+		switch {
+		case a[i] == 0:
+			break
+		}
+	}
+	for i := range a {
+		if a[i] == 0 { // want `loop exits early on a comparison that might depend on a secret value`
+			switch {
+			default:
+				return false
+			}
+		}
+	}
+	return v == 0
+}
+
+//cryptovet:public a, b
+func PublicLoop(a, b []byte) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return bytes.Equal(a, b)
+}
+
+func EqualVarTime(mac, expected []byte) bool {
+	return bytes.Equal(mac, expected)
+}
+
+// Tag is a secret authentication tag.
+//
+//cryptovet:secret
+type Tag [16]byte
+
+func (t Tag) Check(other [16]byte) bool {
+	return t == other // want `== on arrays is not constant-time, and its operands might be secret \(secret: t has type Tag, annotated //cryptovet:secret\)`
+}
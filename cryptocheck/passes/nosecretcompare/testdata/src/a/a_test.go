@@ -0,0 +1,14 @@
+package a
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestCompare is in a _test.go file, so comparisons are allowed.
+func TestCompare(t *testing.T) {
+	mac := []byte{1, 2, 3}
+	if !bytes.Equal(mac, []byte{1, 2, 3}) {
+		t.Error("comparison is broken")
+	}
+}
@@ -0,0 +1,10 @@
+// Package emptyimport is test input for the nosecretcompare suggested fixes
+// in a file with an empty import group.
+package emptyimport
+
+import ()
+
+//cryptovet:secret password
+func Login(password, expected string) bool {
+	return password == expected // want `== on strings is not constant-time`
+}
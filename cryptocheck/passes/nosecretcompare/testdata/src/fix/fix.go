@@ -0,0 +1,24 @@
+// Package fix is test input for the nosecretcompare suggested fixes.
+package fix
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Tag is a secret authentication tag.
+//
+//cryptovet:secret
+type Tag []byte
+
+func Check(tag, expected Tag) error {
+	if !bytes.Equal(tag, expected) { // want `bytes.Equal is not constant-time`
+		return errors.New("invalid tag")
+	}
+	return nil
+}
+
+//cryptovet:secret password
+func Login(password, expected string) bool {
+	return password == expected // want `== on strings is not constant-time`
+}
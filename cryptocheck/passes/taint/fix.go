@@ -0,0 +1,103 @@
+package taint
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+// SuggestedFixes returns the fixes for a diagnostic about exprs, reached
+// through stack, in order of preference:
+//
+//   - if exprs are only unsafe because they depend on parameters of the
+//     enclosing function, annotating those parameters //cryptovet:public;
+//   - if the enclosing function is unexported, renaming it with a VarTime
+//     suffix, which exempts it from the checks.
+//
+// Exported functions are not renamed, since their callers in other packages
+// can't be fixed.
+func (r *Result) SuggestedFixes(stack []ast.Node, exprs ...ast.Expr) []analysis.SuggestedFix {
+	var decl *ast.FuncDecl
+	for i := len(stack) - 1; i >= 0 && decl == nil; i-- {
+		decl, _ = stack[i].(*ast.FuncDecl)
+	}
+	if decl == nil {
+		return nil
+	}
+	var fixes []analysis.SuggestedFix
+	if fix, ok := r.publicParamsFix(decl, exprs); ok {
+		fixes = append(fixes, fix)
+	}
+	if fix, ok := r.renameFix(decl); ok {
+		fixes = append(fixes, fix)
+	}
+	return fixes
+}
+
+func (r *Result) publicParamsFix(decl *ast.FuncDecl, exprs []ast.Expr) (analysis.SuggestedFix, bool) {
+	var v value
+	for _, expr := range exprs {
+		v = join(v, r.t.eval(expr))
+	}
+	if v.level != Public || v.params == 0 {
+		return analysis.SuggestedFix{}, false
+	}
+	var names []string
+	for i, param := range funcParams(decl) {
+		if i < 64 && v.params&(1<<i) != 0 && param != nil && param.Name != "_" {
+			names = append(names, param.Name)
+		}
+	}
+	if len(names) == 0 {
+		return analysis.SuggestedFix{}, false
+	}
+	list := strings.Join(names, ", ")
+	return analysis.SuggestedFix{
+		Message: fmt.Sprintf("Annotate %s as %s", list, PublicPragma),
+		TextEdits: []analysis.TextEdit{{
+			Pos:     decl.Pos(),
+			End:     decl.Pos(),
+			NewText: []byte(PublicPragma + " " + list + "\n"),
+		}},
+	}, true
+}
+
+func (r *Result) renameFix(decl *ast.FuncDecl) (analysis.SuggestedFix, bool) {
+	pass := r.t.pass
+	fn, ok := pass.TypesInfo.Defs[decl.Name].(*types.Func)
+	if !ok || fn.Exported() || decl.Name.Name == "init" || decl.Name.Name == "_" {
+		return analysis.SuggestedFix{}, false
+	}
+	if decl.Recv == nil && decl.Name.Name == "main" && pass.Pkg.Name() == "main" {
+		return analysis.SuggestedFix{}, false
+	}
+	name := fn.Name() + "VarTime"
+	if recv := fn.Signature().Recv(); recv != nil {
+		if obj, _, _ := types.LookupFieldOrMethod(recv.Type(), true, pass.Pkg, name); obj != nil {
+			return analysis.SuggestedFix{}, false
+		}
+	} else if pass.Pkg.Scope().Lookup(name) != nil {
+		return analysis.SuggestedFix{}, false
+	}
+
+	var edits []analysis.TextEdit
+	for _, f := range pass.Files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			id, ok := n.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			if pass.TypesInfo.Defs[id] == fn || pass.TypesInfo.Uses[id] == fn {
+				edits = append(edits, analysis.TextEdit{Pos: id.Pos(), End: id.End(), NewText: []byte(name)})
+			}
+			return true
+		})
+	}
+	return analysis.SuggestedFix{
+		Message:   fmt.Sprintf("Rename %s to %s", fn.Name(), name),
+		TextEdits: edits,
+	}, true
+}
@@ -0,0 +1,332 @@
+// Package taint defines an Analyzer that tracks which values might be secret,
+// across function and package boundaries, for the other cryptocheck analyzers.
+//
+// Values are assigned a [Level] in a three-point lattice: Public, Unknown, and
+// Secret. Secret values derive from a //cryptovet:secret annotation, Public
+// values are known not to depend on secrets, and everything else is Unknown.
+// The other analyzers report operations on values that are not Public (or,
+// with -annotated-only, only those on Secret values).
+//
+// Annotations are comment directives:
+//
+//   - //cryptovet:secret on a type declaration makes all values of that type
+//     (and pointers, slices, and arrays of it) secret, e.g. scalars and field
+//     elements.
+//   - //cryptovet:secret on a struct field makes reads of that field secret.
+//   - //cryptovet:secret a, b on a function makes its a and b parameters (or
+//     receiver) secret, and without names makes its return values secret.
+//   - //cryptovet:public works the same way, but marks the values public.
+//     //cryptovet:return-value-is-not-secret is a synonym of //cryptovet:public
+//     on a function.
+//
+// The results of crypto/subtle.ConstantTimeCompare and crypto/hmac.Equal are
+// public, since they are meant to be branched on.
+//
+// Each function with a body exports a [Summary] fact that records whether its
+// results are secret, or which parameters they depend on, so that calls to it
+// from other packages are analyzed without its body. Annotated types and
+// fields export an [Annotation] fact.
+package taint
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+)
+
+const Doc = `track secret values across functions and packages for the cryptocheck analyzers
+
+The taint analyzer propagates //cryptovet:secret and //cryptovet:public
+annotations on types, struct fields, and function parameters and results,
+and exports a summary of each function. It only reports malformed
+annotations.`
+
+var Analyzer = &analysis.Analyzer{
+	Name:       "taint",
+	Doc:        Doc,
+	Requires:   []*analysis.Analyzer{inspect.Analyzer},
+	Run:        run,
+	ResultType: reflect.TypeFor[*Result](),
+	FactTypes:  []analysis.Fact{new(Summary), new(Annotation)},
+}
+
+var annotatedOnly bool
+
+func init() {
+	Analyzer.Flags.BoolVar(&annotatedOnly, "annotated-only", false,
+		"only report values derived from //cryptovet:secret annotations, treating unannotated values as public")
+}
+
+// Comment directives.
+const (
+	SecretPragma    = "//cryptovet:secret"
+	PublicPragma    = "//cryptovet:public"
+	NotSecretPragma = "//cryptovet:return-value-is-not-secret"
+)
+
+// A Level is a point in the taint lattice, ordered from Public to Secret.
+type Level int
+
+const (
+	Public Level = iota
+	Unknown
+	Secret
+)
+
+func (l Level) String() string {
+	switch l {
+	case Public:
+		return "public"
+	case Unknown:
+		return "unknown"
+	case Secret:
+		return "secret"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// A Summary is the taint of the results of a function.
+//
+// The results are at least as secret as Level, and as secret as the
+// arguments for each parameter in Params, a bitmask where bit 0 is the
+// receiver, if any, followed by the parameters. Variadic arguments all map to
+// the last parameter.
+type Summary struct {
+	Level  Level
+	Params uint64
+	// Path explains why Level is Secret.
+	Path []string
+}
+
+func (*Summary) AFact() {}
+
+func (s *Summary) String() string {
+	return fmt.Sprintf("summary(%v, params=%#x)", s.Level, s.Params)
+}
+
+// An Annotation is a //cryptovet:secret or //cryptovet:public annotation of a
+// type or of a struct field.
+type Annotation struct {
+	Level Level
+}
+
+func (*Annotation) AFact() {}
+
+func (a *Annotation) String() string {
+	return fmt.Sprintf("annotation(%v)", a.Level)
+}
+
+// Exempt reports whether n is exempt from the checks, because it is in a test
+// file or in a function or method with a VarTime name suffix.
+func Exempt(pass *analysis.Pass, n ast.Node, stack []ast.Node) bool {
+	filename := pass.Fset.Position(n.Pos()).Filename
+	if strings.HasSuffix(filename, "_test.go") {
+		return true
+	}
+	return InVarTimeFunc(stack)
+}
+
+// InVarTimeFunc reports whether the stack contains an enclosing function
+// or method whose name ends with "VarTime".
+func InVarTimeFunc(stack []ast.Node) bool {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if fn, ok := stack[i].(*ast.FuncDecl); ok {
+			return strings.HasSuffix(fn.Name.Name, "VarTime")
+		}
+	}
+	return false
+}
+
+// A Result is the taint of the values of a package.
+type Result struct {
+	t *tracker
+}
+
+// IsSafe reports whether expr is a safe (public) value that doesn't need
+// constant-time protection. With -annotated-only, only Secret values are
+// unsafe.
+func (r *Result) IsSafe(expr ast.Expr) bool {
+	switch r.Level(expr) {
+	case Public:
+		return true
+	case Unknown:
+		return annotatedOnly
+	}
+	return false
+}
+
+// Level returns the taint of expr.
+func (r *Result) Level(expr ast.Expr) Level {
+	return r.t.eval(expr).resolve()
+}
+
+// Explain returns a description of why the first Secret expression of exprs
+// is secret, like
+// " (secret: parameter k is annotated //cryptovet:secret -> passed through f)",
+// or an empty string if none are Secret. It is meant to be appended to
+// diagnostics.
+func (r *Result) Explain(exprs ...ast.Expr) string {
+	for _, expr := range exprs {
+		if v := r.t.eval(expr); v.level == Secret {
+			return " (secret: " + strings.Join(v.path, " -> ") + ")"
+		}
+	}
+	return ""
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+
+	t := newTracker(pass)
+	t.collectAnnotations(inspect)
+	t.collectSites(inspect)
+	t.solve()
+
+	for fn, s := range t.summaries {
+		pass.ExportObjectFact(fn, &Summary{Level: s.level, Params: s.params, Path: s.path})
+	}
+	for obj, level := range t.annotations {
+		if _, ok := obj.(*types.Func); ok {
+			continue
+		}
+		pass.ExportObjectFact(obj, &Annotation{Level: level})
+	}
+
+	return &Result{t: t}, nil
+}
+
+// parsePragma reports whether the comment is the given directive, and returns
+// its arguments, split on commas.
+func parsePragma(text, pragma string) (args []string, ok bool) {
+	rest, ok := strings.CutPrefix(text, pragma)
+	if !ok || (rest != "" && rest[0] != ' ' && rest[0] != '\t') {
+		return nil, false
+	}
+	for _, arg := range strings.Split(rest, ",") {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			args = append(args, arg)
+		}
+	}
+	return args, true
+}
+
+// commentLevels returns the annotations in the comment groups, as a level
+// for the annotated object and levels for named arguments.
+func commentLevels(groups ...*ast.CommentGroup) (level Level, named map[string]Level, ok bool) {
+	for _, g := range groups {
+		if g == nil {
+			continue
+		}
+		for _, c := range g.List {
+			for _, p := range []struct {
+				pragma string
+				level  Level
+			}{{SecretPragma, Secret}, {PublicPragma, Public}, {NotSecretPragma, Public}} {
+				args, found := parsePragma(c.Text, p.pragma)
+				if !found {
+					continue
+				}
+				if len(args) == 0 || p.pragma == NotSecretPragma {
+					level, ok = p.level, true
+					continue
+				}
+				if named == nil {
+					named = make(map[string]Level)
+				}
+				for _, a := range args {
+					named[a] = p.level
+				}
+			}
+		}
+	}
+	return level, named, ok
+}
+
+// collectAnnotations records the annotated types, fields, functions, and
+// parameters of the package.
+func (t *tracker) collectAnnotations(inspect *inspector.Inspector) {
+	pass := t.pass
+	nodeFilter := []ast.Node{(*ast.GenDecl)(nil), (*ast.FuncDecl)(nil), (*ast.StructType)(nil)}
+	inspect.Preorder(nodeFilter, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			if n.Tok != token.TYPE {
+				return
+			}
+			for _, spec := range n.Specs {
+				ts := spec.(*ast.TypeSpec)
+				groups := []*ast.CommentGroup{ts.Doc, ts.Comment}
+				if len(n.Specs) == 1 {
+					groups = append(groups, n.Doc)
+				}
+				if level, _, ok := commentLevels(groups...); ok {
+					if obj := pass.TypesInfo.Defs[ts.Name]; obj != nil {
+						t.annotations[obj] = level
+					}
+				}
+			}
+		case *ast.StructType:
+			for _, field := range n.Fields.List {
+				level, _, ok := commentLevels(field.Doc, field.Comment)
+				if !ok {
+					continue
+				}
+				for _, name := range field.Names {
+					if obj := pass.TypesInfo.Defs[name]; obj != nil {
+						t.annotations[obj] = level
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			fn, ok := pass.TypesInfo.Defs[n.Name].(*types.Func)
+			if !ok {
+				return
+			}
+			level, named, ok := commentLevels(n.Doc)
+			if ok {
+				t.annotations[fn] = level
+			}
+			for name, level := range named {
+				found := false
+				for _, param := range funcParams(n) {
+					if param != nil && param.Name == name {
+						if obj := pass.TypesInfo.Defs[param]; obj != nil {
+							t.annotations[obj] = level
+							found = true
+						}
+					}
+				}
+				if !found {
+					pass.Reportf(n.Name.Pos(), "cryptovet annotation names unknown parameter %q", name)
+				}
+			}
+		}
+	})
+}
+
+// funcParams returns the names of the receiver and parameters of fn, in
+// order. Unnamed parameters are returned as nil.
+func funcParams(fn *ast.FuncDecl) []*ast.Ident {
+	var params []*ast.Ident
+	var lists []*ast.FieldList
+	if fn.Recv != nil {
+		lists = append(lists, fn.Recv)
+	}
+	lists = append(lists, fn.Type.Params)
+	for _, list := range lists {
+		for _, field := range list.List {
+			if len(field.Names) == 0 {
+				params = append(params, nil)
+			}
+			params = append(params, field.Names...)
+		}
+	}
+	return params
+}
@@ -0,0 +1,67 @@
+package taint_test
+
+import (
+	"fmt"
+	"go/ast"
+	"testing"
+
+	"filippo.io/mostly-harmless/cryptocheck/passes/taint"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+// sinkAnalyzer reports the taint of the arguments of calls to sink that are
+// not safe.
+var sinkAnalyzer = &analysis.Analyzer{
+	Name:     "sink",
+	Doc:      "report the taint of sink arguments",
+	Requires: []*analysis.Analyzer{taint.Analyzer},
+	Run: func(pass *analysis.Pass) (any, error) {
+		res := pass.ResultOf[taint.Analyzer].(*taint.Result)
+		for _, f := range pass.Files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if id, ok := call.Fun.(*ast.Ident); !ok || id.Name != "sink" {
+					return true
+				}
+				if arg := call.Args[0]; !res.IsSafe(arg) {
+					pass.ReportRangef(arg, "%v%s", res.Level(arg), res.Explain(arg))
+				}
+				return true
+			})
+		}
+		return nil, nil
+	},
+}
+
+func Test(t *testing.T) {
+	testdata := analysistest.TestData()
+	analysistest.Run(t, testdata, sinkAnalyzer, "a")
+}
+
+func TestAnnotatedOnly(t *testing.T) {
+	if err := taint.Analyzer.Flags.Set("annotated-only", "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer taint.Analyzer.Flags.Set("annotated-only", "false")
+	testdata := analysistest.TestData()
+	analysistest.Run(t, testdata, sinkAnalyzer, "annotated")
+}
+
+func TestMalformedAnnotation(t *testing.T) {
+	testdata := analysistest.TestData()
+	analysistest.Run(t, testdata, taint.Analyzer, "bad")
+}
+
+func TestLevelString(t *testing.T) {
+	for level, want := range map[taint.Level]string{
+		taint.Public: "public", taint.Unknown: "unknown", taint.Secret: "secret", 7: "Level(7)",
+	} {
+		if got := fmt.Sprint(level); got != want {
+			t.Errorf("Level(%d).String() = %q, want %q", int(level), got, want)
+		}
+	}
+}
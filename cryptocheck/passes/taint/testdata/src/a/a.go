@@ -0,0 +1,114 @@
+// Package a is test input for the taint analyzer.
+package a
+
+import (
+	"b"
+	"crypto/hmac"
+	"crypto/subtle"
+)
+
+func sink(v any) {}
+
+func Calls(x []byte, public int) {
+	sink(b.Add(len(x), 1))
+	sink(b.Add(len(x), public)) // want `unknown`
+	sink(b.First(len(x), public))
+	sink(b.Random()) // want `secret \(secret: Random is annotated //cryptovet:secret -> returned by Random\)`
+	sink(b.Hash(x))
+	sink(b.Mul(1, 2)) // want `secret \(secret: parameter k is annotated //cryptovet:secret -> returned by Mul\)`
+	sink(b.Recursive(1, 2))
+	sink(b.Recursive(public, 2)) // want `unknown`
+	sink(b.Sum(1, 2, len(x)))
+	sink(b.Sum(1, 2, public)) // want `unknown`
+	sink(b.Table[0])          // want `unknown`
+}
+
+func Types(s *b.Scalar, k *b.Key) {
+	sink(b.Limb(s))      // want `secret \(secret: s has type Scalar, annotated //cryptovet:secret -> returned by Limb\)`
+	sink(*s)             // want `secret \(secret: \*s has type Scalar, annotated //cryptovet:secret\)`
+	sink(len(k.Private)) //
+	sink(k.Public[0])    // want `unknown`
+	sink(k.Private[0])   // want `secret \(secret: field k.Private is annotated //cryptovet:secret\)`
+	sink(k.Len())
+	sink(k.Byte(0)) // want `secret \(secret: field k.Private is annotated //cryptovet:secret -> returned by Byte\)`
+}
+
+//cryptovet:secret key
+func Local(key []byte, public int, err error) {
+	t := key[0]
+	u := int(t) + 1
+	sink(u) // want `secret \(secret: parameter key is annotated //cryptovet:secret -> assigned to t -> assigned to u\)`
+
+	var clean, buf [32]byte
+	sink(clean[0])
+	copy(buf[:], key)
+	sink(buf[1]) // want `secret \(secret: parameter key is annotated //cryptovet:secret -> assigned to buf\)`
+
+	n := 0
+	for i := 0; i < len(key); i++ {
+		n += i
+	}
+	sink(n)
+	for i := range key {
+		sink(i)
+	}
+
+	sink(public)                 // want `unknown`
+	sink(helper(public, 3))      // want `unknown`
+	sink(helper(3, public))      //
+	sink(helper(int(key[0]), 0)) // want `secret \(secret: parameter key is annotated //cryptovet:secret -> passed through helper\)`
+	sink(err)
+	sink(err != nil)
+	sink(key == nil)
+}
+
+func helper(x, y int) int {
+	_ = y
+	return x
+}
+
+//cryptovet:public p
+func PublicParam(p int) {
+	sink(p)
+}
+
+func fill(dst, src []byte) { copy(dst, src) }
+
+//cryptovet:secret key
+func SideEffects(key []byte) {
+	var out [4]byte
+	fill(out[:], key)
+	sink(out[0]) // want `secret \(secret: parameter key is annotated //cryptovet:secret -> assigned to out\)`
+}
+
+func Closure() {
+	f := func(y int) int { return y }
+	sink(f(1)) // want `unknown`
+}
+
+// Global is only assigned public values.
+var Global = len("abc")
+
+var tainted int
+
+//cryptovet:secret k
+func Globals(k int) {
+	sink(Global)
+	tainted = k
+	sink(tainted) // want `secret \(secret: parameter k is annotated //cryptovet:secret -> assigned to tainted\)`
+}
+
+//cryptovet:secret s
+func TypeSwitch(s any) {
+	switch v := s.(type) {
+	case int:
+		sink(v) // want `secret \(secret: parameter s is annotated //cryptovet:secret -> assigned to v\)`
+	}
+}
+
+//cryptovet:secret mac
+func Compare(mac, expected []byte) {
+	sink(subtle.ConstantTimeCompare(mac, expected))
+	sink(hmac.Equal(mac, expected))
+	sink(subtle.ConstantTimeByteEq(mac[0], expected[0])) // want `secret`
+}
@@ -0,0 +1,10 @@
+// Package annotated is test input for the taint analyzer with -annotated-only.
+package annotated
+
+func sink(v any) {}
+
+//cryptovet:secret k
+func F(k, x int) {
+	sink(x)
+	sink(x + k) // want `secret \(secret: parameter k is annotated //cryptovet:secret\)`
+}
@@ -0,0 +1,53 @@
+// Package b is a dependency of the test input for the taint analyzer.
+package b
+
+// Scalar is a secret scalar.
+//
+//cryptovet:secret
+type Scalar struct{ limbs [4]uint64 }
+
+type Key struct {
+	Public []byte
+	//cryptovet:secret
+	Private []byte
+}
+
+// Add depends on both arguments.
+func Add(x, y int) int { return x + y }
+
+// First depends only on its first argument.
+func First(x, y int) int {
+	_ = y
+	return x
+}
+
+//cryptovet:secret
+func Random() int { return 4 }
+
+//cryptovet:public
+func Hash(x []byte) int { return int(x[0]) }
+
+//cryptovet:secret k
+func Mul(k, x int) int { return k * x }
+
+func Limb(s *Scalar) uint64 { return s.limbs[0] }
+
+func Recursive(x, n int) int {
+	if n == 0 {
+		return x
+	}
+	return Recursive(x+1, n-1)
+}
+
+func (k *Key) Len() int { return len(k.Private) }
+
+func (k *Key) Byte(i int) byte { return k.Private[i] }
+
+func Sum(xs ...int) (sum int) {
+	for _, x := range xs {
+		sum += x
+	}
+	return
+}
+
+var Table = [4]byte{1, 2, 3, 4}
@@ -0,0 +1,8 @@
+// Package bad is test input for the taint analyzer with malformed annotations.
+package bad
+
+//cryptovet:secret k, z
+func F(k int) int { return k } // want `cryptovet annotation names unknown parameter "z"` F:`summary\(secret, params=0x0\)` k:`annotation\(secret\)`
+
+//cryptovet:secretive
+func G(k int) int { return k } // want G:`summary\(public, params=0x1\)`
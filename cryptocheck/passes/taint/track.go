@@ -0,0 +1,722 @@
+package taint
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// A value is the taint of an expression.
+//
+// params is a bitmask of the parameters of the enclosing function that the
+// value depends on. They are Unknown within the function, but are resolved at
+// each call site through the function's Summary.
+type value struct {
+	level  Level
+	params uint64
+	// path explains why level is Secret.
+	path []string
+}
+
+// resolve returns the level of v within its function.
+func (v value) resolve() Level {
+	if v.level == Public && v.params != 0 {
+		return Unknown
+	}
+	return v.level
+}
+
+func join(a, b value) value {
+	v := value{level: max(a.level, b.level), params: a.params | b.params}
+	switch {
+	case a.level == Secret:
+		v.path = a.path
+	case b.level == Secret:
+		v.path = b.path
+	}
+	return v
+}
+
+// covers reports whether v is at least as tainted as w.
+func (v value) covers(w value) bool {
+	return v.level >= w.level && v.params|w.params == v.params
+}
+
+// maxPath is the maximum number of steps of a path, to keep diagnostics short.
+const maxPath = 8
+
+// with returns v, extended with a step if it's Secret.
+func (v value) with(step string) value {
+	if v.level != Secret {
+		return v
+	}
+	path := append(v.path[:len(v.path):len(v.path)], step)
+	if len(path) > maxPath {
+		path = append(append(path[:2:2], "..."), path[len(path)-maxPath+3:]...)
+	}
+	v.path = path
+	return v
+}
+
+func secretValue(step string) value {
+	return value{level: Secret, path: []string{step}}
+}
+
+type tracker struct {
+	pass *analysis.Pass
+
+	// annotations are the //cryptovet:secret and //cryptovet:public
+	// annotations of the package's types, fields, functions, and parameters.
+	annotations map[types.Object]Level
+	// env is the taint of the variables of the package, joined across all
+	// their assignments.
+	env map[types.Object]value
+	// summaries are the summaries of the package's functions.
+	summaries map[*types.Func]value
+	// sites are the assignments, calls, and returns of the package, each
+	// propagating taint and reporting whether anything changed.
+	sites []func() bool
+}
+
+func newTracker(pass *analysis.Pass) *tracker {
+	return &tracker{
+		pass:        pass,
+		annotations: make(map[types.Object]Level),
+		env:         make(map[types.Object]value),
+		summaries:   make(map[*types.Func]value),
+	}
+}
+
+// solve propagates taint through the sites until a fixpoint. It terminates
+// because values only grow, and the lattice is finite.
+func (t *tracker) solve() {
+	for changed := true; changed; {
+		changed = false
+		for _, site := range t.sites {
+			if site() {
+				changed = true
+			}
+		}
+	}
+}
+
+// collectSites records the taint propagation sites of the package.
+func (t *tracker) collectSites(inspect *inspector.Inspector) {
+	info := t.pass.TypesInfo
+	nodeFilter := []ast.Node{
+		(*ast.FuncDecl)(nil),
+		(*ast.FuncLit)(nil),
+		(*ast.AssignStmt)(nil),
+		(*ast.ValueSpec)(nil),
+		(*ast.RangeStmt)(nil),
+		(*ast.TypeSwitchStmt)(nil),
+		(*ast.CallExpr)(nil),
+		(*ast.ReturnStmt)(nil),
+	}
+	inspect.WithStack(nodeFilter, func(n ast.Node, push bool, stack []ast.Node) bool {
+		if !push {
+			return true
+		}
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			fn, ok := info.Defs[n.Name].(*types.Func)
+			if !ok {
+				return true
+			}
+			for i, param := range funcParams(n) {
+				obj := info.Defs[param]
+				if obj == nil {
+					continue
+				}
+				if i < 64 {
+					t.env[obj] = value{params: 1 << i}
+				} else {
+					t.env[obj] = value{level: Unknown}
+				}
+			}
+			if level, ok := t.annotations[fn]; ok {
+				if level == Secret {
+					t.summaries[fn] = secretValue(fmt.Sprintf("%s is annotated %s", fn.Name(), SecretPragma))
+				} else {
+					t.summaries[fn] = value{}
+				}
+				return true
+			}
+			t.summaries[fn] = value{}
+			if n.Body == nil || n.Type.Results != nil && !hasReturn(n.Body) {
+				// The results of functions without a body, like assembly
+				// functions, or that never return, like compiler intrinsics
+				// with a panicking body, depend on all their parameters.
+				for i := range min(len(funcParams(n)), 64) {
+					t.summaries[fn] = value{params: t.summaries[fn].params | 1<<i}
+				}
+				return true
+			}
+			// Named results are returned by bare returns, or might be
+			// modified by deferred functions.
+			if n.Type.Results != nil {
+				var results []types.Object
+				for _, field := range n.Type.Results.List {
+					for _, name := range field.Names {
+						if obj := info.Defs[name]; obj != nil {
+							results = append(results, obj)
+						}
+					}
+				}
+				if len(results) > 0 {
+					t.sites = append(t.sites, func() bool {
+						var v value
+						for _, obj := range results {
+							v = join(v, t.env[obj])
+						}
+						return t.updateSummary(fn, v)
+					})
+				}
+			}
+		case *ast.FuncLit:
+			// The arguments of function literals are not tracked.
+			for _, field := range n.Type.Params.List {
+				for _, name := range field.Names {
+					if obj := info.Defs[name]; obj != nil {
+						t.env[obj] = value{level: Unknown}
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			lhs, rhs := n.Lhs, n.Rhs
+			t.sites = append(t.sites, func() bool {
+				changed := false
+				for i, l := range lhs {
+					var v value
+					if len(rhs) == len(lhs) {
+						v = t.eval(rhs[i])
+					} else {
+						v = t.eval(rhs[0])
+					}
+					if t.assign(l, v) {
+						changed = true
+					}
+				}
+				return changed
+			})
+		case *ast.ValueSpec:
+			if len(n.Values) == 0 {
+				return true
+			}
+			names, values := n.Names, n.Values
+			t.sites = append(t.sites, func() bool {
+				changed := false
+				for i, name := range names {
+					var v value
+					if len(values) == len(names) {
+						v = t.eval(values[i])
+					} else {
+						v = t.eval(values[0])
+					}
+					if obj := info.Defs[name]; obj != nil && t.set(obj, v) {
+						changed = true
+					}
+				}
+				return changed
+			})
+		case *ast.RangeStmt:
+			t.sites = append(t.sites, func() bool {
+				key, val := t.rangeValues(n)
+				changed := false
+				if n.Key != nil && t.assign(n.Key, key) {
+					changed = true
+				}
+				if n.Value != nil && t.assign(n.Value, val) {
+					changed = true
+				}
+				return changed
+			})
+		case *ast.TypeSwitchStmt:
+			var x ast.Expr
+			switch a := n.Assign.(type) {
+			case *ast.AssignStmt:
+				x = a.Rhs[0].(*ast.TypeAssertExpr).X
+			case *ast.ExprStmt:
+				x = a.X.(*ast.TypeAssertExpr).X
+			}
+			for _, stmt := range n.Body.List {
+				obj := info.Implicits[stmt]
+				if obj == nil {
+					continue
+				}
+				t.sites = append(t.sites, func() bool { return t.set(obj, t.eval(x)) })
+			}
+		case *ast.CallExpr:
+			t.sites = append(t.sites, func() bool { return t.callSideEffects(n) })
+		case *ast.ReturnStmt:
+			fn := enclosingFunc(info, stack)
+			if fn == nil || len(n.Results) == 0 {
+				return true
+			}
+			if _, ok := t.annotations[fn]; ok {
+				return true
+			}
+			results := n.Results
+			t.sites = append(t.sites, func() bool {
+				var v value
+				for _, r := range results {
+					v = join(v, t.eval(r))
+				}
+				return t.updateSummary(fn, v)
+			})
+		}
+		return true
+	})
+}
+
+// hasReturn reports whether body contains a return statement, outside of
+// function literals.
+func hasReturn(body *ast.BlockStmt) bool {
+	found := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.ReturnStmt:
+			found = true
+		case *ast.FuncLit:
+			return false
+		}
+		return !found
+	})
+	return found
+}
+
+// enclosingFunc returns the function declared by the innermost enclosing
+// FuncDecl, or nil if the innermost function is a FuncLit.
+func enclosingFunc(info *types.Info, stack []ast.Node) *types.Func {
+	for i := len(stack) - 1; i >= 0; i-- {
+		switch n := stack[i].(type) {
+		case *ast.FuncLit:
+			return nil
+		case *ast.FuncDecl:
+			fn, _ := info.Defs[n.Name].(*types.Func)
+			return fn
+		}
+	}
+	return nil
+}
+
+func (t *tracker) updateSummary(fn *types.Func, v value) bool {
+	old := t.summaries[fn]
+	if old.covers(v) {
+		return false
+	}
+	t.summaries[fn] = join(old, v)
+	return true
+}
+
+// set joins v into the taint of the variable obj.
+func (t *tracker) set(obj types.Object, v value) bool {
+	if _, ok := obj.(*types.Var); !ok || obj.Pkg() != t.pass.Pkg {
+		return false
+	}
+	if obj.Parent() == t.pass.Pkg.Scope() && v.params != 0 {
+		// Parameters can't be resolved through package-level variables.
+		v = join(v, value{level: Unknown})
+		v.params = 0
+	}
+	old := t.env[obj]
+	if old.covers(v) {
+		return false
+	}
+	t.env[obj] = join(old, v.with("assigned to "+obj.Name()))
+	return true
+}
+
+// assign joins v into the variable at the root of lhs, like x in x.f[i].
+// Indexes are joined too, since writing at a secret index taints the whole
+// variable.
+func (t *tracker) assign(lhs ast.Expr, v value) bool {
+	for {
+		switch e := lhs.(type) {
+		case *ast.Ident:
+			obj := t.pass.TypesInfo.Defs[e]
+			if obj == nil {
+				obj = t.pass.TypesInfo.Uses[e]
+			}
+			if obj == nil {
+				return false
+			}
+			return t.set(obj, v)
+		case *ast.ParenExpr:
+			lhs = e.X
+		case *ast.StarExpr:
+			lhs = e.X
+		case *ast.UnaryExpr:
+			lhs = e.X
+		case *ast.SelectorExpr:
+			if t.pass.TypesInfo.Selections[e] == nil {
+				// A qualified identifier, a variable of another package.
+				return false
+			}
+			lhs = e.X
+		case *ast.IndexExpr:
+			v = join(v, t.eval(e.Index))
+			lhs = e.X
+		case *ast.SliceExpr:
+			lhs = e.X
+		default:
+			return false
+		}
+	}
+}
+
+// callSideEffects approximates the effects of a call on its arguments: the
+// callee might write any argument into any argument of reference type, or
+// into the receiver. copy only writes its second argument into its first.
+func (t *tracker) callSideEffects(call *ast.CallExpr) bool {
+	info := t.pass.TypesInfo
+	if tv := info.Types[call.Fun]; tv.IsType() {
+		return false
+	}
+	var targets []ast.Expr
+	var v value
+	if id, ok := ast.Unparen(call.Fun).(*ast.Ident); ok {
+		if b, ok := info.Uses[id].(*types.Builtin); ok {
+			if b.Name() != "copy" || len(call.Args) != 2 {
+				return false
+			}
+			return t.assign(call.Args[0], t.eval(call.Args[1]))
+		}
+	}
+	args := call.Args
+	if sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr); ok {
+		if s := info.Selections[sel]; s != nil && s.Kind() == types.MethodVal {
+			args = append([]ast.Expr{sel.X}, args...)
+			if isReference(info.TypeOf(sel.X)) || isPointerMethod(s) {
+				targets = append(targets, sel.X)
+			}
+		}
+	}
+	for _, arg := range args {
+		v = join(v, t.eval(arg))
+	}
+	if v.resolve() == Public {
+		return false
+	}
+	for _, arg := range call.Args {
+		if isReference(info.TypeOf(arg)) {
+			targets = append(targets, arg)
+		}
+	}
+	changed := false
+	for _, target := range targets {
+		if t.assign(target, v) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func isPointerMethod(s *types.Selection) bool {
+	sig := s.Obj().Type().(*types.Signature)
+	if sig.Recv() == nil {
+		return false
+	}
+	_, ok := sig.Recv().Type().Underlying().(*types.Pointer)
+	return ok
+}
+
+// isReference reports whether values of type typ can be used to modify other
+// values.
+func isReference(typ types.Type) bool {
+	if typ == nil {
+		return false
+	}
+	switch typ.Underlying().(type) {
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Interface:
+		return true
+	}
+	return false
+}
+
+// rangeValues returns the taint of the key and value of a range statement.
+// Slice, array, and string indexes are public, since they are leaked by the
+// loop progression.
+func (t *tracker) rangeValues(n *ast.RangeStmt) (key, val value) {
+	x := t.eval(n.X)
+	typ := t.pass.TypesInfo.TypeOf(n.X)
+	if typ == nil {
+		return value{level: Unknown}, value{level: Unknown}
+	}
+	u := typ.Underlying()
+	if p, ok := u.(*types.Pointer); ok {
+		u = p.Elem().Underlying()
+	}
+	switch u := u.(type) {
+	case *types.Slice, *types.Array:
+		return value{}, x
+	case *types.Basic:
+		if u.Info()&types.IsString != 0 {
+			return value{}, x
+		}
+		return x, value{}
+	case *types.Map, *types.Chan:
+		return x, x
+	}
+	// Range-over-func iterators.
+	unknown := join(x, value{level: Unknown})
+	return unknown, unknown
+}
+
+// eval returns the taint of expr.
+func (t *tracker) eval(expr ast.Expr) value {
+	tv := t.pass.TypesInfo.Types[expr]
+	if tv.Value != nil || tv.IsNil() || tv.IsType() {
+		return value{}
+	}
+	if tv.Type != nil {
+		// Values of annotated types have the type's level, and errors are public.
+		if level, name, ok := t.typeLevel(tv.Type); ok {
+			if level == Secret {
+				return secretValue(fmt.Sprintf("%s has type %s, annotated %s", types.ExprString(expr), name, SecretPragma))
+			}
+			return value{}
+		}
+		if types.Identical(tv.Type, types.Universe.Lookup("error").Type()) {
+			return value{}
+		}
+	}
+
+	switch e := expr.(type) {
+	case *ast.Ident:
+		obj := t.pass.TypesInfo.Uses[e]
+		if obj == nil {
+			obj = t.pass.TypesInfo.Defs[e]
+		}
+		if v, ok := obj.(*types.Var); ok {
+			return t.varValue(v)
+		}
+		return value{}
+	case *ast.BasicLit, *ast.FuncLit:
+		return value{}
+	case *ast.ParenExpr:
+		return t.eval(e.X)
+	case *ast.StarExpr:
+		return t.eval(e.X)
+	case *ast.UnaryExpr:
+		return t.eval(e.X)
+	case *ast.BinaryExpr:
+		if (e.Op == token.EQL || e.Op == token.NEQ) &&
+			(t.pass.TypesInfo.Types[e.X].IsNil() || t.pass.TypesInfo.Types[e.Y].IsNil()) {
+			// The nilness of pointers, slices, maps, and interfaces is public.
+			return value{}
+		}
+		return join(t.eval(e.X), t.eval(e.Y))
+	case *ast.SelectorExpr:
+		sel := t.pass.TypesInfo.Selections[e]
+		if sel == nil {
+			// A qualified identifier.
+			return t.eval(e.Sel)
+		}
+		switch sel.Kind() {
+		case types.FieldVal:
+			if level, ok := t.annotation(sel.Obj()); ok {
+				if level == Secret {
+					return secretValue(fmt.Sprintf("field %s is annotated %s", types.ExprString(e), SecretPragma))
+				}
+				return value{}
+			}
+			return t.eval(e.X)
+		case types.MethodVal:
+			return t.eval(e.X)
+		}
+		return value{}
+	case *ast.IndexExpr:
+		if tv := t.pass.TypesInfo.Types[e.X]; !tv.IsValue() {
+			return value{}
+		}
+		if _, ok := t.pass.TypesInfo.TypeOf(e.X).Underlying().(*types.Signature); ok {
+			// An instantiated generic function.
+			return value{}
+		}
+		return join(t.eval(e.X), t.eval(e.Index))
+	case *ast.IndexListExpr:
+		return value{}
+	case *ast.SliceExpr:
+		v := t.eval(e.X)
+		for _, index := range []ast.Expr{e.Low, e.High, e.Max} {
+			if index != nil {
+				v = join(v, t.eval(index))
+			}
+		}
+		return v
+	case *ast.TypeAssertExpr:
+		return t.eval(e.X)
+	case *ast.CompositeLit:
+		var v value
+		for _, elt := range e.Elts {
+			v = join(v, t.eval(elt))
+		}
+		return v
+	case *ast.KeyValueExpr:
+		if _, ok := e.Key.(*ast.Ident); ok {
+			if _, ok := t.pass.TypesInfo.Uses[e.Key.(*ast.Ident)].(*types.Var); ok {
+				// A struct field name.
+				return t.eval(e.Value)
+			}
+		}
+		return join(t.eval(e.Key), t.eval(e.Value))
+	case *ast.CallExpr:
+		return t.evalCall(e)
+	}
+	return value{level: Unknown}
+}
+
+// varValue returns the taint of the variable obj.
+func (t *tracker) varValue(obj *types.Var) value {
+	if level, ok := t.annotations[obj]; ok {
+		if level == Secret {
+			return secretValue(fmt.Sprintf("parameter %s is annotated %s", obj.Name(), SecretPragma))
+		}
+		return value{}
+	}
+	if obj.Pkg() != t.pass.Pkg {
+		// A variable of another package.
+		return value{level: Unknown}
+	}
+	// Variables that are never assigned are zero, so public.
+	return t.env[obj]
+}
+
+// publicResults are functions whose results are public regardless of their
+// arguments, because the outcome of a constant-time comparison is meant to be
+// revealed.
+var publicResults = map[string]bool{
+	"crypto/subtle.ConstantTimeCompare": true,
+	"crypto/hmac.Equal":                 true,
+}
+
+func (t *tracker) evalCall(call *ast.CallExpr) value {
+	info := t.pass.TypesInfo
+	if info.Types[call.Fun].IsType() {
+		// A conversion.
+		return t.eval(call.Args[0])
+	}
+
+	var args value
+	for _, arg := range call.Args {
+		args = join(args, t.eval(arg))
+	}
+
+	if id, ok := ast.Unparen(call.Fun).(*ast.Ident); ok {
+		if b, ok := info.Uses[id].(*types.Builtin); ok {
+			switch b.Name() {
+			case "len", "cap", "copy", "make", "new":
+				// Lengths leak via cache side-channels anyway.
+				return value{}
+			case "recover":
+				return value{level: Unknown}
+			}
+			return args
+		}
+	}
+
+	fn := typeutil.StaticCallee(info, call)
+	if fn == nil {
+		// A dynamic call, of a function value or an interface method.
+		return join(join(args, t.eval(call.Fun)), value{level: Unknown})
+	}
+	fn = fn.Origin()
+	if publicResults[fn.FullName()] {
+		return value{}
+	}
+
+	// Build the arguments, starting with the receiver.
+	argExprs := call.Args
+	if sel, ok := ast.Unparen(call.Fun).(*ast.SelectorExpr); ok {
+		if s := info.Selections[sel]; s != nil && s.Kind() == types.MethodVal {
+			argExprs = append([]ast.Expr{sel.X}, argExprs...)
+		}
+	}
+
+	s, ok := t.summary(fn)
+	if !ok {
+		// A function without a summary, from a package that was not analyzed.
+		var v value
+		for _, arg := range argExprs {
+			v = join(v, t.eval(arg))
+		}
+		return v.with("passed through " + fn.Name())
+	}
+	v := value{level: s.level, path: s.path}.with("returned by " + fn.Name())
+	if s.params == 0 {
+		return v
+	}
+	sig := fn.Signature()
+	nparams := sig.Params().Len()
+	if sig.Recv() != nil {
+		nparams++
+	}
+	if len(call.Args) == 1 && sig.Params().Len() > 1 {
+		// f(g()), with g returning multiple values.
+		return join(v, args.with("passed through "+fn.Name()))
+	}
+	for i, arg := range argExprs {
+		param := min(i, nparams-1)
+		if param < 64 && s.params&(1<<param) != 0 {
+			v = join(v, t.eval(arg).with("passed through "+fn.Name()))
+		}
+	}
+	if nparams > 64 {
+		v = join(v, value{level: Unknown})
+	}
+	return v
+}
+
+// summary returns the summary of fn, from this package or from a fact.
+func (t *tracker) summary(fn *types.Func) (value, bool) {
+	if fn.Pkg() == t.pass.Pkg {
+		v, ok := t.summaries[fn]
+		return v, ok
+	}
+	var s Summary
+	if !t.pass.ImportObjectFact(fn, &s) {
+		return value{}, false
+	}
+	return value{level: s.Level, params: s.Params, path: s.Path}, true
+}
+
+// annotation returns the annotation of a type or field, from this package or
+// from a fact.
+func (t *tracker) annotation(obj types.Object) (Level, bool) {
+	if obj.Pkg() == t.pass.Pkg {
+		level, ok := t.annotations[obj]
+		return level, ok
+	}
+	var a Annotation
+	if obj.Pkg() == nil || !t.pass.ImportObjectFact(obj, &a) {
+		return 0, false
+	}
+	return a.Level, true
+}
+
+// typeLevel returns the annotation of typ, or of the element type of a
+// pointer, slice, or array type, and the name of the annotated type.
+func (t *tracker) typeLevel(typ types.Type) (Level, string, bool) {
+	for {
+		switch u := types.Unalias(typ).(type) {
+		case *types.Pointer:
+			typ = u.Elem()
+		case *types.Slice:
+			typ = u.Elem()
+		case *types.Array:
+			typ = u.Elem()
+		case *types.Named:
+			obj := u.Origin().Obj()
+			level, ok := t.annotation(obj)
+			return level, obj.Name(), ok
+		default:
+			return 0, "", false
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -27,11 +28,63 @@ type GerritChange struct {
 		} `json:"fetch"`
 	} `json:"revisions"`
 	CurrentRevision string `json:"current_revision"`
+
+	// The following are only set by QueryChanges.
+	ChangeID               string                  `json:"change_id"`
+	Status                 string                  `json:"status"` // NEW, MERGED, or ABANDONED
+	Subject                string                  `json:"subject"`
+	Submittable            bool                    `json:"submittable"`
+	UnresolvedCommentCount int                     `json:"unresolved_comment_count"`
+	Labels                 map[string]*GerritLabel `json:"labels"`
+}
+
+type GerritLabel struct {
+	All []struct {
+		Value int    `json:"value"`
+		Name  string `json:"name"`
+	} `json:"all"`
+}
+
+// Vote returns the summary vote of the label: the most negative vote if
+// there are any, or else the most positive one.
+func (l *GerritLabel) Vote() int {
+	lo, hi := 0, 0
+	for _, a := range l.All {
+		lo = min(lo, a.Value)
+		hi = max(hi, a.Value)
+	}
+	if lo < 0 {
+		return lo
+	}
+	return hi
 }
 
 func GetChange(q string) (*GerritChange, error) {
-	url := fmt.Sprintf("https://go-review.googlesource.com/changes/?q=%s&n=1&o=ALL_REVISIONS", q)
-	resp, err := client.Get(url)
+	changes, err := queryChanges(q, 1, "ALL_REVISIONS")
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("change not found")
+	}
+	return changes[0], nil
+}
+
+// QueryChanges returns all the changes matching the query, with their
+// revisions, votes, and submittability.
+func QueryChanges(q string) ([]*GerritChange, error) {
+	return queryChanges(q, 0, "ALL_REVISIONS", "DETAILED_LABELS", "SUBMITTABLE")
+}
+
+func queryChanges(q string, n int, options ...string) ([]*GerritChange, error) {
+	u := gerritURL() + "/changes/?q=" + url.QueryEscape(q)
+	if n > 0 {
+		u += fmt.Sprintf("&n=%d", n)
+	}
+	for _, o := range options {
+		u += "&o=" + o
+	}
+	resp, err := client.Get(u)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch change: %v", err)
 	}
@@ -48,8 +101,5 @@ func GetChange(q string) (*GerritChange, error) {
 	if err := json.NewDecoder(reader).Decode(&changes); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
-	if len(changes) == 0 {
-		return nil, fmt.Errorf("change not found")
-	}
-	return changes[0], nil
+	return changes, nil
 }
@@ -15,7 +15,7 @@ func cmdCleanup(args []string) {
 		fmt.Fprintf(stderr(), trim(`
 Usage: %s cleanup %s
 
-Abandons mutable changes that were merged in a origin branch.
+Abandons mutable changes that were merged in a branch of the push remote.
 `), progName, globalFlags)
 		exit(2)
 	}
@@ -25,10 +25,29 @@ Abandons mutable changes that were merged in a origin branch.
 	defer os.Remove(config)
 	jjLog := jjLog(config)
 
+	mergedByChangeID := mergedChanges()
+	for _, rev := range jjLog("-T", "commit_id ++ '\n'", "-r", "mutable()") {
+		changeID := commitChangeID(rev)
+		if changeID == "" {
+			continue
+		}
+		mergedRev, ok := mergedByChangeID[changeID]
+		if !ok {
+			continue
+		}
+		restackChildren(jjLog, rev, mergedRev)
+		run("jj", "abandon", "-r", rev)
+		printf("merged as %s", mergedRev)
+	}
+}
+
+// mergedChanges returns the commits recently merged in a branch of the push
+// remote, by Change-Id.
+func mergedChanges() map[string]string {
 	mergedByChangeID := map[string]string{}
 	for _, line := range nonBlankLines(cmdOutput("git", "log",
 		"--format=%H %(trailers:key=Change-Id,valueonly)",
-		"--since=45 days ago", "--remotes=origin")) {
+		"--since=45 days ago", "--remotes="+pushRemote())) {
 		commit, changeID, ok := strings.Cut(line, " ")
 		if !ok || changeID == "" {
 			continue
@@ -37,20 +56,17 @@ Abandons mutable changes that were merged in a origin branch.
 			mergedByChangeID[changeID] = commit
 		}
 	}
+	return mergedByChangeID
+}
 
-	for _, rev := range jjLog("-T", "commit_id ++ '\n'", "-r", "mutable()") {
-		changeID := trim(cmdOutput("git", "show", "-s", `--format=%(trailers:key=Change-Id,valueonly)`, rev))
-		if changeID == "" {
-			continue
-		}
-		mergedRev, ok := mergedByChangeID[changeID]
-		if !ok {
-			continue
-		}
-		for _, child := range jjLog("-T", "commit_id ++ '\n'", "-r", "children("+rev+")") {
-			run("jj", "rebase", "-s", child, "-d", mergedRev)
-		}
-		run("jj", "abandon", "-r", rev)
-		printf("merged as %s", mergedRev)
+// commitChangeID returns the Change-Id trailer of a commit, or "".
+func commitChangeID(commit string) string {
+	return trim(cmdOutput("git", "show", "-s", `--format=%(trailers:key=Change-Id,valueonly)`, commit))
+}
+
+// restackChildren rebases the children of rev onto mergedRev.
+func restackChildren(jjLog func(args ...string) []string, rev, mergedRev string) {
+	for _, child := range jjLog("-T", "commit_id ++ '\n'", "-r", "children("+rev+")") {
+		run("jj", "rebase", "-s", child, "-d", mergedRev)
 	}
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanup(t *testing.T) {
+	gt := newJJTest(t)
+	const changeID = "I0123456789abcdef0123456789abcdef01234567"
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "package hello\n")
+	gt.jj("describe", "-m", "hello: add package\n\nChange-Id: "+changeID+"\n")
+	gt.jj("new")
+	writeFile(t, filepath.Join(gt.repo, "hello_test.go"), "package hello\n")
+	gt.jj("describe", "-m", "hello: add tests")
+	gt.jj("new")
+	mailed := gt.jj("log", "--no-graph", "-r", "@--", "-T", "commit_id")
+	testMain(t, "mail", "@--")
+
+	// Submit the CL like Gerrit would, as a new commit on master.
+	other := filepath.Join(gt.root, "submit")
+	gt.gitDir(gt.root, "clone", "-q", gt.origin, other)
+	gt.gitDir(other, "cherry-pick", mailed)
+	gt.gitDir(other, "commit", "-q", "--amend", "--no-edit", "--reset-author")
+	gt.gitDir(other, "push", "-q", "origin", "HEAD:master")
+	merged := gt.gitDir(other, "rev-parse", "HEAD")
+	gt.jj("git", "fetch")
+
+	_, stderr := testMain(t, "cleanup")
+	if !strings.Contains(stderr, "merged as "+merged) {
+		t.Errorf("unexpected output:\n%s", stderr)
+	}
+	mutable := gt.jj("log", "--no-graph", "-r", "mutable()", "-T", `description.first_line() ++ "\n"`)
+	if strings.Contains(mutable, "hello: add package") {
+		t.Errorf("merged change was not abandoned:\n%s", mutable)
+	}
+	if got := gt.jj("log", "--no-graph", "-r", "description(substring:'hello: add tests')-", "-T", "commit_id"); got != merged {
+		t.Errorf("child was not rebased on the merged commit, parent is %s", got)
+	}
+}
+
+func TestMergedChangesRemote(t *testing.T) {
+	gt := newGitTest(t)
+	gt.setRemote("upstream")
+	const changeID = "I0123456789abcdef0123456789abcdef01234567"
+
+	other := filepath.Join(gt.root, "submit")
+	gt.gitDir(gt.root, "clone", "-q", gt.origin, other)
+	writeFile(t, filepath.Join(other, "hello.go"), "package hello\n")
+	gt.gitDir(other, "add", ".")
+	gt.gitDir(other, "commit", "-q", "-m", "hello: add package\n\nChange-Id: "+changeID)
+	gt.gitDir(other, "push", "-q", "origin", "HEAD:master")
+	merged := gt.gitDir(other, "rev-parse", "HEAD")
+	gt.git("fetch", "-q", "upstream")
+
+	cachedConfig = nil
+	got := mergedChanges()
+	if got[changeID] != merged {
+		t.Errorf("mergedChanges()[%s] = %q, want %s", changeID, got[changeID], merged)
+	}
+	if len(got) != 1 {
+		t.Errorf("mergedChanges() = %v, want only the changes merged in upstream", got)
+	}
+}
+
+func TestCleanupRemote(t *testing.T) {
+	gt := newJJTestRemote(t, "upstream")
+	const changeID = "I0123456789abcdef0123456789abcdef01234567"
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "package hello\n")
+	gt.jj("describe", "-m", "hello: add package\n\nChange-Id: "+changeID+"\n")
+	gt.jj("new")
+	mailed := gt.jj("log", "--no-graph", "-r", "@-", "-T", "commit_id")
+	testMain(t, "mail")
+
+	other := filepath.Join(gt.root, "submit")
+	gt.gitDir(gt.root, "clone", "-q", gt.origin, other)
+	gt.gitDir(other, "cherry-pick", mailed)
+	gt.gitDir(other, "commit", "-q", "--amend", "--no-edit", "--reset-author")
+	gt.gitDir(other, "push", "-q", "origin", "HEAD:master")
+	merged := gt.gitDir(other, "rev-parse", "HEAD")
+	gt.jj("git", "fetch", "--remote", "upstream")
+
+	_, stderr := testMain(t, "cleanup")
+	if !strings.Contains(stderr, "merged as "+merged) {
+		t.Errorf("unexpected output:\n%s", stderr)
+	}
+}
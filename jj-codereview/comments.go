@@ -0,0 +1,313 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func cmdComments(args []string) {
+	jsonOut := flags.Bool("json", false, "print threads as JSON")
+	all := flags.Bool("a", false, "include resolved threads")
+	rev := flags.String("r", "@", "map comments onto `revision`")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), trim(`
+Usage: %s comments %s [-a] [-json] [-r revision] [query]
+
+Prints the unresolved comment threads of the CL matching the query, or if no
+query is specified, of the closest ancestor of the revision with a Change-Id.
+
+Each thread is printed as "file:line: [id] author: message", where file and
+line are mapped from the patchset the comment was left on to the revision
+(by default the working copy), following renames and edits. Threads whose
+line was modified since are marked as outdated.
+
+With -json, the threads are printed as a JSON array. The id of each thread is
+the one to pass to the reply command to answer it.
+`), progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		flags.Usage()
+	}
+
+	config := jjConfig()
+	defer os.Remove(config)
+	jjLog := jjLog(config)
+
+	target := jjLog("-T", "commit_id ++ '\n'", "-r", *rev)
+	if len(target) != 1 {
+		dief("revision %s must resolve to a single commit", *rev)
+	}
+
+	query := flags.Arg(0)
+	if query == "" {
+		for _, commit := range jjLog("-T", "commit_id ++ '\n'", "-r", "::("+*rev+") ~ ::jjcrremote()") {
+			query = commitChangeID(commit)
+			if query != "" {
+				break
+			}
+		}
+		if query == "" {
+			dief("no Change-Id found in %s or its mutable ancestors; specify a query", *rev)
+		}
+	}
+
+	c, err := GetChange(query)
+	if err != nil {
+		dief("failed to fetch change: %v", err)
+	}
+	comments, err := GetComments(c.Number)
+	if err != nil {
+		dief("failed to fetch comments: %v", err)
+	}
+
+	commitByPatchSet := map[int]string{}
+	for commit, r := range c.Revisions {
+		commitByPatchSet[r.Number] = commit
+	}
+	diffs := map[string]map[string]*fileDiff{}
+	var threads []*commentThread
+	for _, t := range commentThreads(comments) {
+		if !t.Unresolved && !*all {
+			continue
+		}
+		threads = append(threads, t)
+		root := t.Comments[0]
+		if strings.HasPrefix(root.Path, "/") {
+			// Special files like /COMMIT_MSG and /PATCHSET_LEVEL.
+			continue
+		}
+		commit, ok := commitByPatchSet[root.PatchSet]
+		if !ok {
+			verbosef("patchset %d of CL %d not found", root.PatchSet, c.Number)
+			t.Outdated = true
+			continue
+		}
+		if _, err := cmdOutputErr("git", "cat-file", "-e", commit+"^{commit}"); err != nil {
+			fetch := c.Revisions[commit].Fetch.HTTP
+			run("git", "fetch", "-q", fetch.URL, fetch.Ref)
+		}
+		if root.Side == "PARENT" {
+			commit += "^"
+		}
+		d, ok := diffs[commit]
+		if !ok {
+			d = diffCommits(commit, target[0])
+			diffs[commit] = d
+		}
+		t.Path, t.Line, t.Outdated = mapPosition(d, root.Path, root.Line)
+	}
+	sort.SliceStable(threads, func(i, j int) bool {
+		if threads[i].Path != threads[j].Path {
+			return threads[i].Path < threads[j].Path
+		}
+		return threads[i].Line < threads[j].Line
+	})
+
+	if *jsonOut {
+		if threads == nil {
+			threads = []*commentThread{}
+		}
+		enc := json.NewEncoder(stdout())
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(threads); err != nil {
+			dief("%v", err)
+		}
+		return
+	}
+	for _, t := range threads {
+		pos := t.Path
+		if t.Line > 0 {
+			pos += ":" + strconv.Itoa(t.Line)
+		}
+		status := t.ID
+		if t.Outdated {
+			status += ", outdated"
+		}
+		if !t.Unresolved {
+			status += ", resolved"
+		}
+		for i, cc := range t.Comments {
+			msg := strings.ReplaceAll(trim(cc.Message), "\n", "\n\t\t")
+			if i == 0 {
+				fmt.Fprintf(stdout(), "%s: [%s] %s: %s\n", pos, status, cc.Author.Name, msg)
+			} else {
+				fmt.Fprintf(stdout(), "\t%s: %s\n", cc.Author.Name, msg)
+			}
+		}
+	}
+}
+
+// commentThread is a comment and all the replies to it, in order.
+type commentThread struct {
+	// ID is the ID of the last comment, which replies should be attached to.
+	ID string `json:"id"`
+
+	// Path and Line are the position of the thread in the target revision.
+	// If Outdated is true, the commented line was changed or removed, or the
+	// file was deleted, and the position is approximate.
+	Path     string `json:"path"`
+	Line     int    `json:"line,omitempty"`
+	Outdated bool   `json:"outdated"`
+
+	Unresolved bool             `json:"unresolved"`
+	Comments   []*GerritComment `json:"comments"`
+}
+
+// commentThreads groups comments into threads, following in_reply_to links.
+func commentThreads(comments map[string]*GerritComment) []*commentThread {
+	byRoot := map[string]*commentThread{}
+	var threads []*commentThread
+	for _, c := range comments {
+		root := c
+		for i := 0; root.InReplyTo != "" && i < len(comments); i++ {
+			parent, ok := comments[root.InReplyTo]
+			if !ok {
+				break
+			}
+			root = parent
+		}
+		t, ok := byRoot[root.ID]
+		if !ok {
+			t = &commentThread{Path: root.Path, Line: root.Line}
+			byRoot[root.ID] = t
+			threads = append(threads, t)
+		}
+		t.Comments = append(t.Comments, c)
+	}
+	for _, t := range threads {
+		sort.Slice(t.Comments, func(i, j int) bool {
+			a, b := t.Comments[i], t.Comments[j]
+			if a.Updated != b.Updated {
+				return a.Updated < b.Updated
+			}
+			return a.ID < b.ID
+		})
+		last := t.Comments[len(t.Comments)-1]
+		t.ID = last.ID
+		t.Unresolved = last.Unresolved
+	}
+	sort.Slice(threads, func(i, j int) bool {
+		return threads[i].Comments[0].Updated < threads[j].Comments[0].Updated
+	})
+	return threads
+}
+
+// fileDiff is the diff of a single file between two commits.
+type fileDiff struct {
+	oldPath, newPath string // newPath is empty if the file was deleted
+	hunks            []diffHunk
+}
+
+type diffHunk struct {
+	oldStart, oldLines int
+	newStart, newLines int
+}
+
+// diffCommits returns the differences between two commits, keyed by the
+// path in the old commit. Files that are unchanged are not included.
+func diffCommits(from, to string) map[string]*fileDiff {
+	out := cmdOutput("git", "-c", "core.quotePath=false", "diff", "--no-color", "--no-ext-diff",
+		"--src-prefix=a/", "--dst-prefix=b/", "-M", "-U0", from, to)
+	diffs, err := parseDiff(out)
+	if err != nil {
+		dief("failed to parse diff between %s and %s: %v", from, to, err)
+	}
+	return diffs
+}
+
+func parseDiff(out string) (map[string]*fileDiff, error) {
+	diffs := map[string]*fileDiff{}
+	var d *fileDiff
+	for _, line := range lines(out) {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			if d != nil {
+				diffs[d.oldPath] = d
+			}
+			// Without renames, the header is "a/path b/path", which is
+			// ambiguous if path has spaces, but has both halves equal.
+			h := strings.TrimPrefix(line, "diff --git ")
+			n := (len(h) - len("a/ b/")) / 2
+			path := ""
+			if n > 0 && h[2:2+n] == h[len(h)-n:] {
+				path = h[2 : 2+n]
+			}
+			d = &fileDiff{oldPath: path, newPath: path}
+		case d == nil:
+			return nil, fmt.Errorf("unexpected line %q", line)
+		case strings.HasPrefix(line, "rename from "):
+			d.oldPath = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			d.newPath = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "--- a/"):
+			d.oldPath = strings.TrimPrefix(line, "--- a/")
+		case strings.HasPrefix(line, "+++ b/"):
+			d.newPath = strings.TrimPrefix(line, "+++ b/")
+		case line == "+++ /dev/null", strings.HasPrefix(line, "deleted file mode "):
+			d.newPath = ""
+		case strings.HasPrefix(line, "@@ "):
+			var h diffHunk
+			oldRange, newRange, ok := strings.Cut(strings.TrimPrefix(line, "@@ -"), " +")
+			newRange, _, _ = strings.Cut(newRange, " @@")
+			if !ok || !parseRange(oldRange, &h.oldStart, &h.oldLines) || !parseRange(newRange, &h.newStart, &h.newLines) {
+				return nil, fmt.Errorf("malformed hunk header %q", line)
+			}
+			d.hunks = append(d.hunks, h)
+		}
+	}
+	if d != nil {
+		diffs[d.oldPath] = d
+	}
+	delete(diffs, "") // new files
+	return diffs, nil
+}
+
+// parseRange parses a "start,lines" or "start" hunk range.
+func parseRange(s string, start, lines *int) bool {
+	a, b, ok := strings.Cut(s, ",")
+	var err1, err2 error
+	*start, err1 = strconv.Atoi(a)
+	*lines = 1
+	if ok {
+		*lines, err2 = strconv.Atoi(b)
+	}
+	return err1 == nil && err2 == nil
+}
+
+// mapPosition maps a line of a file in the old commit of diffs to the
+// corresponding position in the new commit. If the line was changed or
+// removed, it returns the start of the hunk that replaced it, and outdated.
+// A line of zero, for comments on the whole file, is preserved.
+func mapPosition(diffs map[string]*fileDiff, path string, line int) (newPath string, newLine int, outdated bool) {
+	d, ok := diffs[path]
+	if !ok {
+		return path, line, false
+	}
+	if d.newPath == "" {
+		return path, line, true
+	}
+	if line == 0 {
+		return d.newPath, 0, false
+	}
+	offset := 0
+	for _, h := range d.hunks {
+		if h.oldLines == 0 && line <= h.oldStart || h.oldLines > 0 && line < h.oldStart {
+			break
+		}
+		if line < h.oldStart+h.oldLines {
+			return d.newPath, max(h.newStart, 1), true
+		}
+		offset += h.newLines - h.oldLines
+	}
+	return d.newPath, line + offset, false
+}
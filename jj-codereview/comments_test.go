@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func numberedLines(from, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func TestMapPosition(t *testing.T) {
+	gt := newGitTest(t)
+	writeFile(t, filepath.Join(gt.repo, "a.txt"), numberedLines(1, 10))
+	writeFile(t, filepath.Join(gt.repo, "b.txt"), numberedLines(1, 10))
+	writeFile(t, filepath.Join(gt.repo, "c.txt"), "gone\n")
+	writeFile(t, filepath.Join(gt.repo, "d.txt"), "same\n")
+	gt.git("add", ".")
+	gt.git("commit", "-q", "-m", "old")
+	from := gt.git("rev-parse", "HEAD")
+
+	// Insert two lines at the top, change line 5, and remove line 8.
+	writeFile(t, filepath.Join(gt.repo, "a.txt"), "new 1\nnew 2\n"+
+		numberedLines(1, 4)+"changed 5\n"+numberedLines(6, 7)+numberedLines(9, 10))
+	// Rename, and append a line.
+	gt.git("mv", "b.txt", "renamed.txt")
+	writeFile(t, filepath.Join(gt.repo, "renamed.txt"), numberedLines(1, 11))
+	gt.git("rm", "-q", "c.txt")
+	gt.git("add", ".")
+	gt.git("commit", "-q", "-m", "new")
+	to := gt.git("rev-parse", "HEAD")
+
+	diffs := diffCommits(from, to)
+	tests := []struct {
+		path     string
+		line     int
+		newPath  string
+		newLine  int
+		outdated bool
+	}{
+		{"a.txt", 1, "a.txt", 3, false},
+		{"a.txt", 4, "a.txt", 6, false},
+		{"a.txt", 5, "a.txt", 7, true},
+		{"a.txt", 6, "a.txt", 8, false},
+		{"a.txt", 7, "a.txt", 9, false},
+		{"a.txt", 8, "a.txt", 9, true},
+		{"a.txt", 9, "a.txt", 10, false},
+		{"a.txt", 10, "a.txt", 11, false},
+		{"a.txt", 0, "a.txt", 0, false},
+		{"b.txt", 3, "renamed.txt", 3, false},
+		{"b.txt", 10, "renamed.txt", 10, false},
+		{"c.txt", 1, "c.txt", 1, true},
+		{"d.txt", 1, "d.txt", 1, false},
+	}
+	for _, tt := range tests {
+		newPath, newLine, outdated := mapPosition(diffs, tt.path, tt.line)
+		if newPath != tt.newPath || newLine != tt.newLine || outdated != tt.outdated {
+			t.Errorf("mapPosition(%s:%d) = %s:%d, %v; want %s:%d, %v", tt.path, tt.line,
+				newPath, newLine, outdated, tt.newPath, tt.newLine, tt.outdated)
+		}
+	}
+}
+
+func TestCommentThreads(t *testing.T) {
+	comments := map[string]*GerritComment{
+		"a":  {ID: "a", Path: "x.go", Line: 3, Updated: "2025-01-01 00:01:00.000000000", Unresolved: true},
+		"a1": {ID: "a1", Path: "x.go", Line: 3, InReplyTo: "a", Updated: "2025-01-01 00:03:00.000000000", Unresolved: true},
+		"a2": {ID: "a2", Path: "x.go", Line: 3, InReplyTo: "a1", Updated: "2025-01-01 00:04:00.000000000", Unresolved: false},
+		"b":  {ID: "b", Path: "y.go", Line: 1, Updated: "2025-01-01 00:02:00.000000000", Unresolved: true},
+	}
+	threads := commentThreads(comments)
+	if len(threads) != 2 {
+		t.Fatalf("got %d threads, want 2", len(threads))
+	}
+	if th := threads[0]; th.ID != "a2" || th.Unresolved || len(th.Comments) != 3 ||
+		th.Comments[0].ID != "a" || th.Comments[1].ID != "a1" {
+		t.Errorf("unexpected first thread: %+v", th)
+	}
+	if th := threads[1]; th.ID != "b" || !th.Unresolved || th.Path != "y.go" || th.Line != 1 {
+		t.Errorf("unexpected second thread: %+v", th)
+	}
+}
+
+func TestComments(t *testing.T) {
+	gt := newJJTest(t)
+	const changeID = "I0123456789abcdef0123456789abcdef01234567"
+	gt.pushCL("hello.go", "package hello\n\nfunc Hello() {}\n", "hello: add package", changeID)
+	top := gt.gerrit.addComment(1, 1, "hello.go", 3, "Reviewer", "Needs a doc comment.", true)
+	reply := gt.gerrit.addReply(1, top, "Gopher", "Which one?", true)
+	done := gt.gerrit.addComment(1, 1, "hello.go", 1, "Reviewer", "Nice package.", false)
+	gt.gerrit.addComment(1, 1, "/PATCHSET_LEVEL", 0, "Reviewer", "Almost there.", true)
+
+	testMain(t, "fetch", "1")
+	commit := gt.git("rev-parse", "refs/remotes/gerrit/cl/1/1")
+	gt.jj("new", commit)
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "// Package hello says hello.\npackage hello\n\nfunc Hello() {}\n")
+
+	stdout, _ := testMain(t, "comments")
+	want := "/PATCHSET_LEVEL: [comment4] Reviewer: Almost there.\n" +
+		"hello.go:4: [" + reply + "] Reviewer: Needs a doc comment.\n" +
+		"\tGopher: Which one?\n"
+	if stdout != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	stdout, _ = testMain(t, "comments", "-a", "-json", "-r", commit, "1")
+	var threads []*commentThread
+	if err := json.Unmarshal([]byte(stdout), &threads); err != nil {
+		t.Fatal(err)
+	}
+	if len(threads) != 3 {
+		t.Fatalf("got %d threads, want 3:\n%s", len(threads), stdout)
+	}
+	if th := threads[1]; th.ID != done || th.Path != "hello.go" || th.Line != 1 || th.Unresolved {
+		t.Errorf("unexpected resolved thread: %+v", th)
+	}
+	if th := threads[2]; th.ID != reply || th.Line != 3 || th.Outdated || len(th.Comments) != 2 {
+		t.Errorf("unexpected unresolved thread: %+v", th)
+	}
+}
+
+func TestCommentsRemote(t *testing.T) {
+	gt := newJJTestRemote(t, "upstream")
+	const changeID = "I0123456789abcdef0123456789abcdef01234567"
+	gt.pushCL("hello.go", "package hello\n", "hello: add package", changeID)
+	gt.gerrit.addComment(1, 1, "hello.go", 1, "Reviewer", "Nice package.", true)
+
+	testMain(t, "fetch", "1")
+	gt.jj("new", gt.git("rev-parse", "refs/remotes/gerrit/cl/1/1"))
+
+	// The Change-Id is found among the ancestors that are not in upstream.
+	stdout, _ := testMain(t, "comments")
+	if !strings.Contains(stdout, "hello.go:1: ") || !strings.Contains(stdout, "Nice package.") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+}
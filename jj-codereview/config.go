@@ -0,0 +1,108 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var cachedConfig map[string]string
+
+// config returns the code review config, from the codereview.cfg file at the
+// root of the repository, overridden by any codereview.<key> jj settings.
+//
+// The file consists of lines of the form "key: value".
+// Lines beginning with # are comments.
+// If there is no config, it returns an empty map.
+// If the config is malformed, it dies.
+func config() map[string]string {
+	if cachedConfig != nil {
+		return cachedConfig
+	}
+	cachedConfig = make(map[string]string)
+	root, err := trimErr(cmdOutputErr("git", "rev-parse", "--show-toplevel"))
+	if err != nil {
+		dief("failed to find repository root: %v\n%s", err, root)
+	}
+	configPath := filepath.Join(root, "codereview.cfg")
+	b, err := os.ReadFile(configPath)
+	if err == nil {
+		cachedConfig, err = parseConfig(string(b))
+		if err != nil {
+			dief("%s: %v", configPath, err)
+		}
+	} else {
+		verbosef("failed to load config from %q: %v", configPath, err)
+	}
+	for _, key := range []string{"gerrit", "remote"} {
+		if v, err := trimErr(cmdOutputErr("jj", "config", "get", "codereview."+key)); err == nil && v != "" {
+			cachedConfig[key] = v
+		}
+	}
+	return cachedConfig
+}
+
+func parseConfig(raw string) (map[string]string, error) {
+	cfg := make(map[string]string)
+	for _, line := range nonBlankLines(raw) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			// comment line
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("bad config line, expected 'key: value': %q", line)
+		}
+		cfg[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return cfg, nil
+}
+
+// pushRemote returns the name of the remote that CLs are pushed to, which is
+// the "remote" config key, or origin by default.
+func pushRemote() string {
+	if r := config()["remote"]; r != "" {
+		return r
+	}
+	return "origin"
+}
+
+// gerritURL returns the base URL of the Gerrit server, without trailing slash.
+//
+// It's the "gerrit" config key, or if that's not set and the push remote is a
+// googlesource.com repository, the corresponding -review host.
+func gerritURL() string {
+	if g := config()["gerrit"]; g != "" {
+		return strings.TrimSuffix(g, "/")
+	}
+	remote := pushRemote()
+	origin := trim(cmdOutput("git", "config", "remote."+remote+".url"))
+	u, err := url.Parse(origin)
+	if err == nil && u.Scheme == "https" && strings.HasSuffix(u.Host, ".googlesource.com") {
+		host, _, _ := strings.Cut(u.Host, ".")
+		return "https://" + host + "-review.googlesource.com"
+	}
+	dief("cannot determine Gerrit server for remote %s (%s); set gerrit in codereview.cfg", remote, origin)
+	return ""
+}
+
+// gerritCredentialURL returns the URL git-credential is asked to provide a
+// password for. On googlesource.com, that's the git host, whose tokens are
+// also valid for the -review host.
+func gerritCredentialURL() *url.URL {
+	u, err := url.Parse(gerritURL())
+	if err != nil {
+		dief("invalid Gerrit URL: %v", err)
+	}
+	if host, ok := strings.CutSuffix(u.Host, "-review.googlesource.com"); ok {
+		u.Host = host + ".googlesource.com"
+	}
+	return u
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	cases := []struct {
+		raw     string
+		want    map[string]string
+		wanterr bool
+	}{
+		{raw: "", want: map[string]string{}},
+		{raw: "gerrit: http://example.com", want: map[string]string{"gerrit": "http://example.com"}},
+		{raw: "# comment\n  gerrit : https://go-review.googlesource.com/ \n\nremote: upstream\n",
+			want: map[string]string{"gerrit": "https://go-review.googlesource.com/", "remote": "upstream"}},
+		{raw: "gerrit", wanterr: true},
+	}
+	for _, tt := range cases {
+		cfg, err := parseConfig(tt.raw)
+		if err != nil != tt.wanterr {
+			t.Errorf("parseConfig(%q) error: %v", tt.raw, err)
+		}
+		if !reflect.DeepEqual(cfg, tt.want) {
+			t.Errorf("parseConfig(%q) = %v, want %v", tt.raw, cfg, tt.want)
+		}
+	}
+}
+
+func TestGerritURL(t *testing.T) {
+	gt := newGitTest(t)
+
+	cachedConfig = nil
+	if got := gerritURL(); got != gt.gerrit.URL() {
+		t.Errorf("gerritURL() = %q, want %q from codereview.cfg", got, gt.gerrit.URL())
+	}
+
+	gt.git("remote", "add", "upstream", "https://go.googlesource.com/crypto")
+	writeFile(t, "codereview.cfg", "remote: upstream\n")
+	cachedConfig = nil
+	if got, want := gerritURL(), "https://go-review.googlesource.com"; got != want {
+		t.Errorf("gerritURL() = %q, want %q", got, want)
+	}
+	if got, want := gerritCredentialURL().Host, "go.googlesource.com"; got != want {
+		t.Errorf("gerritCredentialURL().Host = %q, want %q", got, want)
+	}
+	if got, want := pushRemote(), "upstream"; got != want {
+		t.Errorf("pushRemote() = %q, want %q", got, want)
+	}
+}
+
+func TestJJConfigRemote(t *testing.T) {
+	gt := newGitTest(t)
+	gt.setRemote("upstream")
+	cachedConfig = nil
+	name := jjConfig()
+	defer os.Remove(name)
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(b)
+	if strings.Contains(config, "origin") || strings.Contains(config, "REMOTE") {
+		t.Errorf("config doesn't use the push remote:\n%s", config)
+	}
+	for _, want := range []string{
+		`"jjcrremote()" = "remote_bookmarks(remote=exact:'upstream')"`,
+		`b.remote() == 'upstream'`,
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("config doesn't contain %q:\n%s", want, config)
+		}
+	}
+}
@@ -36,7 +36,7 @@ Fetches a single CL by number, Change-Id, or other Gerrit query.
 	ref := fmt.Sprintf("refs/remotes/gerrit/cl/%d/%d", c.Number, c.Revisions[c.CurrentRevision].Number)
 	if !*noRun {
 		run("git", "update-ref", ref, "FETCH_HEAD")
-		for _, c := range jjLog("-T", "commit_id ++ '\n'", "-r", "::"+c.CurrentRevision+" ~ ::remote_bookmarks(remote=origin) ~ "+c.CurrentRevision) {
+		for _, c := range jjLog("-T", "commit_id ++ '\n'", "-r", "::"+c.CurrentRevision+" ~ ::jjcrremote() ~ "+c.CurrentRevision) {
 			labelCommit(c, 5)
 		}
 		printf("%s", jjLog("-r", c.CurrentRevision)[0])
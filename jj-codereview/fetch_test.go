@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	gt := newJJTest(t)
+	gt.pushCL("hello.go", "package hello\n", "hello: add package", "I0123456789abcdef0123456789abcdef01234567")
+	commit := gt.pushCL("hello.go", "package hello // v2\n", "hello: add package", "I0123456789abcdef0123456789abcdef01234567")
+
+	_, stderr := testMain(t, "fetch", "1")
+	if got := gt.git("rev-parse", "refs/remotes/gerrit/cl/1/2"); got != commit {
+		t.Errorf("refs/remotes/gerrit/cl/1/2 = %s, want %s", got, commit)
+	}
+	if !strings.Contains(stderr, "hello: add package") {
+		t.Errorf("fetched change not printed:\n%s", stderr)
+	}
+	if got := gt.jj("log", "--no-graph", "-r", commit, "-T", "description.first_line()"); got != "hello: add package" {
+		t.Errorf("fetched commit not visible to jj: %q", got)
+	}
+
+	if stderr := testMainDied(t, "fetch", "2"); !strings.Contains(stderr, "change not found") {
+		t.Errorf("unexpected output:\n%s", stderr)
+	}
+}
+
+func TestFetchRemote(t *testing.T) {
+	gt := newJJTestRemote(t, "upstream")
+	commit := gt.pushCL("hello.go", "package hello\n", "hello: add package", "I0123456789abcdef0123456789abcdef01234567")
+
+	_, stderr := testMain(t, "fetch", "1")
+	if got := gt.jj("log", "--no-graph", "-r", commit, "-T", "description.first_line()"); got != "hello: add package" {
+		t.Errorf("fetched commit not visible to jj: %q", got)
+	}
+	// Only the commits not in upstream are looked up in Gerrit.
+	if strings.Contains(stderr, "failed to fetch change") {
+		t.Errorf("looked up commits already in upstream:\n%s", stderr)
+	}
+}
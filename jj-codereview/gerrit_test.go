@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGerrit implements the parts of the Gerrit REST API used by
+// jj-codereview, backed by a bare git repository that CLs are pushed to.
+//
+// Like a real Gerrit, pushes to refs/for/<branch> create or update changes,
+// by Change-Id. Since there are no hooks, they are processed lazily, at the
+// start of each API request.
+type fakeGerrit struct {
+	t    *testing.T
+	srv  *httptest.Server
+	repo string
+
+	mu       sync.Mutex
+	changes  []*fakeChange
+	comments int
+	authed   bool
+}
+
+type fakeChange struct {
+	Number      int
+	ChangeID    string
+	Branch      string
+	Revisions   []string // commit of each patchset, starting from 1
+	Reviewers   []string
+	Hashtags    []string
+	WIP         bool
+	Status      string // NEW, MERGED, or ABANDONED
+	Submittable bool
+	Votes       map[string]map[string]int // label -> reviewer -> value
+	Comments    map[string][]*GerritComment
+	Drafts      []*fakeDraft
+}
+
+type fakeDraft struct {
+	PatchSet int
+	*CommentInput
+}
+
+const (
+	fakeGerritUser     = "gopher"
+	fakeGerritPassword = "hunter2"
+)
+
+func newFakeGerrit(t *testing.T, repo string) *fakeGerrit {
+	g := &fakeGerrit{t: t, repo: repo}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /changes/{$}", g.handleQuery)
+	mux.HandleFunc("GET /changes/{id}/comments", g.handleComments)
+	mux.HandleFunc("PUT /a/changes/{id}/revisions/{rev}/drafts", g.handleDraft)
+	g.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if err := g.sync(); err != nil {
+			t.Errorf("fake Gerrit: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(g.srv.Close)
+	return g
+}
+
+// URL returns the base URL of the server.
+func (g *fakeGerrit) URL() string {
+	return g.srv.URL
+}
+
+func (g *fakeGerrit) git(args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = g.repo
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// sync turns the refs/for/ refs pushed since the last call into patchsets.
+func (g *fakeGerrit) sync() error {
+	refs, err := g.git("for-each-ref", "--format=%(refname)", "refs/for/")
+	if err != nil {
+		return err
+	}
+	for _, ref := range nonBlankLines(refs) {
+		branch, options, _ := strings.Cut(strings.TrimPrefix(ref, "refs/for/"), "%")
+		commits, err := g.git("rev-list", "--reverse", ref, "^refs/heads/"+branch)
+		if err != nil {
+			return err
+		}
+		for _, commit := range nonBlankLines(commits) {
+			changeID, err := g.git("show", "-s", "--format=%(trailers:key=Change-Id,valueonly)", commit)
+			if err != nil {
+				return err
+			}
+			if changeID == "" {
+				return fmt.Errorf("commit %s has no Change-Id", commit)
+			}
+			c := g.changeByID(changeID, branch)
+			if c == nil {
+				c = &fakeChange{
+					Number:   len(g.changes) + 1,
+					ChangeID: changeID,
+					Branch:   branch,
+					Status:   "NEW",
+					Votes:    make(map[string]map[string]int),
+					Comments: make(map[string][]*GerritComment),
+				}
+				g.changes = append(g.changes, c)
+			}
+			if n := len(c.Revisions); n > 0 && c.Revisions[n-1] == commit {
+				continue
+			}
+			c.Revisions = append(c.Revisions, commit)
+			if _, err := g.git("update-ref", c.ref(len(c.Revisions)), commit); err != nil {
+				return err
+			}
+			c.applyOptions(options)
+		}
+		if _, err := g.git("update-ref", "-d", ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fakeChange) ref(patchSet int) string {
+	return fmt.Sprintf("refs/changes/%02d/%d/%d", c.Number%100, c.Number, patchSet)
+}
+
+func (c *fakeChange) applyOptions(options string) {
+	for _, o := range strings.Split(options, ",") {
+		k, v, _ := strings.Cut(o, "=")
+		switch k {
+		case "r":
+			c.Reviewers = append(c.Reviewers, v)
+		case "hashtag":
+			c.Hashtags = append(c.Hashtags, v)
+		case "wip":
+			c.WIP = true
+		case "ready":
+			c.WIP = false
+		}
+	}
+}
+
+func (g *fakeGerrit) changeByID(changeID, branch string) *fakeChange {
+	for _, c := range g.changes {
+		if c.ChangeID == changeID && c.Branch == branch {
+			return c
+		}
+	}
+	return nil
+}
+
+// query finds the changes matching any of the " OR "-separated terms, as
+// interpreted by lookup.
+func (g *fakeGerrit) query(q string) []*fakeChange {
+	var changes []*fakeChange
+	for _, term := range strings.Split(q, " OR ") {
+		if c := g.lookup(term); c != nil && !slices.Contains(changes, c) {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// lookup finds a change by number, Change-Id, or commit of any patchset.
+func (g *fakeGerrit) lookup(q string) *fakeChange {
+	q = strings.TrimPrefix(q, "change:")
+	for _, c := range g.changes {
+		if strconv.Itoa(c.Number) == q || c.ChangeID == q {
+			return c
+		}
+		for _, rev := range c.Revisions {
+			if rev == q {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (g *fakeGerrit) json(c *fakeChange) any {
+	type revision struct {
+		Number int `json:"_number"`
+		Fetch  struct {
+			HTTP struct {
+				URL string `json:"url"`
+				Ref string `json:"ref"`
+			} `json:"http"`
+		} `json:"fetch"`
+	}
+	type approval struct {
+		Value int    `json:"value"`
+		Name  string `json:"name"`
+	}
+	labels := make(map[string]map[string][]approval)
+	for label, votes := range c.Votes {
+		var all []approval
+		for name, value := range votes {
+			all = append(all, approval{Value: value, Name: name})
+		}
+		labels[label] = map[string][]approval{"all": all}
+	}
+	comments := make(map[string]*GerritComment)
+	for _, cc := range c.Comments {
+		for _, comment := range cc {
+			comments[comment.ID] = comment
+		}
+	}
+	unresolved := 0
+	for _, t := range commentThreads(comments) {
+		if t.Unresolved {
+			unresolved++
+		}
+	}
+	revisions := make(map[string]*revision)
+	for i, commit := range c.Revisions {
+		r := &revision{Number: i + 1}
+		r.Fetch.HTTP.URL = g.repo
+		r.Fetch.HTTP.Ref = c.ref(i + 1)
+		revisions[commit] = r
+	}
+	return map[string]any{
+		"triplet_id":       "test~" + c.Branch + "~" + c.ChangeID,
+		"branch":           c.Branch,
+		"project":          "test",
+		"change_id":        c.ChangeID,
+		"_number":          c.Number,
+		"revisions":        revisions,
+		"current_revision": c.Revisions[len(c.Revisions)-1],
+		"hashtags":         c.Hashtags,
+		"work_in_progress": c.WIP,
+		"status":           c.Status,
+		"submittable":      c.Submittable,
+		"labels":           labels,
+
+		"unresolved_comment_count": unresolved,
+	}
+}
+
+func writeGerritJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	// Gerrit prepends a magic string to prevent XSSI.
+	io.WriteString(w, ")]}'\n")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (g *fakeGerrit) handleQuery(w http.ResponseWriter, r *http.Request) {
+	results := []any{}
+	for _, c := range g.query(r.FormValue("q")) {
+		results = append(results, g.json(c))
+	}
+	if n, err := strconv.Atoi(r.FormValue("n")); err == nil && len(results) > n {
+		results = results[:n]
+	}
+	writeGerritJSON(w, results)
+}
+
+func (g *fakeGerrit) handleComments(w http.ResponseWriter, r *http.Request) {
+	c := g.lookup(r.PathValue("id"))
+	if c == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	writeGerritJSON(w, c.Comments)
+}
+
+func (g *fakeGerrit) handleDraft(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != fakeGerritUser || password != fakeGerritPassword {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	g.authed = true
+	c := g.lookup(r.PathValue("id"))
+	if c == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	patchSet, err := strconv.Atoi(r.PathValue("rev"))
+	if err != nil || patchSet < 1 || patchSet > len(c.Revisions) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	draft := &CommentInput{}
+	if err := json.NewDecoder(r.Body).Decode(draft); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Drafts = append(c.Drafts, &fakeDraft{PatchSet: patchSet, CommentInput: draft})
+	writeGerritJSON(w, draft)
+}
+
+// received processes any pending push, like a real Gerrit would do as part
+// of receiving it, so that the next push to refs/for/ is not rejected as a
+// non-fast-forward.
+func (g *fakeGerrit) received() {
+	g.t.Helper()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.sync(); err != nil {
+		g.t.Fatal(err)
+	}
+}
+
+// change returns the change with the given number, failing the test if it
+// doesn't exist. It processes any pending push first.
+func (g *fakeGerrit) change(number int) *fakeChange {
+	g.t.Helper()
+	g.received()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if number < 1 || number > len(g.changes) {
+		g.t.Fatalf("fake Gerrit: change %d not found", number)
+	}
+	return g.changes[number-1]
+}
+
+// update calls f with the change with the given number, to modify it.
+func (g *fakeGerrit) update(number int, f func(c *fakeChange)) {
+	g.t.Helper()
+	c := g.change(number)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f(c)
+}
+
+// vote sets the vote of a reviewer on a label of a change.
+func (g *fakeGerrit) vote(number int, label, reviewer string, value int) {
+	g.t.Helper()
+	g.update(number, func(c *fakeChange) {
+		if c.Votes[label] == nil {
+			c.Votes[label] = make(map[string]int)
+		}
+		c.Votes[label][reviewer] = value
+	})
+}
+
+// addComment adds a published comment to a patchset of a change, and returns
+// its ID.
+func (g *fakeGerrit) addComment(number, patchSet int, path string, line int, author, message string, unresolved bool) string {
+	g.t.Helper()
+	c := g.change(number)
+	return g.publish(c, path, &GerritComment{
+		PatchSet:   patchSet,
+		Line:       line,
+		Message:    message,
+		Unresolved: unresolved,
+	}, author)
+}
+
+// addReply adds a published reply to a comment, and returns its ID.
+func (g *fakeGerrit) addReply(number int, inReplyTo, author, message string, unresolved bool) string {
+	g.t.Helper()
+	c := g.change(number)
+	for path, comments := range c.Comments {
+		for _, parent := range comments {
+			if parent.ID == inReplyTo {
+				return g.publish(c, path, &GerritComment{
+					PatchSet:   parent.PatchSet,
+					Line:       parent.Line,
+					Side:       parent.Side,
+					InReplyTo:  inReplyTo,
+					Message:    message,
+					Unresolved: unresolved,
+				}, author)
+			}
+		}
+	}
+	g.t.Fatalf("fake Gerrit: comment %s not found", inReplyTo)
+	return ""
+}
+
+func (g *fakeGerrit) publish(c *fakeChange, path string, comment *GerritComment, author string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.comments++
+	comment.ID = fmt.Sprintf("comment%d", g.comments)
+	// Gerrit timestamps are in UTC, in this format.
+	comment.Updated = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).
+		Add(time.Duration(g.comments) * time.Minute).Format("2006-01-02 15:04:05.000000000")
+	comment.Author.Name = author
+	comment.Author.Email = strings.ToLower(author) + "@example.com"
+	c.Comments[path] = append(c.Comments[path], comment)
+	return comment.ID
+}
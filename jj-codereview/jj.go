@@ -2,28 +2,42 @@ package main
 
 import (
 	"os"
+	"strings"
 )
 
+// jjConfigTOML is the configuration for the jj commands run by jj-codereview.
+// REMOTE is replaced with the push remote, as a string literal.
 var jjConfigTOML = `
 [revset-aliases]
-"jjcrmailpending()" = "remote_bookmarks(remote=origin)..jjcrmail() ~ remote_bookmarks(remote=gerrit)"
-"jjcrbranchpoint(x)" = "heads(::x & ::remote_bookmarks(remote=origin))"
-"jjcrbranchhead(x)" = "jjcrbranchpoint(x):: & remote_bookmarks(remote=origin)"
+"jjcrremote()" = "remote_bookmarks(remote=exact:REMOTE)"
+"jjcrmailpending()" = "jjcrremote()..jjcrmail() ~ remote_bookmarks(remote=gerrit)"
+"jjcrbranchpoint(x)" = "heads(::x & ::jjcrremote())"
+"jjcrbranchhead(x)" = "jjcrbranchpoint(x):: & jjcrremote()"
 
 [template-aliases]
-bookmarks = "separate('\n', remote_bookmarks.map(|b| if(b.remote() == 'origin', b.name()))) ++ '\n'"
+bookmarks = "separate('\n', remote_bookmarks.map(|b| if(b.remote() == REMOTE, b.name()))) ++ '\n'"
 
 [ui]
 color = "never"
 paginate = "never"
 `
 
+// jjString returns s as a jj revset and template string literal. Single quoted
+// literals are raw, so they also don't need escaping in a TOML string.
+func jjString(s string) string {
+	if strings.ContainsAny(s, "'\"\\\n") {
+		dief("unsupported remote name %q", s)
+	}
+	return "'" + s + "'"
+}
+
 func jjConfig() string {
 	tmp, err := os.CreateTemp("", "jj-config-")
 	if err != nil {
 		dief("failed to create temporary file: %v", err)
 	}
-	if _, err := tmp.WriteString(jjConfigTOML); err != nil {
+	toml := strings.ReplaceAll(jjConfigTOML, "REMOTE", jjString(pushRemote()))
+	if _, err := tmp.WriteString(toml); err != nil {
 		dief("failed to write temporary file: %v", err)
 	}
 	if err := tmp.Close(); err != nil {
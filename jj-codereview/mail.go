@@ -38,7 +38,8 @@ Usage: %s mail %s [-r reviewer,...] [-cc mail,...]
 	[-autosubmit] [-trybot] [-wip] [-ready] [-hashtag tag,...]
 	[-branch name] [revisions]
 
-Mails all changes in "remote_bookmarks(remote=origin)..revisions".
+Mails all changes in "remote_bookmarks(remote=<remote>)..revisions", where
+<remote> is the push remote: origin, or the remote config key.
 
 If revisions is not specified, it's set to "@-".
 `), progName, globalFlags)
@@ -90,7 +91,7 @@ If revisions is not specified, it's set to "@-".
 			if len(branches) != 1 {
 				dief("cannot determine branch for commit %s, got %v; use -branch to specify one", commit, branches)
 			}
-			target = strings.TrimSuffix(branches[0], "@origin")
+			target = strings.TrimSuffix(branches[0], "@"+pushRemote())
 		}
 
 		refSpec := commit + ":refs/for/" + target
@@ -127,7 +128,7 @@ If revisions is not specified, it's set to "@-".
 		if *autoSubmit {
 			refSpec += start + "l=Auto-Submit"
 		}
-		args := []string{"push", "-q", "-o", "nokeycheck", "origin"}
+		args := []string{"push", "-q", "-o", "nokeycheck", pushRemote()}
 		args = append(args, refSpec)
 		run("git", args...)
 	}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMail(t *testing.T) {
+	gt := newJJTest(t)
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "package hello\n")
+	gt.jj("describe", "-m", "hello: add package\n\nChange-Id: I0123456789abcdef0123456789abcdef01234567\n")
+	gt.jj("new")
+	commit := gt.jj("log", "--no-graph", "-r", "@-", "-T", "commit_id")
+
+	testMain(t, "mail", "-r", "rsc@golang.org", "-hashtag", "test")
+
+	c := gt.gerrit.change(1)
+	if !slices.Equal(c.Revisions, []string{commit}) {
+		t.Errorf("change revisions = %v, want [%s]", c.Revisions, commit)
+	}
+	if c.Branch != "master" {
+		t.Errorf("change branch = %q, want master", c.Branch)
+	}
+	if !slices.Equal(c.Reviewers, []string{"rsc@golang.org"}) {
+		t.Errorf("change reviewers = %v", c.Reviewers)
+	}
+	if !slices.Equal(c.Hashtags, []string{"test"}) {
+		t.Errorf("change hashtags = %v", c.Hashtags)
+	}
+	if got := gt.git("rev-parse", "refs/remotes/gerrit/cl/1/1"); got != commit {
+		t.Errorf("refs/remotes/gerrit/cl/1/1 = %s, want %s", got, commit)
+	}
+
+	// Mailing again without changes is a no-op.
+	testMain(t, "mail")
+	if c := gt.gerrit.change(1); len(c.Revisions) != 1 {
+		t.Errorf("change has %d patchsets after mailing again, want 1", len(c.Revisions))
+	}
+
+	// A new revision of the same change is a new patchset.
+	gt.jj("edit", "@-")
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "package hello // v2\n")
+	gt.jj("new")
+	commit = gt.jj("log", "--no-graph", "-r", "@-", "-T", "commit_id")
+	testMain(t, "mail")
+	if c := gt.gerrit.change(1); len(c.Revisions) != 2 || c.Revisions[1] != commit {
+		t.Errorf("change revisions = %v, want second patchset %s", c.Revisions, commit)
+	}
+	if got := gt.git("rev-parse", "refs/remotes/gerrit/cl/1/2"); got != commit {
+		t.Errorf("refs/remotes/gerrit/cl/1/2 = %s, want %s", got, commit)
+	}
+}
+
+func TestMailPrivate(t *testing.T) {
+	gt := newJJTest(t)
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "package hello\n")
+	gt.jj("describe", "-m", "wip: hello\n\nChange-Id: I0123456789abcdef0123456789abcdef01234567\n")
+	gt.jj("new")
+
+	if stderr := testMainDied(t, "mail"); !strings.Contains(stderr, "the following changes are private") {
+		t.Errorf("unexpected output:\n%s", stderr)
+	}
+	if out := gt.gitDir(gt.origin, "for-each-ref", "refs/for/", "refs/changes/"); out != "" {
+		t.Errorf("private change was pushed:\n%s", out)
+	}
+}
+
+func TestMailRemote(t *testing.T) {
+	gt := newJJTestRemote(t, "upstream")
+	writeFile(t, filepath.Join(gt.repo, "hello.go"), "package hello\n")
+	gt.jj("describe", "-m", "hello: add package\n\nChange-Id: I0123456789abcdef0123456789abcdef01234567\n")
+	gt.jj("new")
+	commit := gt.jj("log", "--no-graph", "-r", "@-", "-T", "commit_id")
+
+	// The changes and branch are relative to upstream, not the unrelated
+	// origin, whose history would otherwise be mailed too.
+	stdout, stderr := testMain(t, "mail")
+	if strings.Contains(stdout+stderr, "unrelated commit") {
+		t.Errorf("mailed a commit from origin:\n%s%s", stdout, stderr)
+	}
+	c := gt.gerrit.change(1)
+	if !slices.Equal(c.Revisions, []string{commit}) || c.Branch != "master" {
+		t.Errorf("change revisions = %v, branch = %q; want [%s], master", c.Revisions, c.Branch, commit)
+	}
+	if got := gt.git("rev-parse", "refs/remotes/gerrit/cl/1/1"); got != commit {
+		t.Errorf("refs/remotes/gerrit/cl/1/1 = %s, want %s", got, commit)
+	}
+}
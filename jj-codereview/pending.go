@@ -0,0 +1,139 @@
+// Copyright 2014 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func cmdPending(args []string) {
+	rebase := flags.Bool("rebase", false, "rebase the children of merged CLs onto the merged commits")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr(), trim(`
+Usage: %s pending %s [-rebase]
+
+Shows the Gerrit state of every mutable revision: the CL it was mailed as,
+the last patchset fetched or mailed locally versus the latest one, whether
+the revision is up to date with it, the votes, the number of unresolved
+comment threads, and whether the CL is submittable.
+
+With -rebase, the children of revisions that were merged in a branch of the
+push remote are first rebased onto the merged commits, like cleanup does,
+leaving the merged revisions to be abandoned by cleanup.
+`), progName, globalFlags)
+		exit(2)
+	}
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+	}
+
+	config := jjConfig()
+	defer os.Remove(config)
+	jjLog := jjLog(config)
+
+	const revset = `mutable() ~ (empty() & description(exact:""))`
+	if *rebase {
+		mergedByChangeID := mergedChanges()
+		for _, rev := range jjLog("-T", "commit_id ++ '\n'", "-r", revset) {
+			if mergedRev, ok := mergedByChangeID[commitChangeID(rev)]; ok {
+				restackChildren(jjLog, rev, mergedRev)
+			}
+		}
+	}
+
+	type pendingRev struct {
+		commit, changeID, summary string
+	}
+	var revs []pendingRev
+	var terms []string
+	for _, line := range jjLog("-T", `commit_id ++ " " ++ change_id.shortest(8) ++ " " ++
+		coalesce(description.first_line(), "(no description set)") ++ "\n"`, "-r", revset) {
+		commit, summary, _ := strings.Cut(line, " ")
+		r := pendingRev{commit: commit, changeID: commitChangeID(commit), summary: summary}
+		if r.changeID != "" {
+			terms = append(terms, "change:"+r.changeID)
+		}
+		revs = append(revs, r)
+	}
+
+	byChangeID := map[string]*GerritChange{}
+	if len(terms) > 0 {
+		changes, err := QueryChanges(strings.Join(terms, " OR "))
+		if err != nil {
+			dief("failed to query changes: %v", err)
+		}
+		for _, c := range changes {
+			if _, ok := byChangeID[c.ChangeID]; !ok {
+				byChangeID[c.ChangeID] = c
+			}
+		}
+	}
+
+	localPatchSet := map[int]int{}
+	for _, ref := range nonBlankLines(cmdOutput("git", "for-each-ref", "--format=%(refname)", "refs/remotes/gerrit/cl/")) {
+		n, ps, ok := strings.Cut(strings.TrimPrefix(ref, "refs/remotes/gerrit/cl/"), "/")
+		number, err1 := strconv.Atoi(n)
+		patchSet, err2 := strconv.Atoi(ps)
+		if ok && err1 == nil && err2 == nil {
+			localPatchSet[number] = max(localPatchSet[number], patchSet)
+		}
+	}
+
+	for _, r := range revs {
+		short, description, _ := strings.Cut(r.summary, " ")
+		c := byChangeID[r.changeID]
+		if c == nil {
+			fmt.Fprintf(stdout(), "%s not mailed: %s\n", short, description)
+			continue
+		}
+		fields := []string{short, "CL " + strconv.Itoa(c.Number)}
+		local := "-"
+		if ps, ok := localPatchSet[c.Number]; ok {
+			local = strconv.Itoa(ps)
+		}
+		latest := c.Revisions[c.CurrentRevision].Number
+		fields = append(fields, fmt.Sprintf("ps %s/%d", local, latest))
+		switch {
+		case c.Status == "MERGED":
+			fields = append(fields, "merged")
+		case c.Status == "ABANDONED":
+			fields = append(fields, "abandoned")
+		case r.commit == c.CurrentRevision:
+			fields = append(fields, "up to date")
+		default:
+			if rev, ok := c.Revisions[r.commit]; ok {
+				fields = append(fields, fmt.Sprintf("behind (ps %d)", rev.Number))
+			} else {
+				fields = append(fields, "modified")
+			}
+		}
+		fields = append(fields, labelVotes(c.Labels)...)
+		if c.UnresolvedCommentCount > 0 {
+			fields = append(fields, fmt.Sprintf("%d unresolved", c.UnresolvedCommentCount))
+		}
+		if c.Submittable {
+			fields = append(fields, "submittable")
+		}
+		fmt.Fprintf(stdout(), "%s: %s\n", strings.Join(fields, " "), description)
+	}
+}
+
+// labelVotes formats the non-zero votes on labels like "Code-Review+2",
+// sorted by label name.
+func labelVotes(labels map[string]*GerritLabel) []string {
+	var votes []string
+	for name, l := range labels {
+		if v := l.Vote(); v != 0 {
+			votes = append(votes, fmt.Sprintf("%s%+d", name, v))
+		}
+	}
+	sort.Strings(votes)
+	return votes
+}
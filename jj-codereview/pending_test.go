@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLabelVotes(t *testing.T) {
+	var labels map[string]*GerritLabel
+	if err := json.Unmarshal([]byte(`{
+		"Code-Review": {"all": [{"value": 1, "name": "A"}, {"value": 2, "name": "B"}]},
+		"TryBot-Result": {"all": [{"value": 1, "name": "Bot"}, {"value": -1, "name": "Bot2"}]},
+		"Auto-Submit": {"all": [{"value": 0, "name": "A"}]},
+		"Commit-Queue": {}
+	}`), &labels); err != nil {
+		t.Fatal(err)
+	}
+	got := labelVotes(labels)
+	want := []string{"Code-Review+2", "TryBot-Result-1"}
+	if !slices.Equal(got, want) {
+		t.Errorf("labelVotes = %v, want %v", got, want)
+	}
+}
+
+func TestPending(t *testing.T) {
+	gt := newJJTest(t)
+	writeFile(t, filepath.Join(gt.repo, "a.go"), "package a\n")
+	gt.jj("describe", "-m", "a: add package\n\nChange-Id: I0123456789abcdef0123456789abcdef0123456a\n")
+	gt.jj("new")
+	writeFile(t, filepath.Join(gt.repo, "b.go"), "package a\n")
+	gt.jj("describe", "-m", "a: add b\n\nChange-Id: I0123456789abcdef0123456789abcdef0123456b\n")
+	gt.jj("new")
+	writeFile(t, filepath.Join(gt.repo, "c.go"), "package a\n")
+	gt.jj("describe", "-m", "a: add c")
+	gt.jj("new")
+	testMain(t, "mail", "@--")
+
+	gt.gerrit.vote(1, "Code-Review", "Reviewer", 2)
+	gt.gerrit.update(1, func(c *fakeChange) { c.Submittable = true })
+	gt.gerrit.addComment(2, 1, "b.go", 1, "Reviewer", "Why?", true)
+	gt.jj("edit", "description(substring:'a: add b')")
+	writeFile(t, filepath.Join(gt.repo, "b.go"), "package a // b\n")
+	gt.jj("edit", "description(substring:'a: add c')")
+
+	stdout, _ := testMain(t, "pending")
+	lines := lines(stdout)
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output:\n%s", stdout)
+	}
+	for i, want := range []string{
+		" not mailed: a: add c",
+		" CL 2 ps 1/1 modified 1 unresolved: a: add b",
+		" CL 1 ps 1/1 up to date Code-Review+2 submittable: a: add package",
+	} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("line %d = %q, want suffix %q", i, lines[i], want)
+		}
+	}
+
+	// Submit CL 1 like Gerrit would, as a new commit on master.
+	mailed := gt.git("rev-parse", "refs/remotes/gerrit/cl/1/1")
+	other := filepath.Join(gt.root, "submit")
+	gt.gitDir(gt.root, "clone", "-q", gt.origin, other)
+	gt.gitDir(other, "cherry-pick", mailed)
+	gt.gitDir(other, "commit", "-q", "--amend", "--no-edit", "--reset-author")
+	gt.gitDir(other, "push", "-q", "origin", "HEAD:master")
+	merged := gt.gitDir(other, "rev-parse", "HEAD")
+	gt.gerrit.update(1, func(c *fakeChange) { c.Status = "MERGED" })
+	gt.jj("git", "fetch")
+
+	stdout, _ = testMain(t, "pending", "-rebase")
+	if !strings.Contains(stdout, " CL 1 ps 1/1 merged Code-Review+2 submittable: a: add package\n") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+	if got := gt.jj("log", "--no-graph", "-r", "description(substring:'a: add b')-", "-T", "commit_id"); got != merged {
+		t.Errorf("child was not rebased on the merged commit, parent is %s", got)
+	}
+}
@@ -98,7 +98,7 @@ on the current patchset. resolved defaults to true.
 			printf("would stage draft on %s: %q", target, r.Message)
 			continue
 		}
-		url := fmt.Sprintf("https://go-review.googlesource.com/a/changes/%d/revisions/%d/drafts", c.Number, patchSet)
+		url := fmt.Sprintf("%s/a/changes/%d/revisions/%d/drafts", gerritURL(), c.Number, patchSet)
 		if _, err := authedRequest("PUT", url, draft); err != nil {
 			dief("failed to stage draft on %s: %v", target, err)
 		}
@@ -132,12 +132,14 @@ type GerritComment struct {
 		Name  string `json:"name"`
 		Email string `json:"email"`
 	} `json:"author"`
+	InReplyTo  string `json:"in_reply_to,omitempty"`
 	Message    string `json:"message"`
+	Updated    string `json:"updated"`
 	Unresolved bool   `json:"unresolved"`
 }
 
 func GetComments(number int) (map[string]*GerritComment, error) {
-	url := fmt.Sprintf("https://go-review.googlesource.com/changes/%d/comments", number)
+	url := fmt.Sprintf("%s/changes/%d/comments", gerritURL(), number)
 	resp, err := client.Get(url)
 	if err != nil {
 		return nil, err
@@ -171,9 +173,9 @@ var gerritCredentials struct {
 	approved           bool
 }
 
-// fillGerritCredentials asks git-credential for a go.googlesource.com token,
-// which is also valid for go-review.googlesource.com. It may go through an
-// interactive git-credential-oauth browser flow if no token is cached.
+// fillGerritCredentials asks git-credential for a token for the Gerrit server
+// (see gerritCredentialURL). It may go through an interactive
+// git-credential-oauth browser flow if no token is cached.
 func fillGerritCredentials() {
 	if gerritCredentials.password != "" {
 		return
@@ -246,7 +248,8 @@ func authedRequest(method, url string, body any) ([]byte, error) {
 
 func newCredentialCommand(action string) *exec.Cmd {
 	cmd := exec.Command("git", "credential", action)
-	attrs := "protocol=https\nhost=go.googlesource.com\n"
+	u := gerritCredentialURL()
+	attrs := "protocol=" + u.Scheme + "\nhost=" + u.Host + "\n"
 	if action != "fill" {
 		attrs += "username=" + gerritCredentials.username + "\n"
 		attrs += "password=" + gerritCredentials.password + "\n"
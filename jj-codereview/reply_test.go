@@ -0,0 +1,45 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReply(t *testing.T) {
+	gt := newGitTest(t)
+	gt.pushCL("hello.go", "package hello\n", "hello: add package", "I0123456789abcdef0123456789abcdef01234567")
+	gt.pushCL("hello.go", "package hello // v2\n", "hello: add package", "I0123456789abcdef0123456789abcdef01234567")
+	id := gt.gerrit.addComment(1, 1, "hello.go", 1, "Reviewer", "Needs a doc comment.", true)
+
+	replies := filepath.Join(gt.root, "replies.json")
+	writeFile(t, replies, `[
+		{"id": "`+id+`", "message": "Done"},
+		{"message": "PTAL", "resolved": false}
+	]`)
+	_, stderr := testMain(t, "reply", "-f", replies, "1")
+	if !strings.Contains(stderr, "staged 2 draft(s) on CL 1") {
+		t.Errorf("unexpected output:\n%s", stderr)
+	}
+
+	c := gt.gerrit.change(1)
+	if !gt.gerrit.authed {
+		t.Errorf("drafts were not staged with credentials")
+	}
+	if len(c.Drafts) != 2 {
+		t.Fatalf("got %d drafts, want 2", len(c.Drafts))
+	}
+	if d := c.Drafts[0]; d.PatchSet != 1 || d.Path != "hello.go" || d.Line != 1 ||
+		d.InReplyTo != id || d.Message != "Done" || d.Unresolved {
+		t.Errorf("unexpected reply draft: %+v %+v", d, d.CommentInput)
+	}
+	if d := c.Drafts[1]; d.PatchSet != 2 || d.Path != patchSetLevel ||
+		d.InReplyTo != "" || d.Message != "PTAL" || !d.Unresolved {
+		t.Errorf("unexpected patchset level draft: %+v %+v", d, d.CommentInput)
+	}
+
+	writeFile(t, replies, `[{"id": "nope", "message": "Done"}]`)
+	if stderr := testMainDied(t, "reply", "-f", replies, "1"); !strings.Contains(stderr, "comment nope not found") {
+		t.Errorf("unexpected output:\n%s", stderr)
+	}
+}
@@ -52,8 +52,16 @@ Available commands:
 	mail [-r reviewer,...] [-cc mail,...] [options] [revisions]
 	fetch <query>
 	reply [-f file] <query>
+	comments [-a] [-json] [-r revision] [query]
+	pending [-rebase]
 	cleanup
 
+The Gerrit server and the remote to push to are read from the "gerrit" and
+"remote" keys of the codereview.cfg file at the root of the repository, and
+can be overridden with the codereview.gerrit and codereview.remote jj settings.
+The remote defaults to origin, and the Gerrit server to the -review host of a
+googlesource.com remote.
+
 `
 
 func main() {
@@ -81,6 +89,10 @@ func main() {
 		cmd = cmdFetch
 	case "reply":
 		cmd = cmdReply
+	case "comments":
+		cmd = cmdComments
+	case "pending":
+		cmd = cmdPending
 	}
 
 	cmd(args)
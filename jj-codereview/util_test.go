@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitTest is a temporary setup with a bare repository served by a fake
+// Gerrit, and a clone of it to run jj-codereview in.
+type gitTest struct {
+	t      *testing.T
+	root   string
+	origin string // bare repository, the origin and push remote
+	repo   string // clone of origin
+	gerrit *fakeGerrit
+}
+
+func newGitTest(t *testing.T) *gitTest {
+	root := t.TempDir()
+	gt := &gitTest{
+		t:      t,
+		root:   root,
+		origin: filepath.Join(root, "origin.git"),
+		repo:   filepath.Join(root, "repo"),
+	}
+
+	// Isolate git and jj from the user configuration.
+	home := filepath.Join(root, "home")
+	if err := os.Mkdir(home, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	writeFile(t, filepath.Join(home, ".gitconfig"), fmt.Sprintf(`
+[user]
+	name = Gopher
+	email = gopher@example.com
+[init]
+	defaultBranch = master
+[credential]
+	helper = "!f() { echo username=%s; echo password=%s; }; f"
+`, fakeGerritUser, fakeGerritPassword))
+	jjConfig := filepath.Join(home, "jj.toml")
+	writeFile(t, jjConfig, `
+[user]
+name = "Gopher"
+email = "gopher@example.com"
+
+[revset-aliases]
+"private()" = "description(glob:'wip:*')"
+`)
+	t.Setenv("JJ_CONFIG", jjConfig)
+
+	gt.gitDir(root, "init", "-q", "--bare", gt.origin)
+	gt.gitDir(gt.origin, "config", "receive.advertisePushOptions", "true")
+	gt.gerrit = newFakeGerrit(t, gt.origin)
+
+	gt.gitDir(root, "clone", "-q", gt.origin, gt.repo)
+	writeFile(t, filepath.Join(gt.repo, "codereview.cfg"), "gerrit: "+gt.gerrit.URL()+"\n")
+	writeFile(t, filepath.Join(gt.repo, "README"), "hello\n")
+	gt.git("add", ".")
+	gt.git("commit", "-q", "-m", "initial commit")
+	gt.git("push", "-q", "origin", "HEAD:master")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(gt.repo); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	return gt
+}
+
+// newJJTest is like newGitTest, but makes the clone a colocated jj repository.
+func newJJTest(t *testing.T) *gitTest {
+	if _, err := exec.LookPath("jj"); err != nil {
+		t.Skip("jj not found in PATH")
+	}
+	gt := newGitTest(t)
+	gt.jj("git", "init", "--colocate")
+	return gt
+}
+
+// newJJTestRemote is like newJJTest, but the bare repository is the remote
+// named remote, configured as the push remote, and origin is an unrelated
+// repository that must be ignored.
+func newJJTestRemote(t *testing.T, remote string) *gitTest {
+	if _, err := exec.LookPath("jj"); err != nil {
+		t.Skip("jj not found in PATH")
+	}
+	gt := newGitTest(t)
+	gt.setRemote(remote)
+	gt.jj("git", "init", "--colocate")
+	return gt
+}
+
+// setRemote renames the origin remote to remote and makes it the push remote,
+// and adds an unrelated repository as origin, with its own master branch.
+func (gt *gitTest) setRemote(remote string) {
+	gt.t.Helper()
+	gt.git("remote", "rename", "origin", remote)
+	writeFile(gt.t, filepath.Join(gt.repo, "codereview.cfg"),
+		"gerrit: "+gt.gerrit.URL()+"\nremote: "+remote+"\n")
+
+	decoy := filepath.Join(gt.root, "decoy")
+	gt.gitDir(gt.root, "init", "-q", decoy)
+	writeFile(gt.t, filepath.Join(decoy, "DECOY"), "unrelated\n")
+	gt.gitDir(decoy, "add", ".")
+	gt.gitDir(decoy, "commit", "-q", "-m", "unrelated commit\n\nChange-Id: Idecoydecoydecoydecoydecoydecoydecoydeco")
+	gt.git("remote", "add", "origin", decoy)
+	gt.git("fetch", "-q", "origin")
+}
+
+func (gt *gitTest) gitDir(dir string, args ...string) string {
+	gt.t.Helper()
+	return gt.cmd(dir, "git", args...)
+}
+
+func (gt *gitTest) git(args ...string) string {
+	gt.t.Helper()
+	return gt.cmd(gt.repo, "git", args...)
+}
+
+func (gt *gitTest) jj(args ...string) string {
+	gt.t.Helper()
+	return gt.cmd(gt.repo, "jj", args...)
+}
+
+func (gt *gitTest) cmd(dir, command string, args ...string) string {
+	gt.t.Helper()
+	cmd := exec.Command(command, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		gt.t.Fatalf("%s %s: %v\n%s", command, strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// pushCL makes a commit on top of origin/master in a separate clone, and
+// pushes it for review, like another user would.
+func (gt *gitTest) pushCL(file, content, message, changeID string) string {
+	gt.t.Helper()
+	other := filepath.Join(gt.root, "other")
+	if _, err := os.Stat(other); err != nil {
+		gt.gitDir(gt.root, "clone", "-q", gt.origin, other)
+	}
+	gt.gitDir(other, "fetch", "-q", "origin")
+	gt.gitDir(other, "checkout", "-q", "--detach", "origin/master")
+	writeFile(gt.t, filepath.Join(other, file), content)
+	gt.gitDir(other, "add", file)
+	gt.gitDir(other, "commit", "-q", "-m", message+"\n\nChange-Id: "+changeID)
+	gt.gitDir(other, "push", "-q", "origin", "HEAD:refs/for/master")
+	gt.gerrit.received()
+	return gt.gitDir(other, "rev-parse", "HEAD")
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var errDied = errors.New("died")
+
+// runMain runs jj-codereview with args in the current directory, returning
+// what it printed and whether it exited.
+func runMain(t *testing.T, args ...string) (stdout, stderr string, died bool) {
+	t.Helper()
+	t.Logf("%s %s", progName, strings.Join(args, " "))
+	*noRun = false
+	*verbose = 0
+	cachedConfig = nil
+	stdoutTrap = new(bytes.Buffer)
+	stderrTrap = new(bytes.Buffer)
+	exitTrap = func() { panic(errDied) }
+	defer func() {
+		stdout, stderr = stdoutTrap.String(), stderrTrap.String()
+		stdoutTrap, stderrTrap, exitTrap = nil, nil, nil
+		if r := recover(); r != nil {
+			if r != errDied {
+				panic(r)
+			}
+			died = true
+		}
+	}()
+	os.Args = append([]string{progName}, args...)
+	main()
+	return
+}
+
+// testMain runs jj-codereview with args, failing the test if it dies.
+func testMain(t *testing.T, args ...string) (stdout, stderr string) {
+	t.Helper()
+	stdout, stderr, died := runMain(t, args...)
+	if died {
+		t.Fatalf("%s %s died:\n%s%s", progName, strings.Join(args, " "), stdout, stderr)
+	}
+	return stdout, stderr
+}
+
+// testMainDied runs jj-codereview with args, failing the test if it doesn't
+// die, and returns its standard error.
+func testMainDied(t *testing.T, args ...string) string {
+	t.Helper()
+	stdout, stderr, died := runMain(t, args...)
+	if !died {
+		t.Fatalf("%s %s did not die:\n%s%s", progName, strings.Join(args, " "), stdout, stderr)
+	}
+	return stderr
+}
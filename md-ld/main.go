@@ -25,8 +25,25 @@
 //
 //	[markdown daringfireball]:
 //
-// With -llm, the document and the placeholders are handed to claude, which
-// looks the links up and fills the placeholders in.
+// md-ld fills in the links it can find deterministically, trying in order
+//
+//   - the -refs file of reference definitions, which can be maintained by
+//     hand, and where links found in the corpus or by claude are remembered;
+//   - Go import paths like golang.org/x/crypto/ssh or `crypto/tls.Config`,
+//     linked to pkg.go.dev;
+//   - RFCs like "RFC 8446" or "RFC 8446, Section 4.2", linked to
+//     rfc-editor.org;
+//   - Go issues and CLs like golang/go#N, go.dev/issue/N, or CL N, and
+//     GitHub owner/repo#N issues;
+//   - reference definitions and inline links with the same name in the
+//     Markdown files of the -corpus directory, like past posts.
+//
+// With -llm, the document and the remaining placeholders are handed to
+// claude, which looks the links up and fills the placeholders in.
+//
+// The definitions are printed to standard output, in the order of the
+// document, and which resolver filled each of them is reported on standard
+// error.
 package main
 
 import (
@@ -35,13 +52,17 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/russross/blackfriday"
 )
 
 func main() {
-	llmFlag := flag.Bool("llm", false, "ask claude to look up and fill in the links")
+	llmFlag := flag.Bool("llm", false, "ask claude to look up and fill in the links that can't be resolved locally")
+	refsFlag := flag.String("refs", defaultReferencesFile(), "`file` of reference definitions to reuse, and to remember new links in")
+	corpusFlag := flag.String("corpus", "", "resolve references from the links in the Markdown files under `dir`")
 	flag.Parse()
 
 	in, err := ioutil.ReadAll(os.Stdin)
@@ -73,20 +94,85 @@ func main() {
 			ReferenceOverride: blackfriday.ReferenceOverrideFunc(f),
 		})
 
-	if !*llmFlag {
-		for _, reference := range references {
-			fmt.Printf("[%s]: \n", reference)
-		}
-		return
-	}
-
 	if len(references) == 0 {
 		return
 	}
-	if err := fillIn(string(in), references); err != nil {
+
+	refs, err := referencesResolver(*refsFlag)
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	resolvers := []resolver{refs, goResolver(), rfcResolver(), issueResolver()}
+	if *corpusFlag != "" {
+		corpus, err := corpusResolver(*corpusFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		resolvers = append(resolvers, corpus)
+	}
+	urls, sources := resolveAll(references, resolvers)
+
+	var leftovers []string
+	for _, reference := range references {
+		if urls[reference] == "" {
+			leftovers = append(leftovers, reference)
+		}
+	}
+	if *llmFlag && len(leftovers) > 0 {
+		out, err := fillIn(string(in), leftovers)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defs, _, err := parseDefinitions(strings.NewReader(out))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, reference := range leftovers {
+			if url := defs[normalizeReference(reference)]; url != "" {
+				urls[reference] = url
+				sources[reference] = "claude"
+			}
+		}
+	}
+
+	// Remember the links that are not cheap or stable to find again.
+	var remembered []string
+	for _, reference := range references {
+		if sources[reference] == "corpus" || sources[reference] == "claude" {
+			remembered = append(remembered, reference)
+		}
+	}
+	if *refsFlag != "" {
+		if err := remember(*refsFlag, remembered, urls); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	report := tabwriter.NewWriter(os.Stderr, 0, 8, 1, ' ', 0)
+	for _, reference := range references {
+		fmt.Printf("[%s]: %s\n", reference, urls[reference])
+		source := sources[reference]
+		if source == "" {
+			source = "unresolved"
+		}
+		fmt.Fprintf(report, "%s:\t[%s]\n", source, reference)
+	}
+	report.Flush()
+}
+
+// defaultReferencesFile returns the path of the references file in the user
+// configuration directory, like ~/.config/md-ld/references.md.
+func defaultReferencesFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "md-ld", "references.md")
 }
 
 const prompt = `You are given a Markdown document and the list of its link
@@ -117,11 +203,11 @@ Here is the document:
 %s
 `
 
-// fillIn runs claude on the document, and writes the definitions it produces to
-// standard output. claude runs in an empty temporary directory, with no access
+// fillIn runs claude on the document, and returns the definitions it
+// produces. claude runs in an empty temporary directory, with no access
 // to the file system, so that the directory md-ld was invoked in has no effect
 // on the result and is left untouched.
-func fillIn(document string, references []string) error {
+func fillIn(document string, references []string) (string, error) {
 	var list strings.Builder
 	for _, reference := range references {
 		fmt.Fprintf(&list, "[%s]: \n", reference)
@@ -129,7 +215,7 @@ func fillIn(document string, references []string) error {
 
 	dir, err := ioutil.TempDir("", "md-ld")
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer os.RemoveAll(dir)
 
@@ -144,10 +230,10 @@ func fillIn(document string, references []string) error {
 		"--no-session-persistence")
 	cmd.Dir = dir
 	cmd.Stdin = strings.NewReader(fmt.Sprintf(prompt, list.String(), document))
-	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("claude: %v", err)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("claude: %v", err)
 	}
-	return nil
+	return string(out), nil
 }
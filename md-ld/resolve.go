@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A resolver looks up the URL of a link reference, returning "" if it doesn't
+// know it.
+type resolver struct {
+	name    string
+	resolve func(reference string) string
+}
+
+// resolveAll tries the resolvers in order for each reference, and returns the
+// URLs it found, and the name of the resolver that found each of them.
+func resolveAll(references []string, resolvers []resolver) (urls, sources map[string]string) {
+	urls = make(map[string]string)
+	sources = make(map[string]string)
+	for _, reference := range references {
+		for _, r := range resolvers {
+			if url := r.resolve(reference); url != "" {
+				urls[reference] = url
+				sources[reference] = r.name
+				break
+			}
+		}
+	}
+	return urls, sources
+}
+
+// normalizeReference returns the key Markdown matches references by: case
+// insensitive, and with whitespace collapsed.
+func normalizeReference(reference string) string {
+	return strings.ToLower(strings.Join(strings.Fields(reference), " "))
+}
+
+var definitionRe = regexp.MustCompile(`^ {0,3}\[([^\]]+)\]:[ \t]*<?([^\s>]*)>?`)
+
+// parseDefinitions returns the reference definitions in a Markdown document,
+// like
+//
+//	[reference name]: https://example.com/page
+//
+// keyed by normalized reference, and in order. Later definitions of the same
+// reference are ignored, like Markdown does. Empty definitions are skipped.
+func parseDefinitions(r io.Reader) (map[string]string, []string, error) {
+	defs := make(map[string]string)
+	var order []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		m := definitionRe.FindStringSubmatch(s.Text())
+		if m == nil || m[2] == "" {
+			continue
+		}
+		key := normalizeReference(m[1])
+		if _, ok := defs[key]; ok {
+			continue
+		}
+		defs[key] = m[2]
+		order = append(order, key)
+	}
+	return defs, order, s.Err()
+}
+
+// referencesResolver resolves references from a user-maintained file of
+// reference definitions. A missing file is treated as empty.
+func referencesResolver(path string) (resolver, error) {
+	defs := make(map[string]string)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return resolver{}, err
+		}
+		if err == nil {
+			defs, _, err = parseDefinitions(f)
+			f.Close()
+			if err != nil {
+				return resolver{}, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	return resolver{name: "references", resolve: func(reference string) string {
+		return defs[normalizeReference(reference)]
+	}}, nil
+}
+
+// remember appends the definitions of references to the references file at
+// path, creating it if needed.
+func remember(path string, references []string, urls map[string]string) error {
+	if len(references) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	for _, reference := range references {
+		if _, err := fmt.Fprintf(f, "[%s]: %s\n", reference, urls[reference]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+var (
+	goPackageRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._~-]*(?:/[A-Za-z0-9._~-]+)*$`)
+	goSymbolRe  = regexp.MustCompile(`^[A-Z][A-Za-z0-9_]*(?:\.[A-Za-z0-9_]+)?$`)
+)
+
+// splitGoSymbol splits a reference like crypto/tls.Config.Clone into an import
+// path and an exported symbol, which starts after the first dot in the last
+// path element that is followed by an upper case letter.
+func splitGoSymbol(ref string) (path, symbol string) {
+	last := strings.LastIndex(ref, "/") + 1
+	for i := last; i < len(ref)-1; i++ {
+		if ref[i] == '.' && ref[i+1] >= 'A' && ref[i+1] <= 'Z' {
+			return ref[:i], ref[i+1:]
+		}
+	}
+	return ref, ""
+}
+
+// goResolver resolves Go import paths to pkg.go.dev, like
+//
+//	golang.org/x/crypto/ssh -> https://pkg.go.dev/golang.org/x/crypto/ssh
+//	`crypto/tls.Config`     -> https://pkg.go.dev/crypto/tls#Config
+//
+// To avoid matching common words and websites, only standard library paths
+// with a slash and golang.org/x/ paths are resolved on their own. Other
+// import paths, including single-element standard library ones like `fmt`,
+// need to be in backticks. Paths with numeric elements, like go.dev/issue/N,
+// are never import paths.
+func goResolver() resolver {
+	return resolver{name: "pkg.go.dev", resolve: func(reference string) string {
+		ref := reference
+		backticks := strings.HasPrefix(ref, "`") && strings.HasSuffix(ref, "`") && len(ref) > 2
+		if backticks {
+			ref = ref[1 : len(ref)-1]
+		}
+		path, symbol := splitGoSymbol(ref)
+		if !goPackageRe.MatchString(path) || symbol != "" && !goSymbolRe.MatchString(symbol) {
+			return ""
+		}
+		elems := strings.Split(path, "/")
+		for _, e := range elems {
+			if strings.Trim(e, "0123456789") == "" {
+				return ""
+			}
+		}
+		switch {
+		case strings.Contains(elems[0], "."):
+			if len(elems) < 2 || !backticks && !strings.HasPrefix(path, "golang.org/x/") {
+				return ""
+			}
+		case !isStdPackage(path), len(elems) == 1 && !backticks:
+			return ""
+		}
+		url := "https://pkg.go.dev/" + path
+		if symbol != "" {
+			url += "#" + symbol
+		}
+		return url
+	}}
+}
+
+// isStdPackage reports whether path is a package of the local Go standard
+// library.
+func isStdPackage(path string) bool {
+	if strings.Contains(path, "internal") || strings.HasPrefix(path, "vendor/") {
+		return false
+	}
+	p, err := build.Default.Import(path, "", build.FindOnly)
+	return err == nil && p.Goroot
+}
+
+var rfcRe = regexp.MustCompile(`(?i)^RFC[ -]?(\d+)(?:,? (?:section|§) ?(\d+(?:\.\d+)*|[A-Z](?:\.\d+)*))?$`)
+
+// rfcResolver resolves RFC references to rfc-editor.org, like
+//
+//	RFC 8446              -> https://www.rfc-editor.org/rfc/rfc8446.html
+//	RFC 8446, Section 4.2 -> https://www.rfc-editor.org/rfc/rfc8446.html#section-4.2
+func rfcResolver() resolver {
+	return resolver{name: "rfc-editor.org", resolve: func(reference string) string {
+		m := rfcRe.FindStringSubmatch(strings.Join(strings.Fields(reference), " "))
+		if m == nil {
+			return ""
+		}
+		url := "https://www.rfc-editor.org/rfc/rfc" + strings.TrimLeft(m[1], "0") + ".html"
+		switch section := m[2]; {
+		case section == "":
+		case section[0] >= '0' && section[0] <= '9':
+			url += "#section-" + section
+		default:
+			url += "#appendix-" + strings.ToUpper(section)
+		}
+		return url
+	}}
+}
+
+var issuePatterns = []struct {
+	re  *regexp.Regexp
+	url string
+}{
+	{regexp.MustCompile(`^(?:https?://)?(?:go\.dev|golang\.org)/(issue|cl)/(\d+)$`), "https://go.dev/$1/$2"},
+	{regexp.MustCompile(`^golang/go#(\d+)$`), "https://go.dev/issue/$1"},
+	{regexp.MustCompile(`^(?i:go issue) #?(\d+)$`), "https://go.dev/issue/$1"},
+	{regexp.MustCompile(`^CL ?(\d+)$`), "https://go.dev/cl/$1"},
+	{regexp.MustCompile(`^([A-Za-z0-9-]+/[A-Za-z0-9._-]+)#(\d+)$`), "https://github.com/$1/issues/$2"},
+}
+
+// issueResolver resolves Go issue and CL references, like go.dev/issue/N,
+// golang/go#N, Go issue N, and CL N, and GitHub owner/repo#N issues.
+func issueResolver() resolver {
+	return resolver{name: "issue", resolve: func(reference string) string {
+		ref := strings.Join(strings.Fields(reference), " ")
+		for _, p := range issuePatterns {
+			if m := p.re.FindStringSubmatchIndex(ref); m != nil {
+				return string(p.re.ExpandString(nil, p.url, ref, m))
+			}
+		}
+		return ""
+	}}
+}
+
+var inlineLinkRe = regexp.MustCompile(`\[([^\[\]]+)\]\((https?://[^\s()]+)\)`)
+
+// corpusResolver resolves references from the links in the Markdown files
+// under dir: reference definitions with the same name, or inline links with
+// the same text. If a reference is linked to different URLs, the most common
+// one wins, and then the first one found.
+func corpusResolver(dir string) (resolver, error) {
+	counts := make(map[string]map[string]int)
+	var order []string
+	add := func(reference, url string) {
+		key := normalizeReference(reference)
+		if counts[key] == nil {
+			counts[key] = make(map[string]int)
+		}
+		if counts[key][url] == 0 {
+			order = append(order, key+"\x00"+url)
+		}
+		counts[key][url]++
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(path), ".md") {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		defs, defOrder, err := parseDefinitions(strings.NewReader(string(content)))
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		for _, key := range defOrder {
+			add(key, defs[key])
+		}
+		for _, m := range inlineLinkRe.FindAllStringSubmatch(string(content), -1) {
+			add(m[1], m[2])
+		}
+		return nil
+	})
+	if err != nil {
+		return resolver{}, err
+	}
+
+	best := make(map[string]string)
+	for _, entry := range order {
+		i := strings.IndexByte(entry, 0)
+		key, url := entry[:i], entry[i+1:]
+		if b, ok := best[key]; !ok || counts[key][url] > counts[key][b] {
+			best[key] = url
+		}
+	}
+	return resolver{name: "corpus", resolve: func(reference string) string {
+		return best[normalizeReference(reference)]
+	}}, nil
+}
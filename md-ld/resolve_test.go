@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "md-ld")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	refsFile := filepath.Join(dir, "references.md")
+	if err := ioutil.WriteFile(refsFile, []byte("[My Blog]: https://words.filippo.io\n[empty]:\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	corpusDir := filepath.Join(dir, "corpus")
+	if err := os.MkdirAll(filepath.Join(corpusDir, ".git"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"a.md":       "[age][] and [the spec](https://example.com/spec)\n\n[age]: https://age-encryption.org\n",
+		"b.md":       "[the spec](https://example.com/spec) [the spec](https://example.com/old)\n",
+		".git/c.md":  "[hidden]: https://example.com/hidden\n",
+		"notes.txt":  "[txt]: https://example.com/txt\n",
+		"sub/d.md":   "[nested][]\n\n  [nested]: <https://example.com/nested> \"Title\"\n",
+		"sub/e.MD":   "[RFC 8446]: https://example.com/not-rfc-editor\n",
+		"sub/f.html": "<a href=\"https://example.com\">html</a>\n",
+	} {
+		path := filepath.Join(corpusDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refs, err := referencesResolver(refsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := corpusResolver(corpusDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvers := []resolver{refs, goResolver(), rfcResolver(), issueResolver(), corpus}
+
+	tests := []struct {
+		reference, url, source string
+	}{
+		{"my  blog", "https://words.filippo.io", "references"},
+		{"empty", "", ""},
+		{"crypto/tls", "https://pkg.go.dev/crypto/tls", "pkg.go.dev"},
+		{"`crypto/tls.Config.Clone`", "https://pkg.go.dev/crypto/tls#Config.Clone", "pkg.go.dev"},
+		{"`fmt`", "https://pkg.go.dev/fmt", "pkg.go.dev"},
+		{"fmt", "", ""},
+		{"golang.org/x/crypto/ssh", "https://pkg.go.dev/golang.org/x/crypto/ssh", "pkg.go.dev"},
+		{"`filippo.io/age`", "https://pkg.go.dev/filippo.io/age", "pkg.go.dev"},
+		{"filippo.io/age", "", ""},
+		{"`notapackage/foo`", "", ""},
+		{"RFC 8446", "https://www.rfc-editor.org/rfc/rfc8446.html", "rfc-editor.org"},
+		{"rfc5280, section 4.2.1.9", "https://www.rfc-editor.org/rfc/rfc5280.html#section-4.2.1.9", "rfc-editor.org"},
+		{"RFC 8446 § B.3", "https://www.rfc-editor.org/rfc/rfc8446.html#appendix-B.3", "rfc-editor.org"},
+		{"go.dev/issue/12345", "https://go.dev/issue/12345", "issue"},
+		{"golang.org/cl/678", "https://go.dev/cl/678", "issue"},
+		{"golang/go#42", "https://go.dev/issue/42", "issue"},
+		{"Go issue #42", "https://go.dev/issue/42", "issue"},
+		{"CL 678", "https://go.dev/cl/678", "issue"},
+		{"FiloSottile/age#10", "https://github.com/FiloSottile/age/issues/10", "issue"},
+		{"Age", "https://age-encryption.org", "corpus"},
+		{"the spec", "https://example.com/spec", "corpus"},
+		{"nested", "https://example.com/nested", "corpus"},
+		{"hidden", "", ""},
+		{"txt", "", ""},
+		{"something else", "", ""},
+	}
+	var references []string
+	for _, tt := range tests {
+		references = append(references, tt.reference)
+	}
+	urls, sources := resolveAll(references, resolvers)
+	for _, tt := range tests {
+		if urls[tt.reference] != tt.url || sources[tt.reference] != tt.source {
+			t.Errorf("%q: got %q from %q, want %q from %q", tt.reference,
+				urls[tt.reference], sources[tt.reference], tt.url, tt.source)
+		}
+	}
+
+	if err := remember(refsFile, []string{"Age"}, urls); err != nil {
+		t.Fatal(err)
+	}
+	refs, err = referencesResolver(refsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := refs.resolve("age"); got != "https://age-encryption.org" {
+		t.Errorf("remembered reference resolved to %q", got)
+	}
+}
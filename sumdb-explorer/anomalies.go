@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+	"golang.org/x/sync/errgroup"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// Anomaly kinds, stored in the kind column of the anomalies table.
+const (
+	// anomalyCaseCollision is a path that differs only in case from the path
+	// of an earlier entry, stored in the detail column. The two can't both be
+	// extracted on case-insensitive file systems.
+	anomalyCaseCollision = "case-collision"
+	// anomalyLateTag is a tagged version that was first published long after
+	// an earlier entry proved the tag existed, as the base of a pseudo-version.
+	anomalyLateTag = "late-tag"
+	// anomalyGoModPath is a version whose go.mod declares a different module
+	// path, stored in the detail column. The go command rejects these.
+	anomalyGoModPath = "gomod-path"
+	// anomalyRetracted is a version retracted by the go.mod of another version
+	// of the same module. The detail column has the retracting version.
+	anomalyRetracted = "retracted"
+)
+
+// lateThreshold is how long after a tag is known to exist its publication
+// is considered an anomaly.
+const lateThreshold = 365 * 24 * time.Hour
+
+// pseudoWindow is how many recent pseudo-versions are used to estimate when
+// an entry was added to the log, which is not recorded anywhere.
+const pseudoWindow = 101
+
+// anomalyDetector checks each new entry against the rest of the log. It keeps
+// the timestamps of the most recent pseudo-versions, whose median is a robust
+// estimate of the time the current entry was published.
+type anomalyDetector struct {
+	pseudoTimes []time.Time
+}
+
+// newAnomalyDetector loads the state of the detector from the entries before
+// index start.
+func newAnomalyDetector(db *sqlite.Conn, start int64) (*anomalyDetector, error) {
+	d := &anomalyDetector{}
+	var versions []string
+	if err := sqlitex.Execute(db, `
+		SELECT version FROM versions WHERE idx < :start AND version GLOB '*-*'
+		ORDER BY idx DESC LIMIT :limit
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":start": start,
+			":limit": pseudoWindow * 10,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			versions = append(versions, stmt.ColumnText(0))
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	slices.Reverse(versions)
+	for _, v := range versions {
+		d.observe(v)
+	}
+	return d, nil
+}
+
+func (d *anomalyDetector) observe(version string) {
+	if !module.IsPseudoVersion(version) {
+		return
+	}
+	t, err := module.PseudoVersionTime(version)
+	if err != nil {
+		return
+	}
+	d.pseudoTimes = append(d.pseudoTimes, t)
+	if len(d.pseudoTimes) > pseudoWindow {
+		d.pseudoTimes = d.pseudoTimes[1:]
+	}
+}
+
+// publishedAfter returns the estimated publication time of the next entry,
+// or the zero time if there is not enough data yet.
+func (d *anomalyDetector) publishedAfter() time.Time {
+	if len(d.pseudoTimes) < pseudoWindow {
+		return time.Time{}
+	}
+	sorted := slices.SortedFunc(slices.Values(d.pseudoTimes), time.Time.Compare)
+	return sorted[len(sorted)/2]
+}
+
+// check runs the detectors that only need the log itself on the entry at idx,
+// which must already be in the versions table.
+func (d *anomalyDetector) check(db *sqlite.Conn, idx int64, v module.Version) error {
+	defer d.observe(v.Version)
+
+	var collision string
+	if err := sqlitex.Execute(db, `
+		SELECT path FROM versions
+		WHERE lower(path) = lower(:path) AND path != :path AND idx < :idx
+		LIMIT 1
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":path": v.Path,
+			":idx":  idx,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			collision = stmt.ColumnText(0)
+			return nil
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to check case collisions: %w", err)
+	}
+	if collision != "" {
+		if err := insertAnomaly(db, idx, anomalyCaseCollision, collision); err != nil {
+			return err
+		}
+	}
+
+	// Versions published after the go.mod that retracts them are not marked
+	// by recordRetraction, so check them against the recorded retractions.
+	var retractedBy string
+	if err := sqlitex.Execute(db, `
+		SELECT v.version, r.low, r.high FROM retractions r
+		JOIN versions v ON r.idx = v.idx
+		WHERE v.path = :path
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":path": v.Path,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			low, high := stmt.ColumnText(1), stmt.ColumnText(2)
+			if semver.Compare(low, v.Version) <= 0 && semver.Compare(v.Version, high) <= 0 {
+				retractedBy = stmt.ColumnText(0)
+			}
+			return nil
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to check retractions: %w", err)
+	}
+	if retractedBy != "" {
+		if err := insertAnomaly(db, idx, anomalyRetracted, retractedBy); err != nil {
+			return err
+		}
+	}
+
+	published := d.publishedAfter()
+	if module.IsPseudoVersion(v.Version) || published.IsZero() {
+		return nil
+	}
+	var tagged time.Time
+	if err := sqlitex.Execute(db, `
+		SELECT version FROM versions WHERE path = :path AND idx < :idx
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":path": v.Path,
+			":idx":  idx,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			pseudo := stmt.ColumnText(0)
+			if !module.IsPseudoVersion(pseudo) {
+				return nil
+			}
+			if base, err := module.PseudoVersionBase(pseudo); err != nil || base != v.Version {
+				return nil
+			}
+			t, err := module.PseudoVersionTime(pseudo)
+			if err != nil {
+				return nil
+			}
+			if tagged.IsZero() || t.Before(tagged) {
+				tagged = t
+			}
+			return nil
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to check late tags: %w", err)
+	}
+	if !tagged.IsZero() && published.Sub(tagged) > lateThreshold {
+		detail := fmt.Sprintf("tagged before %s, published after %s",
+			tagged.Format(time.DateOnly), published.Format(time.DateOnly))
+		return insertAnomaly(db, idx, anomalyLateTag, detail)
+	}
+	return nil
+}
+
+func insertAnomaly(db *sqlite.Conn, idx int64, kind, detail string) error {
+	slog.Debug("found anomaly", "idx", idx, "kind", kind, "detail", detail)
+	if err := sqlitex.Execute(db, `
+		INSERT OR REPLACE INTO anomalies (idx, kind, detail)
+		VALUES (:idx, :kind, :detail)
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":idx":    idx,
+			":kind":   kind,
+			":detail": detail,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to insert %s anomaly for index %d: %w", kind, idx, err)
+	}
+	return nil
+}
+
+var proxyClient = &http.Client{
+	Timeout: 1 * time.Minute,
+}
+
+// gomods fetches the go.mod files of the ingested versions from the module
+// proxy at proxyURL, checks them against the hashes in the log, and records
+// their module path and retractions.
+func gomods(ctx context.Context, pool *sqlitex.Pool, proxyURL string) error {
+	read, err := pool.Take(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to take database connection: %w", err)
+	}
+	defer pool.Put(read)
+	write, err := pool.Take(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to take database connection: %w", err)
+	}
+	defer pool.Put(write)
+
+	ticker := time.NewTicker(1 * time.Minute)
+	for {
+		for {
+			n, err := processGoMods(ctx, read, write, proxyURL)
+			if err != nil {
+				slog.Error("failed to process go.mod files", "error", err)
+				break
+			}
+			if n == 0 {
+				break
+			}
+			slog.Debug("processed go.mod files", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+type goModResult struct {
+	idx  int64
+	v    module.Version
+	path string
+	err  string
+	// retry is set if the go.mod couldn't be fetched because of a transient
+	// failure, and the version is left pending for the next pass.
+	retry      bool
+	retracts   []*modfile.Retract
+	hash, want string
+}
+
+// processGoMods fetches and checks the go.mod files of up to 1000 pending
+// versions, and returns how many it settled, with a go.mod path or error.
+func processGoMods(ctx context.Context, read, write *sqlite.Conn, proxyURL string) (n int, err error) {
+	var results []*goModResult
+	if err := sqlitex.Execute(read, `
+		SELECT idx, path, version, gomod_h1 FROM versions
+		WHERE gomod_path IS NULL AND gomod_error IS NULL
+		AND error IS NULL AND gomod_h1 IS NOT NULL
+		ORDER BY idx LIMIT 1000
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			results = append(results, &goModResult{
+				idx:  stmt.ColumnInt64(0),
+				v:    module.Version{Path: stmt.ColumnText(1), Version: stmt.ColumnText(2)},
+				want: stmt.ColumnText(3),
+			})
+			return nil
+		},
+	}); err != nil {
+		return 0, err
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(50)
+	for _, r := range results {
+		g.Go(func() error {
+			data, err := fetchGoMod(gctx, proxyURL, r.v)
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+				if !errors.Is(err, errGoModUnavailable) {
+					r.retry = true
+					slog.Debug("failed to fetch go.mod, will retry", "module", r.v, "error", err)
+					return nil
+				}
+				r.err = err.Error()
+				return nil
+			}
+			hash, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+				return io.NopCloser(bytes.NewReader(data)), nil
+			})
+			if err != nil {
+				return err
+			}
+			if hash != r.want {
+				r.err = fmt.Sprintf("go.mod hash mismatch: log has %s, proxy served %s", r.want, hash)
+				return nil
+			}
+			f, err := modfile.ParseLax(r.v.String(), data, nil)
+			if err != nil {
+				r.err = err.Error()
+				return nil
+			}
+			if f.Module != nil {
+				r.path = f.Module.Mod.Path
+			}
+			r.retracts = f.Retract
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return 0, err
+	}
+
+	release, err := sqlitex.ImmediateTransaction(write)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer release(&err)
+	for _, r := range results {
+		if r.retry {
+			continue
+		}
+		n++
+		if r.err != "" {
+			if err := sqlitex.Execute(write, `
+				UPDATE versions SET gomod_error = :error WHERE idx = :idx
+			`, &sqlitex.ExecOptions{
+				Named: map[string]any{
+					":idx":   r.idx,
+					":error": r.err,
+				},
+			}); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := sqlitex.Execute(write, `
+			UPDATE versions SET gomod_path = :gomod_path WHERE idx = :idx
+		`, &sqlitex.ExecOptions{
+			Named: map[string]any{
+				":idx":        r.idx,
+				":gomod_path": r.path,
+			},
+		}); err != nil {
+			return 0, err
+		}
+		if r.path != r.v.Path {
+			if err := insertAnomaly(write, r.idx, anomalyGoModPath, r.path); err != nil {
+				return 0, err
+			}
+		}
+		for _, rc := range r.retracts {
+			if err := recordRetraction(write, r.idx, r.v, rc); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if retries := len(results) - n; retries > 0 {
+		slog.Warn("failed to fetch some go.mod files, will retry", "count", retries)
+	}
+	return n, nil
+}
+
+// errGoModUnavailable is returned by fetchGoMod if the proxy definitively
+// doesn't serve the go.mod, as opposed to failures that might be transient.
+var errGoModUnavailable = errors.New("go.mod not available from the proxy")
+
+func fetchGoMod(ctx context.Context, proxyURL string, v module.Version) ([]byte, error) {
+	path, err := module.EscapePath(v.Path)
+	if err != nil {
+		return nil, err
+	}
+	version, err := module.EscapeVersion(v.Version)
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(proxyURL, "/") + "/" + path + "/@v/" + version + ".mod"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := proxyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fmt.Errorf("%w: status code %d", errGoModUnavailable, resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// recordRetraction stores a retract directive from the go.mod of the version
+// at idx, and marks the versions of the module it covers that are already in
+// the log. Versions ingested later are checked by [anomalyDetector.check].
+// Per the go command semantics, only the retractions in the go.mod of the
+// latest version apply, but it's interesting to track all of them.
+func recordRetraction(db *sqlite.Conn, idx int64, v module.Version, rc *modfile.Retract) error {
+	if err := sqlitex.Execute(db, `
+		INSERT OR IGNORE INTO retractions (idx, low, high, rationale)
+		VALUES (:idx, :low, :high, :rationale)
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":idx":       idx,
+			":low":       rc.Low,
+			":high":      rc.High,
+			":rationale": rc.Rationale,
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to insert retraction: %w", err)
+	}
+	var retracted []int64
+	if err := sqlitex.Execute(db, `
+		SELECT idx, version FROM versions WHERE path = :path
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":path": v.Path,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			version := stmt.ColumnText(1)
+			if semver.Compare(rc.Low, version) <= 0 && semver.Compare(version, rc.High) <= 0 {
+				retracted = append(retracted, stmt.ColumnInt64(0))
+			}
+			return nil
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to find retracted versions: %w", err)
+	}
+	for _, r := range retracted {
+		if err := insertAnomaly(db, r, anomalyRetracted, v.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb/dirhash"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// goSumEntry returns a checksum database record for a module version with
+// the given go.mod contents.
+func goSumEntry(t *testing.T, path, version, goMod string) string {
+	t.Helper()
+	goModH1, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(goMod)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("%s %s h1:zip-%s=\n%s %s/go.mod %s\n", path, version, version, path, version, goModH1)
+}
+
+// ingestTestEntries runs the entries through processEntries, after those
+// already in the database.
+func ingestTestEntries(t *testing.T, db *sqlite.Conn, entries ...string) {
+	t.Helper()
+	start, err := dbSize(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detector, err := newAnomalyDetector(db, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processEntries(db, detector, testEntries(start, entries...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testAnomalies returns the details of the anomalies of kind, by index.
+func testAnomalies(t *testing.T, db *sqlite.Conn, kind string) map[int64]string {
+	t.Helper()
+	anomalies := make(map[int64]string)
+	if err := sqlitex.Execute(db, `
+		SELECT idx, detail FROM anomalies WHERE kind = :kind
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{":kind": kind},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			anomalies[stmt.ColumnInt64(0)] = stmt.ColumnText(1)
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return anomalies
+}
+
+// newTestProxy starts a module proxy serving go.mod files keyed by
+// path@version.
+func newTestProxy(t *testing.T, goMods map[string]string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escPath, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/@v/")
+		escVersion, ok1 := strings.CutSuffix(rest, ".mod")
+		path, err := module.UnescapePath(escPath)
+		version, err1 := module.UnescapeVersion(escVersion)
+		goMod, ok2 := goMods[path+"@"+version]
+		if !ok || !ok1 || err != nil || err1 != nil || !ok2 {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, goMod)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/"
+}
+
+// processTestGoMods runs processGoMods until there is nothing left to do.
+func processTestGoMods(t *testing.T, pool *sqlitex.Pool, proxyURL string) int {
+	t.Helper()
+	ctx := context.Background()
+	read, err := pool.Take(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(read)
+	write, err := pool.Take(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Put(write)
+	var total int
+	for {
+		n, err := processGoMods(ctx, read, write, proxyURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return total
+		}
+		total += n
+	}
+}
+
+func TestCaseCollision(t *testing.T) {
+	_, db := newTestDB(t)
+	ingestTestEntries(t, db,
+		goSumEntry(t, "github.com/Example/Mod", "v1.0.0", "module github.com/Example/Mod\n"),
+		goSumEntry(t, "github.com/example/mod", "v1.0.0", "module github.com/example/mod\n"),
+		goSumEntry(t, "github.com/example/other", "v1.0.0", "module github.com/example/other\n"),
+		goSumEntry(t, "github.com/example/mod", "v1.1.0", "module github.com/example/mod\n"),
+	)
+	got := testAnomalies(t, db, anomalyCaseCollision)
+	want := map[int64]string{
+		1: "github.com/Example/Mod",
+		3: "github.com/Example/Mod",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("case collisions = %v, want %v", got, want)
+	}
+}
+
+func TestLateTag(t *testing.T) {
+	_, db := newTestDB(t)
+	const rev = "0123456789ab"
+	published := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []string{
+		// Proves v1.0.0 existed more than a year before it was published.
+		goSumEntry(t, "example.com/late", module.PseudoVersion("", "v1.0.0", published.AddDate(-2, 0, 0), rev), ""),
+		// Proves v1.0.0 existed, but only a month before.
+		goSumEntry(t, "example.com/recent", module.PseudoVersion("", "v1.0.0", published.AddDate(0, -1, 0), rev), ""),
+	}
+	// Pseudo-versions from around the time the next entries are published,
+	// to estimate when that is.
+	for i := range pseudoWindow {
+		v := module.PseudoVersion("", "", published.Add(time.Duration(i)*time.Minute), rev)
+		entries = append(entries, goSumEntry(t, "example.com/busy", v, ""))
+	}
+	ingestTestEntries(t, db, entries...)
+
+	// The detector state is reloaded from the database between batches.
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/late", "v1.0.0", ""),
+		goSumEntry(t, "example.com/recent", "v1.0.0", ""),
+		goSumEntry(t, "example.com/untagged", "v1.0.0", ""),
+	)
+	late := int64(2 + pseudoWindow)
+	got := testAnomalies(t, db, anomalyLateTag)
+	want := map[int64]string{late: "tagged before 2018-01-01, published after 2020-01-01"}
+	if !maps.Equal(got, want) {
+		t.Errorf("late tags = %v, want %v", got, want)
+	}
+}
+
+func TestLateTagNotEnoughData(t *testing.T) {
+	_, db := newTestDB(t)
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/late", module.PseudoVersion("", "v1.0.0", time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC), "0123456789ab"), ""),
+		goSumEntry(t, "example.com/late", "v1.0.0", ""),
+	)
+	if got := testAnomalies(t, db, anomalyLateTag); len(got) != 0 {
+		t.Errorf("late tags without a publication estimate = %v", got)
+	}
+}
+
+func TestGoModPath(t *testing.T) {
+	pool, db := newTestDB(t)
+	goMods := map[string]string{
+		"example.com/good@v1.0.0":        "module example.com/good\n",
+		"example.com/fork@v1.0.0":        "module github.com/upstream/fork\n",
+		"example.com/tampered@v1.0.0":    "module example.com/tampered\n",
+		"example.com/Upper@v1.0.0":       "module example.com/Upper\n",
+		"example.com/unparseable@v1.0.0": "module example.com/unparseable\nrequire (\n",
+		"example.com/good@v1.1.0":        "module example.com/good\n",
+		"example.com/fork@v1.1.0":        "module example.com/fork\n",
+		"example.com/notproxied@v1.0.0":  "module example.com/notproxied\n",
+	}
+	// The log has the hash of a different go.mod than the one the proxy serves.
+	tamperedGoMod := "module example.com/tampered\n\nrequire example.com/evil v1.0.0\n"
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/good", "v1.0.0", goMods["example.com/good@v1.0.0"]),
+		goSumEntry(t, "example.com/fork", "v1.0.0", goMods["example.com/fork@v1.0.0"]),
+		goSumEntry(t, "example.com/tampered", "v1.0.0", tamperedGoMod),
+		goSumEntry(t, "example.com/Upper", "v1.0.0", goMods["example.com/Upper@v1.0.0"]),
+		goSumEntry(t, "example.com/unparseable", "v1.0.0", goMods["example.com/unparseable@v1.0.0"]),
+		goSumEntry(t, "example.com/notproxied", "v1.0.0", goMods["example.com/notproxied@v1.0.0"]),
+	)
+	delete(goMods, "example.com/notproxied@v1.0.0")
+	proxyURL := newTestProxy(t, goMods)
+	if n := processTestGoMods(t, pool, proxyURL); n != 6 {
+		t.Errorf("processed %d go.mod files, want 6", n)
+	}
+
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/good", "v1.1.0", goMods["example.com/good@v1.1.0"]),
+		goSumEntry(t, "example.com/fork", "v1.1.0", goMods["example.com/fork@v1.1.0"]),
+	)
+	if n := processTestGoMods(t, pool, proxyURL); n != 2 {
+		t.Errorf("processed %d new go.mod files, want 2", n)
+	}
+
+	got := testAnomalies(t, db, anomalyGoModPath)
+	want := map[int64]string{1: "github.com/upstream/fork"}
+	if !maps.Equal(got, want) {
+		t.Errorf("go.mod path mismatches = %v, want %v", got, want)
+	}
+
+	gomodErrors := make(map[string]string)
+	if err := sqlitex.Execute(db, `
+		SELECT path, version, COALESCE(gomod_path, ''), COALESCE(gomod_error, '') FROM versions
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			path, version := stmt.ColumnText(0), stmt.ColumnText(1)
+			gomodPath, gomodError := stmt.ColumnText(2), stmt.ColumnText(3)
+			if (gomodPath == "") == (gomodError == "") {
+				t.Errorf("%s@%s: gomod_path = %q, gomod_error = %q", path, version, gomodPath, gomodError)
+			}
+			if gomodError != "" {
+				gomodErrors[path] = gomodError
+			}
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(gomodErrors["example.com/tampered"], "hash mismatch") {
+		t.Errorf("tampered go.mod error = %q, want a hash mismatch", gomodErrors["example.com/tampered"])
+	}
+	for _, path := range []string{"example.com/tampered", "example.com/unparseable", "example.com/notproxied"} {
+		if gomodErrors[path] == "" {
+			t.Errorf("%s: no gomod_error", path)
+		}
+		delete(gomodErrors, path)
+	}
+	if len(gomodErrors) != 0 {
+		t.Errorf("unexpected gomod_error: %q", gomodErrors)
+	}
+}
+
+func TestGoModRetry(t *testing.T) {
+	pool, db := newTestDB(t)
+	goMod := "module example.com/flaky\n"
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/flaky", "v1.0.0", goMod),
+		goSumEntry(t, "example.com/gone", "v1.0.0", "module example.com/gone\n"),
+	)
+	var failStatus int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/example.com/gone/"):
+			http.Error(w, "gone", http.StatusGone)
+		case failStatus != 0:
+			http.Error(w, "try again", failStatus)
+		default:
+			io.WriteString(w, goMod)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	// Transient failures leave the version pending, instead of recording
+	// a go.mod error that would never be retried.
+	failStatus = http.StatusTooManyRequests
+	if n := processTestGoMods(t, pool, srv.URL); n != 1 {
+		t.Errorf("settled %d go.mod files, want 1", n)
+	}
+	failStatus = http.StatusBadGateway
+	if n := processTestGoMods(t, pool, srv.URL); n != 0 {
+		t.Errorf("after a 502, settled %d go.mod files, want 0", n)
+	}
+	srv.Close()
+	if n := processTestGoMods(t, pool, srv.URL); n != 0 {
+		t.Errorf("with the proxy down, settled %d go.mod files, want 0", n)
+	}
+
+	failStatus = 0
+	srv = httptest.NewServer(srv.Config.Handler)
+	t.Cleanup(srv.Close)
+	if n := processTestGoMods(t, pool, srv.URL); n != 1 {
+		t.Errorf("after recovery, settled %d go.mod files, want 1", n)
+	}
+
+	results := make(map[string]string)
+	if err := sqlitex.Execute(db, `
+		SELECT path, COALESCE(gomod_path, ''), COALESCE(gomod_error, '') FROM versions
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			results[stmt.ColumnText(0)] = stmt.ColumnText(1) + "|" + stmt.ColumnText(2)
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := results["example.com/flaky"]; got != "example.com/flaky|" {
+		t.Errorf("flaky go.mod path|error = %q", got)
+	}
+	if got := results["example.com/gone"]; !strings.HasPrefix(got, "|go.mod not available") {
+		t.Errorf("gone go.mod path|error = %q", got)
+	}
+}
+
+func TestRetraction(t *testing.T) {
+	pool, db := newTestDB(t)
+	goMods := map[string]string{
+		"example.com/r@v1.0.0": "module example.com/r\n",
+		"example.com/r@v1.1.0": "module example.com/r\n\nretract (\n\t[v1.0.0, v1.0.5] // broken\n\tv1.2.0 // published by mistake\n)\n",
+	}
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/r", "v1.0.0", goMods["example.com/r@v1.0.0"]),
+		goSumEntry(t, "example.com/r", "v1.1.0", goMods["example.com/r@v1.1.0"]),
+	)
+	processTestGoMods(t, pool, newTestProxy(t, goMods))
+
+	// Versions published after the retraction was recorded are checked as
+	// they are ingested.
+	ingestTestEntries(t, db,
+		goSumEntry(t, "example.com/r", "v1.0.3", ""),
+		goSumEntry(t, "example.com/r", "v1.2.0", ""),
+		goSumEntry(t, "example.com/r", "v1.3.0", ""),
+		goSumEntry(t, "example.com/other", "v1.2.0", ""),
+	)
+
+	got := testAnomalies(t, db, anomalyRetracted)
+	want := map[int64]string{
+		0: "v1.1.0",
+		2: "v1.1.0",
+		3: "v1.1.0",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("retracted versions = %v, want %v", got, want)
+	}
+
+	var rationales []string
+	if err := sqlitex.Execute(db, `
+		SELECT low || ' ' || high || ' ' || rationale FROM retractions ORDER BY low
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			rationales = append(rationales, stmt.ColumnText(0))
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wantRationales := "v1.0.0 v1.0.5 broken\nv1.2.0 v1.2.0 published by mistake"
+	if got := strings.Join(rationales, "\n"); got != wantRationales {
+		t.Errorf("retractions:\n%s\nwant:\n%s", got, wantRationales)
+	}
+}
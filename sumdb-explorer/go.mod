@@ -6,6 +6,7 @@ require (
 	filippo.io/torchwood v0.5.1-0.20250713221105-b067ac9d4cf6
 	golang.org/x/mod v0.24.0
 	golang.org/x/net v0.40.0
+	golang.org/x/sync v0.14.0
 	zombiezen.com/go/sqlite v1.4.2
 )
 
@@ -17,7 +18,6 @@ require (
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/crypto v0.38.0 // indirect
 	golang.org/x/exp v0.0.0-20250408133849-7e4ce0ab07d0 // indirect
-	golang.org/x/sync v0.14.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	modernc.org/libc v1.65.7 // indirect
 	modernc.org/mathutil v1.7.1 // indirect
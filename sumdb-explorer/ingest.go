@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
 	"strings"
 	"time"
@@ -17,24 +18,31 @@ import (
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
-func ingest(ctx context.Context, pool *sqlitex.Pool, cachePath string) error {
+func ingest(ctx context.Context, pool *sqlitex.Pool, cachePath string, source *logSource) error {
 	db, err := pool.Take(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to take database connection: %w", err)
 	}
 	defer pool.Put(db)
 
-	fetcher, err := torchwood.NewTileFetcher("https://sum.golang.org/",
-		torchwood.WithTilePath(tlog.Tile.Path))
+	verifier, err := note.NewVerifier(source.Key)
+	if err != nil {
+		return fmt.Errorf("failed to parse verifier key: %w", err)
+	}
+	tilePath, err := source.tilePath()
+	if err != nil {
+		return err
+	}
+	fetcher, err := source.fetcher()
 	if err != nil {
 		return fmt.Errorf("failed to create tile fetcher: %w", err)
 	}
 	dirCache, err := torchwood.NewPermanentCache(fetcher, cachePath,
-		torchwood.WithPermanentCacheTilePath(tlog.Tile.Path))
+		torchwood.WithPermanentCacheTilePath(tilePath))
 	if err != nil {
 		return fmt.Errorf("failed to create permanent cache: %w", err)
 	}
-	client, err := torchwood.NewClient(dirCache, torchwood.WithSumDBEntries())
+	client, err := torchwood.NewClient(dirCache, source.clientOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to create client: %w", err)
 	}
@@ -43,10 +51,21 @@ func ingest(ctx context.Context, pool *sqlitex.Pool, cachePath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to get database size: %w", err)
 	}
+	// Entries ingested before hashes were recorded are processed again.
+	if missing, err := firstMissingHash(db); err != nil {
+		return fmt.Errorf("failed to find entries without hashes: %w", err)
+	} else if missing >= 0 && missing < start {
+		slog.Info("backfilling hashes", "start", missing)
+		start = missing
+	}
+	detector, err := newAnomalyDetector(db, start)
+	if err != nil {
+		return fmt.Errorf("failed to load anomaly detector state: %w", err)
+	}
 
 	ticker := time.NewTicker(1 * time.Minute)
 	for {
-		checkpoint, err := fetchCheckpoint(ctx, fetcher)
+		checkpoint, err := fetchCheckpoint(ctx, fetcher, source.checkpointPath(), verifier)
 		if err != nil {
 			return fmt.Errorf("failed to fetch checkpoint: %w", err)
 		}
@@ -57,11 +76,25 @@ func ingest(ctx context.Context, pool *sqlitex.Pool, cachePath string) error {
 		if checkpoint.N <= start {
 			slog.Debug("no new entries to ingest", "start", start, "checkpoint", checkpoint.N)
 		} else {
-			if err := processEntries(ctx, client, db, checkpoint, start); err != nil {
+			entries := client.Entries(ctx, checkpoint.Tree, start)
+			if source.Entries == "raw" {
+				err = storeEntries(db, entries)
+			} else {
+				err = processEntries(db, detector, entries)
+			}
+			if err == nil {
+				err = client.Err()
+			}
+			if err != nil {
 				return fmt.Errorf("failed to ingest entries: %w", err)
 			}
-			slog.Debug("ingested entries", "start", start, "end", checkpoint.N)
-			start = checkpoint.N
+			// Iteration may stop early to avoid partial tiles.
+			end, err := dbSize(db)
+			if err != nil {
+				return fmt.Errorf("failed to get database size: %w", err)
+			}
+			slog.Debug("ingested entries", "start", start, "end", end)
+			start = end
 		}
 
 		select {
@@ -72,16 +105,17 @@ func ingest(ctx context.Context, pool *sqlitex.Pool, cachePath string) error {
 	}
 }
 
-func processEntries(ctx context.Context, client *torchwood.Client, db *sqlite.Conn,
-	checkpoint torchwood.Checkpoint, start int64) (err error) {
+// processEntries parses checksum database records into module versions, and
+// checks them for anomalies.
+func processEntries(db *sqlite.Conn, detector *anomalyDetector, entries iter.Seq2[int64, []byte]) (err error) {
 	release, err := sqlitex.ImmediateTransaction(db)
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer release(&err)
 
-	for i, entry := range client.Entries(ctx, checkpoint.Tree, start) {
-		v, err := parseEntry(string(entry))
+	for i, entry := range entries {
+		v, h1, goModH1, err := parseEntry(string(entry))
 		if err != nil {
 			return fmt.Errorf("failed to parse entry %d: %w", i, err)
 		}
@@ -110,70 +144,124 @@ func processEntries(ctx context.Context, client *torchwood.Client, db *sqlite.Co
 			return fmt.Errorf("failed to insert hostname %q into database: %w", hostname, err)
 		}
 
-		if checkErr != nil {
-			return sqlitex.Execute(db, `
-				INSERT INTO versions (idx, path, version, error)
-				VALUES (:idx, :path, :version, :error)
+		newPath := true
+		if err := sqlitex.Execute(db, `
+			SELECT 1 FROM versions WHERE path = :path LIMIT 1
+		`, &sqlitex.ExecOptions{
+			Named: map[string]any{":path": v.Path},
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				newPath = false
+				return nil
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to look up path %q: %w", v.Path, err)
+		}
+		if newPath {
+			if err := sqlitex.Execute(db, `
+				UPDATE hostnames SET modules = modules + 1 WHERE hostname = :hostname
 			`, &sqlitex.ExecOptions{
-				Named: map[string]any{
-					":idx":     i,
-					":path":    v.Path,
-					":version": v.Version,
-					":error":   checkErr.Error(),
-				},
-			})
+				Named: map[string]any{":hostname": hostname},
+			}); err != nil {
+				return fmt.Errorf("failed to count module %q: %w", v.Path, err)
+			}
+		}
+
+		var checkErrText any
+		if checkErr != nil {
+			checkErrText = checkErr.Error()
 		}
-		return sqlitex.Execute(db, `
-			INSERT INTO versions (idx, path, version)
-			VALUES (:idx, :path, :version)
+		if err := sqlitex.Execute(db, `
+			INSERT INTO versions (idx, path, version, error, h1, gomod_h1)
+			VALUES (:idx, :path, :version, :error, :h1, :gomod_h1)
+			ON CONFLICT (idx) DO UPDATE SET h1 = excluded.h1, gomod_h1 = excluded.gomod_h1
 		`, &sqlitex.ExecOptions{
 			Named: map[string]any{
-				":idx":     i,
-				":path":    v.Path,
-				":version": v.Version,
+				":idx":      i,
+				":path":     v.Path,
+				":version":  v.Version,
+				":error":    checkErrText,
+				":h1":       h1,
+				":gomod_h1": goModH1,
 			},
-		})
+		}); err != nil {
+			return fmt.Errorf("failed to insert entry %d into database: %w", i, err)
+		}
+
+		if err := detector.check(db, i, v); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func parseEntry(entry string) (module.Version, error) {
+// storeEntries stores the entries of a log that is not a checksum database,
+// without interpreting them.
+func storeEntries(db *sqlite.Conn, entries iter.Seq2[int64, []byte]) (err error) {
+	release, err := sqlitex.ImmediateTransaction(db)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer release(&err)
+
+	for i, entry := range entries {
+		if err := sqlitex.Execute(db, `
+			INSERT OR REPLACE INTO entries (idx, entry) VALUES (:idx, :entry)
+		`, &sqlitex.ExecOptions{
+			Named: map[string]any{
+				":idx":   i,
+				":entry": entry,
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to insert entry %d into database: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// parseEntry parses a checksum database record, returning the module version
+// and the h1: hashes of its zip and go.mod.
+func parseEntry(entry string) (v module.Version, h1, goModH1 string, err error) {
 	name, rest, ok := strings.Cut(string(entry), " ")
 	if !ok {
-		return module.Version{}, errors.New("invalid entry format")
+		return module.Version{}, "", "", errors.New("invalid entry format")
 	}
 	version, rest, ok := strings.Cut(rest, " ")
 	if !ok {
-		return module.Version{}, errors.New("invalid entry format")
+		return module.Version{}, "", "", errors.New("invalid entry format")
 	}
-	v := module.Version{Path: name, Version: version}
+	v = module.Version{Path: name, Version: version}
 	if module.CanonicalVersion(version) != version {
-		return v, module.VersionError(v, errors.New("version is not canonical"))
+		return v, "", "", module.VersionError(v, errors.New("version is not canonical"))
 	}
-	_, rest, ok = strings.Cut(rest, "\n")
+	h1, rest, ok = strings.Cut(rest, "\n")
 	if !ok {
-		return v, module.VersionError(v, errors.New("invalid entry format"))
+		return v, "", "", module.VersionError(v, errors.New("invalid entry format"))
 	}
 	name1, rest, ok := strings.Cut(rest, " ")
 	if !ok || name1 != name {
-		return v, module.VersionError(v, errors.New("invalid entry format"))
+		return v, "", "", module.VersionError(v, errors.New("invalid entry format"))
 	}
 	version1, rest, ok := strings.Cut(rest, " ")
 	if !ok || version1 != version+"/go.mod" {
-		return v, module.VersionError(v, errors.New("go.mod version mismatch"))
+		return v, "", "", module.VersionError(v, errors.New("go.mod version mismatch"))
 	}
-	_, rest, ok = strings.Cut(rest, "\n")
+	goModH1, rest, ok = strings.Cut(rest, "\n")
 	if !ok || rest != "" {
-		return v, module.VersionError(v, errors.New("invalid entry format"))
+		return v, "", "", module.VersionError(v, errors.New("invalid entry format"))
 	}
-	return v, nil
+	return v, h1, goModH1, nil
 }
 
 func dbSize(db *sqlite.Conn) (int64, error) {
 	var index int64 = -1
-	if err := sqlitex.ExecuteTransient(db, `SELECT MAX(idx) FROM versions`, &sqlitex.ExecOptions{
+	// Only one of the two tables is used, depending on the entry format.
+	if err := sqlitex.ExecuteTransient(db, `
+		SELECT MAX(idx) FROM (SELECT MAX(idx) AS idx FROM versions UNION ALL SELECT MAX(idx) FROM entries)
+	`, &sqlitex.ExecOptions{
 		ResultFunc: func(stmt *sqlite.Stmt) error {
-			index = stmt.ColumnInt64(0)
+			if stmt.ColumnType(0) != sqlite.TypeNull {
+				index = stmt.ColumnInt64(0)
+			}
 			return nil
 		},
 	}); err != nil {
@@ -185,12 +273,24 @@ func dbSize(db *sqlite.Conn) (int64, error) {
 	return index + 1, nil
 }
 
-func fetchCheckpoint(ctx context.Context, fetcher *torchwood.TileFetcher) (torchwood.Checkpoint, error) {
-	signed, err := fetcher.ReadEndpoint(ctx, "latest")
-	if err != nil {
-		return torchwood.Checkpoint{}, err
+// firstMissingHash returns the index of the first entry without hashes, or -1.
+func firstMissingHash(db *sqlite.Conn) (int64, error) {
+	var index int64 = -1
+	if err := sqlitex.ExecuteTransient(db, `SELECT MIN(idx) FROM versions WHERE h1 IS NULL`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			if stmt.ColumnType(0) != sqlite.TypeNull {
+				index = stmt.ColumnInt64(0)
+			}
+			return nil
+		},
+	}); err != nil {
+		return 0, err
 	}
-	v, err := note.NewVerifier("sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8")
+	return index, nil
+}
+
+func fetchCheckpoint(ctx context.Context, fetcher tileSource, path string, v note.Verifier) (torchwood.Checkpoint, error) {
+	signed, err := fetcher.ReadEndpoint(ctx, path)
 	if err != nil {
 		return torchwood.Checkpoint{}, err
 	}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"iter"
+	"maps"
+	"slices"
+	"testing"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// testEntries returns an iterator over entries, starting at index start, like
+// [torchwood.Client.Entries].
+func testEntries(start int64, entries ...string) iter.Seq2[int64, []byte] {
+	return func(yield func(int64, []byte) bool) {
+		for i, e := range entries {
+			if !yield(start+int64(i), []byte(e)) {
+				return
+			}
+		}
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	v, h1, goModH1, err := parseEntry("example.com/m v1.0.0 h1:zip=\nexample.com/m v1.0.0/go.mod h1:mod=\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Path != "example.com/m" || v.Version != "v1.0.0" || h1 != "h1:zip=" || goModH1 != "h1:mod=" {
+		t.Errorf("parseEntry = %v, %q, %q", v, h1, goModH1)
+	}
+
+	for _, entry := range []string{
+		"",
+		"example.com/m v1.0.0 h1:zip=\n",
+		"example.com/m v1.0 h1:zip=\nexample.com/m v1.0/go.mod h1:mod=\n",
+		"example.com/m v1.0.0 h1:zip=\nexample.com/n v1.0.0/go.mod h1:mod=\n",
+		"example.com/m v1.0.0 h1:zip=\nexample.com/m v1.0.1/go.mod h1:mod=\n",
+		"example.com/m v1.0.0 h1:zip=\nexample.com/m v1.0.0/go.mod h1:mod=\nextra",
+		`{"any": "other log entry"}`,
+	} {
+		if _, _, _, err := parseEntry(entry); err == nil {
+			t.Errorf("parseEntry(%q) succeeded", entry)
+		}
+	}
+}
+
+func TestStoreEntries(t *testing.T) {
+	_, db := newTestDB(t)
+	entries := []string{"first entry", "\x00binary\xff", `{"any": "other log entry"}`}
+	if err := storeEntries(db, testEntries(0, entries...)); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[int64]string)
+	if err := sqlitex.Execute(db, `SELECT idx, entry FROM entries`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			got[stmt.ColumnInt64(0)] = stmt.ColumnText(1)
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int64]string{0: entries[0], 1: entries[1], 2: entries[2]}; !maps.Equal(got, want) {
+		t.Errorf("stored entries = %q, want %q", got, want)
+	}
+	if size, err := dbSize(db); err != nil || size != 3 {
+		t.Errorf("dbSize = %d, %v, want 3", size, err)
+	}
+
+	// Raw entries don't go through the module passes.
+	var versions []string
+	if err := sqlitex.Execute(db, `SELECT path FROM versions UNION ALL SELECT hostname FROM hostnames`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			versions = append(versions, stmt.ColumnText(0))
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 0 {
+		t.Errorf("raw entries were parsed as modules: %v", versions)
+	}
+}
+
+func TestProcessEntriesSize(t *testing.T) {
+	_, db := newTestDB(t)
+	if size, err := dbSize(db); err != nil || size != 0 {
+		t.Errorf("dbSize of an empty database = %d, %v, want 0", size, err)
+	}
+	detector, err := newAnomalyDetector(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processEntries(db, detector, testEntries(0,
+		"example.com/a v1.0.0 h1:a=\nexample.com/a v1.0.0/go.mod h1:amod=\n",
+		"example.com/b v1.0.0 h1:b=\nexample.com/b v1.0.0/go.mod h1:bmod=\n",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := dbSize(db); err != nil || size != 2 {
+		t.Errorf("dbSize = %d, %v, want 2", size, err)
+	}
+
+	// A malformed entry rolls back the whole batch.
+	err = processEntries(db, detector, testEntries(2,
+		"example.com/c v1.0.0 h1:c=\nexample.com/c v1.0.0/go.mod h1:cmod=\n",
+		`{"any": "other log entry"}`,
+	))
+	if err == nil {
+		t.Error("processEntries of a raw entry succeeded")
+	}
+	if size, err := dbSize(db); err != nil || size != 2 {
+		t.Errorf("dbSize after a failed batch = %d, %v, want 2", size, err)
+	}
+
+	var hosts []string
+	if err := sqlitex.Execute(db, `SELECT hostname FROM hostnames ORDER BY hostname`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			hosts = append(hosts, stmt.ColumnText(0))
+			return nil
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com"}; !slices.Equal(hosts, want) {
+		t.Errorf("hostnames = %v, want %v", hosts, want)
+	}
+}
+
+func TestHostModules(t *testing.T) {
+	pool, db := newTestDB(t)
+	entries := []string{
+		"example.com v1.0.0 h1:a=\nexample.com v1.0.0/go.mod h1:amod=\n",
+		"example.com/a v1.0.0 h1:a=\nexample.com/a v1.0.0/go.mod h1:amod=\n",
+		"example.com/a v1.1.0 h1:a=\nexample.com/a v1.1.0/go.mod h1:amod=\n",
+		"example.com/b/v2 v2.0.0 h1:b=\nexample.com/b/v2 v2.0.0/go.mod h1:bmod=\n",
+		"example.community/c v1.0.0 h1:c=\nexample.community/c v1.0.0/go.mod h1:cmod=\n",
+	}
+	detector, err := newAnomalyDetector(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processEntries(db, detector, testEntries(0, entries...)); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"example.com": 3, "example.community": 1}
+	hostModules := func() map[string]int64 {
+		t.Helper()
+		modules := make(map[string]int64)
+		if err := sqlitex.Execute(db, `SELECT hostname, modules FROM hostnames`, &sqlitex.ExecOptions{
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				modules[stmt.ColumnText(0)] = stmt.ColumnInt64(1)
+				return nil
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return modules
+	}
+	if got := hostModules(); !maps.Equal(got, want) {
+		t.Errorf("modules = %v, want %v", got, want)
+	}
+
+	// Processing the same entries again, like the hash backfill does, doesn't
+	// count them twice.
+	if err := processEntries(db, detector, testEntries(0, entries...)); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostModules(); !maps.Equal(got, want) {
+		t.Errorf("modules after reprocessing = %v, want %v", got, want)
+	}
+
+	// Databases from before the column existed are backfilled.
+	if err := sqlitex.ExecuteTransient(db, `ALTER TABLE hostnames DROP COLUMN modules`, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := initDatabase(context.Background(), pool); err != nil {
+		t.Fatal(err)
+	}
+	if got := hostModules(); !maps.Equal(got, want) {
+		t.Errorf("backfilled modules = %v, want %v", got, want)
+	}
+}
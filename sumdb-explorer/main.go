@@ -8,12 +8,20 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 
 	"golang.org/x/sync/errgroup"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitex"
 )
 
+const (
+	defaultLogURL = "https://sum.golang.org/"
+	defaultLogKey = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+
+	defaultProxyURL = "https://proxy.golang.org/"
+)
+
 func main() {
 	cache, err := os.UserCacheDir()
 	if err != nil {
@@ -26,7 +34,34 @@ func main() {
 	cacheFlag := flag.String("cache", cache, "path to the cache directory")
 	yoloFlag := flag.Bool("yolo", false, "speed up import by reducing safety")
 	debugFlag := flag.Bool("debug", false, "enable debug logging")
+	source := &logSource{}
+	flag.StringVar(&source.URL, "log", defaultLogURL, "base URL of the log, or path of a local directory containing it")
+	flag.StringVar(&source.Key, "key", defaultLogKey, "note verifier key of the log")
+	flag.StringVar(&source.Scheme, "tiles", "sumdb", "tile path scheme of the log, sumdb or c2sp (for c2sp.org/tlog-tiles)")
+	flag.StringVar(&source.Entries, "entries", "gosum", "entry format of the log, gosum for checksum database records or raw to store them as-is")
+	proxyFlag := flag.String("proxy", defaultProxyURL, "base URL of the module proxy to fetch go.mod files from")
+	listenFlag := flag.String("listen", ":8000", "address to listen on for the HTTP server in serve mode")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: sumdb-explorer [flags] [serve]\n")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+	if err := source.checkEntries(); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "%v\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// In serve mode, the database is also served over HTTP while ingesting.
+	serveMode := false
+	switch flag.Arg(0) {
+	case "":
+	case "serve":
+		serveMode = true
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
 
 	if *debugFlag {
 		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
@@ -38,6 +73,11 @@ func main() {
 		})))
 	}
 
+	// Tiles of other logs are cached separately, keyed by the log name.
+	if *cacheFlag == cache && source.Key != defaultLogKey {
+		name, _, _ := strings.Cut(source.Key, "+")
+		*cacheFlag = filepath.Join(cache, name)
+	}
 	if err := os.MkdirAll(*cacheFlag, 0o755); err != nil {
 		slog.Error("failed to create cache directory", "error", err)
 		return
@@ -57,6 +97,11 @@ func main() {
 				sqlitex.ExecuteTransient(db, `PRAGMA synchronous = OFF;`, nil)
 				sqlitex.ExecuteTransient(db, `PRAGMA cache_size = -1000000;`, nil)
 				sqlitex.ExecuteTransient(db, `PRAGMA temp_store = MEMORY;`, nil)
+			} else if serveMode {
+				// Let HTTP requests read while ingestion is writing.
+				if err := sqlitex.ExecuteTransient(db, `PRAGMA journal_mode = WAL;`, nil); err != nil {
+					return err
+				}
 			}
 			return sqlitex.ExecuteTransient(db, `PRAGMA foreign_keys = ON;`, nil)
 		},
@@ -75,14 +120,27 @@ func main() {
 		return
 	}
 
-	if !*yoloFlag {
+	// Only checksum database records have module paths and go.mod hashes.
+	if !*yoloFlag && source.Entries == "gosum" {
 		group.Go(func() error {
 			err := domainr(ctx, db)
 			return fmt.Errorf("domainr processing failed: %w", err)
 		})
 	}
+	if !*yoloFlag && source.Entries == "gosum" {
+		group.Go(func() error {
+			err := gomods(ctx, db, *proxyFlag)
+			return fmt.Errorf("go.mod processing failed: %w", err)
+		})
+	}
+	if serveMode {
+		group.Go(func() error {
+			err := serve(ctx, db, *listenFlag)
+			return fmt.Errorf("HTTP server failed: %w", err)
+		})
+	}
 	group.Go(func() error {
-		err := ingest(ctx, db, *cacheFlag)
+		err := ingest(ctx, db, *cacheFlag, source)
 		return fmt.Errorf("ingestion failed: %w", err)
 	})
 	slog.Error("stopping", "error", group.Wait())
@@ -95,7 +153,7 @@ func initDatabase(ctx context.Context, pool *sqlitex.Pool) error {
 	}
 	defer pool.Put(db)
 
-	return sqlitex.ExecScript(db, `
+	if err := sqlitex.ExecScript(db, `
 		CREATE TABLE IF NOT EXISTS versions (
 			idx INTEGER PRIMARY KEY,
 			path TEXT NOT NULL,
@@ -115,5 +173,93 @@ func initDatabase(ctx context.Context, pool *sqlitex.Pool) error {
 			bad_since INTEGER DEFAULT NULL
 		) STRICT;
 		CREATE INDEX IF NOT EXISTS idx_hostnames_etldp1 ON hostnames (etldp1);
+		CREATE TABLE IF NOT EXISTS anomalies (
+			idx INTEGER NOT NULL REFERENCES versions (idx),
+			-- kind is one of case-collision, late-tag, gomod-path, or retracted
+			kind TEXT NOT NULL,
+			detail TEXT NOT NULL,
+			PRIMARY KEY (idx, kind)
+		) STRICT, WITHOUT ROWID;
+		CREATE INDEX IF NOT EXISTS idx_anomalies_kind ON anomalies (kind);
+		CREATE TABLE IF NOT EXISTS retractions (
+			-- idx is the version whose go.mod has the retract directive
+			idx INTEGER NOT NULL REFERENCES versions (idx),
+			low TEXT NOT NULL,
+			high TEXT NOT NULL,
+			rationale TEXT NOT NULL,
+			PRIMARY KEY (idx, low, high)
+		) STRICT, WITHOUT ROWID;
+		-- entries holds the entries of logs that are not checksum databases
+		CREATE TABLE IF NOT EXISTS entries (
+			idx INTEGER PRIMARY KEY,
+			entry BLOB NOT NULL
+		) STRICT;
+	`); err != nil {
+		return err
+	}
+
+	// Columns added after the versions table was first created.
+	for _, column := range []string{
+		// h1 and gomod_h1 are the go.sum hashes of the zip and go.mod
+		"h1 TEXT DEFAULT NULL",
+		"gomod_h1 TEXT DEFAULT NULL",
+		// gomod_path is the module path declared in the go.mod fetched from
+		// the proxy, or gomod_error why it couldn't be fetched or parsed
+		"gomod_path TEXT DEFAULT NULL",
+		"gomod_error TEXT DEFAULT NULL",
+	} {
+		if _, err := addColumn(db, "versions", column); err != nil {
+			return err
+		}
+	}
+
+	// modules is the number of module paths under the hostname, kept up to
+	// date by processEntries so the server doesn't count them on each request.
+	added, err := addColumn(db, "hostnames", "modules INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	if added {
+		if err := sqlitex.ExecuteTransient(db, `
+			UPDATE hostnames SET modules = (
+				SELECT COUNT(DISTINCT path) FROM versions
+				WHERE path = hostname OR (path > hostname || '/' AND path < hostname || '0')
+			)
+		`, nil); err != nil {
+			return fmt.Errorf("failed to count modules per hostname: %w", err)
+		}
+	}
+
+	return sqlitex.ExecScript(db, `
+		CREATE INDEX IF NOT EXISTS idx_versions_lower_path ON versions (lower(path));
+		CREATE INDEX IF NOT EXISTS idx_versions_gomod_pending ON versions (idx)
+			WHERE gomod_path IS NULL AND gomod_error IS NULL;
+		CREATE INDEX IF NOT EXISTS idx_hostnames_bad_since ON hostnames (bad_since)
+			WHERE bad_since IS NOT NULL;
 	`)
 }
+
+// addColumn adds a column to table, unless it already exists, and reports
+// whether it did.
+func addColumn(db *sqlite.Conn, table, column string) (added bool, err error) {
+	name, _, _ := strings.Cut(column, " ")
+	var exists bool
+	if err := sqlitex.Execute(db, `
+		SELECT 1 FROM pragma_table_info(:table) WHERE name = :name
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":table": table,
+			":name":  name,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			exists = true
+			return nil
+		},
+	}); err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	return true, sqlitex.ExecuteTransient(db, `ALTER TABLE `+table+` ADD COLUMN `+column, nil)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// Server serves the contents of the database as HTML pages, and as JSON under
+// /api/, from the same pool the ingestion uses.
+type Server struct {
+	pool *sqlitex.Pool
+}
+
+//go:embed templates
+var templates embed.FS
+
+var pageTemplates = template.Must(template.New("").ParseFS(templates, "templates/*.html"))
+
+var (
+	errNotFound   = errors.New("not found")
+	errBadRequest = errors.New("bad request")
+)
+
+// handlerFunc returns the data for both the HTML template and the JSON API.
+type handlerFunc func(r *http.Request, db *sqlite.Conn) (any, error)
+
+func (s *Server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	s.handle(mux, "/{$}", "index.html", s.handleIndex)
+	s.handle(mux, "/module/{path...}", "module.html", s.handleModule)
+	s.handle(mux, "/search", "search.html", s.handleSearch)
+	s.handle(mux, "/hosts", "hosts.html", s.handleHosts)
+	s.handle(mux, "/dangling", "dangling.html", s.handleDangling)
+	return mux
+}
+
+func (s *Server) handle(mux *http.ServeMux, pattern, name string, fn handlerFunc) {
+	serve := func(w http.ResponseWriter, r *http.Request, api bool) {
+		db, err := s.pool.Take(r.Context())
+		if err != nil {
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.pool.Put(db)
+
+		data, err := fn(r, db)
+		switch {
+		case errors.Is(err, errNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errBadRequest):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			if r.Context().Err() == nil {
+				slog.ErrorContext(r.Context(), "failed to serve request", "path", r.URL.Path, "error", err)
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if api {
+			w.Header().Set("Content-Type", "application/json")
+			e := json.NewEncoder(w)
+			e.SetIndent("", "  ")
+			if err := e.Encode(data); err != nil && r.Context().Err() == nil {
+				slog.ErrorContext(r.Context(), "failed to encode JSON", "path", r.URL.Path, "error", err)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := pageTemplates.ExecuteTemplate(w, name, data); err != nil && r.Context().Err() == nil {
+			slog.ErrorContext(r.Context(), "failed to execute template", "name", name, "error", err)
+		}
+	}
+	mux.HandleFunc("GET "+pattern, func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r, false)
+	})
+	if pattern != "/{$}" {
+		mux.HandleFunc("GET /api"+pattern, func(w http.ResponseWriter, r *http.Request) {
+			serve(w, r, true)
+		})
+	}
+}
+
+func (s *Server) handleIndex(r *http.Request, db *sqlite.Conn) (any, error) {
+	var checkpoint string
+	if err := sqlitex.Execute(db, `SELECT checkpoint FROM checkpoint`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			checkpoint = stmt.ColumnText(0)
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	size, err := dbSize(db)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		Checkpoint string
+		Entries    int64
+	}{
+		Checkpoint: checkpoint,
+		Entries:    size,
+	}, nil
+}
+
+type Anomaly struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+type ModuleVersion struct {
+	Index      int64     `json:"index"`
+	Version    string    `json:"version"`
+	Error      string    `json:"error,omitempty"`
+	H1         string    `json:"h1,omitempty"`
+	GoModH1    string    `json:"gomod_h1,omitempty"`
+	GoModPath  string    `json:"gomod_path,omitempty"`
+	GoModError string    `json:"gomod_error,omitempty"`
+	Anomalies  []Anomaly `json:"anomalies,omitempty"`
+}
+
+type Host struct {
+	Hostname       string `json:"hostname"`
+	ETLDPlusOne    string `json:"etldp1"`
+	DomainrStatus  string `json:"domainr_status,omitempty"`
+	DomainrUpdated string `json:"domainr_updated,omitempty"`
+	// BadSince is the log size when the domain was first seen with a bad
+	// status, or zero.
+	BadSince int64 `json:"bad_since,omitempty"`
+	Modules  int64 `json:"modules"`
+}
+
+// hostColumns selects the columns of a Host from the hostnames table h.
+const hostColumns = `
+	h.hostname, h.etldp1, h.domainr_status, h.domainr_updated, h.bad_since, h.modules
+`
+
+func scanHost(stmt *sqlite.Stmt) *Host {
+	return &Host{
+		Hostname:       stmt.ColumnText(0),
+		ETLDPlusOne:    stmt.ColumnText(1),
+		DomainrStatus:  stmt.ColumnText(2),
+		DomainrUpdated: stmt.ColumnText(3),
+		BadSince:       stmt.ColumnInt64(4),
+		Modules:        stmt.ColumnInt64(5),
+	}
+}
+
+func (s *Server) handleModule(r *http.Request, db *sqlite.Conn) (any, error) {
+	path := r.PathValue("path")
+	var versions []*ModuleVersion
+	byIndex := make(map[int64]*ModuleVersion)
+	if err := sqlitex.Execute(db, `
+		SELECT idx, version, error, h1, gomod_h1, gomod_path, gomod_error
+		FROM versions WHERE path = :path ORDER BY idx
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{":path": path},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			v := &ModuleVersion{
+				Index:      stmt.ColumnInt64(0),
+				Version:    stmt.ColumnText(1),
+				Error:      stmt.ColumnText(2),
+				H1:         stmt.ColumnText(3),
+				GoModH1:    stmt.ColumnText(4),
+				GoModPath:  stmt.ColumnText(5),
+				GoModError: stmt.ColumnText(6),
+			}
+			versions = append(versions, v)
+			byIndex[v.Index] = v
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("module %q %w", path, errNotFound)
+	}
+	if err := sqlitex.Execute(db, `
+		SELECT a.idx, a.kind, a.detail FROM anomalies a
+		JOIN versions v ON a.idx = v.idx WHERE v.path = :path
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{":path": path},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			v := byIndex[stmt.ColumnInt64(0)]
+			v.Anomalies = append(v.Anomalies, Anomaly{
+				Kind:   stmt.ColumnText(1),
+				Detail: stmt.ColumnText(2),
+			})
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	var host *Host
+	hostname, _, _ := strings.Cut(path, "/")
+	if err := sqlitex.Execute(db, `
+		SELECT `+hostColumns+` FROM hostnames h WHERE h.hostname = :hostname
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{":hostname": hostname},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			host = scanHost(stmt)
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Path     string           `json:"path"`
+		Host     *Host            `json:"host"`
+		Versions []*ModuleVersion `json:"versions"`
+	}{
+		Path:     path,
+		Host:     host,
+		Versions: versions,
+	}, nil
+}
+
+const searchLimit = 100
+
+type SearchResult struct {
+	Path     string `json:"path"`
+	Versions int64  `json:"versions"`
+}
+
+func (s *Server) handleSearch(r *http.Request, db *sqlite.Conn) (any, error) {
+	prefix := r.FormValue("prefix")
+	if prefix == "" {
+		return nil, fmt.Errorf("%w: missing prefix", errBadRequest)
+	}
+	// Paths are compared bytewise, and can't contain 0xff bytes, so this
+	// range matches all paths starting with prefix using the index.
+	results := []SearchResult{}
+	if err := sqlitex.Execute(db, `
+		SELECT path, COUNT(*) FROM versions
+		WHERE path >= :prefix AND path < :prefix || x'ff'
+		GROUP BY path ORDER BY path LIMIT :limit
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":prefix": prefix,
+			":limit":  searchLimit + 1,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			results = append(results, SearchResult{
+				Path:     stmt.ColumnText(0),
+				Versions: stmt.ColumnInt64(1),
+			})
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	truncated := len(results) > searchLimit
+	if truncated {
+		results = results[:searchLimit]
+	}
+	return struct {
+		Prefix    string         `json:"prefix"`
+		Results   []SearchResult `json:"results"`
+		Truncated bool           `json:"truncated"`
+	}{
+		Prefix:    prefix,
+		Results:   results,
+		Truncated: truncated,
+	}, nil
+}
+
+const hostsPageSize = 100
+
+type StatusCount struct {
+	Status string `json:"status"`
+	Hosts  int64  `json:"hosts"`
+}
+
+func (s *Server) handleHosts(r *http.Request, db *sqlite.Conn) (any, error) {
+	status := r.FormValue("status")
+	after := r.FormValue("after")
+
+	statuses := []StatusCount{}
+	if err := sqlitex.Execute(db, `
+		SELECT COALESCE(domainr_status, ''), COUNT(*) FROM hostnames
+		GROUP BY domainr_status ORDER BY COUNT(*) DESC
+	`, &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			statuses = append(statuses, StatusCount{
+				Status: stmt.ColumnText(0),
+				Hosts:  stmt.ColumnInt64(1),
+			})
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+
+	hosts := []*Host{}
+	if err := sqlitex.Execute(db, `
+		SELECT `+hostColumns+` FROM hostnames h
+		WHERE h.hostname > :after
+		AND (:status = '' OR h.domainr_status = :status)
+		ORDER BY h.hostname LIMIT :limit
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":after":  after,
+			":status": status,
+			":limit":  hostsPageSize,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			hosts = append(hosts, scanHost(stmt))
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	var next string
+	if len(hosts) == hostsPageSize {
+		next = hosts[len(hosts)-1].Hostname
+	}
+
+	return struct {
+		Status   string        `json:"status"`
+		Statuses []StatusCount `json:"statuses"`
+		Hosts    []*Host       `json:"hosts"`
+		Next     string        `json:"next,omitempty"`
+	}{
+		Status:   status,
+		Statuses: statuses,
+		Hosts:    hosts,
+		Next:     next,
+	}, nil
+}
+
+// handleDangling lists the hosts marked as bad by the domainr loop, whose
+// domain might be available for registration, hijacking the vanity import
+// paths of the modules under it. The most recently marked come first, and the
+// after parameter is the "bad_since:hostname" of the last host of the previous
+// page.
+func (s *Server) handleDangling(r *http.Request, db *sqlite.Conn) (any, error) {
+	var afterSince int64
+	var afterHost string
+	if after := r.FormValue("after"); after != "" {
+		since, hostname, ok := strings.Cut(after, ":")
+		n, err := strconv.ParseInt(since, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%w: malformed after %q", errBadRequest, after)
+		}
+		afterSince, afterHost = n, hostname
+	}
+
+	hosts := []*Host{}
+	if err := sqlitex.Execute(db, `
+		SELECT `+hostColumns+` FROM hostnames h
+		WHERE h.bad_since IS NOT NULL
+		AND (:after_host = '' OR h.bad_since < :after_since
+			OR (h.bad_since = :after_since AND h.hostname > :after_host))
+		ORDER BY h.bad_since DESC, h.hostname LIMIT :limit
+	`, &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":after_since": afterSince,
+			":after_host":  afterHost,
+			":limit":       hostsPageSize,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			hosts = append(hosts, scanHost(stmt))
+			return nil
+		},
+	}); err != nil {
+		return nil, err
+	}
+	var next string
+	if len(hosts) == hostsPageSize {
+		last := hosts[len(hosts)-1]
+		next = fmt.Sprintf("%d:%s", last.BadSince, last.Hostname)
+	}
+
+	return struct {
+		Hosts []*Host `json:"hosts"`
+		Next  string  `json:"next,omitempty"`
+	}{
+		Hosts: hosts,
+		Next:  next,
+	}, nil
+}
+
+func serve(ctx context.Context, pool *sqlitex.Pool, addr string) error {
+	s := &Server{pool: pool}
+	hs := &http.Server{
+		Addr:    addr,
+		Handler: s.httpHandler(),
+	}
+	go func() {
+		<-ctx.Done()
+		hs.Shutdown(context.Background())
+	}()
+	slog.Info("starting HTTP server", "addr", addr)
+	return hs.ListenAndServe()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+// newTestDB returns a connection to a new initialized database.
+func newTestDB(t *testing.T) (*sqlitex.Pool, *sqlite.Conn) {
+	t.Helper()
+	pool, err := sqlitex.NewPool(filepath.Join(t.TempDir(), "sumdb.sqlite3"), sqlitex.PoolOptions{
+		PrepareConn: func(db *sqlite.Conn) error {
+			return sqlitex.ExecuteTransient(db, `PRAGMA foreign_keys = ON;`, nil)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	if err := initDatabase(context.Background(), pool); err != nil {
+		t.Fatal(err)
+	}
+	db, err := pool.Take(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Put(db) })
+	return pool, db
+}
+
+// newTestServer returns a server over a database with a few modules, hosts
+// with domainr statuses, and anomalies.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	pool, db := newTestDB(t)
+	entries := []string{
+		goSumEntry(t, "example.com/mod", "v1.0.0", "module example.com/mod\n"),
+		goSumEntry(t, "example.com/Mod", "v1.0.0", "module example.com/Mod\n"),
+		goSumEntry(t, "example.com/mod", "v1.1.0", "module example.com/mod\n"),
+		goSumEntry(t, "gone.example/vanity", "v0.1.0", "module gone.example/vanity\n"),
+		goSumEntry(t, "gone.example/other", "v0.1.0", "module gone.example/other\n"),
+		goSumEntry(t, "parked.example/x", "v1.0.0", "module parked.example/x\n"),
+	}
+	for i := range searchLimit + 1 {
+		path := fmt.Sprintf("many.example/m%03d", i)
+		entries = append(entries, goSumEntry(t, path, "v1.0.0", "module "+path+"\n"))
+	}
+	ingestTestEntries(t, db, entries...)
+	if err := sqlitex.ExecScript(db, `
+		UPDATE hostnames SET domainr_status = 'active' WHERE hostname = 'example.com';
+		UPDATE hostnames SET domainr_status = 'inactive', bad_since = 42 WHERE hostname = 'gone.example';
+		UPDATE hostnames SET domainr_status = 'parked active', bad_since = 7 WHERE hostname = 'parked.example';
+		INSERT INTO checkpoint (checkpoint) VALUES ('go.sum database tree
+107
+AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=
+');
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer((&Server{pool: pool}).httpHandler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getJSON(t *testing.T, srv *httptest.Server, path string, v any) {
+	t.Helper()
+	res, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		t.Fatalf("GET %s: %s: %s", path, res.Status, body)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: Content-Type = %q", path, ct)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+func TestServeModule(t *testing.T) {
+	srv := newTestServer(t)
+	var m struct {
+		Path     string
+		Host     *Host
+		Versions []*ModuleVersion
+	}
+	getJSON(t, srv, "/api/module/example.com/Mod", &m)
+	if m.Path != "example.com/Mod" || m.Host == nil || m.Host.Hostname != "example.com" ||
+		m.Host.DomainrStatus != "active" || m.Host.Modules != 2 {
+		t.Errorf("module = %+v, host = %+v", m, m.Host)
+	}
+	if len(m.Versions) != 1 {
+		t.Fatalf("versions = %+v, want one", m.Versions)
+	}
+	v := m.Versions[0]
+	if v.Index != 1 || v.Version != "v1.0.0" || v.H1 != "h1:zip-v1.0.0=" || v.GoModH1 == "" {
+		t.Errorf("version = %+v", v)
+	}
+	if want := []Anomaly{{Kind: anomalyCaseCollision, Detail: "example.com/mod"}}; !slices.Equal(v.Anomalies, want) {
+		t.Errorf("anomalies = %+v, want %+v", v.Anomalies, want)
+	}
+
+	getJSON(t, srv, "/api/module/example.com/mod", &m)
+	var versions []string
+	for _, v := range m.Versions {
+		versions = append(versions, v.Version)
+	}
+	if want := []string{"v1.0.0", "v1.1.0"}; !slices.Equal(versions, want) {
+		t.Errorf("versions = %v, want %v", versions, want)
+	}
+
+	res, err := http.Get(srv.URL + "/api/module/example.com/missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("missing module: %s, want 404", res.Status)
+	}
+}
+
+func TestServeSearch(t *testing.T) {
+	srv := newTestServer(t)
+	var s struct {
+		Prefix    string
+		Results   []SearchResult
+		Truncated bool
+	}
+	getJSON(t, srv, "/api/search?prefix="+url.QueryEscape("example.com/"), &s)
+	want := []SearchResult{{"example.com/Mod", 1}, {"example.com/mod", 2}}
+	if s.Prefix != "example.com/" || !slices.Equal(s.Results, want) || s.Truncated {
+		t.Errorf("search = %+v, want results %+v", s, want)
+	}
+
+	getJSON(t, srv, "/api/search?prefix=many.example/", &s)
+	if len(s.Results) != searchLimit || !s.Truncated || s.Results[0].Path != "many.example/m000" {
+		t.Errorf("search returned %d results, truncated %v, want %d, true",
+			len(s.Results), s.Truncated, searchLimit)
+	}
+
+	getJSON(t, srv, "/api/search?prefix=nothing.example/", &s)
+	if s.Results == nil || len(s.Results) != 0 || s.Truncated {
+		t.Errorf("empty search = %+v", s)
+	}
+
+	res, err := http.Get(srv.URL + "/api/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("search without prefix: %s, want 400", res.Status)
+	}
+}
+
+func TestServeHosts(t *testing.T) {
+	srv := newTestServer(t)
+	var h struct {
+		Status   string
+		Statuses []StatusCount
+		Hosts    []*Host
+		Next     string
+	}
+	getJSON(t, srv, "/api/hosts", &h)
+	var hostnames []string
+	for _, host := range h.Hosts {
+		hostnames = append(hostnames, host.Hostname)
+	}
+	if want := []string{"example.com", "gone.example", "many.example", "parked.example"}; !slices.Equal(hostnames, want) {
+		t.Errorf("hosts = %v, want %v", hostnames, want)
+	}
+	if len(h.Statuses) != 4 || h.Next != "" {
+		t.Errorf("statuses = %+v, next = %q", h.Statuses, h.Next)
+	}
+
+	getJSON(t, srv, "/api/hosts?status=inactive", &h)
+	if len(h.Hosts) != 1 || h.Hosts[0].Hostname != "gone.example" || h.Hosts[0].Modules != 2 ||
+		h.Hosts[0].BadSince != 42 || h.Hosts[0].ETLDPlusOne != "gone.example" {
+		t.Errorf("inactive hosts = %+v", h.Hosts)
+	}
+}
+
+func TestServeDangling(t *testing.T) {
+	srv := newTestServer(t)
+	var d struct {
+		Hosts []*Host
+	}
+	getJSON(t, srv, "/api/dangling", &d)
+	var hostnames []string
+	for _, host := range d.Hosts {
+		hostnames = append(hostnames, host.Hostname)
+	}
+	// Most recently marked first.
+	if want := []string{"gone.example", "parked.example"}; !slices.Equal(hostnames, want) {
+		t.Errorf("dangling hosts = %v, want %v", hostnames, want)
+	}
+}
+
+func TestServeDanglingPages(t *testing.T) {
+	pool, db := newTestDB(t)
+	// Two hosts per bad_since value, to page through ties.
+	for i := range hostsPageSize + 3 {
+		if err := sqlitex.Execute(db, `
+			INSERT INTO hostnames (hostname, etldp1, bad_since) VALUES (:hostname, :hostname, :bad_since)
+		`, &sqlitex.ExecOptions{
+			Named: map[string]any{
+				":hostname":  fmt.Sprintf("h%03d.example", i),
+				":bad_since": i / 2,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer((&Server{pool: pool}).httpHandler())
+	t.Cleanup(srv.Close)
+
+	var hostnames []string
+	var pages int
+	for after := ""; ; pages++ {
+		var d struct {
+			Hosts []*Host
+			Next  string
+		}
+		getJSON(t, srv, "/api/dangling?after="+url.QueryEscape(after), &d)
+		for _, host := range d.Hosts {
+			hostnames = append(hostnames, host.Hostname)
+		}
+		if d.Next == "" {
+			break
+		}
+		after = d.Next
+	}
+	if pages != 1 {
+		t.Errorf("got %d next pages, want 1", pages)
+	}
+	if len(hostnames) != hostsPageSize+3 {
+		t.Fatalf("got %d hosts, want %d", len(hostnames), hostsPageSize+3)
+	}
+	// Most recently marked first, then by hostname.
+	if hostnames[0] != "h102.example" || hostnames[1] != "h100.example" || hostnames[2] != "h101.example" ||
+		hostnames[len(hostnames)-1] != "h001.example" {
+		t.Errorf("hosts = %v", hostnames)
+	}
+	seen := make(map[string]bool)
+	for _, h := range hostnames {
+		if seen[h] {
+			t.Errorf("host %s listed twice", h)
+		}
+		seen[h] = true
+	}
+
+	res, err := http.Get(srv.URL + "/api/dangling?after=nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed after: %s, want 400", res.Status)
+	}
+}
+
+func TestServePages(t *testing.T) {
+	srv := newTestServer(t)
+	for path, want := range map[string]string{
+		"/":                          "107 entries ingested",
+		"/module/example.com/Mod":    "case-collision",
+		"/search?prefix=example.com": "example.com/Mod",
+		"/hosts":                     "gone.example",
+		"/hosts?status=inactive":     "gone.example",
+		"/dangling":                  "parked.example",
+	} {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: %s", path, res.Status)
+			continue
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("GET %s: Content-Type = %q", path, ct)
+		}
+		if !strings.Contains(string(body), want) {
+			t.Errorf("GET %s: page doesn't contain %q:\n%s", path, want, body)
+		}
+	}
+}
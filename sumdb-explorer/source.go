@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/torchwood"
+	"golang.org/x/mod/sumdb/tlog"
+)
+
+// logSource describes where and how to fetch the log.
+type logSource struct {
+	// URL is the base URL of the log, or a file:// URL or local path of a
+	// directory containing it.
+	URL string
+	// Key is the note verifier key of the log.
+	Key string
+	// Scheme is the tile path scheme, either "sumdb" for the
+	// go.dev/design/25530-sumdb scheme or "c2sp" for c2sp.org/tlog-tiles.
+	Scheme string
+	// Entries is the entry format, either "gosum" for checksum database
+	// records, which are parsed into module versions and checked for
+	// anomalies, or "raw" for any other log, whose entries are stored as-is.
+	Entries string
+}
+
+// checkEntries returns an error if the entry format is unknown.
+func (l *logSource) checkEntries() error {
+	switch l.Entries {
+	case "gosum", "raw":
+		return nil
+	default:
+		return fmt.Errorf("unknown entry format %q", l.Entries)
+	}
+}
+
+// tileSource is implemented by [torchwood.TileFetcher] and [dirSource].
+type tileSource interface {
+	torchwood.TileReaderWithContext
+	ReadEndpoint(ctx context.Context, path string) ([]byte, error)
+}
+
+func (l *logSource) tilePath() (func(tlog.Tile) string, error) {
+	switch l.Scheme {
+	case "sumdb":
+		return tlog.Tile.Path, nil
+	case "c2sp":
+		return torchwood.TilePath, nil
+	default:
+		return nil, fmt.Errorf("unknown tile path scheme %q", l.Scheme)
+	}
+}
+
+// checkpointPath returns the path of the latest signed tree head.
+func (l *logSource) checkpointPath() string {
+	if l.Scheme == "sumdb" {
+		return "latest"
+	}
+	return "checkpoint"
+}
+
+func (l *logSource) clientOptions() []torchwood.ClientOption {
+	if l.Scheme == "sumdb" {
+		return []torchwood.ClientOption{torchwood.WithSumDBEntries()}
+	}
+	return []torchwood.ClientOption{torchwood.WithCutEntry(cutEntryBundle)}
+}
+
+// cutEntryBundle splits the next entry from a c2sp.org/tlog-tiles entry
+// bundle, where each entry is prefixed by its big-endian uint16 length.
+func cutEntryBundle(tile []byte) (entry []byte, rh tlog.Hash, rest []byte, err error) {
+	if len(tile) < 2 {
+		return nil, tlog.Hash{}, nil, errors.New("malformed entry bundle")
+	}
+	n := int(binary.BigEndian.Uint16(tile))
+	if len(tile) < 2+n {
+		return nil, tlog.Hash{}, nil, errors.New("malformed entry bundle")
+	}
+	entry, rest = tile[2:2+n], tile[2+n:]
+	return entry, tlog.RecordHash(entry), rest, nil
+}
+
+func (l *logSource) fetcher() (tileSource, error) {
+	tilePath, err := l.tilePath()
+	if err != nil {
+		return nil, err
+	}
+	if dir, ok := l.localDir(); ok {
+		return &dirSource{dir: dir, tilePath: tilePath}, nil
+	}
+	return torchwood.NewTileFetcher(l.URL, torchwood.WithTilePath(tilePath))
+}
+
+func (l *logSource) localDir() (string, bool) {
+	u, err := url.Parse(l.URL)
+	if err != nil || u.Scheme == "" {
+		return l.URL, true
+	}
+	if u.Scheme == "file" {
+		return u.Path, true
+	}
+	return "", false
+}
+
+// dirSource reads a log from a local directory, laid out like the URL space
+// of the log.
+type dirSource struct {
+	dir      string
+	tilePath func(tlog.Tile) string
+}
+
+func (d *dirSource) ReadTiles(ctx context.Context, tiles []tlog.Tile) ([][]byte, error) {
+	data := make([][]byte, len(tiles))
+	for i, t := range tiles {
+		tile, err := d.ReadEndpoint(ctx, d.tilePath(t))
+		if err != nil {
+			return nil, err
+		}
+		data[i] = tile
+	}
+	return data, nil
+}
+
+func (d *dirSource) SaveTiles(tiles []tlog.Tile, data [][]byte) {}
+
+func (d *dirSource) ReadEndpoint(ctx context.Context, path string) ([]byte, error) {
+	if !filepath.IsLocal(path) || strings.Contains(path, "\\") {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	return os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(path)))
+}
@@ -0,0 +1,334 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+// A ctlEntry is a TrustedSubject of a Microsoft Certificate Trust List, with
+// the properties relevant to server authentication.
+type ctlEntry struct {
+	// SHA1 is the hash of the certificate.
+	SHA1 [sha1.Size]byte
+	// EKUs are the purposes the root is trusted for, if restricted.
+	EKUs []asn1.ObjectIdentifier
+	// NotBefore is the date after which issued certificates are not trusted,
+	// for NotBeforeEKUs, or for all purposes if NotBeforeEKUs is nil.
+	NotBefore     *time.Time
+	NotBeforeEKUs []asn1.ObjectIdentifier
+	// Disallowed is the date since which the root is not trusted, for
+	// DisallowedEKUs, or for all purposes if DisallowedEKUs is nil.
+	Disallowed     *time.Time
+	DisallowedEKUs []asn1.ObjectIdentifier
+}
+
+var (
+	oidSignedData     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidCTL            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 1}
+	oidCertProperty   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 11}
+	oidServerAuth     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+	errMalformedCTL   = errors.New("malformed CTL")
+	errMalformedEntry = errors.New("malformed CTL entry")
+)
+
+// Certificate property IDs, from wincrypt.h.
+const (
+	propEnhKeyUsage          = 9
+	propDisallowedFiletime   = 104
+	propDisallowedEnhKey     = 105
+	propNotBeforeFiletime    = 126
+	propNotBeforeEnhKeyUsage = 127
+)
+
+func hasServerAuth(ekus []asn1.ObjectIdentifier) bool {
+	for _, eku := range ekus {
+		if eku.Equal(oidServerAuth) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCTL parses the entries of a PKCS #7 SignedData CTL, such as
+// authroot.stl, without verifying its signature.
+func parseCTL(der []byte) ([]*ctlEntry, error) {
+	input := cryptobyte.String(der)
+	var contentInfo, signedData, encap, content cryptobyte.String
+	var oid asn1.ObjectIdentifier
+	var version int64
+	if !input.ReadASN1(&contentInfo, cbasn1.SEQUENCE) ||
+		!contentInfo.ReadASN1ObjectIdentifier(&oid) || !oid.Equal(oidSignedData) ||
+		!contentInfo.ReadASN1(&signedData, cbasn1.Tag(0).Constructed().ContextSpecific()) ||
+		!signedData.ReadASN1(&signedData, cbasn1.SEQUENCE) ||
+		!signedData.ReadASN1Integer(&version) ||
+		!signedData.SkipASN1(cbasn1.SET) ||
+		!signedData.ReadASN1(&encap, cbasn1.SEQUENCE) ||
+		!encap.ReadASN1ObjectIdentifier(&oid) ||
+		!encap.ReadASN1(&content, cbasn1.Tag(0).Constructed().ContextSpecific()) {
+		return nil, errMalformedCTL
+	}
+	if !oid.Equal(oidCTL) {
+		return nil, fmt.Errorf("unexpected content type %v", oid)
+	}
+	// PKCS #7 embeds the CTL directly, while CMS wraps it in an OCTET STRING.
+	if content.PeekASN1Tag(cbasn1.OCTET_STRING) {
+		if !content.ReadASN1(&content, cbasn1.OCTET_STRING) {
+			return nil, errMalformedCTL
+		}
+	}
+
+	var ctl, subjects cryptobyte.String
+	var hasSubjects bool
+	if !content.ReadASN1(&ctl, cbasn1.SEQUENCE) ||
+		!ctl.SkipOptionalASN1(cbasn1.INTEGER) || // version
+		!ctl.SkipASN1(cbasn1.SEQUENCE) || // subjectUsage
+		!ctl.SkipOptionalASN1(cbasn1.OCTET_STRING) || // listIdentifier
+		!ctl.SkipOptionalASN1(cbasn1.INTEGER) || // sequenceNumber
+		!skipTime(&ctl) || // ctlThisUpdate
+		(ctl.PeekASN1Tag(cbasn1.UTCTime) || ctl.PeekASN1Tag(cbasn1.GeneralizedTime)) && !skipTime(&ctl) || // ctlNextUpdate
+		!ctl.SkipASN1(cbasn1.SEQUENCE) || // subjectAlgorithm
+		!ctl.ReadOptionalASN1(&subjects, &hasSubjects, cbasn1.SEQUENCE) {
+		return nil, errMalformedCTL
+	}
+
+	var entries []*ctlEntry
+	for !subjects.Empty() {
+		e, err := parseCTLEntry(&subjects)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func skipTime(s *cryptobyte.String) bool {
+	if s.PeekASN1Tag(cbasn1.GeneralizedTime) {
+		return s.SkipASN1(cbasn1.GeneralizedTime)
+	}
+	return s.SkipASN1(cbasn1.UTCTime)
+}
+
+func parseCTLEntry(s *cryptobyte.String) (*ctlEntry, error) {
+	var subject, id, attributes cryptobyte.String
+	var hasAttributes bool
+	if !s.ReadASN1(&subject, cbasn1.SEQUENCE) ||
+		!subject.ReadASN1(&id, cbasn1.OCTET_STRING) ||
+		!subject.ReadOptionalASN1(&attributes, &hasAttributes, cbasn1.SET) {
+		return nil, errMalformedEntry
+	}
+	if len(id) != sha1.Size {
+		return nil, fmt.Errorf("unexpected CTL subject identifier length %d", len(id))
+	}
+	e := &ctlEntry{SHA1: [sha1.Size]byte(id)}
+	for !attributes.Empty() {
+		var attribute, values, value cryptobyte.String
+		var oid asn1.ObjectIdentifier
+		if !attributes.ReadASN1(&attribute, cbasn1.SEQUENCE) ||
+			!attribute.ReadASN1ObjectIdentifier(&oid) ||
+			!attribute.ReadASN1(&values, cbasn1.SET) ||
+			!values.ReadASN1(&value, cbasn1.OCTET_STRING) {
+			return nil, errMalformedEntry
+		}
+		if len(oid) != len(oidCertProperty)+1 || !oid[:len(oidCertProperty)].Equal(oidCertProperty) {
+			continue
+		}
+		var err error
+		switch oid[len(oid)-1] {
+		case propEnhKeyUsage:
+			e.EKUs, err = parseEKUs(value)
+		case propNotBeforeEnhKeyUsage:
+			e.NotBeforeEKUs, err = parseEKUs(value)
+		case propDisallowedEnhKey:
+			e.DisallowedEKUs, err = parseEKUs(value)
+		case propNotBeforeFiletime:
+			e.NotBefore, err = parseFiletime(value)
+		case propDisallowedFiletime:
+			e.Disallowed, err = parseFiletime(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+func parseEKUs(value cryptobyte.String) ([]asn1.ObjectIdentifier, error) {
+	var seq cryptobyte.String
+	if !value.ReadASN1(&seq, cbasn1.SEQUENCE) {
+		return nil, errMalformedEntry
+	}
+	ekus := []asn1.ObjectIdentifier{}
+	for !seq.Empty() {
+		var oid asn1.ObjectIdentifier
+		if !seq.ReadASN1ObjectIdentifier(&oid) {
+			return nil, errMalformedEntry
+		}
+		ekus = append(ekus, oid)
+	}
+	return ekus, nil
+}
+
+// parseFiletime parses a Windows FILETIME, the little-endian count of 100ns
+// intervals since 1601-01-01.
+func parseFiletime(value []byte) (*time.Time, error) {
+	if len(value) != 8 {
+		return nil, errMalformedEntry
+	}
+	ft := binary.LittleEndian.Uint64(value)
+	const unixEpoch = 116444736000000000 // 1970-01-01 as a FILETIME
+	t := time.Unix(0, 0).UTC().Add(time.Duration(int64(ft)-unixEpoch) * 100)
+	return &t, nil
+}
+
+// extractCabinet returns the contents of the named file in a Microsoft
+// Cabinet archive. Only uncompressed and MSZIP folders are supported.
+func extractCabinet(cab []byte, name string) ([]byte, error) {
+	errMalformed := errors.New("malformed cabinet")
+	s := cryptobyte.String(cab)
+	var filesOffset uint32
+	var folders, files, flags uint16
+	var reserveFolder, reserveData uint8
+	if !s.Skip(16) || // signature, reserved1, cbCabinet, reserved2
+		!readUint32LE(&s, &filesOffset) ||
+		!s.Skip(6) || // reserved3, versionMinor, versionMajor
+		!readUint16LE(&s, &folders) ||
+		!readUint16LE(&s, &files) ||
+		!readUint16LE(&s, &flags) ||
+		!s.Skip(4) { // setID, iCabinet
+		return nil, errMalformed
+	}
+	if flags&0x3 != 0 {
+		return nil, errors.New("multi-cabinet archives are not supported")
+	}
+	if flags&0x4 != 0 {
+		var reserveHeader uint16
+		if !readUint16LE(&s, &reserveHeader) || !s.ReadUint8(&reserveFolder) ||
+			!s.ReadUint8(&reserveData) || !s.Skip(int(reserveHeader)) {
+			return nil, errMalformed
+		}
+	}
+
+	type folder struct {
+		offset      uint32
+		blocks      uint16
+		compression uint16
+	}
+	var fs []folder
+	for range folders {
+		var f folder
+		if !readUint32LE(&s, &f.offset) || !readUint16LE(&s, &f.blocks) ||
+			!readUint16LE(&s, &f.compression) || !s.Skip(int(reserveFolder)) {
+			return nil, errMalformed
+		}
+		fs = append(fs, f)
+	}
+
+	if int(filesOffset) > len(cab) {
+		return nil, errMalformed
+	}
+	s = cryptobyte.String(cab[filesOffset:])
+	for range files {
+		var size, offset uint32
+		var index uint16
+		var fileName []byte
+		if !readUint32LE(&s, &size) || !readUint32LE(&s, &offset) ||
+			!readUint16LE(&s, &index) || !s.Skip(6) { // date, time, attribs
+			return nil, errMalformed
+		}
+		i := bytes.IndexByte(s, 0)
+		if i < 0 || !s.ReadBytes(&fileName, i) || !s.Skip(1) {
+			return nil, errMalformed
+		}
+		if string(fileName) != name {
+			continue
+		}
+		if int(index) >= len(fs) {
+			return nil, errMalformed
+		}
+		data, err := readFolder(cab, fs[index].offset, fs[index].blocks, fs[index].compression, reserveData)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, errMalformed
+		}
+		return data[offset : offset+size], nil
+	}
+	return nil, fmt.Errorf("%s not found in cabinet", name)
+}
+
+func readFolder(cab []byte, offset uint32, blocks, compression uint16, reserveData uint8) ([]byte, error) {
+	errMalformed := errors.New("malformed cabinet folder")
+	if int(offset) > len(cab) {
+		return nil, errMalformed
+	}
+	s := cryptobyte.String(cab[offset:])
+	var out []byte
+	for range blocks {
+		var compressedSize, size uint16
+		var data []byte
+		if !s.Skip(4) || // csum
+			!readUint16LE(&s, &compressedSize) || !readUint16LE(&s, &size) ||
+			!s.Skip(int(reserveData)) || !s.ReadBytes(&data, int(compressedSize)) {
+			return nil, errMalformed
+		}
+		switch compression & 0xf {
+		case 0: // none
+			out = append(out, data...)
+		case 1: // MSZIP
+			// Each block is a deflate stream prefixed by "CK", using the
+			// previous 32KiB of output as the dictionary.
+			if !bytes.HasPrefix(data, []byte("CK")) {
+				return nil, errMalformed
+			}
+			dict := out[max(0, len(out)-32768):]
+			r := flate.NewReaderDict(bytes.NewReader(data[2:]), dict)
+			block, err := io.ReadAll(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decompress MSZIP block: %v", err)
+			}
+			if len(block) != int(size) {
+				return nil, errMalformed
+			}
+			out = append(out, block...)
+		default:
+			return nil, fmt.Errorf("unsupported cabinet compression %d", compression)
+		}
+	}
+	return out, nil
+}
+
+func readUint16LE(s *cryptobyte.String, out *uint16) bool {
+	var b []byte
+	if !s.ReadBytes(&b, 2) {
+		return false
+	}
+	*out = binary.LittleEndian.Uint16(b)
+	return true
+}
+
+func readUint32LE(s *cryptobyte.String, out *uint32) bool {
+	var b []byte
+	if !s.ReadBytes(&b, 4) {
+		return false
+	}
+	*out = binary.LittleEndian.Uint32(b)
+	return true
+}
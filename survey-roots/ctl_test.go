@@ -0,0 +1,233 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+type testCTLEntry struct {
+	sha1            [sha1.Size]byte
+	ekus            []asn1.ObjectIdentifier
+	notBefore       time.Time
+	disallowed      time.Time
+	disallowedEKUs  []asn1.ObjectIdentifier
+	unknownProperty bool
+}
+
+func filetime(t time.Time) []byte {
+	ft := uint64(t.Unix())*10000000 + 116444736000000000
+	return binary.LittleEndian.AppendUint64(nil, ft)
+}
+
+// buildCTL encodes entries as a PKCS #7 SignedData CTL, like authroot.stl,
+// but without signers.
+func buildCTL(entries []testCTLEntry) []byte {
+	property := func(b *cryptobyte.Builder, id int, value func(*cryptobyte.Builder)) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			oid := append(append(asn1.ObjectIdentifier{}, oidCertProperty...), id)
+			b.AddASN1ObjectIdentifier(oid)
+			b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.OCTET_STRING, value)
+			})
+		})
+	}
+	ekus := func(oids []asn1.ObjectIdentifier) func(*cryptobyte.Builder) {
+		return func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				for _, oid := range oids {
+					b.AddASN1ObjectIdentifier(oid)
+				}
+			})
+		}
+	}
+	b := cryptobyte.NewBuilder(nil)
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1ObjectIdentifier(oidSignedData)
+		b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1Int64(1)
+				b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {})
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1ObjectIdentifier(oidCTL)
+					b.AddASN1(cbasn1.Tag(0).Constructed().ContextSpecific(), func(b *cryptobyte.Builder) {
+						b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+								b.AddASN1ObjectIdentifier(asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 3, 9})
+							})
+							b.AddASN1(cbasn1.OCTET_STRING, func(b *cryptobyte.Builder) { b.AddBytes([]byte{1}) })
+							b.AddASN1Int64(42)
+							b.AddASN1UTCTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+							b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+								b.AddASN1ObjectIdentifier(asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26})
+							})
+							b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+								for _, e := range entries {
+									b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+										b.AddASN1OctetString(e.sha1[:])
+										b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {
+											if e.unknownProperty {
+												property(b, 20, func(b *cryptobyte.Builder) { b.AddBytes([]byte{1, 2, 3}) })
+											}
+											if e.ekus != nil {
+												property(b, propEnhKeyUsage, ekus(e.ekus))
+											}
+											if !e.notBefore.IsZero() {
+												property(b, propNotBeforeFiletime, func(b *cryptobyte.Builder) { b.AddBytes(filetime(e.notBefore)) })
+											}
+											if !e.disallowed.IsZero() {
+												property(b, propDisallowedFiletime, func(b *cryptobyte.Builder) { b.AddBytes(filetime(e.disallowed)) })
+											}
+											if e.disallowedEKUs != nil {
+												property(b, propDisallowedEnhKey, ekus(e.disallowedEKUs))
+											}
+										})
+									})
+								}
+							})
+						})
+					})
+				})
+				b.AddASN1(cbasn1.SET, func(b *cryptobyte.Builder) {}) // signerInfos
+			})
+		})
+	})
+	return b.BytesOrPanic()
+}
+
+// buildCabinet stores data as a single file in a single MSZIP folder, split in
+// blocks of blockSize bytes.
+func buildCabinet(name string, data []byte, blockSize int) []byte {
+	var blocks [][]byte
+	var sizes []int
+	for i := 0; i < len(data); i += blockSize {
+		chunk := data[i:min(i+blockSize, len(data))]
+		var buf bytes.Buffer
+		buf.WriteString("CK")
+		w, err := flate.NewWriterDict(&buf, flate.BestCompression, data[max(0, i-32768):i])
+		if err != nil {
+			panic(err)
+		}
+		w.Write(chunk)
+		w.Close()
+		blocks = append(blocks, buf.Bytes())
+		sizes = append(sizes, len(chunk))
+	}
+
+	const headerSize, folderSize = 36, 8
+	filesOffset := headerSize + folderSize
+	fileEntry := append(binary.LittleEndian.AppendUint32(nil, uint32(len(data))),
+		0, 0, 0, 0, // uoffFolderStart
+		0, 0, // iFolder
+		0, 0, 0, 0, 0x20, 0) // date, time, attribs
+	fileEntry = append(append(fileEntry, name...), 0)
+	dataOffset := filesOffset + len(fileEntry)
+
+	le := binary.LittleEndian
+	var cab []byte
+	cab = append(cab, "MSCF"...)
+	cab = le.AppendUint32(cab, 0)
+	cab = le.AppendUint32(cab, 0) // cbCabinet, ignored
+	cab = le.AppendUint32(cab, 0)
+	cab = le.AppendUint32(cab, uint32(filesOffset))
+	cab = le.AppendUint32(cab, 0)
+	cab = append(cab, 3, 1)
+	cab = le.AppendUint16(cab, 1) // cFolders
+	cab = le.AppendUint16(cab, 1) // cFiles
+	cab = le.AppendUint16(cab, 0) // flags
+	cab = le.AppendUint16(cab, 0)
+	cab = le.AppendUint16(cab, 0)
+	cab = le.AppendUint32(cab, uint32(dataOffset))
+	cab = le.AppendUint16(cab, uint16(len(blocks)))
+	cab = le.AppendUint16(cab, 1) // MSZIP
+	cab = append(cab, fileEntry...)
+	for i, block := range blocks {
+		cab = le.AppendUint32(cab, 0)
+		cab = le.AppendUint16(cab, uint16(len(block)))
+		cab = le.AppendUint16(cab, uint16(sizes[i]))
+		cab = append(cab, block...)
+	}
+	return cab
+}
+
+func TestLoadMicrosoft(t *testing.T) {
+	server := sha1.Sum([]byte("server"))
+	email := sha1.Sum([]byte("email"))
+	constrained := sha1.Sum([]byte("constrained"))
+	disabled := sha1.Sum([]byte("disabled"))
+	emailDisabled := sha1.Sum([]byte("email disabled"))
+	noEKU := sha1.Sum([]byte("no EKU"))
+	emailProtection := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 4}
+	notBefore := time.Date(2024, 4, 16, 0, 0, 0, 0, time.UTC)
+	disallowedDate := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	ctl := buildCTL([]testCTLEntry{
+		{sha1: server, ekus: []asn1.ObjectIdentifier{oidServerAuth, emailProtection}, unknownProperty: true},
+		{sha1: email, ekus: []asn1.ObjectIdentifier{emailProtection}},
+		{sha1: constrained, ekus: []asn1.ObjectIdentifier{oidServerAuth}, notBefore: notBefore},
+		{sha1: disabled, ekus: []asn1.ObjectIdentifier{oidServerAuth}, disallowed: disallowedDate},
+		{sha1: emailDisabled, ekus: []asn1.ObjectIdentifier{oidServerAuth}, disallowed: disallowedDate,
+			disallowedEKUs: []asn1.ObjectIdentifier{emailProtection}},
+		{sha1: noEKU},
+	})
+
+	dir := t.TempDir()
+	stl := filepath.Join(dir, "authroot.stl")
+	cab := filepath.Join(dir, "authrootstl.cab")
+	if err := os.WriteFile(stl, ctl, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cab, buildCabinet("authroot.stl", ctl, 100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{stl, cab} {
+		p, err := loadMicrosoft(nil, file)
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if p.Len() != 5 {
+			t.Errorf("%s: loaded %d roots, want 5", file, p.Len())
+		}
+		if _, ok := p.bySHA1[email]; ok {
+			t.Errorf("%s: email-only root is trusted for server authentication", file)
+		}
+		if s := p.bySHA1[server]; s == nil || s.DistrustAfter != nil || s.DisabledSince != nil {
+			t.Errorf("%s: server root status = %+v", file, s)
+		}
+		if s := p.bySHA1[constrained]; s == nil || s.DistrustAfter == nil || !s.DistrustAfter.Equal(notBefore) {
+			t.Errorf("%s: constrained root status = %+v", file, s)
+		}
+		if s := p.bySHA1[disabled]; s == nil || s.DisabledSince == nil || !s.DisabledSince.Equal(disallowedDate) {
+			t.Errorf("%s: disabled root status = %+v", file, s)
+		}
+		if s := p.bySHA1[emailDisabled]; s == nil || s.DisabledSince != nil {
+			t.Errorf("%s: root disabled for email status = %+v", file, s)
+		}
+		if s := p.bySHA1[noEKU]; s == nil {
+			t.Errorf("%s: root without EKU restrictions is not trusted", file)
+		}
+	}
+
+	if _, err := extractCabinet(buildCabinet("other.stl", ctl, 100), "authroot.stl"); err == nil {
+		t.Errorf("extracting a missing file succeeded")
+	}
+	if _, err := parseCTL(ctl[:len(ctl)-10]); err == nil {
+		t.Errorf("parsing a truncated CTL succeeded")
+	}
+}
@@ -1,5 +1,5 @@
 module filippo.io/mostly-harmless/survey-roots
 
-go 1.14
+go 1.24.1
 
-require github.com/gwatts/rootcerts v0.0.0-20200118023839-4d1bf2f5971d
+require golang.org/x/crypto v0.38.0
@@ -9,7 +9,6 @@ package main
 import (
 	"crypto/sha256"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"flag"
 	"fmt"
@@ -19,10 +18,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
-	"strings"
 	"time"
-
-	"github.com/gwatts/rootcerts/certparse"
 )
 
 type Root struct {
@@ -34,14 +30,26 @@ type Fingerprint [32]byte
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, "usage: survey-roots [roots.pem]")
+		fmt.Fprintln(os.Stderr, "usage: survey-roots [flags] [roots.pem]")
+		flag.PrintDefaults()
 	}
 	verbose := flag.Bool("v", false, "print source and hashes of roots")
+	format := flag.String("format", "text", "output `format`: text, json, or csv")
+	mozillaFile := flag.String("mozilla", "", "read the Mozilla certdata.txt from `file` instead of fetching it")
+	chromeFile := flag.String("chrome", "", "read the Chrome root_store.textproto from `file` instead of fetching it, or \"none\"")
+	appleFile := flag.String("apple", "", "read the Apple trust store list from `file` instead of fetching it, or \"none\"")
+	microsoftFile := flag.String("microsoft", "", "read the Microsoft authroot.stl or authrootstl.cab from `file` instead of fetching it, or \"none\"")
+	ctFile := flag.String("ct", "", "read the Argon CT log get-roots response from `file` instead of fetching it, or \"none\"")
 	flag.Parse()
 
-	var verboseOut, verboseErr io.Writer = os.Stdout, os.Stderr
+	if *format != "text" && *format != "json" && *format != "csv" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var verboseErr io.Writer = os.Stderr
 	if !*verbose {
-		verboseOut, verboseErr = ioutil.Discard, ioutil.Discard
+		verboseErr = ioutil.Discard
 	}
 
 	var roots []*Root
@@ -49,11 +57,11 @@ func main() {
 	case 0:
 		roots = loadSystemRoots()
 	case 1:
-		data, err := ioutil.ReadFile(os.Args[1])
+		data, err := ioutil.ReadFile(flag.Arg(0))
 		if err != nil {
 			log.Fatal(err)
 		}
-		roots = appendFromPEM(roots, data, os.Args[1])
+		roots = appendFromPEM(roots, data, flag.Arg(0))
 	default:
 		flag.Usage()
 		os.Exit(1)
@@ -80,76 +88,54 @@ func main() {
 	})
 	fmt.Fprintf(verboseErr, "[+] Found %d unique roots in target set\n", len(uniqueRoots))
 
-	fmt.Fprintf(verboseErr, "[ ] Fetching Mozilla root store...\n")
 	c := &http.Client{Timeout: 20 * time.Second}
-	resp, err := c.Get("https://hg.mozilla.org/releases/mozilla-release/raw-file/default/security/nss/lib/ckfw/builtins/certdata.txt")
-	if err != nil {
-		log.Fatal(err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("hg.mozilla.org GET failed: %v", resp.Status)
-	}
-	mozillaCerts, err := certparse.ReadTrustedCerts(resp.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-	mozillaGood := make(map[Fingerprint]bool)
-	for _, c := range mozillaCerts {
-		if c.Trust&certparse.ServerTrustedDelegator != 0 {
-			mozillaGood[spkiSubjectFingerprint(c.Cert)] = true
-		}
-	}
-	fmt.Fprintf(verboseErr, "[+] Loaded %d Mozilla roots\n", len(mozillaGood))
-
 	year := time.Now().Format("2006")
-	fmt.Fprintf(verboseErr, "[ ] Fetching Argon%s root store...\n", year)
-	resp, err = c.Get("https://ct.googleapis.com/logs/argon" + year + "/ct/v1/get-roots")
-	if err != nil {
-		log.Fatal(err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("/ct/v1/get-roots GET failed: %v", resp.Status)
-	}
-	var ctCerts struct {
-		Certificates [][]byte
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&ctCerts); err != nil {
-		log.Fatal(err)
-	}
-	ctGood := make(map[Fingerprint]bool)
-	for _, der := range ctCerts.Certificates {
-		c, err := x509.ParseCertificate(der)
-		if err != nil {
+	loaders := []struct {
+		name, file string
+		load       func() (*program, error)
+	}{
+		{"Mozilla", *mozillaFile, func() (*program, error) { return loadMozilla(c, *mozillaFile) }},
+		{"Chrome", *chromeFile, func() (*program, error) { return loadChrome(c, *chromeFile) }},
+		{"Apple", *appleFile, func() (*program, error) { return loadApple(c, *appleFile) }},
+		{"Microsoft", *microsoftFile, func() (*program, error) { return loadMicrosoft(c, *microsoftFile) }},
+		{"Argon" + year + " CT log", *ctFile, func() (*program, error) {
+			return loadCTLog(c, "https://ct.googleapis.com/logs/argon"+year+"/ct/v1/get-roots", *ctFile)
+		}},
+	}
+	var programs []*program
+	for i, l := range loaders {
+		if l.file == "none" && i > 0 {
 			continue
 		}
-		ctGood[spkiSubjectFingerprint(c)] = true
+		fmt.Fprintf(verboseErr, "[ ] Loading %s root store...\n", l.name)
+		p, err := l.load()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Fprintf(verboseErr, "[+] Loaded %d %s roots\n", p.Len(), l.name)
+		programs = append(programs, p)
 	}
-	fmt.Fprintf(verboseErr, "[+] Loaded %d roots from Argon%s CT log\n", len(ctGood), year)
+
+	host, _ := os.Hostname()
+	r := buildReport(host, time.Now(), uniqueRoots, programs)
 
 	var notInMozilla, unknown int
-	for _, root := range uniqueRoots {
-		fingerprint := spkiSubjectFingerprint(root.c)
-		if mozillaGood[fingerprint] {
-			continue
-		}
-		if ctGood[fingerprint] {
-			notInMozilla++
-			fmt.Printf(" - %v\n", root.c.Subject)
+	switch *format {
+	case "text":
+		notInMozilla, unknown = writeText(os.Stdout, *verbose, r)
+	case "json", "csv":
+		var err error
+		if *format == "json" {
+			err = writeJSON(os.Stdout, r)
 		} else {
-			unknown++
-			fmt.Printf("!! %v\n", root.c.Subject)
+			err = writeCSV(os.Stdout, r)
 		}
-		fmt.Fprintf(verboseOut, "\tfrom %s\n", strings.Join(root.source, ", "))
-		fmt.Fprintf(verboseOut, "\thttps://censys.io/authorities/%x\n", fingerprint)
-		fmt.Fprintf(verboseOut, "\thttps://crt.sh/?q=%x\n", sha256.Sum256(root.c.Raw))
-		fmt.Fprintf(verboseOut, "\n")
-	}
-	if notInMozilla+unknown > 0 && !*verbose {
-		fmt.Printf("\n")
+		if err != nil {
+			log.Fatal(err)
+		}
+		notInMozilla, unknown = writeText(io.Discard, false, r)
 	}
 
-	fmt.Printf("Found %d root(s) not in the Mozilla store, and %d completely unknown one(s).\n", notInMozilla, unknown)
-
 	if notInMozilla+unknown > 0 {
 		os.Exit(1)
 	}
@@ -0,0 +1,288 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/x509roots/nss"
+)
+
+const (
+	MozillaCertdata = "https://hg.mozilla.org/releases/mozilla-release/raw-file/default/security/nss/lib/ckfw/builtins/certdata.txt"
+	ChromeRootStore = "https://chromium.googlesource.com/chromium/src/+/main/net/data/ssl/chrome_root_store/root_store.textproto?format=TEXT"
+	AppleRootStore  = "https://support.apple.com/en-us/121672"
+	MicrosoftCTL    = "http://ctldl.windowsupdate.com/msdownload/update/v3/static/trustedr/en/authrootstl.cab"
+)
+
+// A program is the set of roots trusted for server authentication by a root
+// program, with their constraints.
+//
+// Mozilla publishes the certificates, so its roots are matched like the local
+// ones, by SPKI and Subject. The other programs only publish hashes, so their
+// roots are matched by exact certificate.
+type program struct {
+	Name string
+	// Source is the URL or file the program was loaded from.
+	Source string
+
+	bySPKISubject map[Fingerprint]*programStatus
+	bySHA256      map[[sha256.Size]byte]*programStatus
+	bySHA1        map[[sha1.Size]byte]*programStatus
+}
+
+// programStatus is the status of a root in a program that includes it.
+type programStatus struct {
+	// DistrustAfter is the date after which certificates issued by the root
+	// are not trusted (for Chrome, based on the SCT timestamps).
+	DistrustAfter *time.Time `json:"distrust_after,omitempty"`
+	// DisabledSince is the date since which the root is not trusted at all.
+	DisabledSince *time.Time `json:"disabled_since,omitempty"`
+}
+
+// disabled reports whether the root is not trusted at all at time t.
+func (s *programStatus) disabled(t time.Time) bool {
+	return s.DisabledSince != nil && !s.DisabledSince.After(t)
+}
+
+func (p *program) Len() int {
+	return len(p.bySPKISubject) + len(p.bySHA256) + len(p.bySHA1)
+}
+
+// Lookup returns the status of c in the program, or nil if the program does
+// not include c.
+func (p *program) Lookup(c *x509.Certificate) *programStatus {
+	if s, ok := p.bySPKISubject[spkiSubjectFingerprint(c)]; ok {
+		return s
+	}
+	if s, ok := p.bySHA256[sha256.Sum256(c.Raw)]; ok {
+		return s
+	}
+	if s, ok := p.bySHA1[sha1.Sum(c.Raw)]; ok {
+		return s
+	}
+	return nil
+}
+
+// readSource reads file, or fetches url if file is empty.
+func readSource(c *http.Client, url, file string) ([]byte, string, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		return data, file, err
+	}
+	resp, err := c.Get(url)
+	if err != nil {
+		return nil, url, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, url, fmt.Errorf("GET %s failed: %v", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, url, err
+}
+
+// loadMozilla loads the roots trusted for server authentication from a NSS
+// certdata.txt file.
+func loadMozilla(c *http.Client, file string) (*program, error) {
+	data, source, err := readSource(c, MozillaCertdata, file)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := nss.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+	}
+	p := &program{Name: "mozilla", Source: source, bySPKISubject: make(map[Fingerprint]*programStatus)}
+	for _, c := range certs {
+		s := &programStatus{}
+		for _, constraint := range c.Constraints {
+			if d, ok := constraint.(nss.DistrustAfter); ok {
+				t := time.Time(d)
+				s.DistrustAfter = &t
+			}
+		}
+		p.bySPKISubject[spkiSubjectFingerprint(c.X509)] = s
+	}
+	return p, nil
+}
+
+// loadChrome loads the trust anchors of the Chrome Root Store from its
+// root_store.textproto file. When fetched from Gitiles, the file is
+// base64-encoded.
+func loadChrome(c *http.Client, file string) (*program, error) {
+	data, source, err := readSource(c, ChromeRootStore, file)
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		data, err = base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %v", source, err)
+		}
+	}
+	p := &program{Name: "chrome", Source: source, bySHA256: make(map[[sha256.Size]byte]*programStatus)}
+	if err := parseChromeRootStore(p, data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+	}
+	return p, nil
+}
+
+// parseChromeRootStore parses the sha256_hex and sct_not_after_sec fields of
+// the top-level trust_anchors messages of a root_store.textproto file.
+func parseChromeRootStore(p *program, data []byte) error {
+	var anchor *programStatus
+	depth := 0
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "{") {
+			if depth == 0 && strings.TrimSpace(strings.TrimSuffix(line, "{")) == "trust_anchors" {
+				anchor = &programStatus{}
+			}
+			depth++
+			continue
+		}
+		if line == "}" {
+			depth--
+			if depth == 0 {
+				anchor = nil
+			}
+			continue
+		}
+		if anchor == nil {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		switch name {
+		case "sha256_hex":
+			h, err := hex.DecodeString(value)
+			if err != nil || len(h) != sha256.Size {
+				return fmt.Errorf("invalid sha256_hex %q", value)
+			}
+			p.bySHA256[[sha256.Size]byte(h)] = anchor
+		case "sct_not_after_sec":
+			sec, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid sct_not_after_sec %q", value)
+			}
+			t := time.Unix(sec, 0).UTC()
+			if anchor.DistrustAfter == nil || t.After(*anchor.DistrustAfter) {
+				anchor.DistrustAfter = &t
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(p.bySHA256) == 0 {
+		return fmt.Errorf("no trust anchors found")
+	}
+	return nil
+}
+
+var appleFingerprintRegex = regexp.MustCompile(`([0-9A-F][0-9A-F] ){31}[0-9A-F][0-9A-F]`)
+
+// loadApple loads the SHA-256 fingerprints from the Apple trust store list.
+func loadApple(c *http.Client, file string) (*program, error) {
+	data, source, err := readSource(c, AppleRootStore, file)
+	if err != nil {
+		return nil, err
+	}
+	p := &program{Name: "apple", Source: source, bySHA256: make(map[[sha256.Size]byte]*programStatus)}
+	for _, m := range appleFingerprintRegex.FindAllString(string(data), -1) {
+		h, err := hex.DecodeString(strings.ReplaceAll(m, " ", ""))
+		if err != nil {
+			return nil, err
+		}
+		p.bySHA256[[sha256.Size]byte(h)] = &programStatus{}
+	}
+	if len(p.bySHA256) == 0 {
+		return nil, fmt.Errorf("no fingerprints found in %s", source)
+	}
+	return p, nil
+}
+
+// loadMicrosoft loads the roots trusted for server authentication from the
+// Microsoft authroot.stl CTL, or from the authrootstl.cab that contains it.
+// The CTL signature is not verified.
+func loadMicrosoft(c *http.Client, file string) (*program, error) {
+	data, source, err := readSource(c, MicrosoftCTL, file)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("MSCF")) {
+		data, err = extractCabinet(data, "authroot.stl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to extract %s: %v", source, err)
+		}
+	}
+	entries, err := parseCTL(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+	}
+	p := &program{Name: "microsoft", Source: source, bySHA1: make(map[[sha1.Size]byte]*programStatus)}
+	for _, e := range entries {
+		if e.EKUs != nil && !hasServerAuth(e.EKUs) {
+			continue
+		}
+		s := &programStatus{}
+		if e.NotBefore != nil && (e.NotBeforeEKUs == nil || hasServerAuth(e.NotBeforeEKUs)) {
+			s.DistrustAfter = e.NotBefore
+		}
+		if e.Disallowed != nil && (e.DisallowedEKUs == nil || hasServerAuth(e.DisallowedEKUs)) {
+			s.DisabledSince = e.Disallowed
+		}
+		p.bySHA1[e.SHA1] = s
+	}
+	return p, nil
+}
+
+// loadCTLog loads the roots accepted by a CT log, from its get-roots endpoint.
+func loadCTLog(c *http.Client, url, file string) (*program, error) {
+	data, source, err := readSource(c, url, file)
+	if err != nil {
+		return nil, err
+	}
+	var ctCerts struct {
+		Certificates [][]byte
+	}
+	if err := json.Unmarshal(data, &ctCerts); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", source, err)
+	}
+	p := &program{Name: "ct", Source: source, bySPKISubject: make(map[Fingerprint]*programStatus)}
+	for _, der := range ctCerts.Certificates {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			continue
+		}
+		p.bySPKISubject[spkiSubjectFingerprint(c)] = &programStatus{}
+	}
+	return p, nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A report is the result of a survey of a set of roots, meant to be
+// aggregated across hosts.
+type report struct {
+	Host     string           `json:"host"`
+	Time     time.Time        `json:"time"`
+	Programs []*reportProgram `json:"programs"`
+	Roots    []*reportRoot    `json:"roots"`
+}
+
+type reportProgram struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Roots  int    `json:"roots"`
+}
+
+type reportRoot struct {
+	Subject            string    `json:"subject"`
+	SHA256             string    `json:"sha256"`
+	SPKISubjectSHA256  string    `json:"spki_subject_sha256"`
+	NotAfter           time.Time `json:"not_after"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	Expired            bool      `json:"expired"`
+	SHA1               bool      `json:"sha1"`
+	// Sources are the files the root was loaded from.
+	Sources []string `json:"sources"`
+	// Programs are the programs that include the root, by name.
+	Programs map[string]*programStatus `json:"programs"`
+}
+
+func buildReport(host string, now time.Time, roots []*Root, programs []*program) *report {
+	r := &report{Host: host, Time: now.UTC()}
+	for _, p := range programs {
+		r.Programs = append(r.Programs, &reportProgram{Name: p.Name, Source: p.Source, Roots: p.Len()})
+	}
+	for _, root := range roots {
+		fingerprint := spkiSubjectFingerprint(root.c)
+		h := sha256.Sum256(root.c.Raw)
+		rr := &reportRoot{
+			Subject:            root.c.Subject.String(),
+			SHA256:             hex.EncodeToString(h[:]),
+			SPKISubjectSHA256:  hex.EncodeToString(fingerprint[:]),
+			NotAfter:           root.c.NotAfter.UTC(),
+			SignatureAlgorithm: root.c.SignatureAlgorithm.String(),
+			Expired:            now.After(root.c.NotAfter),
+			SHA1:               isSHA1(root.c.SignatureAlgorithm),
+			Sources:            root.source,
+			Programs:           make(map[string]*programStatus),
+		}
+		for _, p := range programs {
+			if s := p.Lookup(root.c); s != nil {
+				rr.Programs[p.Name] = s
+			}
+		}
+		r.Roots = append(r.Roots, rr)
+	}
+	return r
+}
+
+func isSHA1(alg x509.SignatureAlgorithm) bool {
+	switch alg {
+	case x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		return true
+	}
+	return false
+}
+
+// flags returns the issues of the root, for the text output, including the
+// distrust-after and disabled-since dates of each program in programs.
+func (r *reportRoot) flags(programs []*reportProgram) []string {
+	var flags []string
+	if r.Expired {
+		flags = append(flags, "expired")
+	}
+	if r.SHA1 {
+		flags = append(flags, "SHA-1")
+	}
+	for _, p := range programs {
+		s := r.Programs[p.Name]
+		if s == nil {
+			continue
+		}
+		if s.DistrustAfter != nil {
+			flags = append(flags, p.Name+" distrusted after "+s.DistrustAfter.Format(time.DateOnly))
+		}
+		if s.DisabledSince != nil {
+			flags = append(flags, p.Name+" disabled since "+s.DisabledSince.Format(time.DateOnly))
+		}
+	}
+	return flags
+}
+
+func writeJSON(w io.Writer, r *report) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(r)
+}
+
+// writeCSV writes a row per root, with three columns per program: whether it
+// includes the root, and its distrust-after and disabled-since dates.
+func writeCSV(w io.Writer, r *report) error {
+	cw := csv.NewWriter(w)
+	header := []string{"host", "subject", "sha256", "spki_subject_sha256", "not_after",
+		"signature_algorithm", "expired", "sha1", "sources"}
+	for _, p := range r.Programs {
+		header = append(header, p.Name, p.Name+"_distrust_after", p.Name+"_disabled_since")
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	date := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	for _, root := range r.Roots {
+		row := []string{r.Host, root.Subject, root.SHA256, root.SPKISubjectSHA256,
+			root.NotAfter.Format(time.RFC3339), root.SignatureAlgorithm,
+			strconv.FormatBool(root.Expired), strconv.FormatBool(root.SHA1),
+			strings.Join(root.Sources, " ")}
+		for _, p := range r.Programs {
+			s := root.Programs[p.Name]
+			if s == nil {
+				row = append(row, "false", "", "")
+				continue
+			}
+			row = append(row, "true", date(s.DistrustAfter), date(s.DisabledSince))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeText writes the roots that are not in the Mozilla store, or that are
+// flagged, and a summary. It returns the number of roots not in the Mozilla
+// store, and of roots not in any program or CT log. Roots a program disabled
+// before the report time don't count as included in it.
+func writeText(w io.Writer, verbose bool, r *report) (notInMozilla, unknown int) {
+	verboseOut := w
+	if !verbose {
+		verboseOut = io.Discard
+	}
+	var printed, expired, sha1 int
+	for _, root := range r.Roots {
+		if root.Expired {
+			expired++
+		}
+		if root.SHA1 {
+			sha1++
+		}
+		flags := root.flags(r.Programs)
+		var others []string
+		for _, p := range r.Programs {
+			if s := root.Programs[p.Name]; p.Name != "mozilla" && s != nil && !s.disabled(r.Time) {
+				others = append(others, p.Name)
+			}
+		}
+
+		var prefix string
+		switch {
+		case root.Programs["mozilla"] != nil:
+			if len(flags) == 0 {
+				continue
+			}
+			prefix = " ~"
+		case len(others) > 0:
+			notInMozilla++
+			prefix = " -"
+		default:
+			unknown++
+			prefix = "!!"
+		}
+		line := fmt.Sprintf("%s %v", prefix, root.Subject)
+		if root.Programs["mozilla"] == nil && len(others) > 0 {
+			line += " (in " + strings.Join(others, ", ") + ")"
+		}
+		if len(flags) > 0 {
+			line += " [" + strings.Join(flags, ", ") + "]"
+		}
+		fmt.Fprintln(w, line)
+		printed++
+		fmt.Fprintf(verboseOut, "\tfrom %s\n", strings.Join(root.Sources, ", "))
+		fmt.Fprintf(verboseOut, "\thttps://censys.io/authorities/%s\n", root.SPKISubjectSHA256)
+		fmt.Fprintf(verboseOut, "\thttps://crt.sh/?q=%s\n", root.SHA256)
+		fmt.Fprintf(verboseOut, "\n")
+	}
+	if printed > 0 && !verbose {
+		fmt.Fprintf(w, "\n")
+	}
+
+	fmt.Fprintf(w, "Found %d root(s) not in the Mozilla store, and %d completely unknown one(s).\n", notInMozilla, unknown)
+	if expired+sha1 > 0 {
+		fmt.Fprintf(w, "Found %d expired root(s), and %d SHA-1 signed one(s).\n", expired, sha1)
+	}
+	return notInMozilla, unknown
+}
@@ -0,0 +1,241 @@
+// Copyright 2019 Google LLC
+//
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file or at
+// https://developers.google.com/open-source/licenses/bsd
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRoot(t *testing.T, name string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func octal(b []byte) string {
+	var s strings.Builder
+	for _, c := range b {
+		fmt.Fprintf(&s, "\\%03o", c)
+	}
+	return s.String() + "\n"
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReport(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	future := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	mozilla := newRoot(t, "Mozilla Root", future)
+	distrusted := newRoot(t, "Distrusted Root", future)
+	chrome := newRoot(t, "Chrome Root", future)
+	microsoft := newRoot(t, "Microsoft Root", future)
+	disabled := newRoot(t, "Disabled Root", future)
+	ct := newRoot(t, "CT Root", future)
+	unknown := newRoot(t, "Unknown Root", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	var certdata bytes.Buffer
+	for _, c := range []*x509.Certificate{mozilla, distrusted} {
+		fmt.Fprintf(&certdata, "CKA_CLASS CK_OBJECT_CLASS CKO_CERTIFICATE\nCKA_VALUE MULTILINE_OCTAL\n%sEND\n", octal(c.Raw))
+		if c == distrusted {
+			fmt.Fprintf(&certdata, "CKA_NSS_SERVER_DISTRUST_AFTER MULTILINE_OCTAL\n%sEND\n", octal([]byte("191130235959Z")))
+		}
+		h := sha1.Sum(c.Raw)
+		fmt.Fprintf(&certdata, "\nCKA_CLASS CK_OBJECT_CLASS CKO_NSS_TRUST\nCKA_CERT_SHA1_HASH MULTILINE_OCTAL\n%sEND\n", octal(h[:]))
+		certdata.WriteString("CKA_TRUST_SERVER_AUTH CK_TRUST CKT_NSS_TRUSTED_DELEGATOR\n\n")
+	}
+	chromeHash := sha256.Sum256(chrome.Raw)
+	distrustedHash := sha256.Sum256(distrusted.Raw)
+	textproto := fmt.Sprintf(`# Chrome Root Store
+trust_anchors {
+  sha256_hex: "%x"
+}
+
+trust_anchors {
+  sha256_hex: "%x"
+  constraints {
+    sct_not_after_sec: 1574899199
+  }
+}
+
+additional_certs {
+  sha256_hex: "%x"
+}
+`, chromeHash, distrustedHash, sha256.Sum256(unknown.Raw))
+	apple := fmt.Sprintf("<td>% X</td>\n", chromeHash[:])
+	ctRoots, err := json.Marshal(map[string][][]byte{"certificates": {ct.Raw, mozilla.Raw}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stl := buildCTL([]testCTLEntry{
+		{sha1: sha1.Sum(microsoft.Raw), ekus: []asn1.ObjectIdentifier{oidServerAuth}},
+		{sha1: sha1.Sum(disabled.Raw), ekus: []asn1.ObjectIdentifier{oidServerAuth},
+			disallowed: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+	})
+
+	mozillaProgram, err := loadMozilla(nil, writeFile(t, "certdata.txt", certdata.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chromeProgram, err := loadChrome(nil, writeFile(t, "root_store.textproto", []byte(textproto)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appleProgram, err := loadApple(nil, writeFile(t, "apple.html", []byte(apple)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	microsoftProgram, err := loadMicrosoft(nil, writeFile(t, "authroot.stl", stl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctProgram, err := loadCTLog(nil, "", writeFile(t, "get-roots.json", ctRoots))
+	if err != nil {
+		t.Fatal(err)
+	}
+	programs := []*program{mozillaProgram, chromeProgram, appleProgram, microsoftProgram, ctProgram}
+
+	var pemRoots []byte
+	for _, c := range []*x509.Certificate{chrome, ct, disabled, distrusted, microsoft, mozilla, unknown} {
+		pemRoots = append(pemRoots, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	roots := appendFromPEM(nil, pemRoots, "roots.pem")
+	roots[len(roots)-1].c.SignatureAlgorithm = x509.SHA1WithRSA
+	r := buildReport("host.example", now, roots, programs)
+
+	want := map[string]string{
+		"Chrome Root":     "apple chrome",
+		"CT Root":         "ct",
+		"Disabled Root":   "microsoft",
+		"Distrusted Root": "chrome mozilla",
+		"Microsoft Root":  "microsoft",
+		"Mozilla Root":    "ct mozilla",
+		"Unknown Root":    "",
+	}
+	for _, root := range r.Roots {
+		var names []string
+		for _, p := range programs {
+			if root.Programs[p.Name] != nil {
+				names = append(names, p.Name)
+			}
+		}
+		slices.Sort(names)
+		name := strings.TrimPrefix(root.Subject, "CN=")
+		if got := strings.Join(names, " "); got != want[name] {
+			t.Errorf("%s: programs = %q, want %q", name, got, want[name])
+		}
+		if root.Expired != (name == "Unknown Root") || root.SHA1 != (name == "Unknown Root") {
+			t.Errorf("%s: expired = %v, SHA-1 = %v", name, root.Expired, root.SHA1)
+		}
+		if name == "Distrusted Root" {
+			if d := root.Programs["mozilla"].DistrustAfter; d == nil || !d.Equal(time.Date(2019, 11, 30, 23, 59, 59, 0, time.UTC)) {
+				t.Errorf("Mozilla distrust after = %v", d)
+			}
+			if d := root.Programs["chrome"].DistrustAfter; d == nil || d.Unix() != 1574899199 {
+				t.Errorf("Chrome distrust after = %v", d)
+			}
+		}
+	}
+
+	var text bytes.Buffer
+	notInMozilla, unknownCount := writeText(&text, false, r)
+	if notInMozilla != 3 || unknownCount != 2 {
+		t.Errorf("writeText = %d, %d, want 3, 2", notInMozilla, unknownCount)
+	}
+	for _, line := range []string{
+		" - CN=Chrome Root (in chrome, apple)\n",
+		" ~ CN=Distrusted Root [mozilla distrusted after 2019-11-30, chrome distrusted after 2019-11-27]\n",
+		"!! CN=Disabled Root [microsoft disabled since 2023-06-01]\n",
+		"!! CN=Unknown Root [expired, SHA-1]\n",
+		"Found 3 root(s) not in the Mozilla store, and 2 completely unknown one(s).\n",
+		"Found 1 expired root(s), and 1 SHA-1 signed one(s).\n",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("text output does not contain %q:\n%s", line, text.String())
+		}
+	}
+	if strings.Contains(text.String(), "Mozilla Root") {
+		t.Errorf("text output contains a trusted Mozilla root:\n%s", text.String())
+	}
+
+	var j bytes.Buffer
+	if err := writeJSON(&j, r); err != nil {
+		t.Fatal(err)
+	}
+	var decoded report
+	if err := json.Unmarshal(j.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Host != "host.example" || len(decoded.Roots) != 7 || len(decoded.Programs) != 5 {
+		t.Errorf("unexpected JSON report:\n%s", j.String())
+	}
+
+	var c bytes.Buffer
+	if err := writeCSV(&c, r); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&c).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 8 || len(records[0]) != 9+3*5 {
+		t.Fatalf("unexpected CSV shape: %d rows, %d columns", len(records), len(records[0]))
+	}
+	for _, row := range records[1:] {
+		if row[1] != "CN=Distrusted Root" {
+			continue
+		}
+		if row[9] != "true" || row[10] != "2019-11-30T23:59:59Z" || row[12] != "true" {
+			t.Errorf("unexpected CSV row: %q", row)
+		}
+	}
+}
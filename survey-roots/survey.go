@@ -18,7 +18,7 @@ import (
 )
 
 const Dockerfile = `
-FROM golang:alpine3.11
+FROM golang:1.24-alpine
 
 ENV CGO_ENABLED=0
 ADD . /usr/local/src/survey-roots